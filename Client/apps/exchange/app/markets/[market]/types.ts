@@ -6,7 +6,20 @@ export interface WsOrderbookPayload {
   bidDepth: DepthMap
   askDepth: DepthMap
   currentPrice: number
-  fills?: Array<{ price: number; quantity: number; tradeId: string }>
+  depthSeq: number
+}
+
+export interface WsLevelDelta {
+  price: number
+  quantity: number
+}
+
+export interface WsDepthDeltaPayload {
+  depthSeq: number
+  bids: WsLevelDelta[] | null
+  asks: WsLevelDelta[] | null
+  currentPrice: number
+  fills?: Array<{ price: number; quantity: number; tradeId: string }> | null
 }
 
 export interface WsOrderCancelledPayload {
@@ -15,11 +28,49 @@ export interface WsOrderCancelledPayload {
   cancelledQty?: number
 }
 
+// Private events of the user's own orders, sent to each of their connections.
+export interface WsOrderEventPayload {
+  orderId: string
+  marketId: string
+  side?: OrderSide
+  price?: number
+  quantity?: number
+  reason?: string
+  error?: string
+}
+
+export interface WsOrderFillPayload {
+  orderId: string
+  marketId: string
+  side: OrderSide
+  price: number
+  quantity: number
+  tradeId: string
+  remaining: number
+  maker: boolean
+}
+
+// Rolling 24h statistics DBWritter pushes as TICKER; prices are in cents.
+export interface WsTickerPayload {
+  marketId: string
+  lastPrice: number
+  openPrice24h: number
+  high24h: number
+  low24h: number
+  volume24h: number
+  tradeCount24h: number
+  totalVolume: number
+}
+
 export interface WsMessage {
-  type: "SUBSCRIBED" | "ORDERBOOK_DATA" | "ORDERBOOK_UPDATE" | "DEPTH_DATA" | "ERROR" | "ORDER_CANCELLED"
-  payload: WsOrderbookPayload | WsOrderCancelledPayload | Record<string, unknown>
+  type:
+    | "SUBSCRIBED" | "ORDERBOOK_DATA" | "DEPTH_DELTA" | "DEPTH_DATA" | "ERROR" | "ORDER_CANCELLED"
+    | "ORDER_ACCEPTED" | "ORDER_PARTIALLY_FILLED" | "ORDER_FILLED" | "ORDER_REJECTED" | "WALLET_UPDATED"
+    | "TICKER"
+  payload: WsOrderbookPayload | WsDepthDeltaPayload | WsOrderCancelledPayload | WsOrderEventPayload | WsOrderFillPayload | WsTickerPayload | Record<string, unknown>
   connectionId?: string
   userId?: string
+  epoch?: number
 }
 
 export interface OpenOrder {
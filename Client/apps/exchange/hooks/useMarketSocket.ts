@@ -7,7 +7,12 @@ import type {
   DepthMap,
   WsMessage,
   WsOrderbookPayload,
+  WsDepthDeltaPayload,
+  WsLevelDelta,
   WsOrderCancelledPayload,
+  WsOrderEventPayload,
+  WsOrderFillPayload,
+  WsTickerPayload,
   WsStatus,
   OpenOrder,
 } from "@/app/markets/[market]/types"
@@ -36,10 +41,26 @@ function depthToLevels(depth: DepthMap, side: "bid" | "ask"): OrderLevel[] {
   return levels
 }
 
-function applyDepth(payload: WsOrderbookPayload): OrderBookData {
+// LocalBook is the depth the client keeps in sync from ORDERBOOK_DATA and the DEPTH_DELTAs
+// numbered after it.
+interface LocalBook {
+  bidDepth: DepthMap
+  askDepth: DepthMap
+  depthSeq: number
+  epoch?: number
+}
+
+function applyLevels(depth: DepthMap, levels: WsLevelDelta[] | null) {
+  for (const l of levels ?? []) {
+    if (l.quantity > 0) depth[l.price] = l.quantity
+    else delete depth[l.price]
+  }
+}
+
+function toOrderBookData(book: LocalBook): OrderBookData {
   return {
-    asks: depthToLevels(payload.askDepth, "ask"),
-    bids: depthToLevels(payload.bidDepth, "bid"),
+    asks: depthToLevels(book.askDepth, "ask"),
+    bids: depthToLevels(book.bidDepth, "bid"),
   }
 }
 
@@ -55,15 +76,20 @@ export function useMarketSocket(
   openOrders: OpenOrder[]
   addOpenOrder: (order: OpenOrder) => void
   cancelOrder: (orderId: string, cancelQty?: number) => void
+  ticker: WsTickerPayload | null
 } {
   const [orderBook, setOrderBook] = useState<OrderBookData>({ asks: [], bids: [] })
   const [livePrice, setLivePrice] = useState<number | null>(null)
   const [wsStatus, setWsStatus] = useState<WsStatus>("connecting")
   const [openOrders, setOpenOrders] = useState<OpenOrder[]>([])
+  const [ticker, setTicker] = useState<WsTickerPayload | null>(null)
 
   const wsRef = useRef<WebSocket | null>(null)
   const reconnectTimer = useRef<ReturnType<typeof setTimeout> | null>(null)
   const mountedRef = useRef(true)
+  const bookRef = useRef<LocalBook | null>(null)
+  // deltas that arrived while waiting for ORDERBOOK_DATA
+  const pendingDeltas = useRef<WsDepthDeltaPayload[]>([])
 
   const addOpenOrder = useCallback((order: OpenOrder) => {
     setOpenOrders((prev) =>
@@ -96,18 +122,32 @@ export function useMarketSocket(
       ws.send(
         JSON.stringify({
           type: "SUBSCRIBE_MARKET",
-          payload: { marketID: marketId, ...(userId ? { userID: userId } : {}) },
+          // The WS server knows the user from the access_token cookie sent on connect, so
+          // the socket is opened again when userId changes.
+          payload: { marketID: marketId },
         })
       )
     },
+    // eslint-disable-next-line react-hooks/exhaustive-deps
     [marketId, userId]
   )
 
+  // resync drops the local book and asks for the full one after a missed delta.
+  const resync = useCallback(
+    (ws: WebSocket) => {
+      bookRef.current = null
+      ws.send(JSON.stringify({ type: "RESYNC_DEPTH", payload: { marketID: marketId } }))
+    },
+    [marketId]
+  )
+
   const connect = useCallback(() => {
     if (!mountedRef.current) return
 
     const ws = new WebSocket(WS_URL)
     wsRef.current = ws
+    bookRef.current = null
+    pendingDeltas.current = []
     setWsStatus("connecting")
 
     ws.onopen = () => {
@@ -120,14 +160,101 @@ export function useMarketSocket(
       try {
         const msg: WsMessage = JSON.parse(event.data as string)
 
-        if (msg.type === "ORDERBOOK_DATA" || msg.type === "ORDERBOOK_UPDATE") {
+        if (msg.type === "ORDERBOOK_DATA") {
           const payload = msg.payload as WsOrderbookPayload
-          setOrderBook(applyDepth(payload))
+          const book: LocalBook = {
+            bidDepth: { ...payload.bidDepth },
+            askDepth: { ...payload.askDepth },
+            depthSeq: payload.depthSeq,
+            epoch: msg.epoch,
+          }
+          let inSync = true
+          for (const delta of pendingDeltas.current) {
+            if (delta.depthSeq <= book.depthSeq) continue
+            if (delta.depthSeq !== book.depthSeq + 1) { inSync = false; break }
+            applyLevels(book.bidDepth, delta.bids)
+            applyLevels(book.askDepth, delta.asks)
+            book.depthSeq = delta.depthSeq
+          }
+          pendingDeltas.current = []
+          if (!inSync) {
+            resync(ws)
+            return
+          }
+          bookRef.current = book
+          setOrderBook(toOrderBookData(book))
           if (payload.currentPrice > 0) setLivePrice(payload.currentPrice / 100)
           setWsStatus("live")
           return
         }
 
+        if (msg.type === "DEPTH_DELTA") {
+          const payload = msg.payload as WsDepthDeltaPayload
+          if (payload.currentPrice > 0) setLivePrice(payload.currentPrice / 100)
+          const book = bookRef.current
+          if (!book) {
+            pendingDeltas.current.push(payload)
+            return
+          }
+          // A new epoch is a restarted market, whose numbering starts over.
+          if (msg.epoch !== book.epoch || payload.depthSeq > book.depthSeq + 1) {
+            pendingDeltas.current.push(payload)
+            resync(ws)
+            return
+          }
+          if (payload.depthSeq <= book.depthSeq) return
+          applyLevels(book.bidDepth, payload.bids)
+          applyLevels(book.askDepth, payload.asks)
+          book.depthSeq = payload.depthSeq
+          setOrderBook(toOrderBookData(book))
+          return
+        }
+
+        if (msg.type === "TICKER") {
+          const payload = msg.payload as WsTickerPayload
+          if (payload.marketId === marketId) setTicker(payload)
+          return
+        }
+
+        if (msg.type === "ORDER_ACCEPTED") {
+          const payload = msg.payload as WsOrderEventPayload
+          if (payload.marketId !== marketId || !payload.side) return
+          addOpenOrder({
+            orderId: payload.orderId,
+            side: payload.side,
+            price: (payload.price ?? 0) / 100,
+            quantity: payload.quantity ?? 0,
+            filled: 0,
+            status: "open",
+          })
+          return
+        }
+
+        if (msg.type === "ORDER_PARTIALLY_FILLED" || msg.type === "ORDER_FILLED") {
+          const payload = msg.payload as WsOrderFillPayload
+          if (payload.marketId !== marketId) return
+          setOpenOrders((prev) =>
+            payload.remaining === 0
+              ? prev.filter((o) => o.orderId !== payload.orderId)
+              : prev.map((o) =>
+                  o.orderId === payload.orderId
+                    ? { ...o, filled: Math.max(o.filled, o.quantity - payload.remaining) }
+                    : o
+                )
+          )
+          if (payload.maker) {
+            toast.success(`${payload.side} order filled: ${payload.quantity} @ ${payload.price / 100}`)
+          }
+          return
+        }
+
+        if (msg.type === "ORDER_REJECTED") {
+          const payload = msg.payload as WsOrderEventPayload
+          if (payload.marketId !== marketId) return
+          setOpenOrders((prev) => prev.filter((o) => o.orderId !== payload.orderId))
+          return
+        }
+
         if (msg.type === "ORDER_CANCELLED") {
           const payload = msg.payload as WsOrderCancelledPayload
           if (payload.success) {
@@ -168,7 +295,7 @@ export function useMarketSocket(
     ws.onerror = () => {
       ws.close()
     }
-  }, [subscribe])
+  }, [subscribe, resync, addOpenOrder, marketId])
 
   // Fetch persisted open orders from DB when userId is known
   useEffect(() => {
@@ -209,5 +336,5 @@ export function useMarketSocket(
     }
   }, [connect])
 
-  return { orderBook, livePrice, wsStatus, openOrders, addOpenOrder, cancelOrder }
+  return { orderBook, livePrice, wsStatus, openOrders, addOpenOrder, cancelOrder, ticker }
 }
@@ -9,6 +9,7 @@ import (
 	config "github.com/raiashpanda007/rivon/dbwritter/internals/Config"
 	db "github.com/raiashpanda007/rivon/dbwritter/internals/Db"
 	candles "github.com/raiashpanda007/rivon/dbwritter/internals/Candles"
+	marketstats "github.com/raiashpanda007/rivon/dbwritter/internals/MarketStats"
 	repowriter "github.com/raiashpanda007/rivon/dbwritter/internals/RepoWriter"
 	tradestreamreader "github.com/raiashpanda007/rivon/dbwritter/internals/TradeStreamReader"
 	tsdb "github.com/raiashpanda007/rivon/dbwritter/internals/TSDB"
@@ -35,15 +36,25 @@ func main() {
 		panic(err)
 	}
 
+	wsRedisClient, err := redisconnection.ConnectToTradeStream(cfg.WS_PUB_SUB_REDIS_URL)
+	if err != nil {
+		panic(err)
+	}
+
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
 	writer := repowriter.NewRepoWriter(pool)
 	tsdbWriter := tsdb.NewTSDBWriter(pool)
 	candleSvc := candles.NewCandleService(pool, redisClient)
+	statsSvc := marketstats.NewStatsService(pool, wsRedisClient)
+	if err := statsSvc.Load(ctx); err != nil {
+		panic(err)
+	}
 
 	go tsdbWriter.StartFlushLoop(ctx)
 	go candleSvc.StartAllPublishers(ctx)
+	go statsSvc.Start(ctx)
 
-	tradestreamreader.InitTradeConsumer(ctx, redisClient, writer, tsdbWriter)
+	tradestreamreader.InitTradeConsumer(ctx, redisClient, writer, tsdbWriter, statsSvc)
 }
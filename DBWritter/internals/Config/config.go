@@ -13,6 +13,8 @@ type Config struct {
 	ENVIROMENT      string
 	TRADE_REDIS_URL string
 	PG_DB_URL       string
+	// WS_PUB_SUB_REDIS_URL is the Redis the WS server reads WS_OUT from; TICKER goes there.
+	WS_PUB_SUB_REDIS_URL string
 }
 
 func mustEnv(key string) string {
@@ -36,5 +38,6 @@ func MustLoad() *Config {
 	cfg.ENVIROMENT = mustEnv("ENVIROMENT")
 	cfg.PG_DB_URL = mustEnv("PG_DB_URL")
 	cfg.TRADE_REDIS_URL = mustEnv("TRADE_REDIS_URL")
+	cfg.WS_PUB_SUB_REDIS_URL = mustEnv("WS_PUB_SUB_REDIS_URL")
 	return &cfg
 }
@@ -0,0 +1,310 @@
+package marketstats
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	repowriter "github.com/raiashpanda007/rivon/dbwritter/internals/RepoWriter"
+)
+
+const (
+	window      = 24 * time.Hour
+	bucketWidth = time.Minute
+	// tickerInterval is how often changed markets get a TICKER; persistInterval how often
+	// the markets table is brought up to date.
+	tickerInterval  = time.Second
+	persistInterval = 10 * time.Second
+)
+
+// Ticker is the rolling 24h statistics of a market, sent as the payload of TICKER.
+type Ticker struct {
+	MarketId      string `json:"marketId"`
+	LastPrice     int64  `json:"lastPrice"`
+	OpenPrice24H  int64  `json:"openPrice24h"`
+	High24H       int64  `json:"high24h"`
+	Low24H        int64  `json:"low24h"`
+	Volume24H     int64  `json:"volume24h"`
+	TradeCount24H int64  `json:"tradeCount24h"`
+	TotalVolume   int64  `json:"totalVolume"`
+}
+
+// tickerMessage is a TICKER in the shape the Engine publishes on WS_OUT.
+type tickerMessage struct {
+	Type    string `json:"type"`
+	Payload Ticker `json:"payload"`
+}
+
+// bucket aggregates the trades of one minute.
+type bucket struct {
+	start  time.Time
+	open   int64
+	high   int64
+	low    int64
+	volume int64
+	trades int64
+}
+
+// marketStats keeps the last 24h of a market's trades in minute buckets, oldest first. The
+// window moves a bucket at a time, so it spans between 24h and 24h and one minute.
+type marketStats struct {
+	buckets     []bucket
+	lastPrice   int64
+	lastAt      time.Time
+	totalVolume int64
+}
+
+func (m *marketStats) observe(at time.Time, price, quantity int64) {
+	m.totalVolume += quantity
+	if !at.Before(m.lastAt) {
+		m.lastPrice, m.lastAt = price, at
+	}
+
+	start := at.Truncate(bucketWidth)
+	i := sort.Search(len(m.buckets), func(i int) bool { return !m.buckets[i].start.Before(start) })
+	if i == len(m.buckets) || !m.buckets[i].start.Equal(start) {
+		m.buckets = append(m.buckets, bucket{})
+		copy(m.buckets[i+1:], m.buckets[i:])
+		m.buckets[i] = bucket{start: start, open: price, high: price, low: price}
+	}
+	b := &m.buckets[i]
+	b.high = max(b.high, price)
+	b.low = min(b.low, price)
+	b.volume += quantity
+	b.trades++
+}
+
+// restore appends b, a bucket of the window read back from trades, newest last. lastPrice
+// is the price of its last trade at lastAt, which the markets table may not have yet.
+func (m *marketStats) restore(b bucket, lastPrice int64, lastAt time.Time) {
+	m.buckets = append(m.buckets, b)
+	if !lastAt.Before(m.lastAt) {
+		m.lastPrice, m.lastAt = lastPrice, lastAt
+	}
+}
+
+// ticker drops the buckets that have left the window at now and sums the rest. A market
+// that has not traded in the window opens, highs and lows at its last price.
+func (m *marketStats) ticker(marketId string, now time.Time) Ticker {
+	cutoff := now.Add(-window).Truncate(bucketWidth)
+	i := sort.Search(len(m.buckets), func(i int) bool { return !m.buckets[i].start.Before(cutoff) })
+	m.buckets = m.buckets[i:]
+
+	t := Ticker{
+		MarketId:     marketId,
+		LastPrice:    m.lastPrice,
+		OpenPrice24H: m.lastPrice,
+		High24H:      m.lastPrice,
+		Low24H:       m.lastPrice,
+		TotalVolume:  m.totalVolume,
+	}
+	for i, b := range m.buckets {
+		if i == 0 {
+			t.OpenPrice24H, t.High24H, t.Low24H = b.open, b.high, b.low
+		}
+		t.High24H = max(t.High24H, b.high)
+		t.Low24H = min(t.Low24H, b.low)
+		t.Volume24H += b.volume
+		t.TradeCount24H += b.trades
+	}
+	return t
+}
+
+// StatsService maintains the rolling 24h statistics of every market from the fills on the
+// TRADES stream, writes them back to the markets table and publishes them as TICKER.
+type StatsService struct {
+	db        *pgxpool.Pool
+	wsRedis   *redis.Client
+	mu        sync.Mutex
+	markets   map[string]*marketStats
+	published map[string]Ticker
+	persisted map[string]Ticker
+}
+
+func NewStatsService(db *pgxpool.Pool, wsRedis *redis.Client) *StatsService {
+	return &StatsService{
+		db:        db,
+		wsRedis:   wsRedis,
+		markets:   map[string]*marketStats{},
+		published: map[string]Ticker{},
+		persisted: map[string]Ticker{},
+	}
+}
+
+// Load reads the last price and all-time volume of every market from the markets table,
+// which persist keeps, and rebuilds only the 24h window from trades, which the RepoWriter
+// commits with each TRADES entry. It runs before the trade consumer starts; a fill already
+// in trades is not applied again when its entry is redelivered, so Observe never sees it
+// twice. The all-time volume of fills written after the last persist before a crash is
+// not recovered.
+func (s *StatsService) Load(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rows, err := s.db.Query(ctx, `SELECT id::text, last_price, total_volume FROM markets`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id string
+		m := &marketStats{}
+		if err := rows.Scan(&id, &m.lastPrice, &m.totalVolume); err != nil {
+			rows.Close()
+			return err
+		}
+		s.markets[id] = m
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = s.db.Query(ctx, `
+		SELECT market_id::text, time_bucket($1::interval, created_at) AS start,
+		       first(price, created_at), max(price), min(price), sum(quantity), count(*),
+		       last(price, created_at), max(created_at)
+		FROM trades
+		WHERE created_at >= time_bucket($1::interval, NOW() - $2::interval)
+		GROUP BY 1, 2
+		ORDER BY 1, 2`,
+		"1 minute", "24 hours",
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var b bucket
+		var lastPrice int64
+		var lastAt time.Time
+		if err := rows.Scan(&id, &b.start, &b.open, &b.high, &b.low, &b.volume, &b.trades, &lastPrice, &lastAt); err != nil {
+			return err
+		}
+		m, ok := s.markets[id]
+		if !ok {
+			continue
+		}
+		m.restore(b, lastPrice, lastAt)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	slog.Info("StatsService: loaded market statistics", "markets", len(s.markets))
+	return nil
+}
+
+// Observe adds the fills of a TRADES entry executed at executedAt that the RepoWriter has
+// just written.
+func (s *StatsService) Observe(marketId string, executedAt time.Time, fills []repowriter.Fill) {
+	if len(fills) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.markets[marketId]
+	if !ok {
+		m = &marketStats{}
+		s.markets[marketId] = m
+	}
+	// Fills are a microsecond apart so the last one sets the last price.
+	for i, f := range fills {
+		m.observe(executedAt.Add(time.Duration(i)*time.Microsecond), int64(f.Price), int64(f.Quantity))
+	}
+}
+
+// tickers returns the statistics of every market as of now.
+func (s *StatsService) tickers(now time.Time) []Ticker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tickers := make([]Ticker, 0, len(s.markets))
+	for id, m := range s.markets {
+		tickers = append(tickers, m.ticker(id, now))
+	}
+	return tickers
+}
+
+// Start publishes changed statistics every tickerInterval and persists them every
+// persistInterval until ctx is done, persisting once more on the way out.
+func (s *StatsService) Start(ctx context.Context) {
+	publish := time.NewTicker(tickerInterval)
+	defer publish.Stop()
+	persist := time.NewTicker(persistInterval)
+	defer persist.Stop()
+
+	for {
+		select {
+		case now := <-publish.C:
+			s.publish(ctx, s.tickers(now))
+		case now := <-persist.C:
+			s.persist(ctx, s.tickers(now))
+		case <-ctx.Done():
+			s.persist(context.Background(), s.tickers(time.Now()))
+			return
+		}
+	}
+}
+
+// publish sends TICKER on WS_OUT_<marketId> for every market whose statistics changed since
+// it was last sent, the window moving included.
+func (s *StatsService) publish(ctx context.Context, tickers []Ticker) {
+	for _, t := range tickers {
+		if s.published[t.MarketId] == t {
+			continue
+		}
+		data, err := json.Marshal(tickerMessage{Type: "TICKER", Payload: t})
+		if err != nil {
+			slog.Error("StatsService: marshal failed", "err", err)
+			continue
+		}
+		if err := s.wsRedis.Publish(ctx, "WS_OUT_"+t.MarketId, string(data)).Err(); err != nil {
+			slog.Error("StatsService: ticker publish failed", "marketId", t.MarketId, "err", err)
+			continue
+		}
+		s.published[t.MarketId] = t
+	}
+}
+
+// persist writes back the statistics of every market that changed since the last write.
+func (s *StatsService) persist(ctx context.Context, tickers []Ticker) {
+	batch := &pgx.Batch{}
+	var queued []Ticker
+	for _, t := range tickers {
+		if last, ok := s.persisted[t.MarketId]; ok && last == t {
+			continue
+		}
+		batch.Queue(`
+			UPDATE markets
+			   SET last_price = $2,
+			       open_price_24h = $3,
+			       high_24h = $4,
+			       low_24h = $5,
+			       volume_24h = $6,
+			       trade_count_24h = $7,
+			       total_volume = $8,
+			       updated_at = NOW()
+			 WHERE id = $1::uuid`,
+			t.MarketId, t.LastPrice, t.OpenPrice24H, t.High24H, t.Low24H, t.Volume24H, t.TradeCount24H, t.TotalVolume,
+		)
+		queued = append(queued, t)
+	}
+	if len(queued) == 0 {
+		return
+	}
+
+	results := s.db.SendBatch(ctx, batch)
+	defer results.Close()
+	for _, t := range queued {
+		if _, err := results.Exec(); err != nil {
+			slog.Error("StatsService: failed to write market statistics", "marketId", t.MarketId, "err", err)
+			continue
+		}
+		s.persisted[t.MarketId] = t
+	}
+}
@@ -0,0 +1,106 @@
+package marketstats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	repowriter "github.com/raiashpanda007/rivon/dbwritter/internals/RepoWriter"
+)
+
+var t0 = time.Date(2026, 1, 1, 12, 0, 30, 0, time.UTC)
+
+func TestWindowRollsOver(t *testing.T) {
+	m := &marketStats{}
+	m.observe(t0, 100, 2)
+	m.observe(t0.Add(10*time.Second), 90, 1) // same minute
+	m.observe(t0.Add(time.Hour), 110, 4)
+
+	got := m.ticker("m1", t0.Add(2*time.Hour))
+	want := Ticker{MarketId: "m1", LastPrice: 110, OpenPrice24H: 100, High24H: 110, Low24H: 90, Volume24H: 7, TradeCount24H: 3, TotalVolume: 7}
+	if got != want {
+		t.Fatalf("ticker %+v, want %+v", got, want)
+	}
+
+	// A day and a minute later the first minute has left the window; the total has not.
+	got = m.ticker("m1", t0.Add(window+bucketWidth))
+	want = Ticker{MarketId: "m1", LastPrice: 110, OpenPrice24H: 110, High24H: 110, Low24H: 110, Volume24H: 4, TradeCount24H: 1, TotalVolume: 7}
+	if got != want {
+		t.Fatalf("ticker %+v, want %+v", got, want)
+	}
+}
+
+func TestEmptyWindowOpensAtTheLastPrice(t *testing.T) {
+	m := &marketStats{}
+	m.observe(t0, 100, 2)
+	got := m.ticker("m1", t0.Add(2*window))
+	want := Ticker{MarketId: "m1", LastPrice: 100, OpenPrice24H: 100, High24H: 100, Low24H: 100, TotalVolume: 2}
+	if got != want || len(m.buckets) != 0 {
+		t.Fatalf("ticker %+v with %d buckets, want %+v", got, len(m.buckets), want)
+	}
+}
+
+func TestLateFillKeepsTheLastPrice(t *testing.T) {
+	m := &marketStats{}
+	m.observe(t0.Add(5*time.Minute), 100, 1)
+	m.observe(t0, 80, 1)
+	got := m.ticker("m1", t0.Add(time.Hour))
+	if got.LastPrice != 100 || got.OpenPrice24H != 80 || got.Low24H != 80 || len(m.buckets) != 2 {
+		t.Fatalf("ticker %+v, want the later fill's price with the earlier one opening", got)
+	}
+}
+
+func TestRestoreContinuesFromThePersistedTotals(t *testing.T) {
+	// As Load leaves a market: totals from the markets table, the window from trades.
+	m := &marketStats{lastPrice: 95, totalVolume: 1000}
+	m.restore(bucket{start: t0.Truncate(bucketWidth), open: 99, high: 101, low: 98, volume: 5, trades: 2}, 101, t0)
+	m.restore(bucket{start: t0.Add(time.Hour).Truncate(bucketWidth), open: 102, high: 102, low: 102, volume: 1, trades: 1}, 102, t0.Add(time.Hour))
+
+	got := m.ticker("m1", t0.Add(2*time.Hour))
+	want := Ticker{MarketId: "m1", LastPrice: 102, OpenPrice24H: 99, High24H: 102, Low24H: 98, Volume24H: 6, TradeCount24H: 3, TotalVolume: 1000}
+	if got != want {
+		t.Fatalf("restored ticker %+v, want %+v", got, want)
+	}
+
+	m.observe(t0.Add(2*time.Hour), 97, 3)
+	got = m.ticker("m1", t0.Add(2*time.Hour))
+	if got.LastPrice != 97 || got.Low24H != 97 || got.Volume24H != 9 || got.TotalVolume != 1003 {
+		t.Fatalf("ticker after a new fill %+v", got)
+	}
+}
+
+func TestRestoreWithoutTradesKeepsTheMarketsTable(t *testing.T) {
+	m := &marketStats{lastPrice: 95, totalVolume: 1000}
+	got := m.ticker("m1", t0)
+	if got.LastPrice != 95 || got.OpenPrice24H != 95 || got.Volume24H != 0 || got.TotalVolume != 1000 {
+		t.Fatalf("ticker %+v, want the persisted price and total", got)
+	}
+}
+
+func TestObserveTakesTheLastFillOfAnEntry(t *testing.T) {
+	s := NewStatsService(nil, nil)
+	s.Observe("m1", t0, nil)
+	if len(s.markets) != 0 {
+		t.Fatal("an entry without fills added a market")
+	}
+
+	s.Observe("m1", t0, []repowriter.Fill{{Price: 100, Quantity: 1}, {Price: 105, Quantity: 2}})
+	tickers := s.tickers(t0)
+	if len(tickers) != 1 {
+		t.Fatalf("%d tickers", len(tickers))
+	}
+	if got := tickers[0]; got.LastPrice != 105 || got.Volume24H != 3 || got.TradeCount24H != 2 {
+		t.Fatalf("ticker %+v", got)
+	}
+}
+
+func TestUnpublishedTickerIsSentAgain(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	defer client.Close()
+	s := NewStatsService(nil, client)
+	s.publish(context.Background(), []Ticker{{MarketId: "m1", LastPrice: 100}})
+	if _, ok := s.published["m1"]; ok {
+		t.Fatal("a ticker that was not published is recorded as sent")
+	}
+}
@@ -12,6 +12,9 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// adminUserId owns each market's unsold supply; settlement retires it without a payout.
+const adminUserId = "00000000-0000-0000-0000-000000000001"
+
 type Fill struct {
 	Price        int    `json:"Price"`
 	Quantity     int    `json:"Quantity"`
@@ -32,6 +35,13 @@ type TradeMessage struct {
 	ExecutedQty int64
 	Price       int64
 	Fills       []Fill
+	Status      string // market status, market_status entries only
+	// FencingToken is the market lease the Engine wrote the entry under; 0 from engines
+	// that predate leases.
+	FencingToken int64
+	// Seq numbers the market's TRADES entries, one after another; 0 from engines that
+	// predate it.
+	Seq int64
 }
 
 type RepoWriter struct {
@@ -63,36 +73,60 @@ func ParseTradeMessage(values map[string]interface{}) (*TradeMessage, error) {
 	}
 
 	return &TradeMessage{
-		TradeType:   getString("tradeType"),
-		MarketId:    getString("marketId"),
-		OrderId:     getString("orderId"),
-		UserId:      getString("userId"),
-		Side:        getString("side"),
-		Quantity:    getInt64("quantity"),
-		ExecutedQty: getInt64("executedQty"),
-		Price:       getInt64("price"),
-		Fills:       fills,
+		TradeType:    getString("tradeType"),
+		MarketId:     getString("marketId"),
+		OrderId:      getString("orderId"),
+		UserId:       getString("userId"),
+		Side:         getString("side"),
+		Quantity:     getInt64("quantity"),
+		ExecutedQty:  getInt64("executedQty"),
+		Price:        getInt64("price"),
+		Fills:        fills,
+		Status:       getString("status"),
+		FencingToken: getInt64("fencingToken"),
+		Seq:          getInt64("seq"),
 	}, nil
 }
 
-func (r *RepoWriter) ProcessTradeMessage(ctx context.Context, msg TradeMessage) error {
+// ProcessTradeMessage applies a TRADES entry in one transaction and returns the fills it
+// wrote. Fills already in trades were applied when the entry was delivered before, so a
+// redelivered entry returns none of them.
+func (r *RepoWriter) ProcessTradeMessage(ctx context.Context, msg TradeMessage) ([]Fill, error) {
 	tx, err := r.db.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("begin tx: %w", err)
+		return nil, fmt.Errorf("begin tx: %w", err)
 	}
 	defer tx.Rollback(ctx)
 
 	if msg.TradeType == "order_cancelled" {
 		if msg.OrderId != "" {
 			if err := r.cancelOrder(ctx, tx, msg.OrderId); err != nil {
-				return fmt.Errorf("cancel order %s: %w", msg.OrderId, err)
+				return nil, fmt.Errorf("cancel order %s: %w", msg.OrderId, err)
 			}
 		}
-		return tx.Commit(ctx)
+		return nil, tx.Commit(ctx)
+	}
+	if msg.TradeType == "market_status" {
+		if err := r.updateMarketStatus(ctx, tx, msg.MarketId, msg.Status); err != nil {
+			return nil, fmt.Errorf("update market %s status: %w", msg.MarketId, err)
+		}
+		return nil, tx.Commit(ctx)
+	}
+	if msg.TradeType == "market_settled" {
+		if err := r.settleMarket(ctx, tx, msg.MarketId, msg.Price); err != nil {
+			return nil, fmt.Errorf("settle market %s: %w", msg.MarketId, err)
+		}
+		return nil, tx.Commit(ctx)
+	}
+	if msg.TradeType == "order_replaced" {
+		if err := r.replaceOrder(ctx, tx, msg.OrderId, msg.Price, msg.Quantity); err != nil {
+			return nil, fmt.Errorf("replace order %s: %w", msg.OrderId, err)
+		}
+		return nil, tx.Commit(ctx)
 	}
 	if len(msg.Fills) == 0 {
 		// Queued order with no immediate match — already written as 'pending', nothing to update.
-		return tx.Commit(ctx)
+		return nil, tx.Commit(ctx)
 	}
 
 	takerStatus := "partial"
@@ -101,7 +135,7 @@ func (r *RepoWriter) ProcessTradeMessage(ctx context.Context, msg TradeMessage)
 	}
 
 	if err := r.upsertOrder(ctx, tx, msg.OrderId, msg.UserId, msg.MarketId, msg.Side, msg.Price, msg.Quantity, msg.ExecutedQty, takerStatus); err != nil {
-		return fmt.Errorf("upsert taker order %s: %w", msg.OrderId, err)
+		return nil, fmt.Errorf("upsert taker order %s: %w", msg.OrderId, err)
 	}
 
 	makerSide := "SELL"
@@ -109,17 +143,19 @@ func (r *RepoWriter) ProcessTradeMessage(ctx context.Context, msg TradeMessage)
 		makerSide = "BUY"
 	}
 
+	var applied []Fill
 	for _, fill := range msg.Fills {
 		rowsAffected, err := r.writeTrade(ctx, tx, fill.TradeId, msg.MarketId, fill.OrderId, fill.OtherOrderId, fill.Price, fill.Quantity)
 		if err != nil {
-			return fmt.Errorf("write trade %s: %w", fill.TradeId, err)
+			return nil, fmt.Errorf("write trade %s: %w", fill.TradeId, err)
 		}
 		if rowsAffected == 0 {
 			continue // already processed — idempotency
 		}
+		applied = append(applied, fill)
 
 		if err := r.upsertMakerOrder(ctx, tx, fill.OtherOrderId, fill.OtherUserId, msg.MarketId, makerSide, fill.Price, fill.Quantity); err != nil {
-			return fmt.Errorf("upsert maker order %s: %w", fill.OtherOrderId, err)
+			return nil, fmt.Errorf("upsert maker order %s: %w", fill.OtherOrderId, err)
 		}
 
 		var buyerId, sellerId, takerOrderId, makerOrderId string
@@ -132,11 +168,14 @@ func (r *RepoWriter) ProcessTradeMessage(ctx context.Context, msg TradeMessage)
 		}
 		amount := int64(fill.Price) * int64(fill.Quantity)
 		if err := r.settleWallets(ctx, tx, buyerId, sellerId, msg.MarketId, fill.TradeId, takerOrderId, makerOrderId, amount, int64(fill.Quantity), int64(fill.Price)); err != nil {
-			return fmt.Errorf("settle wallets for trade %s: %w", fill.TradeId, err)
+			return nil, fmt.Errorf("settle wallets for trade %s: %w", fill.TradeId, err)
 		}
 	}
 
-	return tx.Commit(ctx)
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return applied, nil
 }
 
 func (r *RepoWriter) cancelOrder(ctx context.Context, tx pgx.Tx, orderId string) error {
@@ -148,6 +187,80 @@ func (r *RepoWriter) cancelOrder(ctx context.Context, tx pgx.Tx, orderId string)
 	return err
 }
 
+// updateMarketStatus records a circuit breaker halt ('suspended') or resume ('open'). A settled
+// market stays 'closed'.
+func (r *RepoWriter) updateMarketStatus(ctx context.Context, tx pgx.Tx, marketId, status string) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE markets SET status = $2::market_state, updated_at = NOW()
+		WHERE id = $1 AND status <> 'closed'`,
+		marketId, status,
+	)
+	return err
+}
+
+// settleMarket closes a market at its settlement price and pays every holder quantity * price
+// with a 'settle' transaction. The Engine cancels resting orders on TRADES first, so only the
+// assets table is left to clear. A market that is already settled is left alone, so a
+// redelivered entry pays nobody twice.
+func (r *RepoWriter) settleMarket(ctx context.Context, tx pgx.Tx, marketId string, price int64) error {
+	tag, err := tx.Exec(ctx, `
+		UPDATE markets
+		   SET status = 'closed', settlement_price = $2, settled_at = NOW(), last_price = $2, updated_at = NOW()
+		 WHERE id = $1 AND settled_at IS NULL`,
+		marketId, price,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	if price > 0 {
+		// CTE reads pre-update balance to satisfy valid_balance_transition CHECK.
+		_, err = tx.Exec(ctx, `
+			WITH holders AS (
+			    SELECT user_id, quantity * $2::bigint AS amount
+			      FROM assets
+			     WHERE market_id = $1 AND quantity > 0 AND user_id <> $3
+			), upd AS (
+			    UPDATE wallets w
+			       SET balance = w.balance + h.amount, updated_at = NOW()
+			      FROM holders h
+			     WHERE w.user_id = h.user_id
+			    RETURNING w.id AS wallet_id, h.amount, w.balance - h.amount AS balance_before, w.balance AS balance_after
+			)
+			INSERT INTO transactions (id, wallet_id, type, amount, balance_before, balance_after, market_id)
+			SELECT gen_random_uuid(), upd.wallet_id, 'settle', upd.amount, upd.balance_before, upd.balance_after, $1::uuid
+			FROM upd`,
+			marketId, price, adminUserId,
+		)
+		if err != nil {
+			return fmt.Errorf("pay out holders: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE assets SET quantity = 0, locked_qty = 0, updated_at = NOW()
+		WHERE market_id = $1 AND (quantity > 0 OR locked_qty > 0)`,
+		marketId,
+	)
+	if err != nil {
+		return fmt.Errorf("clear assets: %w", err)
+	}
+	return nil
+}
+
+// replaceOrder applies an amended price and quantity; fills from the re-queue follow as an order_updated entry.
+func (r *RepoWriter) replaceOrder(ctx context.Context, tx pgx.Tx, orderId string, price, quantity int64) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE orders SET price = $2, quantity = $3, updated_at = NOW()
+		WHERE id = $1 AND status NOT IN ('filled', 'cancelled')`,
+		orderId, price, quantity,
+	)
+	return err
+}
+
 // upsertOrder uses GREATEST for executed_qty — idempotent across retries.
 func (r *RepoWriter) upsertOrder(ctx context.Context, tx pgx.Tx, orderId, userId, marketId, side string, price, quantity, executedQty int64, status string) error {
 	_, err := tx.Exec(ctx, `
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	marketstats "github.com/raiashpanda007/rivon/dbwritter/internals/MarketStats"
 	repowriter "github.com/raiashpanda007/rivon/dbwritter/internals/RepoWriter"
 	tsdb "github.com/raiashpanda007/rivon/dbwritter/internals/TSDB"
 )
@@ -19,18 +20,55 @@ func createGroupForTradeStream(redisClient *redis.Client, ctx context.Context) {
 	}
 }
 
-func InitTradeConsumer(ctx context.Context, tradeRedisStreamClient *redis.Client, writer *repowriter.RepoWriter, tsdbWriter *tsdb.TSDBWriter) {
+// fencesKey is a hash of the newest lease token DBWritter has seen per market. It outlives a
+// restart, so entries from a stale owner still pending on TRADES are dropped afterwards too.
+const fencesKey = "TRADES_FENCES"
+
+// loadFences reads the lease tokens recorded under fencesKey.
+func loadFences(ctx context.Context, redisClient *redis.Client) map[string]int64 {
+	fences := map[string]int64{}
+	stored, err := redisClient.HGetAll(ctx, fencesKey).Result()
+	if err != nil {
+		slog.Error("Unable to read market fencing tokens", "err", err)
+		return fences
+	}
+	for marketId, v := range stored {
+		token, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			slog.Error("Skipping unreadable fencing token", "marketId", marketId, "value", v)
+			continue
+		}
+		fences[marketId] = token
+	}
+	return fences
+}
+
+func InitTradeConsumer(ctx context.Context, tradeRedisStreamClient *redis.Client, writer *repowriter.RepoWriter, tsdbWriter *tsdb.TSDBWriter, statsSvc *marketstats.StatsService) {
 	slog.Info("Starting up trade consumer readers")
 
 	createGroupForTradeStream(tradeRedisStreamClient, ctx)
 
 	retries := map[string]int{}
+	// fences is the newest lease token seen per market. Entries under an older token come
+	// from an Engine that lost the market to another node and are dropped.
+	fences := loadFences(ctx, tradeRedisStreamClient)
+	// seqs is the last sequence number written per market under its current lease token. A
+	// number already seen is an entry written twice; a skipped one is an entry lost.
+	seqs := map[string]int64{}
+
+	// Entries this consumer read but did not ack, left by a restart or a failed write, are
+	// read again ("0") before new ones (">"), so they are retried in stream order.
+	readPending := true
 
 	for {
+		readId := ">"
+		if readPending {
+			readId = "0"
+		}
 		streams, err := tradeRedisStreamClient.XReadGroup(ctx, &redis.XReadGroupArgs{
 			Group:    "DB_WRITTER",
 			Consumer: "DB_WRITTER_1",
-			Streams:  []string{"TRADES", ">"},
+			Streams:  []string{"TRADES", readId},
 			Count:    10,
 			Block:    0,
 		}).Result()
@@ -44,6 +82,13 @@ func InitTradeConsumer(ctx context.Context, tradeRedisStreamClient *redis.Client
 			continue
 		}
 
+		if readPending && !hasMessages(streams) {
+			readPending = false
+			continue
+		}
+
+		failed := false
+	entries:
 		for _, stream := range streams {
 			for _, msg := range stream.Messages {
 				tradeMsg, err := repowriter.ParseTradeMessage(msg.Values)
@@ -53,6 +98,33 @@ func InitTradeConsumer(ctx context.Context, tradeRedisStreamClient *redis.Client
 					tradeRedisStreamClient.XAck(ctx, "TRADES", "DB_WRITTER", msg.ID)
 					continue
 				}
+				if tradeMsg.FencingToken > 0 {
+					if tradeMsg.FencingToken < fences[tradeMsg.MarketId] {
+						slog.Warn("Dropping entry from a stale market owner", "id", msg.ID, "marketId", tradeMsg.MarketId, "fencingToken", tradeMsg.FencingToken, "current", fences[tradeMsg.MarketId])
+						tradeRedisStreamClient.XAck(ctx, "TRADES", "DB_WRITTER", msg.ID)
+						continue
+					}
+					if tradeMsg.FencingToken > fences[tradeMsg.MarketId] {
+						// Recorded before the entry is applied: if that fails the entry is
+						// redelivered under the same token and still gets through.
+						if err := tradeRedisStreamClient.HSet(ctx, fencesKey, tradeMsg.MarketId, tradeMsg.FencingToken).Err(); err != nil {
+							slog.Error("Unable to record market fencing token", "marketId", tradeMsg.MarketId, "fencingToken", tradeMsg.FencingToken, "err", err)
+						}
+						delete(seqs, tradeMsg.MarketId)
+					}
+					fences[tradeMsg.MarketId] = tradeMsg.FencingToken
+				}
+				if tradeMsg.Seq > 0 {
+					last, seen := seqs[tradeMsg.MarketId]
+					if seen && tradeMsg.Seq <= last {
+						slog.Warn("Dropping duplicate entry", "id", msg.ID, "marketId", tradeMsg.MarketId, "seq", tradeMsg.Seq, "last", last)
+						tradeRedisStreamClient.XAck(ctx, "TRADES", "DB_WRITTER", msg.ID)
+						continue
+					}
+					if seen && tradeMsg.Seq > last+1 {
+						slog.Error("Gap in market entries", "marketId", tradeMsg.MarketId, "from", last+1, "to", tradeMsg.Seq-1)
+					}
+				}
 				// Stream entry IDs are "<ms-since-epoch>-<seq>"; use this as the
 				// trade execution time so candle buckets reflect when the trade happened.
 				if parts := strings.SplitN(msg.ID, "-", 2); len(parts) == 2 {
@@ -64,21 +136,42 @@ func InitTradeConsumer(ctx context.Context, tradeRedisStreamClient *redis.Client
 					tradeMsg.ExecutedAt = time.Now().UTC()
 				}
 
-				if err := writer.ProcessTradeMessage(ctx, *tradeMsg); err != nil {
+				applied, err := writer.ProcessTradeMessage(ctx, *tradeMsg)
+				if err != nil {
 					retries[msg.ID]++
 					slog.Error("ProcessTradeMessage failed", "id", msg.ID, "attempt", retries[msg.ID], "err", err)
 					if retries[msg.ID] >= 5 {
 						slog.Error("Dead-lettering message after 5 failed attempts", "id", msg.ID)
 						tradeRedisStreamClient.XAck(ctx, "TRADES", "DB_WRITTER", msg.ID)
 						delete(retries, msg.ID)
+						continue
 					}
-					continue
+					// The entries after it stay pending and are retried behind it.
+					failed = true
+					break entries
 				}
 
+				if tradeMsg.Seq > 0 {
+					seqs[tradeMsg.MarketId] = tradeMsg.Seq
+				}
 				tsdbWriter.Enqueue(*tradeMsg)
+				statsSvc.Observe(tradeMsg.MarketId, tradeMsg.ExecutedAt, applied)
 				delete(retries, msg.ID)
 				tradeRedisStreamClient.XAck(ctx, "TRADES", "DB_WRITTER", msg.ID)
 			}
 		}
+		if failed {
+			readPending = true
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+func hasMessages(streams []redis.XStream) bool {
+	for _, stream := range streams {
+		if len(stream.Messages) > 0 {
+			return true
+		}
 	}
+	return false
 }
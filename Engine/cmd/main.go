@@ -2,13 +2,21 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	config "github.com/raiashpanda007/rivon/engine/internals/Config"
 	database "github.com/raiashpanda007/rivon/engine/internals/Database"
 	engine "github.com/raiashpanda007/rivon/engine/internals/Engine"
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
 	pubsub "github.com/raiashpanda007/rivon/engine/internals/PubSub"
 	redis "github.com/raiashpanda007/rivon/engine/internals/Redis"
+	snapshots "github.com/raiashpanda007/rivon/engine/internals/Snapshots"
+	"github.com/raiashpanda007/rivon/engine/internals/markets"
 )
 
 func main() {
@@ -54,15 +62,75 @@ func main() {
 		slog.Error("ERROR :: IN CONNECTION TO WALLET MAP REDIS :: ", slog.Any("ERROR :: ", err))
 	}
 
+	snapshotBackend, err := newSnapshotBackend(cfg, db)
+	if err != nil {
+		slog.Error("ERROR :: IN SETTING UP SNAPSHOT STORE :: ", slog.Any("ERROR :: ", err))
+		return
+	}
+
 	pubsubSvc := pubsub.InitPubSub(ctx, apiPubSubRedisClient, wsInPubSubRedisClient, wsOutPubSubRedisClient)
 
-	if err := engine.InitEngine(ctx, orderRedis, tradeRedis, db, pubsubSvc, userWalletMapRedis); err != nil {
+	marketParams := markets.MarketParams{
+		MaxSlippageBps: cfg.MARKET_MAX_SLIPPAGE_BPS,
+		CircuitBreaker: orderbooks.BreakerParams{
+			MaxMoveBps: cfg.CIRCUIT_BREAKER_MAX_MOVE_BPS,
+			WindowMs:   int64(cfg.CIRCUIT_BREAKER_WINDOW_SEC) * 1000,
+			CooldownMs: int64(cfg.CIRCUIT_BREAKER_COOLDOWN_SEC) * 1000,
+		},
+		AuctionCallMs:   int64(cfg.AUCTION_CALL_SEC) * 1000,
+		TrimOrderStream: cfg.ORDER_STREAM_TRIM != 0,
+		// A node taking over a market reads no fs snapshot of this one.
+		PruneWalletOps: cfg.SNAPSHOT_BACKEND != "fs",
+		JournalDir:     cfg.JOURNAL_DIR,
+		Snapshots: snapshots.Store{
+			Backend:   snapshotBackend,
+			Retention: cfg.SNAPSHOT_RETENTION,
+		},
+	}
+
+	shardParams := engine.ShardParams{
+		NodeId:   cfg.ENGINE_NODE_ID,
+		LeaseTTL: time.Duration(cfg.MARKET_LEASE_TTL_SEC) * time.Second,
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	stopped, err := engine.InitEngine(ctx, orderRedis, tradeRedis, db, pubsubSvc, userWalletMapRedis, marketParams, shardParams)
+	if err != nil {
 		slog.Error("ERROR :: FAILED TO INITIALIZE ENGINE :: ", slog.Any("ERROR :: ", err))
 		cancel()
 		return
 	}
 	slog.Info("Trade engine running... Press Ctrl+C to stop")
 
-	select {}
+	// The markets are handed over to the other nodes before the process exits.
+	sig := <-signals
+	slog.Info("Stopping the trade engine", "signal", sig.String())
+	cancel()
+	<-stopped
+}
 
+// newSnapshotBackend builds the snapshot store SNAPSHOT_BACKEND names.
+func newSnapshotBackend(cfg *config.Config, db *database.Database) (snapshots.SnapshotStore, error) {
+	switch cfg.SNAPSHOT_BACKEND {
+	case "fs":
+		return snapshots.NewFSStore(cfg.SNAPSHOT_DIR)
+	case "postgres":
+		if db == nil {
+			return nil, fmt.Errorf("postgres snapshot store needs the database")
+		}
+		return snapshots.NewPostgresStore(db), nil
+	case "s3":
+		return snapshots.NewS3Store(snapshots.S3Params{
+			Endpoint:  cfg.SNAPSHOT_S3_ENDPOINT,
+			Bucket:    cfg.SNAPSHOT_S3_BUCKET,
+			Region:    cfg.SNAPSHOT_S3_REGION,
+			AccessKey: cfg.SNAPSHOT_S3_ACCESS_KEY,
+			SecretKey: cfg.SNAPSHOT_S3_SECRET_KEY,
+			Prefix:    cfg.SNAPSHOT_S3_PREFIX,
+		})
+	default:
+		return nil, fmt.Errorf("unknown SNAPSHOT_BACKEND %q", cfg.SNAPSHOT_BACKEND)
+	}
 }
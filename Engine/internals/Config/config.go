@@ -4,6 +4,8 @@ import (
 	"log"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/joho/godotenv"
@@ -17,6 +19,33 @@ type Config struct {
 	PG_URL                string
 	WS_PUB_SUB_REDIS_URL  string
 	WALLET_USER_MAP       string
+
+	MARKET_MAX_SLIPPAGE_BPS int
+
+	CIRCUIT_BREAKER_MAX_MOVE_BPS int // 0 disables the circuit breaker
+	CIRCUIT_BREAKER_WINDOW_SEC   int
+	CIRCUIT_BREAKER_COOLDOWN_SEC int
+
+	AUCTION_CALL_SEC int // opening auction length; 0 opens new markets straight into continuous matching
+
+	ENGINE_NODE_ID       string // unique per Engine instance; defaults to the hostname
+	MARKET_LEASE_TTL_SEC int    // how long a market stays with a node that stopped renewing its lease
+
+	ORDER_STREAM_TRIM int    // 1 trims ORDERS_ streams behind each saved snapshot; only honoured with a shared (postgres or s3) snapshot store
+	ORDER_JOURNAL     int    // 1 journals every market's inputs under JOURNAL_DIR before publishing their effects; off by default
+	JOURNAL_DIR       string // absolute directory market journals are kept under; required with ORDER_JOURNAL=1
+
+	SNAPSHOT_BACKEND   string // fs (default), postgres or s3
+	SNAPSHOT_DIR       string // fs backend: directory snapshots are kept under; defaults to ../snapshots, made absolute at startup
+	SNAPSHOT_RETENTION int    // snapshots kept per market; defaults to 3
+
+	// The postgres backend stores snapshots in PG_URL's database and needs nothing else.
+	SNAPSHOT_S3_ENDPOINT   string // s3 backend: scheme and host of the store, such as http://minio:9000; required
+	SNAPSHOT_S3_BUCKET     string // s3 backend: required
+	SNAPSHOT_S3_REGION     string // s3 backend: defaults to us-east-1
+	SNAPSHOT_S3_ACCESS_KEY string // s3 backend: required
+	SNAPSHOT_S3_SECRET_KEY string // s3 backend: required
+	SNAPSHOT_S3_PREFIX     string // s3 backend: key prefix under the bucket; defaults to snapshots
 }
 
 func mustEnv(key string) string {
@@ -27,6 +56,28 @@ func mustEnv(key string) string {
 	return val
 }
 
+// envOrDefault reads an optional env var, falling back to def when unset.
+func envOrDefault(key, def string) string {
+	val := strings.TrimSpace(os.Getenv(key))
+	if val == "" {
+		return def
+	}
+	return val
+}
+
+// envIntOrDefault reads an optional integer env var, falling back to def when unset.
+func envIntOrDefault(key string, def int) int {
+	val := strings.TrimSpace(os.Getenv(key))
+	if val == "" {
+		return def
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		log.Fatalf("ERROR :: INVALID INTEGER env VAR :: %s", key)
+	}
+	return n
+}
+
 func MustLoad() *Config {
 	var cfg Config
 	slog.Info("Loading Config for env .... ")
@@ -51,5 +102,55 @@ func MustLoad() *Config {
 
 	cfg.WALLET_USER_MAP = mustEnv("WALLET_USER_MAP")
 
+	cfg.MARKET_MAX_SLIPPAGE_BPS = envIntOrDefault("MARKET_MAX_SLIPPAGE_BPS", 500)
+
+	cfg.CIRCUIT_BREAKER_MAX_MOVE_BPS = envIntOrDefault("CIRCUIT_BREAKER_MAX_MOVE_BPS", 1000)
+	cfg.CIRCUIT_BREAKER_WINDOW_SEC = envIntOrDefault("CIRCUIT_BREAKER_WINDOW_SEC", 300)
+	cfg.CIRCUIT_BREAKER_COOLDOWN_SEC = envIntOrDefault("CIRCUIT_BREAKER_COOLDOWN_SEC", 60)
+	cfg.AUCTION_CALL_SEC = envIntOrDefault("AUCTION_CALL_SEC", 60)
+
+	cfg.ENGINE_NODE_ID = strings.TrimSpace(os.Getenv("ENGINE_NODE_ID"))
+	if cfg.ENGINE_NODE_ID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Fatal("ERROR :: ENGINE_NODE_ID IS UNSET AND THE HOSTNAME IS UNAVAILABLE", err.Error())
+		}
+		cfg.ENGINE_NODE_ID = hostname
+	}
+	cfg.MARKET_LEASE_TTL_SEC = envIntOrDefault("MARKET_LEASE_TTL_SEC", 10)
+	cfg.ORDER_STREAM_TRIM = envIntOrDefault("ORDER_STREAM_TRIM", 0)
+	cfg.ORDER_JOURNAL = envIntOrDefault("ORDER_JOURNAL", 0)
+	if cfg.ORDER_JOURNAL != 0 {
+		cfg.JOURNAL_DIR = mustEnv("JOURNAL_DIR")
+		if !filepath.IsAbs(cfg.JOURNAL_DIR) {
+			log.Fatalf("ERROR :: JOURNAL_DIR MUST BE AN ABSOLUTE PATH :: %s", cfg.JOURNAL_DIR)
+		}
+	}
+
+	cfg.SNAPSHOT_BACKEND = envOrDefault("SNAPSHOT_BACKEND", "fs")
+	// A node taking over a market must find the snapshot the stream was trimmed behind, which
+	// a node-local fs store cannot promise.
+	if cfg.ORDER_STREAM_TRIM != 0 && cfg.SNAPSHOT_BACKEND == "fs" {
+		log.Println("WARN :: ORDER_STREAM_TRIM NEEDS A SHARED SNAPSHOT_BACKEND (postgres OR s3), NOT TRIMMING")
+		cfg.ORDER_STREAM_TRIM = 0
+	}
+	if cfg.SNAPSHOT_BACKEND == "fs" {
+		// Unset keeps the old ../snapshots, resolved once here so a later chdir cannot move it.
+		dir, err := filepath.Abs(envOrDefault("SNAPSHOT_DIR", "../snapshots"))
+		if err != nil {
+			log.Fatal("ERROR :: UNABLE TO RESOLVE SNAPSHOT_DIR", err.Error())
+		}
+		cfg.SNAPSHOT_DIR = dir
+	}
+	cfg.SNAPSHOT_RETENTION = envIntOrDefault("SNAPSHOT_RETENTION", 3)
+	if cfg.SNAPSHOT_BACKEND == "s3" {
+		cfg.SNAPSHOT_S3_ENDPOINT = mustEnv("SNAPSHOT_S3_ENDPOINT")
+		cfg.SNAPSHOT_S3_BUCKET = mustEnv("SNAPSHOT_S3_BUCKET")
+		cfg.SNAPSHOT_S3_ACCESS_KEY = mustEnv("SNAPSHOT_S3_ACCESS_KEY")
+		cfg.SNAPSHOT_S3_SECRET_KEY = mustEnv("SNAPSHOT_S3_SECRET_KEY")
+	}
+	cfg.SNAPSHOT_S3_REGION = envOrDefault("SNAPSHOT_S3_REGION", "us-east-1")
+	cfg.SNAPSHOT_S3_PREFIX = envOrDefault("SNAPSHOT_S3_PREFIX", "snapshots")
+
 	return &cfg
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"log/slog"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -12,8 +13,15 @@ type Database struct {
 }
 
 type Market struct {
-	Id   string
-	Name string
+	Id             string
+	Name           string
+	MatchingPolicy string
+	TickSize       int
+	LotSize        int
+	MinPrice       int
+	MaxPrice       int // 0 means no upper limit
+	MaxOrderQty    int // 0 means no upper limit
+	Status         string
 }
 
 func InitDb(pgUrl string) (*Database, error) {
@@ -80,9 +88,60 @@ func (r *Database) GetAdminData(adminID string) (*AdminData, error) {
 	return &AdminData{Balance: int(balance), LockedBalance: int(lockedBalance), Assets: assets}, nil
 }
 
+// WalletData is a user's wallet as DBWritter last stored it: the unlocked balance and the
+// quantity held per market, the same shape the wallet takes in Redis.
+type WalletData struct {
+	Balance int
+	Assets  map[string]int
+}
+
+func (r *Database) GetUserWallet(userId string) (*WalletData, error) {
+	ctx := context.Background()
+
+	var balance int64
+	if err := r.pgdb.QueryRow(ctx, "SELECT balance FROM wallets WHERE user_id = $1", userId).Scan(&balance); err != nil {
+		return nil, err
+	}
+
+	rows, err := r.pgdb.Query(ctx, "SELECT market_id::text, quantity FROM assets WHERE user_id = $1 AND quantity > 0", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assets := make(map[string]int)
+	for rows.Next() {
+		var marketID string
+		var qty int64
+		if err := rows.Scan(&marketID, &qty); err != nil {
+			return nil, err
+		}
+		assets[marketID] = int(qty)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &WalletData{Balance: int(balance), Assets: assets}, nil
+}
+
+const marketColumns = "id, market_name, matching_policy::text, tick_size, lot_size, min_price, max_price, max_order_qty, status::text"
+
+// scanMarket reads one row selected with marketColumns.
+func scanMarket(row pgx.Row) (Market, error) {
+	var market Market
+	var tickSize, lotSize, minPrice, maxPrice, maxOrderQty int64
+	err := row.Scan(&market.Id, &market.Name, &market.MatchingPolicy, &tickSize, &lotSize, &minPrice, &maxPrice, &maxOrderQty, &market.Status)
+	if err != nil {
+		return market, err
+	}
+	market.TickSize, market.LotSize = int(tickSize), int(lotSize)
+	market.MinPrice, market.MaxPrice, market.MaxOrderQty = int(minPrice), int(maxPrice), int(maxOrderQty)
+	return market, nil
+}
+
 func (r *Database) GetAllMarkets() ([]Market, error) {
 	ctx := context.Background()
-	rows, err := r.pgdb.Query(ctx, "SELECT id, market_name FROM markets")
+	rows, err := r.pgdb.Query(ctx, "SELECT "+marketColumns+" FROM markets")
 	if err != nil {
 		slog.Error("ERROR :: IN GETTING ALL MARKETS ", slog.Any("ERROR :: ", err))
 		return nil, err
@@ -91,8 +150,7 @@ func (r *Database) GetAllMarkets() ([]Market, error) {
 
 	var markets []Market
 	for rows.Next() {
-		var market Market
-		err := rows.Scan(&market.Id, &market.Name)
+		market, err := scanMarket(rows)
 		if err != nil {
 			slog.Error("ERROR :: IN SCANNING MARKET ROW ", slog.Any("ERROR :: ", err))
 			return nil, err
@@ -103,3 +161,55 @@ func (r *Database) GetAllMarkets() ([]Market, error) {
 	return markets, nil
 
 }
+
+// GetMarket loads one market, for markets the Engine starts after InitEngine.
+func (r *Database) GetMarket(marketId string) (Market, error) {
+	ctx := context.Background()
+	market, err := scanMarket(r.pgdb.QueryRow(ctx, "SELECT "+marketColumns+" FROM markets WHERE id = $1", marketId))
+	if err != nil {
+		slog.Error("ERROR :: IN GETTING MARKET ", slog.Any("marketId", marketId), slog.Any("ERROR :: ", err))
+		return market, err
+	}
+	return market, nil
+}
+
+// SaveMarketSnapshot stores one encoded order book snapshot of marketId under name.
+func (r *Database) SaveMarketSnapshot(ctx context.Context, marketId, name string, data []byte) error {
+	_, err := r.pgdb.Exec(ctx,
+		`INSERT INTO market_snapshots (market_id, name, data) VALUES ($1, $2, $3)
+		 ON CONFLICT (market_id, name) DO UPDATE SET data = EXCLUDED.data`,
+		marketId, name, data)
+	return err
+}
+
+// MarketSnapshot loads the snapshot of marketId stored under name.
+func (r *Database) MarketSnapshot(ctx context.Context, marketId, name string) ([]byte, error) {
+	var data []byte
+	err := r.pgdb.QueryRow(ctx, "SELECT data FROM market_snapshots WHERE market_id = $1 AND name = $2", marketId, name).Scan(&data)
+	return data, err
+}
+
+// MarketSnapshotNames lists the names of the snapshots stored for marketId.
+func (r *Database) MarketSnapshotNames(ctx context.Context, marketId string) ([]string, error) {
+	rows, err := r.pgdb.Query(ctx, "SELECT name FROM market_snapshots WHERE market_id = $1", marketId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// DeleteMarketSnapshot removes the snapshot of marketId stored under name.
+func (r *Database) DeleteMarketSnapshot(ctx context.Context, marketId, name string) error {
+	_, err := r.pgdb.Exec(ctx, "DELETE FROM market_snapshots WHERE market_id = $1 AND name = $2", marketId, name)
+	return err
+}
@@ -0,0 +1,202 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	redisStream "github.com/raiashpanda007/rivon/engine/internals/Redis"
+	"github.com/raiashpanda007/rivon/engine/internals/markets"
+)
+
+const (
+	orderChannelSize = 50
+	// resumeOccupancy is how far a paused market's channel has to drain before it is fed again.
+	resumeOccupancy = orderChannelSize / 2
+	// pausedPollInterval is how often a consumer whose markets are all paused checks them.
+	pausedPollInterval = 50 * time.Millisecond
+	feedStatsInterval  = 30 * time.Second
+)
+
+// marketFeed is the order channel of one running market and how far the consumers have fed
+// it. A market whose channel fills up is paused instead of losing the entry: the consumer
+// stops reading its stream, the entries already read stay pending in the consumer group,
+// and once the market has caught up they are claimed and fed in stream order.
+type marketFeed struct {
+	orders chan markets.OrderMessages
+
+	mu     sync.Mutex
+	lastId string // last stream entry handed to the market
+	paused bool
+
+	// reported and reset by logFeedStats
+	peak      int
+	pauses    int
+	recovered int
+}
+
+func newMarketFeed() *marketFeed {
+	return &marketFeed{orders: make(chan markets.OrderMessages, orderChannelSize)}
+}
+
+// deliver hands order to the market if its channel has room. Callers hold f.mu.
+func (f *marketFeed) deliver(order markets.OrderMessages) bool {
+	select {
+	case f.orders <- order:
+		f.lastId = order.StreamId
+		if n := len(f.orders); n > f.peak {
+			f.peak = n
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// skipUnparsable acks an entry no market can apply and moves the feed past it, so it
+// neither stays pending nor holds up the acks of the entries after it. Callers hold f.mu.
+func (f *marketFeed) skipUnparsable(ctx context.Context, redisClient *redis.Client, stream string, message redis.XMessage, err error) {
+	slog.Error("Error in parsing the stream message, skipping it", "stream", stream, "id", message.ID, slog.Any("err", err))
+	if err := redisStream.AckOrderEntries(ctx, redisClient, stream, []string{message.ID}); err != nil {
+		slog.Error("Unable to ack unparsable entry", "stream", stream, "id", message.ID, "error", err)
+	}
+	f.lastId = message.ID
+}
+
+// offer hands a stream entry to the market. It reports false, pausing the market, when the
+// channel is full; the entry and every later one of the read stay pending.
+func (f *marketFeed) offer(ctx context.Context, redisClient *redis.Client, stream string, message redis.XMessage) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.paused {
+		return false
+	}
+	order, err := parseOrderMessage(message.Values, message.ID)
+	if err != nil {
+		f.skipUnparsable(ctx, redisClient, stream, message, err)
+		return true
+	}
+	if f.deliver(order) {
+		return true
+	}
+	f.paused = true
+	f.pauses++
+	slog.Warn("Market channel full, pausing its stream", "marketId", order.MarketId, "pendingFrom", message.ID)
+	return false
+}
+
+// resume reports whether the market can be read live. A paused market is first fed the
+// entries it left pending after lastId, oldest first, claiming them for consumer; it stays
+// paused while its channel is above resumeOccupancy or fills up again.
+func (f *marketFeed) resume(ctx context.Context, redisClient *redis.Client, stream, consumer string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.paused {
+		return true
+	}
+	if len(f.orders) > resumeOccupancy {
+		return false
+	}
+
+	start := "-"
+	if f.lastId != "" {
+		start = "(" + f.lastId
+	}
+	for {
+		free := int64(cap(f.orders) - len(f.orders))
+		if free == 0 {
+			return false
+		}
+		pending, err := redisClient.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: stream,
+			Group:  "engine",
+			Start:  start,
+			End:    "+",
+			Count:  free,
+		}).Result()
+		if err != nil {
+			slog.Error("Unable to read pending entries", "stream", stream, "error", err)
+			return false
+		}
+		if len(pending) == 0 {
+			f.paused = false
+			slog.Info("Market caught up, resuming its stream", "stream", stream, "lastId", f.lastId)
+			return true
+		}
+
+		ids := make([]string, len(pending))
+		for i, p := range pending {
+			ids[i] = p.ID
+		}
+		claimed, err := redisClient.XClaim(ctx, &redis.XClaimArgs{
+			Stream:   stream,
+			Group:    "engine",
+			Consumer: consumer,
+			Messages: ids,
+		}).Result()
+		if err != nil {
+			slog.Error("Unable to claim pending entries", "stream", stream, "error", err)
+			return false
+		}
+		for _, message := range claimed {
+			order, err := parseOrderMessage(message.Values, message.ID)
+			if err != nil {
+				f.skipUnparsable(ctx, redisClient, stream, message, err)
+				continue
+			}
+			if !f.deliver(order) {
+				return false
+			}
+			f.recovered++
+		}
+		// Entries trimmed from the stream are not returned by XCLAIM; skip past them.
+		f.lastId = ids[len(ids)-1]
+		start = "(" + f.lastId
+	}
+}
+
+// logFeedStats reports the occupancy of every running market's channel, and how often it
+// was paused, until ctx is done.
+func (s *supervisor) logFeedStats(ctx context.Context) {
+	ticker := time.NewTicker(feedStatsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			ids := make([]string, 0, len(s.running))
+			for id := range s.running {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			paused := 0
+			for _, id := range ids {
+				f := s.running[id].feed
+				f.mu.Lock()
+				if f.paused {
+					paused++
+				}
+				if f.peak > 0 || f.paused {
+					slog.Info("market channel occupancy",
+						"marketId", id,
+						"len", len(f.orders),
+						"cap", cap(f.orders),
+						"peak", f.peak,
+						"paused", f.paused,
+						"pauses", f.pauses,
+						"recovered", f.recovered,
+					)
+				}
+				f.peak, f.pauses, f.recovered = len(f.orders), 0, 0
+				f.mu.Unlock()
+			}
+			s.mu.Unlock()
+			slog.Info("market channels", "nodeId", s.nodeId, "running", len(ids), "paused", paused)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
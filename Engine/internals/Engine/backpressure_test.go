@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/raiashpanda007/rivon/engine/internals/markets"
+)
+
+// offlineRedis is a client against a Redis nothing listens on, so every call fails.
+func offlineRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func orderEntry(seq int) redis.XMessage {
+	return redis.XMessage{
+		ID: fmt.Sprintf("%d-0", seq),
+		Values: map[string]interface{}{
+			"price":     "10",
+			"quantity":  "1",
+			"orderId":   fmt.Sprintf("o%d", seq),
+			"userId":    "alice",
+			"marketId":  "m1",
+			"orderType": "BUY",
+		},
+	}
+}
+
+func TestFullChannelPausesTheMarket(t *testing.T) {
+	ctx := context.Background()
+	client := offlineRedis(t)
+	f := newMarketFeed()
+
+	for seq := 1; seq <= orderChannelSize; seq++ {
+		if !f.offer(ctx, client, "ORDERS_m1", orderEntry(seq)) {
+			t.Fatalf("entry %d refused with room in the channel", seq)
+		}
+	}
+	if f.offer(ctx, client, "ORDERS_m1", orderEntry(orderChannelSize+1)) {
+		t.Fatal("entry taken by a full channel")
+	}
+	if !f.paused || f.pauses != 1 {
+		t.Fatalf("paused %v after %d pauses, want paused once", f.paused, f.pauses)
+	}
+	if want := fmt.Sprintf("%d-0", orderChannelSize); f.lastId != want {
+		t.Fatalf("lastId %s, want the last entry delivered %s", f.lastId, want)
+	}
+
+	// Later entries of the same read stay pending too, even once there is room again.
+	<-f.orders
+	if f.offer(ctx, client, "ORDERS_m1", orderEntry(orderChannelSize+2)) {
+		t.Fatal("paused market took an entry past the one it left pending")
+	}
+	if len(f.orders) != orderChannelSize-1 || f.peak != orderChannelSize {
+		t.Fatalf("channel holds %d with peak %d", len(f.orders), f.peak)
+	}
+}
+
+func TestPausedMarketWaitsToDrain(t *testing.T) {
+	ctx := context.Background()
+	client := offlineRedis(t)
+	f := newMarketFeed()
+	if !f.resume(ctx, client, "ORDERS_m1", "c") {
+		t.Fatal("a market that is not paused is not read live")
+	}
+
+	f.paused = true
+	for seq := 1; seq <= resumeOccupancy+1; seq++ {
+		f.orders <- parsedEntry(t, seq)
+	}
+	// Above resumeOccupancy the pending entries are not even looked at.
+	if f.resume(ctx, client, "ORDERS_m1", "c") {
+		t.Fatal("paused market resumed before draining")
+	}
+
+	<-f.orders
+	// Drained, but the pending entries cannot be read: it stays paused rather than skip them.
+	if f.resume(ctx, client, "ORDERS_m1", "c") || !f.paused {
+		t.Fatal("paused market resumed without feeding its pending entries")
+	}
+}
+
+func TestUnparsableEntryIsSkipped(t *testing.T) {
+	ctx := context.Background()
+	f := newMarketFeed()
+	bad := redis.XMessage{ID: "7-0", Values: map[string]interface{}{"price": "x"}}
+	if !f.offer(ctx, offlineRedis(t), "ORDERS_m1", bad) {
+		t.Fatal("unparsable entry paused the market")
+	}
+	if f.lastId != "7-0" || len(f.orders) != 0 || f.paused {
+		t.Fatalf("lastId %s, %d delivered, paused %v", f.lastId, len(f.orders), f.paused)
+	}
+}
+
+func parsedEntry(t *testing.T, seq int) markets.OrderMessages {
+	t.Helper()
+	entry := orderEntry(seq)
+	order, err := parseOrderMessage(entry.Values, entry.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
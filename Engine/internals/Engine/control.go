@@ -0,0 +1,298 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5"
+	database "github.com/raiashpanda007/rivon/engine/internals/Database"
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	pubsub "github.com/raiashpanda007/rivon/engine/internals/PubSub"
+	sharding "github.com/raiashpanda007/rivon/engine/internals/Sharding"
+	usermap "github.com/raiashpanda007/rivon/engine/internals/UserMap"
+	"github.com/raiashpanda007/rivon/engine/internals/markets"
+	tradestream "github.com/raiashpanda007/rivon/engine/internals/utils/TradeStream"
+	wsmessagestypes "github.com/raiashpanda007/rivon/engine/internals/utils/WsMessagesTypes"
+)
+
+// engineControlStream carries market lifecycle events from the API and the jobs service.
+// It is read without a consumer group so every Engine instance sees every event.
+const engineControlStream = "ENGINE_CONTROL"
+
+type ControlEvent string
+
+const (
+	MARKET_CREATED   ControlEvent = "MARKET_CREATED"
+	MARKET_SUSPENDED ControlEvent = "MARKET_SUSPENDED"
+	MARKET_CLOSED    ControlEvent = "MARKET_CLOSED"
+)
+
+// controlRetryMax caps the wait between attempts at a control event that failed.
+const controlRetryMax = 30 * time.Second
+
+// marketStore is where the supervisor looks up the markets announced on the control stream.
+type marketStore interface {
+	GetMarket(marketId string) (database.Market, error)
+}
+
+// runningMarket is one StarMarketProcess and what it needs to be stopped again.
+type runningMarket struct {
+	market    database.Market
+	feed      *marketFeed
+	cancel    context.CancelFunc
+	done      chan struct{}
+	token     int64     // fencing token of the lease the market runs under
+	renewedAt time.Time // last time the lease was renewed
+}
+
+// exited reports whether the market's process has stopped.
+func (m *runningMarket) exited() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// supervisor owns the markets this Engine runs. Every open market is known to every
+// Engine, but only the node the ring assigns it to, holding its lease, runs it. Wallets
+// are shared by every node, see usermap.UserWallet. The lease loop and the control loop
+// take turns under mu.
+type supervisor struct {
+	ctx        context.Context
+	orderRedis *redis.Client
+	tradeRedis *redis.Client
+	db         marketStore
+	pubsubSvc  pubsub.PubSubService
+	userWallet *usermap.UserWallet
+	params     markets.MarketParams
+	nodeId     string
+	leaseTTL   time.Duration
+	stopped    chan struct{} // closed once the node has shut down, see shutdown
+
+	mu            sync.Mutex
+	markets       map[string]database.Market
+	running       map[string]*runningMarket
+	stopConsumers context.CancelFunc
+	consumers     sync.WaitGroup
+}
+
+// paramsFor applies a market's own rules and status to the Engine-wide parameters.
+func paramsFor(params markets.MarketParams, market database.Market) markets.MarketParams {
+	params.MatchingPolicy = orderbooks.MatchingPolicy(market.MatchingPolicy)
+	params.Rules = markets.TradingRules{
+		TickSize:    market.TickSize,
+		LotSize:     market.LotSize,
+		MinPrice:    market.MinPrice,
+		MaxPrice:    market.MaxPrice,
+		MaxOrderQty: market.MaxOrderQty,
+	}
+	params.Suspended = market.Status == "suspended"
+	return params
+}
+
+// startMarket subscribes to the market's WS input and starts its process under the lease
+// token. The process restores the book from its snapshot and replays the order stream, so
+// a market taken over from another node picks up where that node stopped. The market
+// receives orders once the consumers are rebalanced.
+func (s *supervisor) startMarket(market database.Market, token int64) error {
+	ensureConsumerGroups(s.ctx, s.orderRedis, []database.Market{market})
+
+	ctx, cancel := context.WithCancel(tradestream.WithFencingToken(s.ctx, token))
+	feed := newMarketFeed()
+	wsIn := make(chan wsmessagestypes.WSInMessageStruct, 1000)
+	wsOut := make(chan wsmessagestypes.WSOutMessageStruct, 1000)
+
+	if err := s.pubsubSvc.WSIn().Subscribe(ctx, market.Id, wsIn); err != nil {
+		cancel()
+		slog.Error("failed to subscribe wsIn", "marketId", market.Id, "err", err)
+		return err
+	}
+
+	params := paramsFor(s.params, market)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		markets.StarMarketProcess(ctx, feed.orders, s.tradeRedis, s.pubsubSvc, market.Id, s.orderRedis, wsIn, wsOut, s.userWallet, params)
+	}()
+
+	s.running[market.Id] = &runningMarket{market: market, feed: feed, cancel: cancel, done: done, token: token, renewedAt: time.Now()}
+	slog.Info("Market started", "marketId", market.Id, "fencingToken", token)
+	return nil
+}
+
+// stopMarket stops the market's process and WS subscription, waits until its book is
+// saved and hands its lease back. The consumer group is kept so the stream is not
+// delivered again from the start when the market runs again.
+func (s *supervisor) stopMarket(marketId string) {
+	m, ok := s.running[marketId]
+	if !ok {
+		return
+	}
+	delete(s.running, marketId)
+	m.cancel()
+	<-m.done
+	if err := sharding.ReleaseLease(context.Background(), s.orderRedis, marketId, s.nodeId); err != nil {
+		slog.Error("Unable to release market lease", "marketId", marketId, "error", err)
+	}
+	slog.Info("Market stopped", "marketId", marketId)
+}
+
+// rebalance replaces the batch consumers with a new set spread over the running markets.
+// The old set is waited out first so no market is ever fed by two consumers at once.
+// Markets are ordered by id so a restart batches them the same way.
+func (s *supervisor) rebalance() {
+	if s.stopConsumers != nil {
+		s.stopConsumers()
+	}
+	s.consumers.Wait()
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.stopConsumers = cancel
+
+	feeds := make(map[string]*marketFeed, len(s.running))
+	allMarkets := make([]database.Market, 0, len(s.running))
+	for id, m := range s.running {
+		feeds[id] = m.feed
+		allMarkets = append(allMarkets, m.market)
+	}
+	sort.Slice(allMarkets, func(i, j int) bool { return allMarkets[i].Id < allMarkets[j].Id })
+
+	startBatchedConsumers(ctx, s.orderRedis, feeds, allMarkets, s.nodeId, &s.consumers)
+}
+
+// lastControlId is where the control loop starts reading: events written before the
+// markets were loaded from the database are already reflected in them.
+func lastControlId(ctx context.Context, redisClient *redis.Client) string {
+	msgs, err := redisClient.XRevRangeN(ctx, engineControlStream, "+", "-", 1).Result()
+	if err != nil {
+		slog.Error("Unable to read the control stream position", "error", err)
+		return "$"
+	}
+	if len(msgs) == 0 {
+		return "0"
+	}
+	return msgs[0].ID
+}
+
+// watchControl applies control events from lastId on until the Engine stops.
+func (s *supervisor) watchControl(lastId string) {
+	for {
+		if s.ctx.Err() != nil {
+			return
+		}
+		res, err := s.orderRedis.XRead(s.ctx, &redis.XReadArgs{
+			Streams: []string{engineControlStream, lastId},
+			Count:   10,
+			Block:   consumerBlock,
+		}).Result()
+
+		if err != nil {
+			if err == redis.Nil || s.ctx.Err() != nil {
+				continue
+			}
+			slog.Error("Control stream read error", "error", err)
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		for _, stream := range res {
+			for _, message := range stream.Messages {
+				s.applyControl(message)
+				lastId = message.ID
+			}
+		}
+	}
+}
+
+// applyControl handles a control event, and handles it again with a growing wait between
+// attempts for as long as it fails, so a market is not lost to a passing database error.
+// Later events wait behind it.
+func (s *supervisor) applyControl(message redis.XMessage) {
+	delay := time.Second
+	for {
+		s.mu.Lock()
+		err := s.handleControl(message)
+		s.mu.Unlock()
+		if err == nil {
+			return
+		}
+		slog.Error("Unable to apply control event, retrying", "id", message.ID, "error", err, "retryIn", delay)
+		select {
+		case <-time.After(delay):
+		case <-s.ctx.Done():
+			return
+		}
+		delay = min(2*delay, controlRetryMax)
+	}
+}
+
+// handleControl applies one control event. It returns an error when the event could not be
+// applied yet and has to be handled again. Callers hold s.mu.
+func (s *supervisor) handleControl(message redis.XMessage) error {
+	event := ControlEvent(optionalField(message.Values, "event"))
+	marketId := optionalField(message.Values, "marketId")
+	if marketId == "" {
+		slog.Warn("Control event without a market, ignoring", "event", event, "id", message.ID)
+		return nil
+	}
+
+	switch event {
+	case MARKET_CREATED:
+		if _, ok := s.markets[marketId]; ok {
+			return nil
+		}
+		market, err := s.db.GetMarket(marketId)
+		if errors.Is(err, pgx.ErrNoRows) {
+			slog.Warn("Created market is not in the database, ignoring", "marketId", marketId)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("load created market %s: %w", marketId, err)
+		}
+		if market.Status == "closed" {
+			slog.Info("Created market is already closed, not starting it", "marketId", marketId)
+			return nil
+		}
+		s.markets[marketId] = market
+		s.claimMarkets()
+
+	case MARKET_SUSPENDED:
+		if _, ok := s.running[marketId]; !ok {
+			return nil
+		}
+		// The node running the market sends the suspension down its order stream, so it lands
+		// after the orders already queued and replay halts the book at the same point.
+		_, err := s.orderRedis.XAdd(s.ctx, &redis.XAddArgs{
+			Stream: "ORDERS_" + marketId,
+			Values: map[string]interface{}{
+				"orderId":   message.ID,
+				"userId":    "",
+				"marketId":  marketId,
+				"orderType": "SUSPEND_MARKET",
+				"price":     0,
+				"quantity":  0,
+			},
+		}).Result()
+		if err != nil {
+			return fmt.Errorf("write suspension of %s on the order stream: %w", marketId, err)
+		}
+
+	case MARKET_CLOSED:
+		delete(s.markets, marketId)
+		if _, ok := s.running[marketId]; !ok {
+			return nil
+		}
+		s.stopMarket(marketId)
+		s.rebalance()
+
+	default:
+		slog.Warn("Unknown control event, ignoring", "event", event, "marketId", marketId)
+	}
+	return nil
+}
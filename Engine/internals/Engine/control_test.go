@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5"
+	database "github.com/raiashpanda007/rivon/engine/internals/Database"
+)
+
+type fakeMarketStore struct {
+	market database.Market
+	err    error
+	calls  int
+}
+
+func (f *fakeMarketStore) GetMarket(marketId string) (database.Market, error) {
+	f.calls++
+	return f.market, f.err
+}
+
+func newTestSupervisor(ctx context.Context, db marketStore) *supervisor {
+	return &supervisor{
+		ctx:     ctx,
+		db:      db,
+		markets: make(map[string]database.Market),
+		running: make(map[string]*runningMarket),
+	}
+}
+
+func created(marketId string) redis.XMessage {
+	return redis.XMessage{ID: "1-0", Values: map[string]interface{}{"event": string(MARKET_CREATED), "marketId": marketId}}
+}
+
+func TestMarketCreatedLookupFailures(t *testing.T) {
+	tests := []struct {
+		name  string
+		store *fakeMarketStore
+		retry bool
+	}{
+		{name: "database error is retried", store: &fakeMarketStore{err: errors.New("connection reset")}, retry: true},
+		{name: "unknown market is ignored", store: &fakeMarketStore{err: pgx.ErrNoRows}},
+		{name: "closed market is not started", store: &fakeMarketStore{market: database.Market{Id: "m1", Status: "closed"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSupervisor(context.Background(), tt.store)
+			err := s.handleControl(created("m1"))
+			if (err != nil) != tt.retry {
+				t.Fatalf("handleControl err %v, retry %v", err, tt.retry)
+			}
+			if _, ok := s.markets["m1"]; ok {
+				t.Fatal("market added")
+			}
+		})
+	}
+}
+
+func TestApplyControlStopsRetryingWithTheEngine(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	store := &fakeMarketStore{err: errors.New("connection reset")}
+	s := newTestSupervisor(ctx, store)
+	cancel()
+
+	s.applyControl(created("m1"))
+	if store.calls != 1 {
+		t.Fatalf("GetMarket called %d times, want 1", store.calls)
+	}
+}
@@ -11,13 +11,30 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	database "github.com/raiashpanda007/rivon/engine/internals/Database"
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
 	pubsub "github.com/raiashpanda007/rivon/engine/internals/PubSub"
 	usermap "github.com/raiashpanda007/rivon/engine/internals/UserMap"
 	"github.com/raiashpanda007/rivon/engine/internals/markets"
-	wsmessagestypes "github.com/raiashpanda007/rivon/engine/internals/utils/WsMessagesTypes"
 )
 
+// optionalField reads a stream field that older producers (and CANCEL_ORDER
+// entries) may not have written, returning "" when it is absent.
+func optionalField(values map[string]interface{}, key string) string {
+	if v, ok := values[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// requiredFields are the stream fields every entry carries, synthetic ones included.
+var requiredFields = []string{"price", "quantity", "orderId", "userId", "marketId", "orderType"}
+
 func parseOrderMessage(values map[string]interface{}, streamId string) (markets.OrderMessages, error) {
+	for _, key := range requiredFields {
+		if _, ok := values[key].(string); !ok {
+			return markets.OrderMessages{}, fmt.Errorf("missing field %q", key)
+		}
+	}
 
 	priceStr := values["price"].(string)
 	qtyStr := values["quantity"].(string)
@@ -32,14 +49,49 @@ func parseOrderMessage(values map[string]interface{}, streamId string) (markets.
 		return markets.OrderMessages{}, err
 	}
 
+	var expiresAt int64
+	if raw := optionalField(values, "expiresAt"); raw != "" {
+		expiresAt, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return markets.OrderMessages{}, err
+		}
+	}
+
+	var triggerPrice int
+	if raw := optionalField(values, "triggerPrice"); raw != "" {
+		triggerPrice, err = strconv.Atoi(raw)
+		if err != nil {
+			return markets.OrderMessages{}, err
+		}
+	}
+
+	postOnly, reduceOnly := optionalField(values, "postOnly") == "true", optionalField(values, "reduceOnly") == "true"
+
+	var cancelQty int
+	if raw := optionalField(values, "cancelQty"); raw != "" {
+		cancelQty, err = strconv.Atoi(raw)
+		if err != nil {
+			return markets.OrderMessages{}, err
+		}
+	}
+
 	return markets.OrderMessages{
-		OrderId:   values["orderId"].(string),
-		UserId:    values["userId"].(string),
-		MarketId:  values["marketId"].(string),
-		Price:     price,
-		Quantity:  qty,
-		OrderType: values["orderType"].(string),
-		StreamId:  streamId,
+		OrderId:      values["orderId"].(string),
+		UserId:       values["userId"].(string),
+		MarketId:     values["marketId"].(string),
+		Price:        price,
+		Quantity:     qty,
+		OrderType:    values["orderType"].(string),
+		StreamId:     streamId,
+		TimeInForce:  orderbooks.TimeInForce(optionalField(values, "timeInForce")),
+		OrderKind:    orderbooks.OrderKind(optionalField(values, "orderKind")),
+		ExpiresAt:    expiresAt,
+		TriggerPrice: triggerPrice,
+		PostOnly:     postOnly,
+		ReduceOnly:   reduceOnly,
+		STPMode:      orderbooks.STPMode(optionalField(values, "stpMode")),
+		CancelQty:    cancelQty,
+		ConnectionId: optionalField(values, "connectionId"),
 	}, nil
 }
 
@@ -95,29 +147,46 @@ func ensureConsumerGroups(ctx context.Context, redisClient *redis.Client, market
 
 const batchSize = 20
 
-func redisStreamBatchConsumer(ctx context.Context, redisClient *redis.Client, marketMap map[string]chan markets.OrderMessages, batch []database.Market, batchId int) {
-	// Build streams slice: [stream1, stream2, ..., >, >, ...]
-	streams := make([]string, 0, len(batch)*2)
-	for _, market := range batch {
-		streams = append(streams, "ORDERS_"+market.Id)
-	}
-	for range batch {
-		streams = append(streams, ">")
-	}
+// consumerBlock bounds each blocking read so a consumer notices when it is replaced.
+const consumerBlock = 2 * time.Second
 
+func redisStreamBatchConsumer(ctx context.Context, redisClient *redis.Client, feeds map[string]*marketFeed, batch []database.Market, nodeId string, batchId int) {
+	consumer := fmt.Sprintf("engine-%s-%d", nodeId, batchId)
 	slog.Info("Batch consumer started", "batch_id", batchId, "stream_count", len(batch))
 
 	for {
+		if ctx.Err() != nil {
+			slog.Info("Batch consumer stopped", "batch_id", batchId)
+			return
+		}
+
+		// Build streams slice: [stream1, stream2, ..., >, >, ...] over the markets that are
+		// not paused. A paused market is fed from its pending entries once it has drained.
+		streams := make([]string, 0, len(batch)*2)
+		for _, market := range batch {
+			feed := feeds[market.Id]
+			if feed.resume(ctx, redisClient, "ORDERS_"+market.Id, consumer) {
+				streams = append(streams, "ORDERS_"+market.Id)
+			}
+		}
+		if len(streams) == 0 {
+			time.Sleep(pausedPollInterval)
+			continue
+		}
+		for range len(streams) {
+			streams = append(streams, ">")
+		}
+
 		res, err := redisClient.XReadGroup(ctx, &redis.XReadGroupArgs{
 			Group:    "engine",
-			Consumer: fmt.Sprintf("engine-%d", batchId),
+			Consumer: consumer,
 			Streams:  streams,
 			Count:    10,
-			Block:    0,
+			Block:    consumerBlock,
 		}).Result()
 
 		if err != nil {
-			if err == redis.Nil {
+			if err == redis.Nil || ctx.Err() != nil {
 				continue
 			}
 			if strings.Contains(err.Error(), "NOGROUP") {
@@ -131,38 +200,23 @@ func redisStreamBatchConsumer(ctx context.Context, redisClient *redis.Client, ma
 			continue
 		}
 		for _, stream := range res {
+			feed, ok := feeds[strings.TrimPrefix(stream.Stream, "ORDERS_")]
+			if !ok {
+				continue
+			}
 			for _, message := range stream.Messages {
-				msg, err := parseOrderMessage(message.Values, message.ID)
-
-				if err != nil {
-					slog.Error("Error in parsing the stream message", slog.Any("err", err))
-					continue
-				}
-				ch, ok := marketMap[msg.MarketId]
-				if !ok {
-					continue
-				}
-
-				select {
-				case ch <- msg:
-
-				/*
-						___________________________________
-					|                                    |
-					|Warning in case of message dropping |
-					|____________________________________|
-
-				*/
-
-				default:
-					slog.Warn("Channel full, dropping message", "marketId", msg.MarketId)
+				// Entries the market cannot take yet stay pending in the consumer group.
+				if !feed.offer(ctx, redisClient, stream.Stream, message) {
+					break
 				}
 			}
 		}
 	}
 }
 
-func startBatchedConsumers(ctx context.Context, redisClient *redis.Client, marketMap map[string]chan markets.OrderMessages, allMarkets []database.Market) {
+// startBatchedConsumers starts one consumer per batchSize markets. wg is done once they
+// have all stopped.
+func startBatchedConsumers(ctx context.Context, redisClient *redis.Client, feeds map[string]*marketFeed, allMarkets []database.Market, nodeId string, wg *sync.WaitGroup) {
 	ensureConsumerGroups(ctx, redisClient, allMarkets)
 	slog.Info("Consumer groups verified", "total_streams", len(allMarkets))
 
@@ -174,56 +228,80 @@ func startBatchedConsumers(ctx context.Context, redisClient *redis.Client, marke
 		}
 
 		batch := allMarkets[i:end]
-		go redisStreamBatchConsumer(ctx, redisClient, marketMap, batch, batchCount)
+		wg.Add(1)
+		go func(batchId int) {
+			defer wg.Done()
+			redisStreamBatchConsumer(ctx, redisClient, feeds, batch, nodeId, batchId)
+		}(batchCount)
 		batchCount++
 	}
 
 	slog.Info("All batch consumers started", "total_batches", batchCount, "total_streams", len(allMarkets))
 }
 
-func InitEngine(ctx context.Context, OrderRedis, TradeRedis *redis.Client, Db *database.Database, pubsubSvc pubsub.PubSubService, walletMapRedis *redis.Client) error {
-	userWallet, err := usermap.InitUserMap(walletMapRedis, ctx, Db)
-	if err != nil {
-		return err
-	}
+// ShardParams places this Engine among the others sharing the markets.
+type ShardParams struct {
+	NodeId   string        // unique per Engine instance
+	LeaseTTL time.Duration // how long a market lease outlives its last renewal
+}
 
-	allMarkets, err := Db.GetAllMarkets()
+// InitEngine joins this node to the ring and starts the markets it owns. Once ctx is done
+// the node stops its markets, hands back their leases and leaves the ring; the returned
+// channel is closed when it has.
+func InitEngine(ctx context.Context, OrderRedis, TradeRedis *redis.Client, Db *database.Database, pubsubSvc pubsub.PubSubService, walletMapRedis *redis.Client, params markets.MarketParams, shard ShardParams) (<-chan struct{}, error) {
+	// Read the control stream position first so no market created while loading is missed;
+	// an event for a market that is already known is ignored.
+	controlId := lastControlId(ctx, OrderRedis)
 
-	var marketChannelMap = make(map[string]chan markets.OrderMessages)
+	storedMarkets, err := Db.GetAllMarkets()
+	if err != nil {
+		slog.Error("ERROR :: IN GETTING ALL MARKETS :: ", slog.Any("ERROR :: ", err))
+		return nil, err
+	}
 
-	var wsInMsgsChannelMap = make(map[string]chan wsmessagestypes.WSInMessageStruct)
+	svc := &supervisor{
+		ctx:        ctx,
+		orderRedis: OrderRedis,
+		tradeRedis: TradeRedis,
+		db:         Db,
+		pubsubSvc:  pubsubSvc,
+		params:     params,
+		nodeId:     shard.NodeId,
+		leaseTTL:   shard.LeaseTTL,
+		stopped:    make(chan struct{}),
+		markets:    make(map[string]database.Market, len(storedMarkets)),
+		running:    make(map[string]*runningMarket),
+	}
 
-	var wsOutMsgsChannelMap = make(map[string]chan wsmessagestypes.WSOutMessageStruct)
+	// Closed markets take no more orders, so they are not run.
+	var allMarkets []database.Market
+	for _, market := range storedMarkets {
+		if market.Status != "closed" {
+			allMarkets = append(allMarkets, market)
+			svc.markets[market.Id] = market
+		}
+	}
 
+	svc.userWallet, err = usermap.InitUserMap(walletMapRedis, ctx, Db)
 	if err != nil {
-		slog.Error("ERROR :: IN GETTING ALL MARKETS :: ", slog.Any("ERROR :: ", err))
-		return err
+		return nil, err
 	}
 
 	slog.Info("Initializing Redis streams...", "count", len(allMarkets))
 	if err := redisStreamProducers(ctx, OrderRedis, allMarkets); err != nil {
 		slog.Error("Failed to initialize streams", "error", err)
-		return err
+		return nil, err
 	}
 
-	slog.Info("Creating market channels and starting processors", "count", len(allMarkets))
-	for _, market := range allMarkets {
-		marketChannelMap[market.Id] = make(chan markets.OrderMessages, 50)
+	slog.Info("Claiming markets", "nodeId", shard.NodeId, "count", len(allMarkets))
+	svc.mu.Lock()
+	svc.claimMarkets()
+	svc.mu.Unlock()
 
-		wsInMsgsChannelMap[market.Id] = make(chan wsmessagestypes.WSInMessageStruct, 1000)
-		wsOutMsgsChannelMap[market.Id] = make(chan wsmessagestypes.WSOutMessageStruct, 1000)
+	go svc.holdLeases()
+	go svc.logFeedStats(ctx)
+	go svc.watchControl(controlId)
 
-		err := pubsubSvc.WSIn().Subscribe(market.Id, wsInMsgsChannelMap[market.Id])
-		if err != nil {
-			slog.Error("failed to subscribe wsIn", "marketId", market.Id, "err", err)
-			return err
-		}
-		go markets.StarMarketProcess(ctx, marketChannelMap[market.Id], TradeRedis, pubsubSvc, market.Id, OrderRedis, wsInMsgsChannelMap[market.Id], wsOutMsgsChannelMap[market.Id], userWallet)
-	}
-
-	slog.Info("All streams ready, starting consumers...")
-	startBatchedConsumers(ctx, OrderRedis, marketChannelMap, allMarkets)
-
-	slog.Info("Engine initialized successfully", "market_count", len(allMarkets))
-	return nil
+	slog.Info("Engine initialized successfully", "nodeId", shard.NodeId, "market_count", len(allMarkets))
+	return svc.stopped, nil
 }
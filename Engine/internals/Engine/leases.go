@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	sharding "github.com/raiashpanda007/rivon/engine/internals/Sharding"
+)
+
+// claimMarkets runs every market the ring assigns to this node once its lease is held,
+// renews the leases of the ones already running and stops those it no longer owns. A
+// market whose lease cannot be renewed is stopped before the lease can have expired, so
+// two nodes never match the same market. A market whose process stopped on its own, as it
+// does when its wallets cannot be updated, is handed back and started again on the next
+// round. Callers hold s.mu.
+func (s *supervisor) claimMarkets() {
+	if err := sharding.Heartbeat(s.ctx, s.orderRedis, s.nodeId, s.leaseTTL); err != nil {
+		slog.Error("Engine heartbeat failed", "nodeId", s.nodeId, "error", err)
+	}
+	// Without the node list only the markets already running are kept going.
+	nodes, err := sharding.LiveNodes(s.ctx, s.orderRedis)
+	ringKnown := err == nil
+	if err != nil {
+		slog.Error("Unable to read the live engine nodes", "error", err)
+	}
+	ring := sharding.NewRing(nodes)
+
+	changed := false
+	for id, market := range s.markets {
+		m, running := s.running[id]
+		if running && m.exited() {
+			slog.Warn("Market process stopped on its own, handing it back", "marketId", id)
+			s.stopMarket(id)
+			changed = true
+			continue
+		}
+		if !ringKnown && !running {
+			continue
+		}
+		if ringKnown && ring.Owner(id) != s.nodeId {
+			if running {
+				s.stopMarket(id)
+				changed = true
+			}
+			continue
+		}
+
+		token, ok, err := sharding.AcquireLease(s.ctx, s.orderRedis, id, s.nodeId, s.leaseTTL)
+		if err != nil {
+			slog.Error("Unable to renew market lease", "marketId", id, "error", err)
+			if running && time.Since(m.renewedAt) >= s.leaseTTL*2/3 {
+				s.stopMarket(id)
+				changed = true
+			}
+			continue
+		}
+		// A different token means the lease lapsed and may have had another holder since.
+		if running && (!ok || token != m.token) {
+			slog.Warn("Market lease lost", "marketId", id, "fencingToken", m.token)
+			s.stopMarket(id)
+			running = false
+			changed = true
+		}
+		if running {
+			m.renewedAt = time.Now()
+			continue
+		}
+		if ok && s.startMarket(market, token) == nil {
+			changed = true
+		}
+	}
+
+	if changed {
+		s.rebalance()
+		slog.Info("Markets rebalanced", "nodeId", s.nodeId, "live_nodes", len(nodes), "running", len(s.running), "known", len(s.markets))
+	}
+}
+
+// holdLeases renews this node's heartbeat and leases three times per lease period until
+// the Engine stops, and then shuts the node down.
+func (s *supervisor) holdLeases() {
+	ticker := time.NewTicker(s.leaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			s.claimMarkets()
+			s.mu.Unlock()
+		case <-s.ctx.Done():
+			s.shutdown()
+			return
+		}
+	}
+}
+
+// shutdown stops every market this node runs, which saves their books, hands back their
+// leases and leaves the ring, so the other nodes take the markets over straight away.
+// stopped is closed once it is done.
+func (s *supervisor) shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer close(s.stopped)
+
+	for id := range s.running {
+		s.stopMarket(id)
+	}
+	if s.stopConsumers != nil {
+		s.stopConsumers()
+	}
+	s.consumers.Wait()
+	if err := sharding.Leave(context.Background(), s.orderRedis, s.nodeId); err != nil {
+		slog.Error("Unable to leave the engine ring", "nodeId", s.nodeId, "error", err)
+	}
+	slog.Info("Engine stopped", "nodeId", s.nodeId)
+}
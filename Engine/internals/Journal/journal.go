@@ -0,0 +1,410 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	redisStream "github.com/raiashpanda007/rivon/engine/internals/Redis"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Record is one entry of a market's journal: an input applied to the book and the fills it
+// made. Input is nil for fills no input made, such as an auction uncrossed off the clock.
+// Prev is the stream entry the market applied before Input, so a record that went missing
+// shows up as a break in the chain.
+type Record struct {
+	Input *redisStream.ReplayOrderStreamMessage
+	Prev  string
+	Fills []orderbooks.Fills
+}
+
+const (
+	journalExt = ".wal"
+	// segmentSize is how large a segment grows before the journal moves on to the next one.
+	segmentSize = 64 << 20
+	// headerSize is the length and CRC-32 in front of every record.
+	headerSize = 8
+)
+
+var errClosed = errors.New("journal is closed")
+
+// ErrGap is returned by ReadAfter when the journal skips an input the market applied.
+var ErrGap = errors.New("journal is missing records")
+
+func journalDir(root, marketId string) string {
+	return filepath.Join(root, marketId)
+}
+
+func segmentName(dir string, index int) string {
+	return fmt.Sprintf("%s/%020d%s", dir, index, journalExt)
+}
+
+// segments returns the indexes of the segments in dir, oldest first.
+func segments(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var indexes []int
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), journalExt) {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimSuffix(e.Name(), journalExt))
+		if err != nil {
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes, nil
+}
+
+// readSegment decodes the records of one segment. It stops at the first torn or corrupt
+// record, which only a crash in the middle of a write leaves behind, and returns the
+// offset the valid records end at.
+func readSegment(fileName string, each func(Record)) (int64, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	header := make([]byte, headerSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return offset, nil
+		}
+		size := binary.BigEndian.Uint32(header[:4])
+		if size > segmentSize {
+			return offset, nil
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return offset, nil
+		}
+		if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+			return offset, nil
+		}
+		var rec Record
+		if err := msgpack.Unmarshal(data, &rec); err != nil {
+			return offset, nil
+		}
+		each(rec)
+		offset += headerSize + int64(size)
+	}
+}
+
+// ReadAfter returns every record of marketId's journal under root whose input comes after
+// the stream entry afterId, oldest first. Records without an input are left out: replay
+// makes their fills again when it reaches the stream time they were made at. Each record
+// returned must follow on from the one before it, the first from afterId itself; otherwise
+// the records read so far are returned with ErrGap, and the rest is left to the stream.
+func ReadAfter(root, marketId, afterId string) ([]Record, error) {
+	dir := journalDir(root, marketId)
+	indexes, err := segments(dir)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	var gap error
+	for _, index := range indexes {
+		_, err := readSegment(segmentName(dir, index), func(rec Record) {
+			if gap != nil || rec.Input == nil || !redisStream.StreamIdAfter(rec.Input.StreamId, afterId) {
+				return
+			}
+			want := afterId
+			if len(records) > 0 {
+				want = records[len(records)-1].Input.StreamId
+			}
+			if rec.Prev != want && !(rec.Prev == "" && want == "0") {
+				gap = fmt.Errorf("%w: %s follows %q, not %s", ErrGap, rec.Input.StreamId, rec.Prev, want)
+				return
+			}
+			records = append(records, rec)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if gap != nil {
+			return records, gap
+		}
+	}
+	return records, nil
+}
+
+// Journal is the append-only write-ahead log of one market. Records are written as the
+// market applies its inputs and made durable by a background fsync that takes in every
+// record written while the previous one ran. Wait holds a market's outgoing events back
+// until the records they follow from are durable.
+type Journal struct {
+	dir  string
+	kick chan struct{}
+	done chan struct{}
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	file    *os.File
+	buf     *bufio.Writer
+	segment int
+	size    int64
+	written uint64 // records appended
+	synced  uint64 // records known to be on disk
+	open    bool   // an input is being applied and its record is still to come
+	closed  bool
+	err     error
+}
+
+// Open opens marketId's journal under root for appending, cutting off a record a crash left
+// half written.
+func Open(root, marketId string) (*Journal, error) {
+	dir := journalDir(root, marketId)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	indexes, err := segments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{dir: dir, kick: make(chan struct{}, 1), done: make(chan struct{})}
+	j.cond = sync.NewCond(&j.mu)
+	if len(indexes) == 0 {
+		err = j.openSegment(0)
+	} else {
+		last := indexes[len(indexes)-1]
+		var end int64
+		end, err = readSegment(segmentName(dir, last), func(Record) {})
+		if err == nil {
+			err = os.Truncate(segmentName(dir, last), end)
+		}
+		if err == nil {
+			err = j.openSegment(last)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	go j.syncLoop()
+	return j, nil
+}
+
+// openSegment makes segment index the one records are appended to. Callers hold j.mu or
+// own j exclusively.
+func (j *Journal) openSegment(index int) error {
+	f, err := os.OpenFile(segmentName(j.dir, index), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	j.file, j.buf, j.segment, j.size = f, bufio.NewWriter(f), index, info.Size()
+	return nil
+}
+
+// Begin marks an input as being applied: events published from now on wait for its record.
+func (j *Journal) Begin() {
+	j.mu.Lock()
+	j.open = true
+	j.mu.Unlock()
+}
+
+// Append writes rec, ending the input begun last, and schedules it to be synced.
+func (j *Journal) Append(rec Record) error {
+	data, err := msgpack.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(data))
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.open = false
+	if j.closed {
+		return errClosed
+	}
+	if j.err != nil {
+		return j.err
+	}
+	if _, err := j.buf.Write(header); err != nil {
+		return j.fail(err)
+	}
+	if _, err := j.buf.Write(data); err != nil {
+		return j.fail(err)
+	}
+	j.size += headerSize + int64(len(data))
+	j.written++
+
+	select {
+	case j.kick <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// fail stops the journal after a write error and releases everything waiting on it; the
+// market carries on with the order stream as its only log. Callers hold j.mu.
+func (j *Journal) fail(err error) error {
+	if j.err == nil {
+		slog.Error("Journal write failed, market continues without it", "dir", j.dir, "err", err)
+		j.err = err
+	}
+	j.cond.Broadcast()
+	return err
+}
+
+// Wait blocks until every record appended so far, and the record of an input being applied,
+// is on disk. It returns at once when the journal has failed or is closed.
+func (j *Journal) Wait() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	target := j.written
+	if j.open {
+		target++
+	}
+	for j.synced < target && j.err == nil && !j.closed {
+		j.cond.Wait()
+	}
+}
+
+// syncLoop syncs the journal whenever records were appended, so one fsync covers every
+// record written while the previous one ran. A full segment is closed after its last sync
+// and the next one started.
+func (j *Journal) syncLoop() {
+	defer close(j.done)
+	for range j.kick {
+		j.mu.Lock()
+		if j.err != nil {
+			j.mu.Unlock()
+			continue
+		}
+		if err := j.buf.Flush(); err != nil {
+			j.fail(err)
+			j.mu.Unlock()
+			continue
+		}
+		f, target := j.file, j.written
+		j.mu.Unlock()
+
+		err := f.Sync()
+
+		j.mu.Lock()
+		if err != nil {
+			j.fail(err)
+		} else if target > j.synced {
+			j.synced = target
+			j.cond.Broadcast()
+		}
+		if err == nil && j.size >= segmentSize && !j.closed {
+			j.rotate()
+		}
+		j.mu.Unlock()
+	}
+}
+
+// rotate moves the journal on to a new segment. Callers hold j.mu.
+func (j *Journal) rotate() {
+	if err := j.buf.Flush(); err != nil {
+		j.fail(err)
+		return
+	}
+	if err := j.file.Sync(); err != nil {
+		j.fail(err)
+		return
+	}
+	j.synced = j.written
+	j.cond.Broadcast()
+	if err := j.file.Close(); err != nil {
+		j.fail(err)
+		return
+	}
+	if err := j.openSegment(j.segment + 1); err != nil {
+		j.fail(err)
+	}
+}
+
+// Prune removes the segments whose every record is at or before the stream entry
+// throughId, once a snapshot covering it is durable. The segment being written is kept.
+func (j *Journal) Prune(throughId string) {
+	j.mu.Lock()
+	active := j.segment
+	j.mu.Unlock()
+
+	indexes, err := segments(j.dir)
+	if err != nil {
+		return
+	}
+	for i, index := range indexes {
+		if index >= active || i+1 >= len(indexes) {
+			return
+		}
+		// A segment is covered once the next one starts at or before throughId.
+		var first *redisStream.ReplayOrderStreamMessage
+		readSegment(segmentName(j.dir, indexes[i+1]), func(rec Record) {
+			if first == nil && rec.Input != nil {
+				first = rec.Input
+			}
+		})
+		if first == nil || redisStream.StreamIdAfter(first.StreamId, throughId) {
+			return
+		}
+		if err := os.Remove(segmentName(j.dir, index)); err != nil {
+			slog.Error("Unable to remove journal segment", "dir", j.dir, "segment", index, "err", err)
+			return
+		}
+	}
+}
+
+// Close syncs what is left and closes the journal. Waiters are released.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	if j.closed {
+		j.mu.Unlock()
+		return nil
+	}
+	j.closed = true
+	j.mu.Unlock()
+
+	close(j.kick)
+	<-j.done
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.cond.Broadcast()
+	if j.err != nil {
+		j.file.Close()
+		return j.err
+	}
+	if err := j.buf.Flush(); err != nil {
+		j.file.Close()
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		j.file.Close()
+		return err
+	}
+	return j.file.Close()
+}
@@ -0,0 +1,83 @@
+package journal
+
+import (
+	"errors"
+	"testing"
+
+	redisStream "github.com/raiashpanda007/rivon/engine/internals/Redis"
+)
+
+func input(streamId string) *redisStream.ReplayOrderStreamMessage {
+	return &redisStream.ReplayOrderStreamMessage{OrderId: "o-" + streamId, StreamId: streamId}
+}
+
+func writeJournal(t *testing.T, root string, recs ...Record) {
+	t.Helper()
+	j, err := Open(root, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range recs {
+		j.Begin()
+		if err := j.Append(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func streamIds(recs []Record) []string {
+	var ids []string
+	for _, rec := range recs {
+		ids = append(ids, rec.Input.StreamId)
+	}
+	return ids
+}
+
+func TestReadAfterFollowsTheChain(t *testing.T) {
+	root := t.TempDir()
+	writeJournal(t, root,
+		Record{Input: input("1-0"), Prev: ""},
+		Record{Input: input("2-0"), Prev: "1-0"},
+		Record{},
+		Record{Input: input("10-0"), Prev: "2-0"},
+	)
+
+	recs, err := ReadAfter(root, "m1", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := streamIds(recs); len(ids) != 3 || ids[2] != "10-0" {
+		t.Fatalf("read %v, want [1-0 2-0 10-0]", ids)
+	}
+
+	recs, err = ReadAfter(root, "m1", "2-0")
+	if err != nil || len(recs) != 1 || recs[0].Input.StreamId != "10-0" {
+		t.Fatalf("after 2-0: %v %v", streamIds(recs), err)
+	}
+}
+
+func TestReadAfterStopsAtAGap(t *testing.T) {
+	root := t.TempDir()
+	writeJournal(t, root,
+		Record{Input: input("1-0"), Prev: ""},
+		Record{Input: input("2-0"), Prev: "1-0"},
+		Record{Input: input("5-0"), Prev: "4-0"},
+		Record{Input: input("6-0"), Prev: "5-0"},
+	)
+
+	recs, err := ReadAfter(root, "m1", "0")
+	if !errors.Is(err, ErrGap) {
+		t.Fatalf("err %v, want ErrGap", err)
+	}
+	if ids := streamIds(recs); len(ids) != 2 || ids[1] != "2-0" {
+		t.Fatalf("read %v up to the gap, want [1-0 2-0]", ids)
+	}
+
+	// A journal started after the snapshot does not follow on from it.
+	if recs, err := ReadAfter(root, "m1", "3-0"); !errors.Is(err, ErrGap) || len(recs) != 0 {
+		t.Fatalf("after 3-0: %v %v, want nothing and ErrGap", streamIds(recs), err)
+	}
+}
@@ -0,0 +1,201 @@
+package orderbooks
+
+import (
+	"errors"
+	"sort"
+)
+
+var ErrAuctionImmediateOrder = errors.New("IOC and FOK orders cannot join a call auction")
+
+// CallAuction is an open call period. Orders rest without matching, even when the book
+// crosses, until EndsAt (stream time, unix ms) when the book uncrosses at a single price.
+type CallAuction struct {
+	EndsAt  int64 // 0 until the first order of an opening auction arrives
+	Opening bool  // an opening auction keeps calling until something would execute
+}
+
+// Clone returns a copy safe to hand to the snapshot goroutine; nil stays nil.
+func (a *CallAuction) Clone() *CallAuction {
+	if a == nil {
+		return nil
+	}
+	cp := *a
+	return &cp
+}
+
+// AuctionMatch is every fill one bid received in an uncross. Buy is a copy taken afterwards.
+type AuctionMatch struct {
+	Buy   Order
+	Fills []Fills
+}
+
+// StartAuction stops continuous matching until the auction is uncrossed.
+func (r *OrderBook) StartAuction(endsAt int64, opening bool) {
+	r.Auction = &CallAuction{EndsAt: endsAt, Opening: opening}
+}
+
+// AuctionDue reports whether a call period has run to its end at stream time now.
+func (r *OrderBook) AuctionDue(now int64) bool {
+	return r.Auction != nil && r.Auction.EndsAt > 0 && now >= r.Auction.EndsAt
+}
+
+// Halt trips the market into a call auction that ends with the circuit breaker's cooldown.
+func (r *OrderBook) Halt(params BreakerParams, now int64) {
+	r.Breaker.Halt(params, now)
+	r.StartAuction(r.Breaker.HaltedUntil, false)
+}
+
+// ObserveTrades feeds fills made at stream time now to the circuit breaker and halts the
+// market if they trip it. It reports whether the market halted.
+func (r *OrderBook) ObserveTrades(params BreakerParams, now int64, fills []Fills) bool {
+	if !r.Breaker.Observe(params, now, fills) {
+		return false
+	}
+	r.StartAuction(r.Breaker.HaltedUntil, false)
+	return true
+}
+
+// IndicativeUncross returns the price the book would uncross at now and the volume that
+// would execute there. The price maximises executed volume; ties go to the smallest
+// imbalance, then to the price closest to CurrentPrice, then to the lower price.
+// It returns 0, 0 when the book does not cross.
+func (r *OrderBook) IndicativeUncross() (int, int) {
+	seen := make(map[int]bool)
+	var prices []int
+	for p, qty := range r.BidDepth {
+		if qty > 0 && !seen[p] {
+			seen[p] = true
+			prices = append(prices, p)
+		}
+	}
+	for p, qty := range r.AskDepth {
+		if qty > 0 && !seen[p] {
+			seen[p] = true
+			prices = append(prices, p)
+		}
+	}
+	sort.Ints(prices)
+
+	// supply[i]: asks at or below prices[i]; demand[i]: bids at or above prices[i].
+	supply := make([]int, len(prices))
+	demand := make([]int, len(prices))
+	for i, p := range prices {
+		supply[i] = r.AskDepth[p]
+		if i > 0 {
+			supply[i] += supply[i-1]
+		}
+	}
+	for i := len(prices) - 1; i >= 0; i-- {
+		demand[i] = r.BidDepth[prices[i]]
+		if i < len(prices)-1 {
+			demand[i] += demand[i+1]
+		}
+	}
+
+	bestPrice, bestVolume, bestImbalance, bestDistance := 0, 0, 0, 0
+	for i, p := range prices {
+		volume := min(supply[i], demand[i])
+		if volume == 0 {
+			continue
+		}
+		imbalance := abs(demand[i] - supply[i])
+		distance := 0
+		if r.CurrentPrice > 0 {
+			distance = abs(p - r.CurrentPrice)
+		}
+		better := volume > bestVolume ||
+			(volume == bestVolume && imbalance < bestImbalance) ||
+			(volume == bestVolume && imbalance == bestImbalance && distance < bestDistance)
+		if bestVolume == 0 || better {
+			bestPrice, bestVolume, bestImbalance, bestDistance = p, volume, imbalance, distance
+		}
+	}
+	return bestPrice, bestVolume
+}
+
+// Uncross ends the call period. Every bid at or above the clearing price is matched, in
+// price-time priority, against asks at or below it, and all fills execute at the clearing
+// price. Bids act as the incoming side, so self-trades follow the bid's STP mode. Remainders
+// stay on the book in their original queue position and continuous matching resumes.
+func (r *OrderBook) Uncross() (int, []AuctionMatch) {
+	r.Auction = nil
+	price, volume := r.IndicativeUncross()
+	if volume == 0 {
+		return 0, nil
+	}
+
+	var bidPrices []int
+	for p, level := range r.Bids {
+		if p >= price && level.Len() > 0 {
+			bidPrices = append(bidPrices, p)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(bidPrices)))
+
+	var matches []AuctionMatch
+	for _, bidPrice := range bidPrices {
+		level := r.Bids[bidPrice]
+		for _, bid := range level.Orders() {
+			remaining := bid.Quantity - bid.Filled
+			eventsBefore := len(r.l3Events)
+			fills, executed := r.matchBids(bid, price)
+			cut := remaining - executed - (bid.Quantity - bid.Filled)
+			r.BidDepth[bidPrice] -= executed + cut
+			r.touchDepth(BUY, bidPrice)
+
+			// Both sides of the uncross trade at the clearing price, and the bid rests too.
+			for i := eventsBefore; i < len(r.l3Events); i++ {
+				if r.l3Events[i].Type == L3_EXECUTE {
+					r.l3Events[i].Price = price
+				}
+			}
+			for _, f := range fills {
+				r.recordL3(L3_EXECUTE, bid, price, f.Quantity)
+			}
+
+			removed := bid.Filled >= bid.Quantity
+			if removed {
+				level.Remove(bid)
+				delete(r.UserOrderMap[bid.UserId], bid.Id)
+			}
+			if cut > 0 {
+				r.selfTradeCancels = append(r.selfTradeCancels, SelfTradeCancel{Order: *bid, Quantity: cut, Removed: removed})
+				if removed {
+					r.recordL3(L3_CANCEL, bid, bidPrice, cut)
+				} else {
+					r.recordL3(L3_MODIFY, bid, bidPrice, bid.Quantity-bid.Filled)
+				}
+			}
+			if len(fills) > 0 {
+				made := r.madeFills[len(r.madeFills)-len(fills):]
+				for i := range fills {
+					fills[i].Price = price
+					made[i].Price = price
+				}
+				matches = append(matches, AuctionMatch{Buy: *bid, Fills: fills})
+			}
+		}
+		if level.Len() == 0 {
+			delete(r.Bids, bidPrice)
+			delete(r.BidDepth, bidPrice)
+		}
+	}
+	for r.BidHeap.Size() > 0 {
+		if level, ok := r.Bids[r.BidHeap.Peek()]; ok && level.Len() > 0 {
+			break
+		}
+		r.BidHeap.Pop()
+	}
+
+	if len(matches) > 0 {
+		r.CurrentPrice = price
+	}
+	return price, matches
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
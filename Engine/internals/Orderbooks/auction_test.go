@@ -0,0 +1,158 @@
+package orderbooks
+
+import (
+	"reflect"
+	"testing"
+)
+
+// callBook opens a call auction and queues orders into it without matching.
+func callBook(t *testing.T, current int, orders ...Order) *OrderBook {
+	t.Helper()
+	ob := newTestBook(FIFO)
+	ob.CurrentPrice = current
+	ob.StartAuction(1000, false)
+	for _, o := range orders {
+		if fills := place(t, ob, o); len(fills) != 0 {
+			t.Fatalf("%s matched during the call period", o.Id)
+		}
+	}
+	return ob
+}
+
+func TestIndicativeUncross(t *testing.T) {
+	tests := []struct {
+		name    string
+		current int
+		orders  []Order
+		price   int
+		volume  int
+	}{
+		{
+			name:   "book does not cross",
+			orders: []Order{bid("b1", "bob", 99, 5), ask("a1", "alice", 100, 5)},
+		},
+		{
+			name:   "single crossing price",
+			orders: []Order{bid("b1", "bob", 100, 5), ask("a1", "alice", 100, 5)},
+			price:  100, volume: 5,
+		},
+		{
+			name: "price maximises executed volume",
+			orders: []Order{
+				bid("b1", "bob", 103, 4), bid("b2", "bob", 101, 6),
+				ask("a1", "alice", 100, 3), ask("a2", "alice", 101, 5), ask("a3", "alice", 102, 5),
+			},
+			// At 101: demand 10, supply 8. At 102: demand 4, supply 13. At 100: demand 10, supply 3.
+			price: 101, volume: 8,
+		},
+		{
+			name: "equal volume goes to the smaller imbalance",
+			orders: []Order{
+				bid("b1", "bob", 102, 5), bid("b2", "bob", 100, 3),
+				ask("a1", "alice", 99, 5), ask("a2", "alice", 101, 1),
+			},
+			// 5 executes at every price from 99 to 102. The imbalance is 3 at 99 and 100 and 1 at
+			// 101 and 102, where the lower price wins.
+			price: 101, volume: 5,
+		},
+		{
+			name:    "equal imbalance goes to the price closest to the last trade",
+			current: 104,
+			orders:  []Order{bid("b1", "bob", 105, 5), ask("a1", "alice", 100, 5)},
+			price:   105, volume: 5,
+		},
+		{
+			name:   "without a last trade the lower price wins",
+			orders: []Order{bid("b1", "bob", 105, 5), ask("a1", "alice", 100, 5)},
+			price:  100, volume: 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ob := callBook(t, tt.current, tt.orders...)
+			price, volume := ob.IndicativeUncross()
+			if price != tt.price || volume != tt.volume {
+				t.Fatalf("IndicativeUncross = %d, %d; want %d, %d", price, volume, tt.price, tt.volume)
+			}
+		})
+	}
+}
+
+func TestUncrossFillsAtTheClearingPrice(t *testing.T) {
+	ob := callBook(t, 0,
+		bid("b1", "bob", 103, 4), bid("b2", "carol", 101, 6),
+		ask("a1", "alice", 100, 3), ask("a2", "dave", 101, 5), ask("a3", "alice", 102, 5),
+	)
+	ob.TakeL3Events()
+
+	price, matches := ob.Uncross()
+	if price != 101 {
+		t.Fatalf("clearing price %d, want 101", price)
+	}
+	if ob.Auction != nil {
+		t.Fatal("auction still open after the uncross")
+	}
+
+	// Bids go in price-time priority; every fill, maker side included, is at 101.
+	if len(matches) != 2 || matches[0].Buy.Id != "b1" || matches[1].Buy.Id != "b2" {
+		t.Fatalf("matches %+v, want b1 then b2", matches)
+	}
+	if got := filledAgainst(matches[0].Fills); !reflect.DeepEqual(got, map[string]int{"a1": 3, "a2": 1}) {
+		t.Fatalf("b1 fills %v", got)
+	}
+	if got := filledAgainst(matches[1].Fills); !reflect.DeepEqual(got, map[string]int{"a2": 4}) {
+		t.Fatalf("b2 fills %v", got)
+	}
+	for _, m := range matches {
+		for _, f := range m.Fills {
+			if f.Price != 101 {
+				t.Fatalf("fill %+v not at the clearing price", f)
+			}
+		}
+	}
+	for _, f := range ob.TakeFills() {
+		if f.Price != 101 {
+			t.Fatalf("recorded fill %+v not at the clearing price", f)
+		}
+	}
+	for _, e := range ob.TakeL3Events() {
+		if e.Type == L3_EXECUTE && e.Price != 101 {
+			t.Fatalf("L3 execute %+v not at the clearing price", e)
+		}
+	}
+
+	if ob.CurrentPrice != 101 {
+		t.Fatalf("CurrentPrice %d, want 101", ob.CurrentPrice)
+	}
+	// b2's remainder keeps its place at its own price; a3 was never reached.
+	if left := ob.RestingQuantity("carol", "b2"); left != 2 {
+		t.Fatalf("b2 has %d left, want 2", left)
+	}
+	if ids := levelIds(ob.Asks[102]); !reflect.DeepEqual(ids, []string{"a3"}) {
+		t.Fatalf("asks at 102 %v, want [a3]", ids)
+	}
+	if ob.BidHeap.Peek() != 101 || ob.BidDepth[103] != 0 || ob.BidDepth[101] != 2 {
+		t.Fatalf("bid side left at %d with depth %v", ob.BidHeap.Peek(), ob.BidDepth)
+	}
+}
+
+func TestUncrossWithNothingToMatch(t *testing.T) {
+	ob := callBook(t, 0, bid("b1", "bob", 99, 5), ask("a1", "alice", 100, 5))
+	price, matches := ob.Uncross()
+	if price != 0 || matches != nil || ob.Auction != nil {
+		t.Fatalf("Uncross = %d, %v with auction %v; want 0, nil and no auction", price, matches, ob.Auction)
+	}
+	if ob.RestingQuantity("bob", "b1") != 5 || ob.RestingQuantity("alice", "a1") != 5 {
+		t.Fatal("orders left the book")
+	}
+}
+
+func TestAuctionRefusesImmediateOrders(t *testing.T) {
+	ob := callBook(t, 0)
+	in := bid("b1", "bob", 100, 5)
+	in.TimeInForce = IOC
+	if _, _, err := ob.AddOrder(in, 100); err != ErrAuctionImmediateOrder {
+		t.Fatalf("IOC during the call: err %v, want ErrAuctionImmediateOrder", err)
+	}
+}
@@ -0,0 +1,88 @@
+package orderbooks
+
+// BreakerParams configures a market's circuit breaker. A MaxMoveBps of 0 disables it.
+type BreakerParams struct {
+	MaxMoveBps int   // largest move allowed from the reference price, in basis points
+	WindowMs   int64 // how far back the reference price is taken from
+	CooldownMs int64 // how long matching stays halted once tripped
+}
+
+// PricePoint is a trade price and the stream time (unix ms) of the order that made it.
+type PricePoint struct {
+	Time  int64
+	Price int
+}
+
+// CircuitBreaker halts matching when a trade moves the price more than MaxMoveBps away from
+// the price the market traded at when the rolling window opened. Times are ORDERS_ stream
+// times rather than the wall clock, so replaying the stream reproduces the same halts.
+type CircuitBreaker struct {
+	Window      []PricePoint // trades inside the window, preceded by the last trade before it
+	HaltedUntil int64        // stream time matching resumes at; 0 while trading
+}
+
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{}
+}
+
+// Halted reports whether the market is halted. A halt whose cooldown has run out stays in
+// force until its call auction uncrosses and calls Resume.
+func (b *CircuitBreaker) Halted() bool {
+	return b.HaltedUntil > 0
+}
+
+// ReferencePrice is the price moves are measured against, or 0 before the first trade.
+func (b *CircuitBreaker) ReferencePrice() int {
+	if len(b.Window) == 0 {
+		return 0
+	}
+	return b.Window[0].Price
+}
+
+// Observe records the fills of one order at stream time now and trips the breaker when any
+// of them lands outside the band. It returns true only on the call that trips it.
+func (b *CircuitBreaker) Observe(params BreakerParams, now int64, fills []Fills) bool {
+	if params.MaxMoveBps <= 0 || b.Halted() {
+		return false
+	}
+	cutoff := now - params.WindowMs
+	for len(b.Window) > 1 && b.Window[1].Time <= cutoff {
+		b.Window = b.Window[1:]
+	}
+	for _, f := range fills {
+		b.Window = append(b.Window, PricePoint{Time: now, Price: f.Price})
+		ref := b.Window[0].Price
+		move := f.Price - ref
+		if move < 0 {
+			move = -move
+		}
+		if move*10000 > ref*params.MaxMoveBps {
+			b.Halt(params, now)
+			return true
+		}
+	}
+	return false
+}
+
+// Halt stops matching from now until the cooldown has passed.
+func (b *CircuitBreaker) Halt(params BreakerParams, now int64) {
+	b.HaltedUntil = now + params.CooldownMs
+}
+
+// Resume lifts a halt. The window restarts from lastPrice so the move that tripped the
+// breaker does not trip it again straight away.
+func (b *CircuitBreaker) Resume(now int64, lastPrice int) {
+	b.HaltedUntil = 0
+	b.Window = b.Window[:0]
+	if lastPrice > 0 {
+		b.Window = append(b.Window, PricePoint{Time: now, Price: lastPrice})
+	}
+}
+
+// Clone returns a deep copy safe to hand to the snapshot goroutine.
+func (b *CircuitBreaker) Clone() *CircuitBreaker {
+	return &CircuitBreaker{
+		Window:      append([]PricePoint(nil), b.Window...),
+		HaltedUntil: b.HaltedUntil,
+	}
+}
@@ -0,0 +1,87 @@
+package orderbooks
+
+import "testing"
+
+func fillsAt(prices ...int) []Fills {
+	var fills []Fills
+	for _, p := range prices {
+		fills = append(fills, Fills{Price: p, Quantity: 1})
+	}
+	return fills
+}
+
+func TestCircuitBreakerTripsOutsideTheBand(t *testing.T) {
+	params := BreakerParams{MaxMoveBps: 500, WindowMs: 1000, CooldownMs: 3000}
+	tests := []struct {
+		name   string
+		prices []int
+		trips  bool
+	}{
+		{name: "inside the band", prices: []int{100, 104, 96}},
+		{name: "exactly on the band", prices: []int{100, 105, 95}},
+		{name: "above the band", prices: []int{100, 106}, trips: true},
+		{name: "below the band", prices: []int{100, 94}, trips: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewCircuitBreaker()
+			tripped := b.Observe(params, 10, fillsAt(tt.prices...))
+			if tripped != tt.trips || b.Halted() != tt.trips {
+				t.Fatalf("tripped %v halted %v, want %v", tripped, b.Halted(), tt.trips)
+			}
+			if tt.trips && b.HaltedUntil != 3010 {
+				t.Fatalf("HaltedUntil %d, want 3010", b.HaltedUntil)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerWindowRolls(t *testing.T) {
+	params := BreakerParams{MaxMoveBps: 500, WindowMs: 1000, CooldownMs: 3000}
+	b := NewCircuitBreaker()
+	b.Observe(params, 0, fillsAt(100))
+	b.Observe(params, 600, fillsAt(104))
+	// 100 has left the window, so 104 is the reference and 108 is within 5% of it.
+	if b.Observe(params, 1600, fillsAt(108)) {
+		t.Fatal("tripped against a reference outside the window")
+	}
+	if ref := b.ReferencePrice(); ref != 104 {
+		t.Fatalf("reference %d, want 104", ref)
+	}
+}
+
+func TestCircuitBreakerDisabledAndResume(t *testing.T) {
+	b := NewCircuitBreaker()
+	if b.Observe(BreakerParams{}, 0, fillsAt(100, 1000)) {
+		t.Fatal("a breaker with no band tripped")
+	}
+
+	params := BreakerParams{MaxMoveBps: 100, WindowMs: 1000, CooldownMs: 500}
+	b = NewCircuitBreaker()
+	b.Observe(params, 0, fillsAt(100, 120))
+	if b.Observe(params, 10, fillsAt(200)) {
+		t.Fatal("a halted breaker tripped again")
+	}
+	b.Resume(600, 120)
+	if b.Halted() || b.ReferencePrice() != 120 {
+		t.Fatalf("after resume halted %v reference %d, want false 120", b.Halted(), b.ReferencePrice())
+	}
+	if b.Observe(params, 700, fillsAt(121)) {
+		t.Fatal("the resumed price tripped the breaker")
+	}
+}
+
+func TestObserveTradesStartsTheHaltAuction(t *testing.T) {
+	ob := newTestBook(FIFO)
+	params := BreakerParams{MaxMoveBps: 500, WindowMs: 1000, CooldownMs: 3000}
+	if !ob.ObserveTrades(params, 10, fillsAt(100, 110)) {
+		t.Fatal("ObserveTrades did not halt")
+	}
+	if ob.Auction == nil || ob.Auction.EndsAt != 3010 || ob.Auction.Opening {
+		t.Fatalf("auction %+v, want a re-opening auction ending at 3010", ob.Auction)
+	}
+	if ob.AuctionDue(3009) || !ob.AuctionDue(3010) {
+		t.Fatal("auction due at the wrong time")
+	}
+}
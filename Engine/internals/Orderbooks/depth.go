@@ -0,0 +1,52 @@
+package orderbooks
+
+import "sort"
+
+// LevelChange is the quantity resting at one price of one side after a change to it. A
+// Quantity of 0 means the level is gone.
+type LevelChange struct {
+	Side     OrderSide
+	Price    int
+	Quantity int
+}
+
+type depthLevel struct {
+	side  OrderSide
+	price int
+}
+
+// touchDepth records that the depth of side at price changed.
+func (r *OrderBook) touchDepth(side OrderSide, price int) {
+	if r.touchedLevels == nil {
+		r.touchedLevels = make(map[depthLevel]struct{})
+	}
+	r.touchedLevels[depthLevel{side: side, price: price}] = struct{}{}
+}
+
+// TakeDepthChanges returns and clears the levels whose depth changed since the last call,
+// bids first, each side best price first.
+func (r *OrderBook) TakeDepthChanges() []LevelChange {
+	if len(r.touchedLevels) == 0 {
+		return nil
+	}
+	changes := make([]LevelChange, 0, len(r.touchedLevels))
+	for level := range r.touchedLevels {
+		depth := r.AskDepth
+		if level.side == BUY {
+			depth = r.BidDepth
+		}
+		changes = append(changes, LevelChange{Side: level.side, Price: level.price, Quantity: max(depth[level.price], 0)})
+	}
+	r.touchedLevels = nil
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Side != changes[j].Side {
+			return changes[i].Side == BUY
+		}
+		if changes[i].Side == BUY {
+			return changes[i].Price > changes[j].Price
+		}
+		return changes[i].Price < changes[j].Price
+	})
+	return changes
+}
@@ -0,0 +1,59 @@
+package orderbooks
+
+import "sort"
+
+type L3EventType string
+
+const (
+	L3_ADD     L3EventType = "ADD"     // Quantity rests on the book
+	L3_MODIFY  L3EventType = "MODIFY"  // Quantity is what now rests at Price, 0 once a replaced order filled
+	L3_CANCEL  L3EventType = "CANCEL"  // Quantity was taken off with the order
+	L3_EXECUTE L3EventType = "EXECUTE" // Quantity traded at Price
+)
+
+// L3Event is one change to an order resting on the book.
+type L3Event struct {
+	Type     L3EventType
+	OrderId  string
+	Side     OrderSide
+	Price    int
+	Quantity int
+}
+
+func (r *OrderBook) recordL3(t L3EventType, o *Order, price, quantity int) {
+	r.l3Events = append(r.l3Events, L3Event{Type: t, OrderId: o.Id, Side: o.Side, Price: price, Quantity: quantity})
+}
+
+// TakeL3Events returns and clears the order events since the last call, in the order the
+// book made them.
+func (r *OrderBook) TakeL3Events() []L3Event {
+	events := r.l3Events
+	r.l3Events = nil
+	return events
+}
+
+// RestingOrders returns copies of the orders resting on side, best price first and each
+// level in queue order.
+func (r *OrderBook) RestingOrders(side OrderSide) []Order {
+	levels := r.Asks
+	if side == BUY {
+		levels = r.Bids
+	}
+	prices := make([]int, 0, len(levels))
+	for p := range levels {
+		prices = append(prices, p)
+	}
+	if side == BUY {
+		sort.Sort(sort.Reverse(sort.IntSlice(prices)))
+	} else {
+		sort.Ints(prices)
+	}
+
+	var orders []Order
+	for _, p := range prices {
+		for o := levels[p].Front(); o != nil; o = o.next {
+			orders = append(orders, *o)
+		}
+	}
+	return orders
+}
@@ -0,0 +1,65 @@
+package orderbooks
+
+import "testing"
+
+func TestMarketProtectionPrice(t *testing.T) {
+	tests := []struct {
+		name    string
+		current int
+		side    OrderSide
+		bps     int
+		want    int
+		err     error
+	}{
+		{name: "buy band above the last price", current: 1000, side: BUY, bps: 500, want: 1050},
+		{name: "sell band below the last price", current: 1000, side: SELL, bps: 500, want: 950},
+		{name: "sell band never drops below 1", current: 10, side: SELL, bps: 10000, want: 1},
+		{name: "buy falls back to the best ask", side: BUY, bps: 1000, want: 110},
+		{name: "sell falls back to the best bid", side: SELL, bps: 1000, want: 81},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ob := newTestBook(FIFO)
+			place(t, ob, ask("a1", "alice", 100, 5))
+			place(t, ob, bid("b1", "bob", 90, 5))
+			ob.CurrentPrice = tt.current
+
+			got, err := ob.MarketProtectionPrice(tt.side, tt.bps)
+			if err != tt.err || got != tt.want {
+				t.Fatalf("MarketProtectionPrice = %d, %v; want %d, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestMarketProtectionPriceNeedsAReference(t *testing.T) {
+	ob := newTestBook(FIFO)
+	place(t, ob, bid("b1", "bob", 90, 5))
+	if _, err := ob.MarketProtectionPrice(BUY, 500); err != ErrNoReferencePrice {
+		t.Fatalf("buy into an empty ask side: err %v, want ErrNoReferencePrice", err)
+	}
+}
+
+func TestMarketOrderStopsAtTheBand(t *testing.T) {
+	ob := newTestBook(FIFO)
+	place(t, ob, ask("a1", "alice", 100, 5))
+	place(t, ob, ask("a2", "alice", 104, 5))
+	place(t, ob, ask("a3", "alice", 106, 5))
+	ob.CurrentPrice = 100
+
+	price, err := ob.MarketProtectionPrice(BUY, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := bid("m1", "bob", price, 15)
+	in.TimeInForce = IOC
+	fills := place(t, ob, in)
+
+	if got := filledAgainst(fills); len(got) != 2 || got["a1"] != 5 || got["a2"] != 5 {
+		t.Fatalf("fills %v, want a1 and a2 only", got)
+	}
+	if ob.RestingQuantity("bob", "m1") != 0 {
+		t.Fatal("market order remainder rested")
+	}
+}
@@ -0,0 +1,190 @@
+package orderbooks
+
+import (
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// MatchingPolicy selects how an incoming order is shared among the resting orders of a
+// price level. An empty value is treated as FIFO.
+type MatchingPolicy string
+
+const (
+	FIFO              MatchingPolicy = "FIFO"              // oldest order first
+	PRO_RATA          MatchingPolicy = "PRO_RATA"          // in proportion to resting size
+	PRO_RATA_TOP_FIFO MatchingPolicy = "PRO_RATA_TOP_FIFO" // oldest order first, the rest pro-rata
+)
+
+// Matcher fills an incoming order against one price level. Prices are always walked best
+// first by matchBids / matchAsks; a Matcher only decides who trades within the level.
+type Matcher interface {
+	MatchLevel(r *OrderBook, incoming *Order, level *PriceLevel, price int, depth map[int]int) []Fills
+}
+
+func MatcherFor(policy MatchingPolicy) Matcher {
+	switch policy {
+	case PRO_RATA:
+		return proRataMatcher{}
+	case PRO_RATA_TOP_FIFO:
+		return proRataMatcher{topFIFO: true}
+	default:
+		return fifoMatcher{}
+	}
+}
+
+type fifoMatcher struct{}
+
+func (fifoMatcher) MatchLevel(r *OrderBook, incoming *Order, level *PriceLevel, price int, depth map[int]int) []Fills {
+	var fills []Fills
+	for queuedOrder := level.Front(); queuedOrder != nil && incoming.Filled < incoming.Quantity; {
+		next := queuedOrder.next
+
+		if queuedOrder.UserId == incoming.UserId {
+			if r.preventSelfTrade(incoming, queuedOrder, depth, price) {
+				level.Remove(queuedOrder)
+			}
+			queuedOrder = next
+			continue
+		}
+
+		matchQuantity := min(queuedOrder.Quantity-queuedOrder.Filled, incoming.Quantity-incoming.Filled)
+		fills = append(fills, r.fill(incoming, queuedOrder, level, matchQuantity, price, depth))
+		queuedOrder = next
+	}
+	return fills
+}
+
+// proRataMatcher shares the incoming quantity across a level in proportion to each order's
+// remaining size. Rounding leftovers go one unit at a time in time priority. With topFIFO the
+// oldest order is filled first and only the rest of the incoming quantity is pro-rated.
+// Orders of the incoming user are settled by self-trade prevention before anything is allocated.
+type proRataMatcher struct {
+	topFIFO bool
+}
+
+func (m proRataMatcher) MatchLevel(r *OrderBook, incoming *Order, level *PriceLevel, price int, depth map[int]int) []Fills {
+	for o := level.Front(); o != nil && incoming.Filled < incoming.Quantity; {
+		next := o.next
+		if o.UserId == incoming.UserId && r.preventSelfTrade(incoming, o, depth, price) {
+			level.Remove(o)
+		}
+		o = next
+	}
+
+	var eligible []*Order
+	for o := level.Front(); o != nil; o = o.next {
+		if o.UserId != incoming.UserId {
+			eligible = append(eligible, o)
+		}
+	}
+
+	var fills []Fills
+	if m.topFIFO && len(eligible) > 0 && incoming.Filled < incoming.Quantity {
+		top := eligible[0]
+		fills = append(fills, r.fill(incoming, top, level, min(top.Quantity-top.Filled, incoming.Quantity-incoming.Filled), price, depth))
+		eligible = eligible[1:]
+	}
+
+	remaining := incoming.Quantity - incoming.Filled
+	allocations := proRataAllocate(eligible, remaining)
+	for i, o := range eligible {
+		if allocations[i] > 0 {
+			fills = append(fills, r.fill(incoming, o, level, allocations[i], price, depth))
+		}
+	}
+	return fills
+}
+
+// proRataAllocate splits quantity across orders by remaining size. The result is indexed like
+// orders and never gives an order more than it has left.
+func proRataAllocate(orders []*Order, quantity int) []int {
+	allocations := make([]int, len(orders))
+	total := 0
+	for _, o := range orders {
+		total += o.Quantity - o.Filled
+	}
+	if total == 0 || quantity <= 0 {
+		return allocations
+	}
+	if quantity >= total {
+		for i, o := range orders {
+			allocations[i] = o.Quantity - o.Filled
+		}
+		return allocations
+	}
+
+	allocated := 0
+	for i, o := range orders {
+		allocations[i] = quantity * (o.Quantity - o.Filled) / total
+		allocated += allocations[i]
+	}
+	for i := 0; allocated < quantity; i = (i + 1) % len(orders) {
+		if allocations[i] < orders[i].Quantity-orders[i].Filled {
+			allocations[i]++
+			allocated++
+		}
+	}
+	return allocations
+}
+
+// fill trades quantity between the incoming order and a resting order at price, and takes the
+// resting order off the book once it is complete.
+func (r *OrderBook) fill(incoming, resting *Order, level *PriceLevel, quantity, price int, depth map[int]int) Fills {
+	incoming.Filled += quantity
+	resting.Filled += quantity
+	depth[price] -= quantity
+	r.touchDepth(resting.Side, price)
+	r.recordL3(L3_EXECUTE, resting, price, quantity)
+
+	tradeId := r.nextTradeId()
+	r.CurrentPrice = price
+	r.LastTradeId = tradeId
+
+	if resting.Filled == resting.Quantity {
+		level.Remove(resting)
+		delete(r.UserOrderMap[resting.UserId], resting.Id)
+	}
+
+	fill := Fills{
+		Price:        price,
+		Quantity:     quantity,
+		OtherUserId:  resting.UserId,
+		OtherOrderId: resting.Id,
+		OrderId:      incoming.Id,
+		TradeId:      tradeId,
+	}
+	r.madeFills = append(r.madeFills, fill)
+	return fill
+}
+
+// tradeIdSpace is the UUID namespace trade ids are derived in.
+var tradeIdSpace = uuid.MustParse("5d3f1c2a-8b7e-4f6a-9c1d-2e4b6a8c0f13")
+
+// TradeId derives the id of the index-th fill made for source, the stream entry or auction
+// being applied, in marketId. Replaying the order stream makes the same fills in the same
+// order, so it reproduces the ids the first run published.
+func TradeId(marketId, source string, index int) string {
+	return uuid.NewSHA1(tradeIdSpace, []byte(marketId+"|"+source+"|"+strconv.Itoa(index))).String()
+}
+
+// nextTradeId numbers the fills of the stream entry being applied, or of the matching
+// AttributeFills is running.
+func (r *OrderBook) nextTradeId() string {
+	if !r.attributed && r.fillSource != r.LastStreamId {
+		r.fillSource, r.fillIndex = r.LastStreamId, 0
+	}
+	tradeId := TradeId(r.MarketId, r.fillSource, r.fillIndex)
+	r.fillIndex++
+	return tradeId
+}
+
+// AttributeFills derives the trade ids of the fills fn makes from source instead of the
+// stream entry being applied. It is for matching no one entry causes, such as an uncross,
+// which a live market runs off the clock and replay runs at the next entry.
+func (r *OrderBook) AttributeFills(source string, fn func()) {
+	prevSource, prevIndex := r.fillSource, r.fillIndex
+	r.fillSource, r.fillIndex, r.attributed = source, 0, true
+	fn()
+	r.fillSource, r.fillIndex, r.attributed = prevSource, prevIndex, false
+}
@@ -0,0 +1,163 @@
+package orderbooks
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProRataAllocate(t *testing.T) {
+	tests := []struct {
+		name     string
+		left     []int
+		quantity int
+		want     []int
+	}{
+		{"exact shares", []int{10, 30, 60}, 50, []int{5, 15, 30}},
+		{"leftover goes in time priority", []int{3, 7}, 5, []int{2, 3}},
+		{"leftovers round robin", []int{1, 1, 1}, 2, []int{1, 1, 0}},
+		{"leftover skips a full order", []int{1, 9}, 9, []int{1, 8}},
+		{"quantity covers the level", []int{4, 6}, 20, []int{4, 6}},
+		{"nothing to allocate", []int{4, 6}, 0, []int{0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders := make([]*Order, len(tt.left))
+			for i, left := range tt.left {
+				// Partly filled orders are weighted by what they have left.
+				orders[i] = &Order{Quantity: left + 2, Filled: 2}
+			}
+			got := proRataAllocate(orders, tt.quantity)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("proRataAllocate(%v, %d) = %v, want %v", tt.left, tt.quantity, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProRataMatchesLevelBySize(t *testing.T) {
+	ob := newTestBook(PRO_RATA)
+	place(t, ob, ask("a1", "alice", 100, 10))
+	place(t, ob, ask("a2", "bob", 100, 30))
+	place(t, ob, ask("a3", "carol", 100, 60))
+
+	fills := place(t, ob, bid("b1", "dave", 100, 50))
+	if got := filledAgainst(fills); !reflect.DeepEqual(got, map[string]int{"a1": 5, "a2": 15, "a3": 30}) {
+		t.Fatalf("fills %v", got)
+	}
+	if d := ob.AskDepth[100]; d != 50 {
+		t.Fatalf("ask depth %d, want 50", d)
+	}
+	if ids := levelIds(ob.Asks[100]); !reflect.DeepEqual(ids, []string{"a1", "a2", "a3"}) {
+		t.Fatalf("level %v, want every order still queued in time order", ids)
+	}
+}
+
+func TestProRataTopFIFOFillsOldestFirst(t *testing.T) {
+	ob := newTestBook(PRO_RATA_TOP_FIFO)
+	place(t, ob, ask("a1", "alice", 100, 10))
+	place(t, ob, ask("a2", "bob", 100, 10))
+	place(t, ob, ask("a3", "carol", 100, 20))
+
+	fills := place(t, ob, bid("b1", "dave", 100, 20))
+	if len(fills) == 0 || fills[0].OtherOrderId != "a1" || fills[0].Quantity != 10 {
+		t.Fatalf("first fill %+v, want a1 10", fills)
+	}
+	// The other 10 is split 10:20, the leftover unit going to the older a2.
+	if got := filledAgainst(fills); !reflect.DeepEqual(got, map[string]int{"a1": 10, "a2": 4, "a3": 6}) {
+		t.Fatalf("fills %v", got)
+	}
+	if ids := levelIds(ob.Asks[100]); !reflect.DeepEqual(ids, []string{"a2", "a3"}) {
+		t.Fatalf("level %v, want [a2 a3]", ids)
+	}
+}
+
+func TestProRataWalksPricesBestFirst(t *testing.T) {
+	ob := newTestBook(PRO_RATA)
+	place(t, ob, ask("a1", "alice", 101, 10))
+	place(t, ob, ask("a2", "bob", 100, 4))
+	place(t, ob, ask("a3", "carol", 100, 6))
+
+	fills := place(t, ob, bid("b1", "dave", 101, 15))
+	if got := filledAgainst(fills); !reflect.DeepEqual(got, map[string]int{"a1": 5, "a2": 4, "a3": 6}) {
+		t.Fatalf("fills %v", got)
+	}
+	if fills[len(fills)-1].Price != 101 {
+		t.Fatalf("last fill at %d, want 101", fills[len(fills)-1].Price)
+	}
+	if _, ok := ob.Asks[100]; ok {
+		t.Fatal("emptied level 100 is still on the book")
+	}
+}
+
+func tradeIds(fills []Fills) []string {
+	var ids []string
+	for _, f := range fills {
+		ids = append(ids, f.TradeId)
+	}
+	return ids
+}
+
+// replayTrades applies the same entries to a fresh book and returns every trade id made.
+func replayTrades(t *testing.T) []string {
+	t.Helper()
+	ob := newTestBook(FIFO)
+	place(t, ob, ask("a1", "alice", 100, 5))
+	place(t, ob, ask("a2", "bob", 101, 5))
+	ids := tradeIds(place(t, ob, bid("b1", "carol", 101, 8)))
+	return append(ids, tradeIds(place(t, ob, bid("b2", "dave", 101, 2)))...)
+}
+
+func TestTradeIdsAreDerivedFromTheStream(t *testing.T) {
+	got := replayTrades(t)
+	want := []string{
+		TradeId("test-market", "b1", 0),
+		TradeId("test-market", "b1", 1),
+		TradeId("test-market", "b2", 0),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("trade ids %v, want %v", got, want)
+	}
+	if again := replayTrades(t); !reflect.DeepEqual(again, got) {
+		t.Fatalf("replay made %v, first run %v", again, got)
+	}
+	if TradeId("other-market", "b1", 0) == want[0] {
+		t.Fatal("trade ids collide across markets")
+	}
+}
+
+func TestAttributeFillsNumbersFromItsSource(t *testing.T) {
+	ob := newTestBook(FIFO)
+	place(t, ob, ask("a1", "alice", 100, 5))
+	place(t, ob, ask("a2", "bob", 100, 5))
+	place(t, ob, bid("b1", "carol", 99, 5))
+
+	var attributed []Fills
+	ob.AttributeFills("auction-1", func() {
+		ob.LastStreamId = "b2"
+		fills, _, err := ob.AddOrder(bid("b2", "dave", 100, 3), 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		attributed = append(attributed, fills...)
+		fills, _, err = ob.AddOrder(bid("b3", "erin", 100, 3), 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		attributed = append(attributed, fills...)
+	})
+	want := []string{
+		TradeId("test-market", "auction-1", 0),
+		TradeId("test-market", "auction-1", 1),
+		TradeId("test-market", "auction-1", 2),
+	}
+	if got := tradeIds(attributed); !reflect.DeepEqual(got, want) {
+		t.Fatalf("attributed trade ids %v, want %v", got, want)
+	}
+
+	// The stream entry being applied numbers its own fills again afterwards.
+	fills := place(t, ob, bid("b4", "frank", 100, 1))
+	if got := tradeIds(fills); !reflect.DeepEqual(got, []string{TradeId("test-market", "b4", 0)}) {
+		t.Fatalf("trade ids after AttributeFills %v", got)
+	}
+}
@@ -2,8 +2,8 @@ package orderbooks
 
 import (
 	"errors"
+	"sort"
 
-	"github.com/google/uuid"
 	heap "github.com/raiashpanda007/rivon/engine/internals/utils"
 )
 
@@ -14,14 +14,73 @@ const (
 	SELL OrderSide = "SELL"
 )
 
+// TimeInForce controls what happens to the unfilled remainder of an order.
+// An empty value is treated as GTC.
+type TimeInForce string
+
+const (
+	GTC TimeInForce = "GTC" // rest until filled or cancelled
+	IOC TimeInForce = "IOC" // match what is possible, cancel the rest
+	FOK TimeInForce = "FOK" // fill completely or reject without touching the book
+	GTD TimeInForce = "GTD" // rest until ExpiresAt, then expire
+)
+
+// OrderKind distinguishes limit orders from market orders. An empty value is treated as LIMIT.
+type OrderKind string
+
+const (
+	LIMIT  OrderKind = "LIMIT"
+	MARKET OrderKind = "MARKET"
+)
+
+// STPMode is the self-trade prevention policy of the incoming order, applied when it
+// meets a resting order from the same user. An empty value is treated as CANCEL_OLDEST.
+type STPMode string
+
+const (
+	CANCEL_NEWEST        STPMode = "CANCEL_NEWEST"        // cancel the incoming remainder, keep the resting order
+	CANCEL_OLDEST        STPMode = "CANCEL_OLDEST"        // cancel the resting order and keep matching
+	CANCEL_BOTH          STPMode = "CANCEL_BOTH"          // cancel the resting order and the incoming remainder
+	DECREMENT_AND_CANCEL STPMode = "DECREMENT_AND_CANCEL" // shrink both by the smaller remainder, cancel whichever hits zero
+)
+
+var ErrFOKNotFillable = errors.New("fill or kill order cannot be fully filled")
+
+var ErrNoReferencePrice = errors.New("no reference price available for market order")
+
+var ErrPostOnlyWouldCross = errors.New("post only order would take liquidity")
+
+var ErrOrderNotFound = errors.New("order not found in the book")
+
+var ErrInvalidReplace = errors.New("replacement must keep a positive price and a quantity above the filled quantity")
+
 type Order struct {
-	Id       string
+	Id          string
+	Quantity    int
+	Filled      int
+	Side        OrderSide
+	UserId      string
+	Price       int
+	StreamId    string
+	TimeInForce TimeInForce
+	ExpiresAt   int64 // unix millis, only meaningful for GTD
+	PostOnly    bool  // reject instead of matching if the order would take liquidity
+	STP         STPMode
+
+	prev, next *Order // neighbours in the PriceLevel queue while resting
+}
+
+// Rests reports whether an unfilled remainder of this order may be queued on the book.
+func (o *Order) Rests() bool {
+	return o.TimeInForce != IOC && o.TimeInForce != FOK
+}
+
+// SelfTradeCancel is resting quantity cancelled by self-trade prevention. Order is a copy
+// taken after the cancel and Removed reports whether the order left the book.
+type SelfTradeCancel struct {
+	Order    Order
 	Quantity int
-	Filled   int
-	Side     OrderSide
-	UserId   string
-	Price    int
-	StreamId string
+	Removed  bool
 }
 
 type Fills struct {
@@ -34,8 +93,9 @@ type Fills struct {
 }
 
 type OrderBook struct {
-	Bids         map[int][]*Order
-	Asks         map[int][]*Order
+	MarketId     string // trade ids are derived from it; set by the market process
+	Bids         map[int]*PriceLevel
+	Asks         map[int]*PriceLevel
 	BidHeap      *heap.MaxHeap
 	AskHeap      *heap.MinHeap
 	BidDepth     map[int]int
@@ -45,19 +105,36 @@ type OrderBook struct {
 	LastTradeId  string
 	LastOrderId  string
 	LastStreamId string
+	Triggers     *TriggerBook
+	Policy       MatchingPolicy
+	Breaker      *CircuitBreaker
+	Auction      *CallAuction // nil while matching continuously
+	Settled      bool         // settled at CurrentPrice; the book takes no more orders
+
+	selfTradeCancels []SelfTradeCancel
+	madeFills        []Fills
+	touchedLevels    map[depthLevel]struct{} // see TakeDepthChanges
+	l3Events         []L3Event
+
+	// the source and position of the next fill's trade id, see nextTradeId
+	fillSource string
+	fillIndex  int
+	attributed bool
 }
 
-func NewOrderBook(lastTradeId, lastOrderId, lastStreamId string, bids map[int][]Order, asks map[int][]Order, askHeap *heap.MinHeap, bidHeap *heap.MaxHeap, currentPrice int) OrderBook {
-	bidsCopy := make(map[int][]*Order)
-	asksCopy := make(map[int][]*Order)
+func NewOrderBook(lastTradeId, lastOrderId, lastStreamId string, bids map[int][]Order, asks map[int][]Order, askHeap *heap.MinHeap, bidHeap *heap.MaxHeap, currentPrice int, stops []StopOrder, policy MatchingPolicy) OrderBook {
+	bidsCopy := make(map[int]*PriceLevel)
+	asksCopy := make(map[int]*PriceLevel)
 	userOrderMap := make(map[string]map[string]*Order)
 	bidDepth := make(map[int]int)
 	askDepth := make(map[int]int)
 
+	// Slices are in queue order, oldest first.
 	for price, orders := range bids {
+		level := NewPriceLevel()
 		for i := range orders {
 			orderPtr := &orders[i]
-			bidsCopy[price] = append(bidsCopy[price], orderPtr)
+			level.PushBack(orderPtr)
 			bidDepth[price] += orderPtr.Quantity - orderPtr.Filled
 
 			if _, ok := userOrderMap[orderPtr.UserId]; !ok {
@@ -65,12 +142,14 @@ func NewOrderBook(lastTradeId, lastOrderId, lastStreamId string, bids map[int][]
 			}
 			userOrderMap[orderPtr.UserId][orderPtr.Id] = orderPtr
 		}
+		bidsCopy[price] = level
 	}
 
 	for price, orders := range asks {
+		level := NewPriceLevel()
 		for i := range orders {
 			orderPtr := &orders[i]
-			asksCopy[price] = append(asksCopy[price], orderPtr)
+			level.PushBack(orderPtr)
 			askDepth[price] += orderPtr.Quantity - orderPtr.Filled
 
 			if _, ok := userOrderMap[orderPtr.UserId]; !ok {
@@ -78,6 +157,7 @@ func NewOrderBook(lastTradeId, lastOrderId, lastStreamId string, bids map[int][]
 			}
 			userOrderMap[orderPtr.UserId][orderPtr.Id] = orderPtr
 		}
+		asksCopy[price] = level
 	}
 
 	return OrderBook{
@@ -92,6 +172,9 @@ func NewOrderBook(lastTradeId, lastOrderId, lastStreamId string, bids map[int][]
 		LastTradeId:  lastTradeId,
 		LastOrderId:  lastOrderId,
 		LastStreamId: lastStreamId,
+		Triggers:     NewTriggerBook(stops),
+		Policy:       policy,
+		Breaker:      NewCircuitBreaker(),
 	}
 }
 
@@ -107,22 +190,22 @@ func (r *OrderBook) GetSnapshot() OrderBook {
 		return orderCopy[o]
 	}
 
-	bidsCopy := make(map[int][]*Order, len(r.Bids))
-	for price, orders := range r.Bids {
-		slice := make([]*Order, len(orders))
-		for i, o := range orders {
-			slice[i] = copyOrder(o)
+	bidsCopy := make(map[int]*PriceLevel, len(r.Bids))
+	for price, level := range r.Bids {
+		levelCopy := NewPriceLevel()
+		for o := level.Front(); o != nil; o = o.next {
+			levelCopy.PushBack(copyOrder(o))
 		}
-		bidsCopy[price] = slice
+		bidsCopy[price] = levelCopy
 	}
 
-	asksCopy := make(map[int][]*Order, len(r.Asks))
-	for price, orders := range r.Asks {
-		slice := make([]*Order, len(orders))
-		for i, o := range orders {
-			slice[i] = copyOrder(o)
+	asksCopy := make(map[int]*PriceLevel, len(r.Asks))
+	for price, level := range r.Asks {
+		levelCopy := NewPriceLevel()
+		for o := level.Front(); o != nil; o = o.next {
+			levelCopy.PushBack(copyOrder(o))
 		}
-		asksCopy[price] = slice
+		asksCopy[price] = levelCopy
 	}
 
 	userOrderMapCopy := make(map[string]map[string]*Order, len(r.UserOrderMap))
@@ -143,6 +226,7 @@ func (r *OrderBook) GetSnapshot() OrderBook {
 	}
 
 	return OrderBook{
+		MarketId:     r.MarketId,
 		Bids:         bidsCopy,
 		Asks:         asksCopy,
 		BidHeap:      r.BidHeap.Clone(),
@@ -154,15 +238,23 @@ func (r *OrderBook) GetSnapshot() OrderBook {
 		LastTradeId:  r.LastTradeId,
 		LastOrderId:  r.LastOrderId,
 		LastStreamId: r.LastStreamId,
+		Triggers:     r.Triggers.Clone(),
+		Policy:       r.Policy,
+		Breaker:      r.Breaker.Clone(),
+		Auction:      r.Auction.Clone(),
+		Settled:      r.Settled,
 	}
 }
 
 func (r *OrderBook) addOrderToBids(order *Order, price int) {
 	if _, exists := r.Bids[price]; !exists {
 		r.BidHeap.Insert(price)
+		r.Bids[price] = NewPriceLevel()
 	}
-	r.Bids[price] = append(r.Bids[price], order)
+	r.Bids[price].PushBack(order)
 	r.BidDepth[price] += order.Quantity - order.Filled
+	r.touchDepth(BUY, price)
+	r.recordL3(L3_ADD, order, price, order.Quantity-order.Filled)
 
 	if _, exists := r.UserOrderMap[order.UserId]; !exists {
 		r.UserOrderMap[order.UserId] = make(map[string]*Order)
@@ -173,9 +265,12 @@ func (r *OrderBook) addOrderToBids(order *Order, price int) {
 func (r *OrderBook) addOrderToAsks(order *Order, price int) {
 	if _, ok := r.Asks[price]; !ok {
 		r.AskHeap.Insert(price)
+		r.Asks[price] = NewPriceLevel()
 	}
-	r.Asks[price] = append(r.Asks[price], order)
+	r.Asks[price].PushBack(order)
 	r.AskDepth[price] += order.Quantity - order.Filled
+	r.touchDepth(SELL, price)
+	r.recordL3(L3_ADD, order, price, order.Quantity-order.Filled)
 
 	if _, exists := r.UserOrderMap[order.UserId]; !exists {
 		r.UserOrderMap[order.UserId] = make(map[string]*Order)
@@ -185,209 +280,321 @@ func (r *OrderBook) addOrderToAsks(order *Order, price int) {
 
 func (r *OrderBook) matchBids(order *Order, price int) ([]Fills, int) {
 	var fills []Fills
-	var executedQty int = 0
-	var queued bool
-
-	for order.Filled < order.Quantity {
-		if r.AskHeap.Size() == 0 {
-			r.addOrderToBids(order, price)
-			queued = true
-			break
-		}
+	filledBefore := order.Filled
+	matcher := MatcherFor(r.Policy)
 
+	for order.Filled < order.Quantity && r.AskHeap.Size() > 0 {
 		bestAskPrice := r.AskHeap.Peek()
 
 		if price < bestAskPrice {
-			r.addOrderToBids(order, price)
-			queued = true
 			break
 		}
 
-		askOrders := r.Asks[bestAskPrice]
-		if len(askOrders) == 0 {
+		level, ok := r.Asks[bestAskPrice]
+		if !ok || level.Len() == 0 {
+			delete(r.Asks, bestAskPrice)
+			delete(r.AskDepth, bestAskPrice)
+			r.touchDepth(SELL, bestAskPrice)
 			r.AskHeap.Pop()
 			continue
 		}
 
-		matchedInThisLevel := false
-
-		for i := len(askOrders) - 1; i >= 0; i-- {
-			if order.Filled >= order.Quantity {
-				break
-			}
-
-			queuedOrder := askOrders[i]
+		fills = append(fills, matcher.MatchLevel(r, order, level, bestAskPrice, r.AskDepth)...)
 
-			if queuedOrder.Filled >= queuedOrder.Quantity {
-				askOrders = append(askOrders[:i], askOrders[i+1:]...)
-				continue
-			}
+		if level.Len() > 0 {
+			// The incoming order is done, or was cut short by self-trade prevention.
+			break
+		}
+		delete(r.Asks, bestAskPrice)
+		delete(r.AskDepth, bestAskPrice)
+		r.AskHeap.Pop()
+	}
 
-			if queuedOrder.UserId == order.UserId {
-				continue
-			}
+	return fills, order.Filled - filledBefore
+}
 
-			remainingInQueue := queuedOrder.Quantity - queuedOrder.Filled
-			remainingInIncoming := order.Quantity - order.Filled
+func (r *OrderBook) matchAsks(order *Order, price int) ([]Fills, int) {
+	var fills []Fills
+	filledBefore := order.Filled
+	matcher := MatcherFor(r.Policy)
 
-			matchQuantity := remainingInQueue
-			if remainingInIncoming < remainingInQueue {
-				matchQuantity = remainingInIncoming
-			}
+	for order.Filled < order.Quantity && r.BidHeap.Size() > 0 {
+		bestBidPrice := r.BidHeap.Peek()
 
-			order.Filled += matchQuantity
-			queuedOrder.Filled += matchQuantity
-			executedQty += matchQuantity
-			matchedInThisLevel = true
-			r.AskDepth[bestAskPrice] -= matchQuantity
-
-			tradeId := uuid.NewString()
-			fills = append(fills, Fills{
-				Price:        bestAskPrice,
-				Quantity:     matchQuantity,
-				OtherUserId:  queuedOrder.UserId,
-				OtherOrderId: queuedOrder.Id,
-				OrderId:      order.Id,
-				TradeId:      tradeId,
-			})
-
-			r.CurrentPrice = bestAskPrice
-			r.LastTradeId = tradeId
-
-			if queuedOrder.Filled == queuedOrder.Quantity {
-				askOrders = append(askOrders[:i], askOrders[i+1:]...)
-			}
+		if price > bestBidPrice {
+			break
 		}
 
-		r.Asks[bestAskPrice] = askOrders
-
-		if len(askOrders) == 0 {
-			delete(r.Asks, bestAskPrice)
-			delete(r.AskDepth, bestAskPrice)
-			r.AskHeap.Pop()
+		level, ok := r.Bids[bestBidPrice]
+		if !ok || level.Len() == 0 {
+			delete(r.Bids, bestBidPrice)
+			delete(r.BidDepth, bestBidPrice)
+			r.touchDepth(BUY, bestBidPrice)
+			r.BidHeap.Pop()
+			continue
 		}
 
-		if !matchedInThisLevel {
+		fills = append(fills, matcher.MatchLevel(r, order, level, bestBidPrice, r.BidDepth)...)
+
+		if level.Len() > 0 {
+			// The incoming order is done, or was cut short by self-trade prevention.
 			break
 		}
+		delete(r.Bids, bestBidPrice)
+		delete(r.BidDepth, bestBidPrice)
+		r.BidHeap.Pop()
 	}
 
-	if !queued && order.Filled < order.Quantity {
-		r.addOrderToBids(order, price)
+	return fills, order.Filled - filledBefore
+}
+
+// preventSelfTrade applies the incoming order's STP mode to a resting order of the same user.
+// Cancelled incoming quantity comes off its Quantity so it never rests, and cancelled resting
+// quantity is recorded for TakeSelfTradeCancels. It reports whether the resting order left
+// the book, in which case the caller drops it from its price level.
+func (r *OrderBook) preventSelfTrade(incoming, resting *Order, depth map[int]int, price int) bool {
+	restingLeft := resting.Quantity - resting.Filled
+
+	switch incoming.STP {
+	case CANCEL_NEWEST:
+		incoming.Quantity = incoming.Filled
+		return false
+	case CANCEL_BOTH:
+		incoming.Quantity = incoming.Filled
+		r.cancelRestingSelfTrade(resting, restingLeft, depth, price)
+		return true
+	case DECREMENT_AND_CANCEL:
+		qty := min(incoming.Quantity-incoming.Filled, restingLeft)
+		incoming.Quantity -= qty
+		if qty == restingLeft {
+			r.cancelRestingSelfTrade(resting, restingLeft, depth, price)
+			return true
+		}
+		resting.Quantity -= qty
+		depth[price] -= qty
+		r.touchDepth(resting.Side, price)
+		r.recordL3(L3_MODIFY, resting, price, resting.Quantity-resting.Filled)
+		r.selfTradeCancels = append(r.selfTradeCancels, SelfTradeCancel{Order: *resting, Quantity: qty})
+		return false
+	default:
+		r.cancelRestingSelfTrade(resting, restingLeft, depth, price)
+		return true
 	}
+}
 
-	return fills, executedQty
+func (r *OrderBook) cancelRestingSelfTrade(resting *Order, qty int, depth map[int]int, price int) {
+	depth[price] -= qty
+	r.touchDepth(resting.Side, price)
+	r.recordL3(L3_CANCEL, resting, price, qty)
+	delete(r.UserOrderMap[resting.UserId], resting.Id)
+	r.selfTradeCancels = append(r.selfTradeCancels, SelfTradeCancel{Order: *resting, Quantity: qty, Removed: true})
 }
 
-func (r *OrderBook) matchAsks(order *Order, price int) ([]Fills, int) {
-	var fills []Fills
-	var executedQty int = 0
-	var queued bool
+// TakeSelfTradeCancels returns and clears the resting cancels made by self-trade prevention
+// since the last call, so the caller can release their escrow and report them.
+func (r *OrderBook) TakeSelfTradeCancels() []SelfTradeCancel {
+	cancels := r.selfTradeCancels
+	r.selfTradeCancels = nil
+	return cancels
+}
 
-	for order.Filled < order.Quantity {
-		if r.BidHeap.Size() == 0 {
-			r.addOrderToAsks(order, price)
-			queued = true
-			break
-		}
+// TakeFills returns and clears every fill the book made since the last call, so the caller
+// can journal them with the input that made them.
+func (r *OrderBook) TakeFills() []Fills {
+	fills := r.madeFills
+	r.madeFills = nil
+	return fills
+}
 
-		bestBidPrice := r.BidHeap.Peek()
+// RestingQuantity returns the unfilled quantity of orderId still on the book, or 0.
+func (r *OrderBook) RestingQuantity(userId, orderId string) int {
+	o, ok := r.UserOrderMap[userId][orderId]
+	if !ok || o.Filled >= o.Quantity {
+		return 0
+	}
+	return o.Quantity - o.Filled
+}
 
-		if price > bestBidPrice {
-			r.addOrderToAsks(order, price)
-			queued = true
-			break
-		}
+// AddOrder matches the incoming order against the opposite side and then decides,
+// from its time in force, whether the unfilled remainder rests on the book.
+func (r *OrderBook) AddOrder(order Order, price int) ([]Fills, int, error) {
+	r.LastOrderId = order.Id
+	if order.Side != BUY && order.Side != SELL {
+		return []Fills{}, 0, errors.New("invalid order side")
+	}
 
-		bidOrders := r.Bids[bestBidPrice]
-		if len(bidOrders) == 0 {
-			r.BidHeap.Pop()
-			continue
+	// During a call auction orders only queue; the uncross matches them.
+	if r.Auction != nil {
+		r.LastStreamId = order.StreamId
+		if !order.Rests() {
+			return []Fills{}, 0, ErrAuctionImmediateOrder
 		}
+		if order.Side == BUY {
+			r.addOrderToBids(&order, price)
+		} else {
+			r.addOrderToAsks(&order, price)
+		}
+		return []Fills{}, 0, nil
+	}
 
-		matchedInThisLevel := false
+	if order.PostOnly && r.crosses(order.Side, price) {
+		r.LastStreamId = order.StreamId
+		return []Fills{}, 0, ErrPostOnlyWouldCross
+	}
 
-		for i := len(bidOrders) - 1; i >= 0; i-- {
-			if order.Filled >= order.Quantity {
-				break
-			}
+	if order.TimeInForce == FOK && r.fillableQuantity(order.Side, price, order.UserId, order.STP) < order.Quantity-order.Filled {
+		r.LastStreamId = order.StreamId
+		return []Fills{}, 0, ErrFOKNotFillable
+	}
 
-			queuedOrder := bidOrders[i]
+	var fills []Fills
+	var executedQty int
+	if order.Side == BUY {
+		fills, executedQty = r.matchBids(&order, price)
+		if order.Filled < order.Quantity && order.Rests() {
+			r.addOrderToBids(&order, price)
+		}
+	} else {
+		fills, executedQty = r.matchAsks(&order, price)
+		if order.Filled < order.Quantity && order.Rests() {
+			r.addOrderToAsks(&order, price)
+		}
+	}
+	r.LastStreamId = order.StreamId
+	return fills, executedQty, nil
+}
 
-			if queuedOrder.Filled >= queuedOrder.Quantity {
-				bidOrders = append(bidOrders[:i], bidOrders[i+1:]...)
-				continue
-			}
+// MarketProtectionPrice returns the worst price a MARKET order on the given side may
+// trade at: the reference price moved against the taker by maxSlippageBps basis points.
+// The reference is CurrentPrice, or the best opposite price when nothing has traded yet.
+func (r *OrderBook) MarketProtectionPrice(side OrderSide, maxSlippageBps int) (int, error) {
+	reference := r.CurrentPrice
+	if reference == 0 {
+		if side == BUY && r.AskHeap.Size() > 0 {
+			reference = r.AskHeap.Peek()
+		} else if side == SELL && r.BidHeap.Size() > 0 {
+			reference = r.BidHeap.Peek()
+		}
+	}
+	if reference == 0 {
+		return 0, ErrNoReferencePrice
+	}
 
-			if queuedOrder.UserId == order.UserId {
-				continue
-			}
+	band := reference * maxSlippageBps / 10000
+	if side == BUY {
+		return reference + band, nil
+	}
+	if reference-band < 1 {
+		return 1, nil
+	}
+	return reference - band, nil
+}
 
-			remainingInQueue := queuedOrder.Quantity - queuedOrder.Filled
-			remainingInIncoming := order.Quantity - order.Filled
+// crosses reports whether any resting order, the user's own included, sits at or through price.
+func (r *OrderBook) crosses(side OrderSide, price int) bool {
+	if side == BUY {
+		return r.AskHeap.Size() > 0 && r.AskHeap.Peek() <= price
+	}
+	return r.BidHeap.Size() > 0 && r.BidHeap.Peek() >= price
+}
 
-			matchQuantity := remainingInQueue
-			if remainingInIncoming < remainingInQueue {
-				matchQuantity = remainingInIncoming
-			}
+// fillableQuantity returns how much of an incoming order at the given limit price could be
+// matched right now. Levels are walked in matching order so that a resting order of the same
+// user stops the count unless its STP mode cancels the resting order and keeps going. Under
+// pro-rata the whole level containing such an order is left out, since it is allocated at once.
+// It does not mutate the book.
+func (r *OrderBook) fillableQuantity(side OrderSide, price int, userId string, stp STPMode) int {
+	levels := r.Asks
+	if side == SELL {
+		levels = r.Bids
+	}
 
-			order.Filled += matchQuantity
-			queuedOrder.Filled += matchQuantity
-			executedQty += matchQuantity
-			matchedInThisLevel = true
-			r.BidDepth[bestBidPrice] -= matchQuantity
-
-			tradeId := uuid.NewString()
-			fills = append(fills, Fills{
-				Price:        bestBidPrice,
-				Quantity:     matchQuantity,
-				OtherUserId:  queuedOrder.UserId,
-				OtherOrderId: queuedOrder.Id,
-				OrderId:      order.Id,
-				TradeId:      tradeId,
-			})
-
-			r.CurrentPrice = bestBidPrice
-			r.LastTradeId = tradeId
-
-			if queuedOrder.Filled == queuedOrder.Quantity {
-				bidOrders = append(bidOrders[:i], bidOrders[i+1:]...)
+	var prices []int
+	for levelPrice := range levels {
+		if (side == BUY && levelPrice <= price) || (side == SELL && levelPrice >= price) {
+			prices = append(prices, levelPrice)
+		}
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		if side == BUY {
+			return prices[i] < prices[j]
+		}
+		return prices[i] > prices[j]
+	})
+
+	total := 0
+	for _, levelPrice := range prices {
+		levelStart := total
+		for o := levels[levelPrice].Front(); o != nil; o = o.next {
+			if o.UserId == userId {
+				if stp == CANCEL_NEWEST || stp == CANCEL_BOTH || stp == DECREMENT_AND_CANCEL {
+					if r.Policy == PRO_RATA || r.Policy == PRO_RATA_TOP_FIFO {
+						return levelStart
+					}
+					return total
+				}
+				continue
 			}
+			total += o.Quantity - o.Filled
 		}
+	}
+	return total
+}
 
-		r.Bids[bestBidPrice] = bidOrders
+// expiredBy reports whether o is a GTD order whose ExpiresAt is at or before now.
+func (o *Order) expiredBy(now int64) bool {
+	return o.TimeInForce == GTD && o.ExpiresAt != 0 && o.ExpiresAt <= now
+}
 
-		if len(bidOrders) == 0 {
-			delete(r.Bids, bestBidPrice)
-			delete(r.BidDepth, bestBidPrice)
-			r.BidHeap.Pop()
+// sortByExpiry orders expired orders by ExpiresAt, then id, so they are cancelled in the
+// same order on every run whatever the map iteration order.
+func sortByExpiry(orders []*Order) {
+	sort.Slice(orders, func(i, j int) bool {
+		if orders[i].ExpiresAt != orders[j].ExpiresAt {
+			return orders[i].ExpiresAt < orders[j].ExpiresAt
 		}
+		return orders[i].Id < orders[j].Id
+	})
+}
 
-		if !matchedInThisLevel {
-			break
+// ExpireOrders fully cancels every resting GTD order whose ExpiresAt is at or
+// before now (unix millis) and returns them so the caller can release escrow.
+// They are cancelled earliest expiry first.
+func (r *OrderBook) ExpireOrders(now int64) []*Order {
+	var due []*Order
+	for _, userOrders := range r.UserOrderMap {
+		for _, o := range userOrders {
+			if o.expiredBy(now) {
+				due = append(due, o)
+			}
 		}
 	}
+	sortByExpiry(due)
 
-	if !queued && order.Filled < order.Quantity {
-		r.addOrderToAsks(order, price)
+	var expired []*Order
+	for _, o := range due {
+		if cancelled, ok := r.CancelOrder(o.Id, o.UserId, 0); ok {
+			expired = append(expired, cancelled)
+		}
 	}
-
-	return fills, executedQty
+	return expired
 }
 
-func (r *OrderBook) AddOrder(order Order, price int) ([]Fills, int, error) {
-	r.LastOrderId = order.Id
-	if order.Side == BUY {
-		fills, executedQty := r.matchBids(&order, price)
-		r.LastStreamId = order.StreamId
-		return fills, executedQty, nil
-	} else if order.Side == SELL {
-		fills, executedQty := r.matchAsks(&order, price)
-		r.LastStreamId = order.StreamId
-		return fills, executedQty, nil
+// HasExpired reports whether a resting GTD order or a pending GTD stop has expired by now.
+func (r *OrderBook) HasExpired(now int64) bool {
+	for _, userOrders := range r.UserOrderMap {
+		for _, o := range userOrders {
+			if o.expiredBy(now) {
+				return true
+			}
+		}
 	}
-
-	return []Fills{}, 0, errors.New("invalid order side")
+	for _, stop := range r.Triggers.byId {
+		if stop.expiredBy(now) {
+			return true
+		}
+	}
+	return false
 }
 
 func (r *OrderBook) GetOpenOrders(userId string) []*Order {
@@ -411,7 +618,13 @@ func (r *OrderBook) GetOpenOrders(userId string) []*Order {
 // For partial cancel (0 < cancelQty < remaining unfilled), the order stays in the
 // book with reduced quantity and a synthetic order representing the cancelled portion
 // is returned so the caller can unlock the correct amount of funds/assets.
+//
+// Pending stop orders are only ever cancelled in full, whatever cancelQty says.
 func (r *OrderBook) CancelOrder(orderId string, userId string, cancelQty int) (*Order, bool) {
+	if stop, ok := r.Triggers.Cancel(orderId, userId); ok {
+		return &stop.Order, true
+	}
+
 	userOrders, ok := r.UserOrderMap[userId]
 	if !ok {
 		return nil, false
@@ -422,7 +635,7 @@ func (r *OrderBook) CancelOrder(orderId string, userId string, cancelQty int) (*
 		return nil, false
 	}
 
-	var bucket map[int][]*Order
+	var bucket map[int]*PriceLevel
 	if order.Side == BUY {
 		bucket = r.Bids
 	} else {
@@ -441,7 +654,9 @@ func (r *OrderBook) CancelOrder(orderId string, userId string, cancelQty int) (*
 	// Partial cancel: reduce quantity without removing the order.
 	if cancelQty > 0 && cancelQty < remaining {
 		depthMap[order.Price] -= cancelQty
+		r.touchDepth(order.Side, order.Price)
 		order.Quantity -= cancelQty
+		r.recordL3(L3_MODIFY, order, order.Price, order.Quantity-order.Filled)
 		return &Order{
 			Id:       order.Id,
 			UserId:   order.UserId,
@@ -452,25 +667,84 @@ func (r *OrderBook) CancelOrder(orderId string, userId string, cancelQty int) (*
 		}, true
 	}
 
-	// Full cancel.
-	orders := bucket[order.Price]
-	for i, o := range orders {
-		if o.Id == orderId {
-			bucket[order.Price] = append(orders[:i], orders[i+1:]...)
-			depthMap[order.Price] -= remaining
-			if len(bucket[order.Price]) == 0 {
-				delete(bucket, order.Price)
-				delete(depthMap, order.Price)
-				if order.Side == BUY {
-					r.BidHeap.Remove(order.Price)
-				} else {
-					r.AskHeap.Remove(order.Price)
-				}
-			}
-			delete(userOrders, orderId)
-			return order, true
+	// Full cancel: the order is unlinked from its level directly.
+	level, ok := bucket[order.Price]
+	if !ok {
+		return nil, false
+	}
+	level.Remove(order)
+	depthMap[order.Price] -= remaining
+	r.touchDepth(order.Side, order.Price)
+	r.recordL3(L3_CANCEL, order, order.Price, remaining)
+	if level.Len() == 0 {
+		delete(bucket, order.Price)
+		delete(depthMap, order.Price)
+		if order.Side == BUY {
+			r.BidHeap.Remove(order.Price)
+		} else {
+			r.AskHeap.Remove(order.Price)
+		}
+	}
+	delete(userOrders, orderId)
+	return order, true
+}
+
+// ReplaceOrder amends the price and total quantity of a resting order without changing its id.
+// Reducing the quantity at the same price keeps time priority. Any other change takes the
+// order off the book and runs it through matching again at the new price, behind every
+// order already queued there. It returns a copy of the amended order.
+func (r *OrderBook) ReplaceOrder(orderId, userId string, price, quantity int, streamId string) (Order, []Fills, int, error) {
+	defer func() { r.LastStreamId = streamId }()
+
+	order, ok := r.UserOrderMap[userId][orderId]
+	if !ok || order.Filled >= order.Quantity {
+		return Order{}, []Fills{}, 0, ErrOrderNotFound
+	}
+	if price <= 0 || quantity <= order.Filled {
+		return Order{}, []Fills{}, 0, ErrInvalidReplace
+	}
+	if price == order.Price && quantity <= order.Quantity {
+		if order.Side == BUY {
+			r.BidDepth[order.Price] -= order.Quantity - quantity
+		} else {
+			r.AskDepth[order.Price] -= order.Quantity - quantity
 		}
+		r.touchDepth(order.Side, order.Price)
+		order.Quantity = quantity
+		r.recordL3(L3_MODIFY, order, order.Price, order.Quantity-order.Filled)
+		return *order, []Fills{}, 0, nil
 	}
 
-	return nil, false
+	if r.Auction == nil && order.PostOnly && r.crosses(order.Side, price) {
+		return Order{}, []Fills{}, 0, ErrPostOnlyWouldCross
+	}
+
+	// The order keeps its id, so L3 sees one MODIFY for the move instead of a CANCEL and an
+	// ADD; the fills it makes on the way are reported as they happen.
+	mark := len(r.l3Events)
+	r.CancelOrder(orderId, userId, 0)
+	r.l3Events = r.l3Events[:mark]
+	order.Price, order.Quantity, order.StreamId = price, quantity, streamId
+
+	var fills []Fills
+	var executedQty int
+	if r.Auction == nil {
+		if order.Side == BUY {
+			fills, executedQty = r.matchBids(order, price)
+		} else {
+			fills, executedQty = r.matchAsks(order, price)
+		}
+	}
+	if order.Filled < order.Quantity {
+		// Resting the remainder records an ADD; drop that one event, whatever matching left before it.
+		added := len(r.l3Events)
+		if order.Side == BUY {
+			r.addOrderToBids(order, price)
+		} else {
+			r.addOrderToAsks(order, price)
+		}
+		r.l3Events = append(r.l3Events[:added], r.l3Events[added+1:]...)
+	}
+	r.recordL3(L3_MODIFY, order, price, order.Quantity-order.Filled)
+	return *order, fills, executedQty, nil
 }
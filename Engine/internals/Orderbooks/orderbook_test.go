@@ -0,0 +1,249 @@
+package orderbooks
+
+import (
+	"reflect"
+	"testing"
+
+	heap "github.com/raiashpanda007/rivon/engine/internals/utils"
+)
+
+func newTestBook(policy MatchingPolicy) *OrderBook {
+	ob := NewOrderBook("", "", "", nil, nil, heap.NewMinHeap(), heap.NewMaxHeap(), 0, nil, policy)
+	ob.MarketId = "test-market"
+	return &ob
+}
+
+// place applies order as the market process does: the book is told the stream entry first.
+func place(t *testing.T, ob *OrderBook, order Order) []Fills {
+	t.Helper()
+	if order.StreamId == "" {
+		order.StreamId = order.Id
+	}
+	ob.LastStreamId = order.StreamId
+	fills, _, err := ob.AddOrder(order, order.Price)
+	if err != nil {
+		t.Fatalf("AddOrder(%s): %v", order.Id, err)
+	}
+	return fills
+}
+
+func ask(id, userId string, price, quantity int) Order {
+	return Order{Id: id, UserId: userId, Side: SELL, Price: price, Quantity: quantity}
+}
+
+func bid(id, userId string, price, quantity int) Order {
+	return Order{Id: id, UserId: userId, Side: BUY, Price: price, Quantity: quantity}
+}
+
+func levelIds(level *PriceLevel) []string {
+	if level == nil {
+		return nil
+	}
+	var ids []string
+	for _, o := range level.Orders() {
+		ids = append(ids, o.Id)
+	}
+	return ids
+}
+
+func filledAgainst(fills []Fills) map[string]int {
+	got := make(map[string]int)
+	for _, f := range fills {
+		got[f.OtherOrderId] += f.Quantity
+	}
+	return got
+}
+
+func TestFIFOFillsInTimePriority(t *testing.T) {
+	ob := newTestBook(FIFO)
+	place(t, ob, ask("a1", "alice", 100, 5))
+	place(t, ob, ask("a2", "bob", 100, 5))
+	place(t, ob, ask("a3", "carol", 100, 5))
+
+	fills := place(t, ob, bid("b1", "dave", 100, 12))
+
+	var got []string
+	for _, f := range fills {
+		got = append(got, f.OtherOrderId)
+	}
+	if want := []string{"a1", "a2", "a3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("filled %v, want %v", got, want)
+	}
+	if q := fills[2].Quantity; q != 2 {
+		t.Fatalf("last fill %d, want 2", q)
+	}
+	if ids := levelIds(ob.Asks[100]); !reflect.DeepEqual(ids, []string{"a3"}) {
+		t.Fatalf("level left with %v, want [a3]", ids)
+	}
+	if d := ob.AskDepth[100]; d != 3 {
+		t.Fatalf("ask depth %d, want 3", d)
+	}
+}
+
+func TestPriceLevelRemoveKeepsQueueLinked(t *testing.T) {
+	level := NewPriceLevel()
+	orders := []*Order{{Id: "o1"}, {Id: "o2"}, {Id: "o3"}, {Id: "o4"}}
+	for _, o := range orders {
+		level.PushBack(o)
+	}
+
+	level.Remove(orders[1])
+	if ids := levelIds(level); !reflect.DeepEqual(ids, []string{"o1", "o3", "o4"}) {
+		t.Fatalf("after removing o2: %v", ids)
+	}
+	level.Remove(orders[0])
+	level.Remove(orders[3])
+	if ids := levelIds(level); !reflect.DeepEqual(ids, []string{"o3"}) {
+		t.Fatalf("after removing head and tail: %v", ids)
+	}
+	if level.Len() != 1 || level.Front() != orders[2] {
+		t.Fatalf("len %d front %v, want 1 o3", level.Len(), level.Front())
+	}
+
+	level.PushBack(orders[1])
+	if ids := levelIds(level); !reflect.DeepEqual(ids, []string{"o3", "o2"}) {
+		t.Fatalf("requeued order should go to the back: %v", ids)
+	}
+}
+
+func TestCancelFromMiddleOfLevel(t *testing.T) {
+	ob := newTestBook(FIFO)
+	place(t, ob, ask("a1", "alice", 100, 5))
+	place(t, ob, ask("a2", "bob", 100, 5))
+	place(t, ob, ask("a3", "carol", 100, 5))
+
+	if _, ok := ob.CancelOrder("a2", "bob", 0); !ok {
+		t.Fatal("cancel of a2 failed")
+	}
+	if ids := levelIds(ob.Asks[100]); !reflect.DeepEqual(ids, []string{"a1", "a3"}) {
+		t.Fatalf("level after cancel %v, want [a1 a3]", ids)
+	}
+	if d := ob.AskDepth[100]; d != 10 {
+		t.Fatalf("ask depth %d, want 10", d)
+	}
+
+	fills := place(t, ob, bid("b1", "dave", 100, 10))
+	if got := filledAgainst(fills); !reflect.DeepEqual(got, map[string]int{"a1": 5, "a3": 5}) {
+		t.Fatalf("fills %v", got)
+	}
+}
+
+func TestPartialFillKeepsPlace(t *testing.T) {
+	ob := newTestBook(FIFO)
+	place(t, ob, ask("a1", "alice", 100, 10))
+	place(t, ob, ask("a2", "bob", 100, 5))
+
+	place(t, ob, bid("b1", "dave", 100, 4))
+	if ids := levelIds(ob.Asks[100]); !reflect.DeepEqual(ids, []string{"a1", "a2"}) {
+		t.Fatalf("level after partial fill %v, want [a1 a2]", ids)
+	}
+	if left := ob.RestingQuantity("alice", "a1"); left != 6 {
+		t.Fatalf("a1 has %d left, want 6", left)
+	}
+
+	fills := place(t, ob, bid("b2", "erin", 100, 8))
+	if len(fills) != 2 || fills[0].OtherOrderId != "a1" || fills[0].Quantity != 6 || fills[1].OtherOrderId != "a2" || fills[1].Quantity != 2 {
+		t.Fatalf("fills %+v, want a1 6 then a2 2", fills)
+	}
+}
+
+func TestSnapshotKeepsQueueOrder(t *testing.T) {
+	ob := newTestBook(FIFO)
+	place(t, ob, bid("b1", "alice", 99, 5))
+	place(t, ob, bid("b2", "bob", 99, 5))
+	place(t, ob, bid("b3", "carol", 99, 5))
+	ob.CancelOrder("b1", "alice", 0)
+	place(t, ob, bid("b4", "alice", 99, 5))
+
+	snap := ob.GetSnapshot()
+	want := []string{"b2", "b3", "b4"}
+	if ids := levelIds(snap.Bids[99]); !reflect.DeepEqual(ids, want) {
+		t.Fatalf("snapshot level %v, want %v", ids, want)
+	}
+
+	// The copy is independent: matching it leaves the original queue alone.
+	snap.LastStreamId = "s1"
+	snap.AddOrder(ask("a1", "dave", 99, 5), 99)
+	if ids := levelIds(ob.Bids[99]); !reflect.DeepEqual(ids, want) {
+		t.Fatalf("original level %v after matching the snapshot, want %v", ids, want)
+	}
+	if o := snap.UserOrderMap["carol"]["b3"]; o != snap.Bids[99].Front() {
+		t.Fatal("snapshot UserOrderMap does not share orders with its levels")
+	}
+}
+
+func TestReplaceReportsOneModify(t *testing.T) {
+	ob := newTestBook(FIFO)
+	place(t, ob, ask("a1", "alice", 101, 5))
+	place(t, ob, bid("b1", "bob", 99, 5))
+	place(t, ob, bid("b2", "carol", 98, 5))
+	ob.TakeL3Events()
+
+	if _, _, _, err := ob.ReplaceOrder("b2", "carol", 100, 6, "s1"); err != nil {
+		t.Fatal(err)
+	}
+	want := []L3Event{{Type: L3_MODIFY, OrderId: "b2", Side: BUY, Price: 100, Quantity: 6}}
+	if got := ob.TakeL3Events(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("moving b2: %+v, want %+v", got, want)
+	}
+
+	// Crossing the ask: the fill is reported, then what rests at the new price.
+	if _, fills, _, err := ob.ReplaceOrder("b1", "bob", 101, 8, "s2"); err != nil || len(fills) != 1 {
+		t.Fatalf("replace b1: fills %v err %v", fills, err)
+	}
+	want = []L3Event{
+		{Type: L3_EXECUTE, OrderId: "a1", Side: SELL, Price: 101, Quantity: 5},
+		{Type: L3_MODIFY, OrderId: "b1", Side: BUY, Price: 101, Quantity: 3},
+	}
+	if got := ob.TakeL3Events(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("crossing with b1: %+v, want %+v", got, want)
+	}
+
+	place(t, ob, ask("a2", "dave", 102, 4))
+	ob.TakeL3Events()
+	if _, _, _, err := ob.ReplaceOrder("b2", "carol", 102, 4, "s3"); err != nil {
+		t.Fatal(err)
+	}
+	want = []L3Event{
+		{Type: L3_EXECUTE, OrderId: "a2", Side: SELL, Price: 102, Quantity: 4},
+		{Type: L3_MODIFY, OrderId: "b2", Side: BUY, Price: 102, Quantity: 0},
+	}
+	if got := ob.TakeL3Events(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("filling b2: %+v, want %+v", got, want)
+	}
+}
+
+func TestPostOnlyReplaceCrossesOnBestPrice(t *testing.T) {
+	ob := newTestBook(FIFO)
+	place(t, ob, ask("a1", "alice", 103, 5))
+	place(t, ob, ask("a2", "alice", 101, 5))
+	b := bid("b1", "bob", 99, 5)
+	b.PostOnly = true
+	place(t, ob, b)
+
+	if _, _, _, err := ob.ReplaceOrder("b1", "bob", 100, 5, "s1"); err != nil {
+		t.Fatalf("replace below the best ask: %v", err)
+	}
+	if _, _, _, err := ob.ReplaceOrder("b1", "bob", 101, 5, "s2"); err != ErrPostOnlyWouldCross {
+		t.Fatalf("replace onto the best ask: err %v, want ErrPostOnlyWouldCross", err)
+	}
+}
+
+func TestReplaceKeepsSelfTradeCancelEvents(t *testing.T) {
+	ob := newTestBook(FIFO)
+	place(t, ob, ask("own", "alice", 100, 5))
+	place(t, ob, bid("b1", "alice", 98, 5))
+	ob.TakeL3Events()
+
+	// Self-trade prevention cancels the resting ask before the remainder rests.
+	if _, _, _, err := ob.ReplaceOrder("b1", "alice", 100, 5, "s1"); err != nil {
+		t.Fatal(err)
+	}
+	want := []L3Event{
+		{Type: L3_CANCEL, OrderId: "own", Side: SELL, Price: 100, Quantity: 5},
+		{Type: L3_MODIFY, OrderId: "b1", Side: BUY, Price: 100, Quantity: 5},
+	}
+	if got := ob.TakeL3Events(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
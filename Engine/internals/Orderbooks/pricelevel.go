@@ -0,0 +1,59 @@
+package orderbooks
+
+// PriceLevel is the FIFO queue of resting orders at one price. Orders are linked through
+// their own prev/next pointers, so an order reached through UserOrderMap is unlinked in O(1).
+type PriceLevel struct {
+	head *Order
+	tail *Order
+	size int
+}
+
+func NewPriceLevel() *PriceLevel {
+	return &PriceLevel{}
+}
+
+// PushBack queues o behind every order already at this price.
+func (l *PriceLevel) PushBack(o *Order) {
+	o.prev, o.next = l.tail, nil
+	if l.tail == nil {
+		l.head = o
+	} else {
+		l.tail.next = o
+	}
+	l.tail = o
+	l.size++
+}
+
+// Remove unlinks o, which must be queued at this level.
+func (l *PriceLevel) Remove(o *Order) {
+	if o.prev == nil {
+		l.head = o.next
+	} else {
+		o.prev.next = o.next
+	}
+	if o.next == nil {
+		l.tail = o.prev
+	} else {
+		o.next.prev = o.prev
+	}
+	o.prev, o.next = nil, nil
+	l.size--
+}
+
+// Front returns the order with the highest time priority, or nil.
+func (l *PriceLevel) Front() *Order {
+	return l.head
+}
+
+func (l *PriceLevel) Len() int {
+	return l.size
+}
+
+// Orders returns the queue in priority order, oldest first.
+func (l *PriceLevel) Orders() []*Order {
+	orders := make([]*Order, 0, l.size)
+	for o := l.head; o != nil; o = o.next {
+		orders = append(orders, o)
+	}
+	return orders
+}
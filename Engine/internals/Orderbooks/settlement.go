@@ -0,0 +1,29 @@
+package orderbooks
+
+import "sort"
+
+// Settle closes the book for good at the final settlement price. Every resting order and
+// pending stop is taken off the book and returned so the caller can release their escrow.
+// Any auction or halt in progress is dropped with them.
+func (r *OrderBook) Settle(price int) ([]*Order, []*StopOrder) {
+	var orders []*Order
+	for userId, userOrders := range r.UserOrderMap {
+		for orderId := range userOrders {
+			if o, ok := r.CancelOrder(orderId, userId, 0); ok {
+				orders = append(orders, o)
+			}
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].Id < orders[j].Id })
+
+	stops := r.Triggers.Orders()
+	for _, stop := range stops {
+		r.Triggers.Cancel(stop.Id, stop.UserId)
+	}
+
+	r.Auction = nil
+	r.Breaker = NewCircuitBreaker()
+	r.CurrentPrice = price
+	r.Settled = true
+	return orders, stops
+}
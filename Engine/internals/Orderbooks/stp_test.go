@@ -0,0 +1,134 @@
+package orderbooks
+
+import (
+	"reflect"
+	"testing"
+)
+
+// stpBook rests an ask of alice's ahead of one of bob's at 100.
+func stpBook(t *testing.T, policy MatchingPolicy) *OrderBook {
+	t.Helper()
+	ob := newTestBook(policy)
+	place(t, ob, ask("own", "alice", 100, 5))
+	place(t, ob, ask("other", "bob", 100, 5))
+	return ob
+}
+
+func TestSelfTradePrevention(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        STPMode
+		quantity    int
+		fills       map[string]int
+		cancels     []SelfTradeCancel
+		asks        []string
+		ownLeft     int
+		restingBids []string
+	}{
+		{
+			name:        "default cancels the resting order and keeps matching",
+			quantity:    8,
+			fills:       map[string]int{"other": 5},
+			cancels:     []SelfTradeCancel{{Quantity: 5, Removed: true}},
+			restingBids: []string{"in"},
+		},
+		{
+			name:     "cancel newest drops the incoming remainder",
+			mode:     CANCEL_NEWEST,
+			quantity: 8,
+			fills:    map[string]int{},
+			asks:     []string{"own", "other"},
+			ownLeft:  5,
+		},
+		{
+			name:     "cancel both drops the resting order and the remainder",
+			mode:     CANCEL_BOTH,
+			quantity: 8,
+			fills:    map[string]int{},
+			cancels:  []SelfTradeCancel{{Quantity: 5, Removed: true}},
+			asks:     []string{"other"},
+		},
+		{
+			name:     "decrement cancels the smaller resting order",
+			mode:     DECREMENT_AND_CANCEL,
+			quantity: 8,
+			fills:    map[string]int{"other": 3},
+			cancels:  []SelfTradeCancel{{Quantity: 5, Removed: true}},
+			asks:     []string{"other"},
+		},
+		{
+			name:     "decrement shrinks the larger resting order",
+			mode:     DECREMENT_AND_CANCEL,
+			quantity: 3,
+			fills:    map[string]int{},
+			cancels:  []SelfTradeCancel{{Quantity: 3}},
+			asks:     []string{"own", "other"},
+			ownLeft:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ob := stpBook(t, FIFO)
+			in := bid("in", "alice", 100, tt.quantity)
+			in.STP = tt.mode
+			fills := place(t, ob, in)
+
+			if got := filledAgainst(fills); !reflect.DeepEqual(got, tt.fills) {
+				t.Fatalf("fills %v, want %v", got, tt.fills)
+			}
+			cancels := ob.TakeSelfTradeCancels()
+			if len(cancels) != len(tt.cancels) {
+				t.Fatalf("%d self-trade cancels, want %d", len(cancels), len(tt.cancels))
+			}
+			for i, c := range cancels {
+				if c.Order.Id != "own" || c.Quantity != tt.cancels[i].Quantity || c.Removed != tt.cancels[i].Removed {
+					t.Fatalf("cancel %+v, want own %+v", c, tt.cancels[i])
+				}
+			}
+			if ids := levelIds(ob.Asks[100]); !reflect.DeepEqual(ids, tt.asks) {
+				t.Fatalf("asks %v, want %v", ids, tt.asks)
+			}
+			if left := ob.RestingQuantity("alice", "own"); left != tt.ownLeft {
+				t.Fatalf("own order has %d left, want %d", left, tt.ownLeft)
+			}
+			if ids := levelIds(ob.Bids[100]); !reflect.DeepEqual(ids, tt.restingBids) {
+				t.Fatalf("bids %v, want %v", ids, tt.restingBids)
+			}
+			wantDepth := 0
+			if level := ob.Asks[100]; level != nil {
+				for _, o := range level.Orders() {
+					wantDepth += o.Quantity - o.Filled
+				}
+			}
+			if d := ob.AskDepth[100]; d != wantDepth {
+				t.Fatalf("ask depth %d, want %d", d, wantDepth)
+			}
+		})
+	}
+}
+
+func TestSelfTradePreventionUnderProRata(t *testing.T) {
+	ob := stpBook(t, PRO_RATA)
+	fills := place(t, ob, bid("in", "alice", 100, 8))
+
+	if got := filledAgainst(fills); !reflect.DeepEqual(got, map[string]int{"other": 5}) {
+		t.Fatalf("fills %v, want other 5", got)
+	}
+	if cancels := ob.TakeSelfTradeCancels(); len(cancels) != 1 || cancels[0].Order.Id != "own" {
+		t.Fatalf("self-trade cancels %+v, want own", cancels)
+	}
+}
+
+func TestFOKDoesNotCountOwnOrders(t *testing.T) {
+	ob := stpBook(t, FIFO)
+	in := bid("in", "alice", 100, 8)
+	in.TimeInForce = FOK
+	ob.LastStreamId = "in"
+	if _, _, err := ob.AddOrder(in, 100); err != ErrFOKNotFillable {
+		t.Fatalf("AddOrder err %v, want ErrFOKNotFillable", err)
+	}
+	if ids := levelIds(ob.Asks[100]); !reflect.DeepEqual(ids, []string{"own", "other"}) {
+		t.Fatalf("rejected FOK touched the book: %v", ids)
+	}
+}
@@ -0,0 +1,87 @@
+package orderbooks
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTimeInForce(t *testing.T) {
+	tests := []struct {
+		name     string
+		tif      TimeInForce
+		quantity int
+		err      error
+		filled   int
+		rests    bool
+	}{
+		{name: "GTC rests the remainder", tif: GTC, quantity: 8, filled: 5, rests: true},
+		{name: "empty is GTC", quantity: 8, filled: 5, rests: true},
+		{name: "GTD rests the remainder", tif: GTD, quantity: 8, filled: 5, rests: true},
+		{name: "IOC drops the remainder", tif: IOC, quantity: 8, filled: 5},
+		{name: "FOK fills completely", tif: FOK, quantity: 5, filled: 5},
+		{name: "FOK rejects a partial fill", tif: FOK, quantity: 8, err: ErrFOKNotFillable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ob := newTestBook(FIFO)
+			place(t, ob, ask("a1", "alice", 100, 5))
+
+			in := bid("in", "bob", 100, tt.quantity)
+			in.TimeInForce = tt.tif
+			in.ExpiresAt = 1000
+			ob.LastStreamId = "in"
+			fills, executed, err := ob.AddOrder(in, 100)
+			if err != tt.err {
+				t.Fatalf("AddOrder err %v, want %v", err, tt.err)
+			}
+			if executed != tt.filled || len(fills) != min(tt.filled, 1) {
+				t.Fatalf("executed %d in %d fills, want %d", executed, len(fills), tt.filled)
+			}
+			if rests := ob.RestingQuantity("bob", "in") > 0; rests != tt.rests {
+				t.Fatalf("remainder rests %v, want %v", rests, tt.rests)
+			}
+			if tt.err != nil && ob.AskDepth[100] != 5 {
+				t.Fatalf("rejected order touched the book: ask depth %d", ob.AskDepth[100])
+			}
+		})
+	}
+}
+
+func TestExpireOrdersAtStreamTime(t *testing.T) {
+	ob := newTestBook(FIFO)
+	gtd := func(o Order, expiresAt int64) Order {
+		o.TimeInForce = GTD
+		o.ExpiresAt = expiresAt
+		return o
+	}
+	place(t, ob, gtd(bid("b2", "alice", 99, 5), 2000))
+	place(t, ob, gtd(bid("b1", "bob", 98, 5), 2000))
+	place(t, ob, gtd(ask("a1", "carol", 101, 5), 1000))
+	place(t, ob, gtd(ask("a2", "carol", 102, 5), 3000))
+	place(t, ob, bid("gtc", "dave", 97, 5))
+
+	if ob.HasExpired(999) {
+		t.Fatal("HasExpired before any expiry")
+	}
+	if !ob.HasExpired(1000) {
+		t.Fatal("HasExpired at a1's expiry")
+	}
+
+	var got []string
+	for _, o := range ob.ExpireOrders(2000) {
+		got = append(got, o.Id)
+	}
+	if want := []string{"a1", "b1", "b2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expired %v, want %v", got, want)
+	}
+	if ob.HasExpired(2000) {
+		t.Fatal("orders left to expire at 2000")
+	}
+	if ob.BidDepth[99] != 0 || ob.BidDepth[98] != 0 || ob.AskDepth[101] != 0 {
+		t.Fatalf("expired orders left depth: bids %v asks %v", ob.BidDepth, ob.AskDepth)
+	}
+	if ob.RestingQuantity("dave", "gtc") != 5 || ob.RestingQuantity("carol", "a2") != 5 {
+		t.Fatal("an order that had not expired was cancelled")
+	}
+}
@@ -0,0 +1,172 @@
+package orderbooks
+
+import "sort"
+
+const (
+	STOP       OrderKind = "STOP"       // becomes a MARKET order once triggered
+	STOP_LIMIT OrderKind = "STOP_LIMIT" // becomes a LIMIT order once triggered
+)
+
+// StopOrder is an order parked in the TriggerBook until the last trade price crosses
+// TriggerPrice. Price is the limit for STOP_LIMIT orders and the escrow cap for STOP orders.
+type StopOrder struct {
+	Order
+	TriggerPrice int
+	Kind         OrderKind
+}
+
+// TriggerBook holds STOP / STOP_LIMIT orders keyed by trigger price.
+// BUY stops fire when CurrentPrice >= TriggerPrice, SELL stops when CurrentPrice <= TriggerPrice.
+type TriggerBook struct {
+	BuyStops  map[int][]*StopOrder
+	SellStops map[int][]*StopOrder
+	byId      map[string]*StopOrder
+}
+
+func NewTriggerBook(stops []StopOrder) *TriggerBook {
+	tb := &TriggerBook{
+		BuyStops:  make(map[int][]*StopOrder),
+		SellStops: make(map[int][]*StopOrder),
+		byId:      make(map[string]*StopOrder),
+	}
+	for i := range stops {
+		tb.Add(&stops[i])
+	}
+	return tb
+}
+
+func (t *TriggerBook) Add(stop *StopOrder) {
+	if stop.Side == BUY {
+		t.BuyStops[stop.TriggerPrice] = append(t.BuyStops[stop.TriggerPrice], stop)
+	} else {
+		t.SellStops[stop.TriggerPrice] = append(t.SellStops[stop.TriggerPrice], stop)
+	}
+	t.byId[stop.Id] = stop
+}
+
+// ShouldTrigger reports whether a stop on the given side would fire at lastPrice.
+func ShouldTrigger(side OrderSide, triggerPrice, lastPrice int) bool {
+	if lastPrice == 0 {
+		return false
+	}
+	if side == BUY {
+		return lastPrice >= triggerPrice
+	}
+	return lastPrice <= triggerPrice
+}
+
+// Release removes and returns every stop that fires at lastPrice. BUY stops are
+// released lowest trigger first and SELL stops highest trigger first, FIFO within a
+// trigger price, so replays release them in the same order.
+func (t *TriggerBook) Release(lastPrice int) []*StopOrder {
+	var released []*StopOrder
+	released = append(released, t.release(t.BuyStops, BUY, lastPrice, false)...)
+	released = append(released, t.release(t.SellStops, SELL, lastPrice, true)...)
+	return released
+}
+
+func (t *TriggerBook) release(levels map[int][]*StopOrder, side OrderSide, lastPrice int, descending bool) []*StopOrder {
+	var prices []int
+	for price := range levels {
+		if ShouldTrigger(side, price, lastPrice) {
+			prices = append(prices, price)
+		}
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		if descending {
+			return prices[i] > prices[j]
+		}
+		return prices[i] < prices[j]
+	})
+
+	var released []*StopOrder
+	for _, price := range prices {
+		for _, stop := range levels[price] {
+			delete(t.byId, stop.Id)
+			released = append(released, stop)
+		}
+		delete(levels, price)
+	}
+	return released
+}
+
+// Cancel removes a pending stop belonging to userId.
+func (t *TriggerBook) Cancel(orderId, userId string) (*StopOrder, bool) {
+	stop, ok := t.byId[orderId]
+	if !ok || stop.UserId != userId {
+		return nil, false
+	}
+
+	levels := t.SellStops
+	if stop.Side == BUY {
+		levels = t.BuyStops
+	}
+	orders := levels[stop.TriggerPrice]
+	for i, o := range orders {
+		if o.Id == orderId {
+			levels[stop.TriggerPrice] = append(orders[:i], orders[i+1:]...)
+			break
+		}
+	}
+	if len(levels[stop.TriggerPrice]) == 0 {
+		delete(levels, stop.TriggerPrice)
+	}
+	delete(t.byId, orderId)
+	return stop, true
+}
+
+// ExpireStops cancels pending GTD stops whose ExpiresAt is at or before now (unix millis),
+// earliest expiry first.
+func (t *TriggerBook) ExpireStops(now int64) []*StopOrder {
+	var due []*Order
+	for _, stop := range t.byId {
+		if stop.expiredBy(now) {
+			due = append(due, &stop.Order)
+		}
+	}
+	sortByExpiry(due)
+
+	var expired []*StopOrder
+	for _, o := range due {
+		if cancelled, ok := t.Cancel(o.Id, o.UserId); ok {
+			expired = append(expired, cancelled)
+		}
+	}
+	return expired
+}
+
+// Orders returns every pending stop in trigger order.
+func (t *TriggerBook) Orders() []*StopOrder {
+	var all []*StopOrder
+	all = append(all, t.sorted(t.BuyStops, false)...)
+	all = append(all, t.sorted(t.SellStops, true)...)
+	return all
+}
+
+func (t *TriggerBook) sorted(levels map[int][]*StopOrder, descending bool) []*StopOrder {
+	prices := make([]int, 0, len(levels))
+	for price := range levels {
+		prices = append(prices, price)
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		if descending {
+			return prices[i] > prices[j]
+		}
+		return prices[i] < prices[j]
+	})
+	var all []*StopOrder
+	for _, price := range prices {
+		all = append(all, levels[price]...)
+	}
+	return all
+}
+
+// Clone returns a deep copy safe to hand to the snapshot goroutine.
+func (t *TriggerBook) Clone() *TriggerBook {
+	pending := t.Orders()
+	stops := make([]StopOrder, len(pending))
+	for i, s := range pending {
+		stops[i] = *s
+	}
+	return NewTriggerBook(stops)
+}
@@ -0,0 +1,85 @@
+package orderbooks
+
+import (
+	"reflect"
+	"testing"
+)
+
+func stop(id string, side OrderSide, trigger int) *StopOrder {
+	return &StopOrder{
+		Order:        Order{Id: id, UserId: "alice", Side: side, Price: trigger, Quantity: 1},
+		TriggerPrice: trigger,
+		Kind:         STOP_LIMIT,
+	}
+}
+
+func stopIds(stops []*StopOrder) []string {
+	var ids []string
+	for _, s := range stops {
+		ids = append(ids, s.Id)
+	}
+	return ids
+}
+
+func TestShouldTrigger(t *testing.T) {
+	tests := []struct {
+		side      OrderSide
+		trigger   int
+		lastPrice int
+		want      bool
+	}{
+		{BUY, 100, 99, false},
+		{BUY, 100, 100, true},
+		{BUY, 100, 101, true},
+		{SELL, 100, 101, false},
+		{SELL, 100, 100, true},
+		{SELL, 100, 99, true},
+		{BUY, 100, 0, false},
+		{SELL, 100, 0, false},
+	}
+	for _, tt := range tests {
+		if got := ShouldTrigger(tt.side, tt.trigger, tt.lastPrice); got != tt.want {
+			t.Errorf("ShouldTrigger(%s, %d, %d) = %v, want %v", tt.side, tt.trigger, tt.lastPrice, got, tt.want)
+		}
+	}
+}
+
+func TestReleaseOrder(t *testing.T) {
+	tb := NewTriggerBook(nil)
+	tb.Add(stop("buy105", BUY, 105))
+	tb.Add(stop("buy100a", BUY, 100))
+	tb.Add(stop("buy100b", BUY, 100))
+	tb.Add(stop("buy110", BUY, 110))
+	tb.Add(stop("sell95", SELL, 95))
+	tb.Add(stop("sell108", SELL, 108))
+	tb.Add(stop("sell106", SELL, 106))
+
+	// BUYs lowest trigger first and FIFO within a price, then SELLs highest trigger first.
+	got := stopIds(tb.Release(106))
+	want := []string{"buy100a", "buy100b", "buy105", "sell108", "sell106"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("released %v, want %v", got, want)
+	}
+	if left := stopIds(tb.Orders()); len(left) != 2 {
+		t.Fatalf("pending %v, want buy110 and sell95", left)
+	}
+	if _, ok := tb.Cancel("buy100a", "alice"); ok {
+		t.Fatal("a released stop can still be cancelled")
+	}
+}
+
+func TestCancelStop(t *testing.T) {
+	tb := NewTriggerBook(nil)
+	tb.Add(stop("s1", SELL, 95))
+	tb.Add(stop("s2", SELL, 95))
+
+	if _, ok := tb.Cancel("s1", "bob"); ok {
+		t.Fatal("cancelled another user's stop")
+	}
+	if _, ok := tb.Cancel("s1", "alice"); !ok {
+		t.Fatal("cancel of s1 failed")
+	}
+	if got := stopIds(tb.Release(90)); !reflect.DeepEqual(got, []string{"s2"}) {
+		t.Fatalf("released %v, want [s2]", got)
+	}
+}
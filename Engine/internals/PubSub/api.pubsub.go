@@ -16,14 +16,42 @@ const (
 	ORDER_UPDATE   PubSubOrderMessageType = "UPDATE_ORDER"
 	ORDER_CANCEL   PubSubOrderMessageType = "CANCEL_ORDER"
 	ORDER_REJECTED PubSubOrderMessageType = "ORDER_REJECTED"
+	ORDER_REPLACED PubSubOrderMessageType = "ORDER_REPLACED"
+	MARKET_SETTLED PubSubOrderMessageType = "MARKET_SETTLED"
+)
+
+// RejectReason is the machine readable cause carried by an ORDER_REJECTED message.
+type RejectReason string
+
+const (
+	REJECT_PRICE_NOT_ON_TICK       RejectReason = "PRICE_NOT_ON_TICK"
+	REJECT_QTY_NOT_ON_LOT          RejectReason = "QTY_NOT_ON_LOT"
+	REJECT_PRICE_OUT_OF_RANGE      RejectReason = "PRICE_OUT_OF_RANGE"
+	REJECT_QTY_OUT_OF_RANGE        RejectReason = "QTY_OUT_OF_RANGE"
+	REJECT_INSUFFICIENT_BALANCE    RejectReason = "INSUFFICIENT_BALANCE"
+	REJECT_ORDER_EXPIRED           RejectReason = "ORDER_EXPIRED"
+	REJECT_FOK_NOT_FILLABLE        RejectReason = "FOK_NOT_FILLABLE"
+	REJECT_POST_ONLY_WOULD_CROSS   RejectReason = "POST_ONLY_WOULD_CROSS"
+	REJECT_NO_REFERENCE_PRICE      RejectReason = "NO_REFERENCE_PRICE"
+	REJECT_NOTHING_TO_REDUCE       RejectReason = "NOTHING_TO_REDUCE"
+	REJECT_ORDER_NOT_FOUND         RejectReason = "ORDER_NOT_FOUND"
+	REJECT_INVALID_REPLACE         RejectReason = "INVALID_REPLACE"
+	REJECT_AUCTION_IMMEDIATE_ORDER RejectReason = "AUCTION_IMMEDIATE_ORDER"
+	REJECT_MARKET_CLOSED           RejectReason = "MARKET_CLOSED"
 )
 
 type PubSubOrderMessage struct {
-	OrderId          string                 `json:"orderId"`
-	Fills            []orderbooks.Fills     `json:"fills"`
-	ExecutedQuantity int                    `json:"executedQty"`
-	MessageType      PubSubOrderMessageType `json:"type"`
-	Error            string                 `json:"error,omitempty"`
+	OrderId           string                 `json:"orderId"`
+	Fills             []orderbooks.Fills     `json:"fills"`
+	ExecutedQuantity  int                    `json:"executedQty"`
+	CancelledQuantity int                    `json:"cancelledQty,omitempty"`
+	MessageType       PubSubOrderMessageType `json:"type"`
+	Error             string                 `json:"error,omitempty"`
+	Reason            RejectReason           `json:"reason,omitempty"`
+	// MarketId, Epoch and Seq number the messages of one market run; see ForMarket.
+	MarketId string `json:"marketId,omitempty"`
+	Epoch    int64  `json:"epoch,omitempty"`
+	Seq      int64  `json:"seq,omitempty"`
 }
 
 type ApiPubSubServices interface {
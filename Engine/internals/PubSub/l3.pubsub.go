@@ -0,0 +1,57 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/go-redis/redis/v8"
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+)
+
+// L3Event is one change to a resting order with the user taken out: Handle stands for the
+// order id and is the same for every event of the order.
+type L3Event struct {
+	Seq      int64                  `json:"seq"`
+	Type     orderbooks.L3EventType `json:"type"`
+	Handle   string                 `json:"handle"`
+	Side     orderbooks.OrderSide   `json:"side"`
+	Price    int                    `json:"price"`
+	Quantity int                    `json:"quantity"`
+}
+
+// L3Message carries the order events one input made. Seq numbers the events of one market
+// run, told apart by Epoch like the messages of ForMarket.
+type L3Message struct {
+	MarketId string    `json:"marketId"`
+	Epoch    int64     `json:"epoch"`
+	Events   []L3Event `json:"events"`
+}
+
+type L3PubSubServices interface {
+	Publish(message L3Message) error
+}
+
+type l3PubSubStruct struct {
+	ctx         context.Context
+	redisClient *redis.Client
+}
+
+func InitL3PubSub(ctx context.Context, l3PubSubRedisClient *redis.Client) L3PubSubServices {
+	return &l3PubSubStruct{
+		ctx:         ctx,
+		redisClient: l3PubSubRedisClient,
+	}
+}
+
+func (r *l3PubSubStruct) Publish(message L3Message) error {
+	if r.redisClient == nil {
+		return nil
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		slog.Error("failed to marshal L3 message", "marketId", message.MarketId, "err", err)
+		return err
+	}
+	return r.redisClient.Publish(r.ctx, "L3_"+message.MarketId, string(data)).Err()
+}
@@ -0,0 +1,60 @@
+package pubsub
+
+import (
+	sequencer "github.com/raiashpanda007/rivon/engine/internals/utils/Sequencer"
+	wsmessagestypes "github.com/raiashpanda007/rivon/engine/internals/utils/WsMessagesTypes"
+)
+
+// ForMarket returns svc with every API and WS_OUT message of marketId numbered. Pub/sub
+// keeps no history, so numbering starts again at 1 with each run of the market, told apart
+// by epoch: a subscriber starts over on a new epoch and treats a skipped number within one
+// as a lost message and a repeated one as a duplicate. Messages wait for gate, if any,
+// before they are published.
+func ForMarket(svc PubSubService, marketId string, epoch int64, gate sequencer.Gate) PubSubService {
+	return &marketPubSub{
+		PubSubService: svc,
+		api:           &sequencedApi{ApiPubSubServices: svc.Api(), marketId: marketId, epoch: epoch, seq: sequencer.New(0, gate)},
+		wsOut:         &sequencedWSOut{WSOutPubSubServices: svc.WSOut(), epoch: epoch, seq: sequencer.New(0, gate)},
+	}
+}
+
+type marketPubSub struct {
+	PubSubService
+	api   *sequencedApi
+	wsOut *sequencedWSOut
+}
+
+func (p *marketPubSub) Api() ApiPubSubServices {
+	return p.api
+}
+
+func (p *marketPubSub) WSOut() WSOutPubSubServices {
+	return p.wsOut
+}
+
+type sequencedApi struct {
+	ApiPubSubServices
+	marketId string
+	epoch    int64
+	seq      *sequencer.Sequencer
+}
+
+func (r *sequencedApi) Publish(message PubSubOrderMessage) error {
+	return r.seq.Publish(func(seq int64) error {
+		message.MarketId, message.Epoch, message.Seq = r.marketId, r.epoch, seq
+		return r.ApiPubSubServices.Publish(message)
+	})
+}
+
+type sequencedWSOut struct {
+	WSOutPubSubServices
+	epoch int64
+	seq   *sequencer.Sequencer
+}
+
+func (r *sequencedWSOut) Publish(marketID string, msg wsmessagestypes.WSOutMessageStruct) error {
+	return r.seq.Publish(func(seq int64) error {
+		msg.Epoch, msg.Seq = r.epoch, seq
+		return r.WSOutPubSubServices.Publish(marketID, msg)
+	})
+}
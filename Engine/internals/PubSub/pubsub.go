@@ -10,22 +10,27 @@ type PubSubService interface {
 	Api() ApiPubSubServices
 	WSIn() WSInPubSubServices
 	WSOut() WSOutPubSubServices
+	L3() L3PubSubServices
 }
 
 type pubsubStruct struct {
 	api   ApiPubSubServices
 	wsIn  WSInPubSubServices
 	wsOut WSOutPubSubServices
+	l3    L3PubSubServices
 }
 
 func InitPubSub(ctx context.Context, apiPubSubRedisClient *redis.Client, wsInPubSubRedisClient *redis.Client, wsOutPubSubRedisClient *redis.Client) PubSubService {
 	apiPubSub := InitApiPubSub(ctx, apiPubSubRedisClient)
 	wsInPubSub := InitWSInPubSub(ctx, wsInPubSubRedisClient)
 	wsOutPubSub := InitWSOutPubSub(ctx, wsOutPubSubRedisClient)
+	// The L3 feed is market data like WS_OUT and goes out on the same Redis.
+	l3PubSub := InitL3PubSub(ctx, wsOutPubSubRedisClient)
 	return &pubsubStruct{
 		api:   apiPubSub,
 		wsIn:  wsInPubSub,
 		wsOut: wsOutPubSub,
+		l3:    l3PubSub,
 	}
 }
 
@@ -40,3 +45,7 @@ func (p *pubsubStruct) WSIn() WSInPubSubServices {
 func (p *pubsubStruct) WSOut() WSOutPubSubServices {
 	return p.wsOut
 }
+
+func (p *pubsubStruct) L3() L3PubSubServices {
+	return p.l3
+}
@@ -10,7 +10,8 @@ import (
 )
 
 type WSInPubSubServices interface {
-	Subscribe(marketID string, wsInChan chan wsmessagestypes.WSInMessageStruct) error
+	// Subscribe forwards MARKET_<marketID> messages to wsInChan until ctx is done.
+	Subscribe(ctx context.Context, marketID string, wsInChan chan wsmessagestypes.WSInMessageStruct) error
 }
 
 type wsInPubSubStruct struct {
@@ -25,14 +26,15 @@ func InitWSInPubSub(ctx context.Context, wsInPubSubRedisClient *redis.Client) WS
 	}
 }
 
-func (r *wsInPubSubStruct) Subscribe(marketID string, wsInChan chan wsmessagestypes.WSInMessageStruct) error {
+func (r *wsInPubSubStruct) Subscribe(ctx context.Context, marketID string, wsInChan chan wsmessagestypes.WSInMessageStruct) error {
 	if r.redisClient == nil {
 		return nil
 	}
-	pubsub := r.redisClient.Subscribe(r.ctx, "MARKET_"+marketID)
+	pubsub := r.redisClient.Subscribe(ctx, "MARKET_"+marketID)
 
-	_, err := pubsub.Receive(r.ctx)
+	_, err := pubsub.Receive(ctx)
 	if err != nil {
+		pubsub.Close()
 		return err
 	}
 
@@ -40,15 +42,27 @@ func (r *wsInPubSubStruct) Subscribe(marketID string, wsInChan chan wsmessagesty
 		defer pubsub.Close()
 		ch := pubsub.Channel()
 
-		for msg := range ch {
-			var parsed wsmessagestypes.WSInMessageStruct
+		for {
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				var parsed wsmessagestypes.WSInMessageStruct
 
-			if err := json.Unmarshal([]byte(msg.Payload), &parsed); err != nil {
-				slog.Error("wsIn unmarshal failed", "marketId", marketID, "err", err)
-				continue
-			}
+				if err := json.Unmarshal([]byte(msg.Payload), &parsed); err != nil {
+					slog.Error("wsIn unmarshal failed", "marketId", marketID, "err", err)
+					continue
+				}
 
-			wsInChan <- parsed
+				select {
+				case wsInChan <- parsed:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 
@@ -25,6 +25,7 @@ func InitWSOutPubSub(ctx context.Context, wsOutPubSubRedisClient *redis.Client)
 	}
 }
 
+// Publish sends msg on WS_OUT_<marketID>, or on WS_USER_<userId> when it is private.
 func (r *wsOutPubSubStruct) Publish(marketID string, msg wsmessagestypes.WSOutMessageStruct) error {
 	if r.redisClient == nil {
 		return nil
@@ -34,5 +35,9 @@ func (r *wsOutPubSubStruct) Publish(marketID string, msg wsmessagestypes.WSOutMe
 		slog.Error("failed to marshal wsOut message", "marketId", marketID, "err", err)
 		return err
 	}
-	return r.redisClient.Publish(r.ctx, "WS_OUT_"+marketID, string(data)).Err()
+	channel := "WS_OUT_" + marketID
+	if msg.Private() {
+		channel = "WS_USER_" + msg.UserId
+	}
+	return r.redisClient.Publish(r.ctx, channel, string(data)).Err()
 }
@@ -4,18 +4,28 @@ import (
 	"context"
 	"log/slog"
 	"strconv"
+	"strings"
 
 	"github.com/go-redis/redis/v8"
 )
 
 type ReplayOrderStreamMessage struct {
-	OrderId   string
-	UserId    string
-	MarketId  string
-	Price     int
-	Quantity  int
-	OrderType string
-	StreamId  string
+	OrderId      string
+	UserId       string
+	MarketId     string
+	Price        int
+	Quantity     int
+	OrderType    string
+	StreamId     string
+	TimeInForce  string
+	ExpiresAt    int64
+	OrderKind    string
+	TriggerPrice int
+	PostOnly     bool
+	ReduceOnly   bool
+	STPMode      string
+	CancelQty    int    // CANCEL_ORDER: how much to cancel, 0 for all of it
+	ConnectionId string // CANCEL_ORDER sent over WS: the connection to answer
 }
 
 func getString(values map[string]interface{}, key string) string {
@@ -64,6 +74,21 @@ func Init_Order_Redis(orderRedisURL string) (*redis.Client, error) {
 	return OrderRedis, nil
 }
 
+// StreamIdAfter reports whether stream id a comes after b. Ids compare by their ms part,
+// then their sequence number; string order gets "10-0" < "9-0" wrong.
+func StreamIdAfter(a, b string) bool {
+	msA, seqA, _ := strings.Cut(a, "-")
+	msB, seqB, _ := strings.Cut(b, "-")
+	ta, _ := strconv.ParseInt(msA, 10, 64)
+	tb, _ := strconv.ParseInt(msB, 10, 64)
+	if ta != tb {
+		return ta > tb
+	}
+	sa, _ := strconv.ParseInt(seqA, 10, 64)
+	sb, _ := strconv.ParseInt(seqB, 10, 64)
+	return sa > sb
+}
+
 // ReadLastTradeOrderIdForMarket scans the TRADES stream (newest-first) and
 // returns the orderId of the most recent trade entry for the given marketId.
 // Returns ("", false) if no matching entry is found.
@@ -73,7 +98,8 @@ func ReadLastTradeOrderIdForMarket(ctx context.Context, tradeRedis *redis.Client
 		return "", false
 	}
 	for _, msg := range msgs {
-		if getString(msg.Values, "marketId") == marketId {
+		// market_status entries carry no order and cannot serve as the replay pivot.
+		if getString(msg.Values, "marketId") == marketId && getString(msg.Values, "orderId") != "" {
 			return getString(msg.Values, "orderId"), true
 		}
 	}
@@ -103,13 +129,22 @@ func ReplayOrderStream(ctx context.Context, orderRedis *redis.Client, streamName
 
 		for _, msg := range msgs[start:] {
 			order := ReplayOrderStreamMessage{
-				OrderId:   getString(msg.Values, "orderId"),
-				UserId:    getString(msg.Values, "userId"),
-				MarketId:  getString(msg.Values, "marketId"),
-				OrderType: getString(msg.Values, "orderType"),
-				Price:     getInt(msg.Values, "price"),
-				Quantity:  getInt(msg.Values, "quantity"),
-				StreamId:  msg.ID,
+				OrderId:      getString(msg.Values, "orderId"),
+				UserId:       getString(msg.Values, "userId"),
+				MarketId:     getString(msg.Values, "marketId"),
+				OrderType:    getString(msg.Values, "orderType"),
+				Price:        getInt(msg.Values, "price"),
+				Quantity:     getInt(msg.Values, "quantity"),
+				StreamId:     msg.ID,
+				TimeInForce:  getString(msg.Values, "timeInForce"),
+				ExpiresAt:    int64(getInt(msg.Values, "expiresAt")),
+				OrderKind:    getString(msg.Values, "orderKind"),
+				TriggerPrice: getInt(msg.Values, "triggerPrice"),
+				PostOnly:     getString(msg.Values, "postOnly") == "true",
+				ReduceOnly:   getString(msg.Values, "reduceOnly") == "true",
+				STPMode:      getString(msg.Values, "stpMode"),
+				CancelQty:    getInt(msg.Values, "cancelQty"),
+				ConnectionId: getString(msg.Values, "connectionId"),
 			}
 			messages = append(messages, order)
 			lastStreamID = msg.ID
@@ -122,3 +157,52 @@ func ReplayOrderStream(ctx context.Context, orderRedis *redis.Client, streamName
 
 	return messages, nil
 }
+
+// AckOrderEntries acknowledges entries of streamName the market has applied, taking them
+// off the engine group's pending list.
+func AckOrderEntries(ctx context.Context, orderRedis *redis.Client, streamName string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return orderRedis.XAck(ctx, streamName, "engine", ids...).Err()
+}
+
+// AckPendingThrough acknowledges every entry up to and including lastId that is still
+// pending in the engine group, whichever consumer read it. It returns how many it acked.
+func AckPendingThrough(ctx context.Context, orderRedis *redis.Client, streamName string, lastId string) (int, error) {
+	const page = 500
+	start, acked := "-", 0
+	for {
+		pending, err := orderRedis.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: streamName,
+			Group:  "engine",
+			Start:  start,
+			End:    lastId,
+			Count:  page,
+		}).Result()
+		if err != nil {
+			return acked, err
+		}
+		if len(pending) == 0 {
+			return acked, nil
+		}
+		ids := make([]string, len(pending))
+		for i, p := range pending {
+			ids[i] = p.ID
+		}
+		if err := AckOrderEntries(ctx, orderRedis, streamName, ids); err != nil {
+			return acked, err
+		}
+		acked += len(ids)
+		if len(pending) < page {
+			return acked, nil
+		}
+		start = "(" + ids[len(ids)-1]
+	}
+}
+
+// TrimOrderStream drops the entries of streamName older than minId, the LastStreamId of a
+// snapshot already on disk. Replay starts from that snapshot, so it never needs them.
+func TrimOrderStream(ctx context.Context, orderRedis *redis.Client, streamName string, minId string) (int64, error) {
+	return orderRedis.XTrimMinID(ctx, streamName, minId).Result()
+}
@@ -0,0 +1,47 @@
+package redis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestStreamIdAfter(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		after bool
+	}{
+		{"10-0", "9-0", true},
+		{"9-0", "10-0", false},
+		{"5-10", "5-9", true},
+		{"5-9", "5-10", false},
+		{"5-1", "5-1", false},
+		{"1700000000001-0", "1700000000000-99", true},
+	}
+	for _, tt := range tests {
+		if got := StreamIdAfter(tt.a, tt.b); got != tt.after {
+			t.Errorf("StreamIdAfter(%s, %s) = %v, want %v", tt.a, tt.b, got, tt.after)
+		}
+	}
+}
+
+func TestAckHelpersWithoutRedis(t *testing.T) {
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	defer client.Close()
+
+	// Nothing to ack goes nowhere near Redis.
+	if err := AckOrderEntries(ctx, client, "ORDERS_m1", nil); err != nil {
+		t.Fatalf("acking no entries: %v", err)
+	}
+	if err := AckOrderEntries(ctx, client, "ORDERS_m1", []string{"1-0"}); err == nil {
+		t.Fatal("ack reported done without Redis")
+	}
+	if acked, err := AckPendingThrough(ctx, client, "ORDERS_m1", "5-0"); err == nil || acked != 0 {
+		t.Fatalf("AckPendingThrough without Redis: acked %d err %v", acked, err)
+	}
+	if _, err := TrimOrderStream(ctx, client, "ORDERS_m1", "5-0"); err == nil {
+		t.Fatal("trim reported done without Redis")
+	}
+}
@@ -0,0 +1,81 @@
+package sharding
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Engine nodes announce themselves in a sorted set scored by the time their heartbeat
+// runs out; a node that stops renewing drops off every other node's ring.
+const nodesKey = "ENGINE_NODES"
+
+func leaseKey(marketId string) string { return "MARKET_LEASE:" + marketId }
+func tokenKey(marketId string) string { return "MARKET_LEASE_TOKEN:" + marketId }
+
+// acquireScript takes or renews the lease of KEYS[1] for node ARGV[1] for ARGV[2] ms. A new
+// holder gets the next fencing token from KEYS[2], so tokens only grow across owners. It
+// returns the holder's token, or 0 when another node holds the lease.
+var acquireScript = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if cur then
+	local node, token = string.match(cur, '^(.*):(%d+)$')
+	if node == ARGV[1] then
+		redis.call('PEXPIRE', KEYS[1], ARGV[2])
+		return tonumber(token)
+	end
+	return 0
+end
+local token = redis.call('INCR', KEYS[2])
+redis.call('SET', KEYS[1], ARGV[1] .. ':' .. token, 'PX', ARGV[2])
+return token
+`)
+
+// releaseScript drops the lease of KEYS[1] if node ARGV[1] still holds it.
+var releaseScript = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if cur and string.match(cur, '^(.*):%d+$') == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Heartbeat keeps nodeId on the ring for ttl and forgets nodes whose heartbeat ran out.
+func Heartbeat(ctx context.Context, client *redis.Client, nodeId string, ttl time.Duration) error {
+	now := time.Now().UnixMilli()
+	pipe := client.TxPipeline()
+	pipe.ZAdd(ctx, nodesKey, &redis.Z{Score: float64(now + ttl.Milliseconds()), Member: nodeId})
+	pipe.ZRemRangeByScore(ctx, nodesKey, "-inf", "("+strconv.FormatInt(now, 10))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// LiveNodes returns the nodes whose heartbeat has not run out.
+func LiveNodes(ctx context.Context, client *redis.Client) ([]string, error) {
+	return client.ZRangeByScore(ctx, nodesKey, &redis.ZRangeBy{
+		Min: strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+}
+
+// Leave takes nodeId off the ring so its markets move without waiting for the heartbeat.
+func Leave(ctx context.Context, client *redis.Client, nodeId string) error {
+	return client.ZRem(ctx, nodesKey, nodeId).Err()
+}
+
+// AcquireLease takes or renews nodeId's lease on marketId for ttl and returns its fencing
+// token. ok is false when another node holds the lease.
+func AcquireLease(ctx context.Context, client *redis.Client, marketId, nodeId string, ttl time.Duration) (token int64, ok bool, err error) {
+	token, err = acquireScript.Run(ctx, client, []string{leaseKey(marketId), tokenKey(marketId)}, nodeId, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return 0, false, err
+	}
+	return token, token > 0, nil
+}
+
+// ReleaseLease hands marketId back so the next owner need not wait for the lease to expire.
+func ReleaseLease(ctx context.Context, client *redis.Client, marketId, nodeId string) error {
+	return releaseScript.Run(ctx, client, []string{leaseKey(marketId)}, nodeId).Err()
+}
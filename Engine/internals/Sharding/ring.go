@@ -0,0 +1,66 @@
+package sharding
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// ringReplicas is the number of points each node gets on the ring, so markets spread
+// evenly and a node joining or leaving only moves its share of them.
+const ringReplicas = 64
+
+type ringPoint struct {
+	hash uint32
+	node string
+}
+
+// Ring assigns market ids to engine nodes by consistent hashing.
+type Ring struct {
+	points []ringPoint
+}
+
+// hashKey is FNV-1a followed by the murmur3 finaliser; FNV alone clusters ids that only
+// differ in their last characters.
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+// NewRing builds the ring over nodes. Every node that builds it from the same node set
+// gets the same assignment.
+func NewRing(nodes []string) *Ring {
+	points := make([]ringPoint, 0, len(nodes)*ringReplicas)
+	for _, node := range nodes {
+		for i := 0; i < ringReplicas; i++ {
+			points = append(points, ringPoint{hash: hashKey(node + "#" + strconv.Itoa(i)), node: node})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].node < points[j].node
+	})
+	return &Ring{points: points}
+}
+
+// Owner returns the node marketId belongs to, or "" for an empty ring.
+func (r *Ring) Owner(marketId string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hashKey(marketId)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].node
+}
@@ -0,0 +1,60 @@
+package sharding
+
+import (
+	"fmt"
+	"testing"
+)
+
+func testMarkets(n int) []string {
+	markets := make([]string, n)
+	for i := range markets {
+		markets[i] = fmt.Sprintf("market-%d", i)
+	}
+	return markets
+}
+
+func TestEmptyRingHasNoOwner(t *testing.T) {
+	if owner := NewRing(nil).Owner("m1"); owner != "" {
+		t.Fatalf("empty ring gave %q", owner)
+	}
+}
+
+func TestRingIsTheSameOnEveryNode(t *testing.T) {
+	a := NewRing([]string{"node-a", "node-b", "node-c"})
+	b := NewRing([]string{"node-c", "node-a", "node-b"})
+	for _, m := range testMarkets(200) {
+		if a.Owner(m) != b.Owner(m) {
+			t.Fatalf("%s owned by %s and %s depending on the node order", m, a.Owner(m), b.Owner(m))
+		}
+	}
+}
+
+func TestRingSpreadsMarkets(t *testing.T) {
+	nodes := []string{"node-a", "node-b", "node-c"}
+	r := NewRing(nodes)
+	owned := make(map[string]int)
+	markets := testMarkets(3000)
+	for _, m := range markets {
+		owned[r.Owner(m)]++
+	}
+	for _, node := range nodes {
+		// An even share is 1000; allow the ring's skew but not a node left out.
+		if owned[node] < 600 || owned[node] > 1400 {
+			t.Fatalf("%s owns %d of %d markets: %v", node, owned[node], len(markets), owned)
+		}
+	}
+}
+
+func TestRingMovesOnlyTheChangedNodesMarkets(t *testing.T) {
+	before := NewRing([]string{"node-a", "node-b", "node-c"})
+	joined := NewRing([]string{"node-a", "node-b", "node-c", "node-d"})
+	left := NewRing([]string{"node-a", "node-c"})
+	for _, m := range testMarkets(2000) {
+		if owner := joined.Owner(m); owner != before.Owner(m) && owner != "node-d" {
+			t.Fatalf("%s moved from %s to %s when node-d joined", m, before.Owner(m), owner)
+		}
+		if owner := before.Owner(m); owner != "node-b" && left.Owner(m) != owner {
+			t.Fatalf("%s moved from %s to %s when node-b left", m, owner, left.Owner(m))
+		}
+	}
+}
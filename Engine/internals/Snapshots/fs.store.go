@@ -0,0 +1,104 @@
+package snapshots
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// fsStore keeps snapshots as files under dir/<owner>.
+type fsStore struct {
+	dir string
+}
+
+// NewFSStore returns a store that keeps snapshots under dir, which must be absolute so the
+// Engine's working directory does not move them.
+func NewFSStore(dir string) (SnapshotStore, error) {
+	if !filepath.IsAbs(dir) {
+		return nil, fmt.Errorf("snapshot directory %q is not an absolute path", dir)
+	}
+	return &fsStore{dir: filepath.Clean(dir)}, nil
+}
+
+func (s *fsStore) ownerDir(owner string) string {
+	return filepath.Join(s.dir, owner)
+}
+
+func (s *fsStore) Put(ctx context.Context, owner, name string, data []byte) error {
+	dir := s.ownerDir(owner)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := writeFileSynced(filepath.Join(dir, name), data); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func (s *fsStore) Get(ctx context.Context, owner, name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.ownerDir(owner), name))
+}
+
+func (s *fsStore) List(ctx context.Context, owner string) ([]string, error) {
+	entries, err := os.ReadDir(s.ownerDir(owner))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+func (s *fsStore) Delete(ctx context.Context, owner, name string) error {
+	err := os.Remove(filepath.Join(s.ownerDir(owner), name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// writeFileSynced writes data next to fileName, syncs it and renames it over fileName so a
+// reader never sees a partial snapshot.
+func writeFileSynced(fileName string, data []byte) error {
+	tmp := fileName + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, fileName)
+}
+
+// syncDir makes a rename in dir survive a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync %s: %w", dir, err)
+	}
+	return nil
+}
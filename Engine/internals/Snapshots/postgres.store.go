@@ -0,0 +1,34 @@
+package snapshots
+
+import (
+	"context"
+
+	database "github.com/raiashpanda007/rivon/engine/internals/Database"
+)
+
+// postgresStore keeps snapshots as bytea rows of the market_snapshots table.
+type postgresStore struct {
+	db *database.Database
+}
+
+// NewPostgresStore returns a store that keeps snapshots in db.
+func NewPostgresStore(db *database.Database) SnapshotStore {
+	return &postgresStore{db: db}
+}
+
+// Put is a single-row upsert, so the snapshot is stored whole or not at all.
+func (s *postgresStore) Put(ctx context.Context, owner, name string, data []byte) error {
+	return s.db.SaveMarketSnapshot(ctx, owner, name, data)
+}
+
+func (s *postgresStore) Get(ctx context.Context, owner, name string) ([]byte, error) {
+	return s.db.MarketSnapshot(ctx, owner, name)
+}
+
+func (s *postgresStore) List(ctx context.Context, owner string) ([]string, error) {
+	return s.db.MarketSnapshotNames(ctx, owner)
+}
+
+func (s *postgresStore) Delete(ctx context.Context, owner, name string) error {
+	return s.db.DeleteMarketSnapshot(ctx, owner, name)
+}
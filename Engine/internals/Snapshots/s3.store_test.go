@@ -0,0 +1,219 @@
+package snapshots
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	heap "github.com/raiashpanda007/rivon/engine/internals/utils"
+)
+
+// s3Stub is an in-memory S3-compatible bucket serving path-style requests the way MinIO
+// does. It checks every request's Signature Version 4 and pages listings pageSize keys at a
+// time.
+type s3Stub struct {
+	t        *testing.T
+	bucket   string
+	pageSize int
+	verifier *s3Store
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newS3Stub(t *testing.T, params S3Params) (*s3Stub, SnapshotStore) {
+	t.Helper()
+	stub := &s3Stub{t: t, bucket: params.Bucket, pageSize: 2, objects: make(map[string][]byte)}
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+
+	params.Endpoint = srv.URL
+	store, err := NewS3Store(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.verifier = store.(*s3Store)
+	return stub, store
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if !s.signedCorrectly(r, body) {
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.bucket {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		s.list(w, r.URL.Query())
+	case r.Method == http.MethodPut:
+		s.objects[key] = body
+	case r.Method == http.MethodGet:
+		data, ok := s.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "MethodNotAllowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// signedCorrectly signs the request again as it arrived and compares the signatures, which
+// fails when the path or query went out differently from how they were signed.
+func (s *s3Stub) signedCorrectly(r *http.Request, body []byte) bool {
+	at, err := time.Parse("20060102T150405Z", r.Header.Get("x-amz-date"))
+	if err != nil {
+		return false
+	}
+	check, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	s.verifier.sign(check, r.URL.EscapedPath(), canonicalQueryString(r.URL.Query()), body, at)
+	return check.Header.Get("Authorization") == r.Header.Get("Authorization")
+}
+
+func (s *s3Stub) list(w http.ResponseWriter, query url.Values) {
+	if query.Get("list-type") != "2" {
+		http.Error(w, "only ListObjectsV2 is served", http.StatusBadRequest)
+		return
+	}
+	prefix := query.Get("prefix")
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	start, _ := strconv.Atoi(query.Get("continuation-token"))
+	end := min(start+s.pageSize, len(keys))
+	var result listBucketResult
+	for _, key := range keys[start:end] {
+		result.Contents = append(result.Contents, struct {
+			Key string `xml:"Key"`
+		}{key})
+	}
+	if end < len(keys) {
+		result.IsTruncated, result.NextContinuationToken = true, strconv.Itoa(end)
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		listBucketResult
+	}{listBucketResult: result})
+}
+
+func (s *s3Stub) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var testS3Params = S3Params{Bucket: "snapshots", AccessKey: "minio", SecretKey: "minio123", Prefix: "/engine/"}
+
+func TestS3StoreRoundTrip(t *testing.T) {
+	stub, store := newS3Stub(t, testS3Params)
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "m1", "1.mpac", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	// Names are escaped for the signature and the wire alike.
+	if err := store.Put(ctx, "m1", "with space+plus.mpac", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if got := stub.keys(); !reflect.DeepEqual(got, []string{"engine/m1/1.mpac", "engine/m1/with space+plus.mpac"}) {
+		t.Fatalf("bucket holds %v", got)
+	}
+
+	data, err := store.Get(ctx, "m1", "with space+plus.mpac")
+	if err != nil || string(data) != "second" {
+		t.Fatalf("Get = %q, %v", data, err)
+	}
+	if _, err := store.Get(ctx, "m1", "missing.mpac"); err == nil {
+		t.Fatal("Get of a missing object did not fail")
+	}
+
+	if err := store.Delete(ctx, "m1", "1.mpac"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, "m1", "1.mpac"); err == nil {
+		t.Fatal("deleted object is still readable")
+	}
+}
+
+func TestS3StoreListFollowsContinuation(t *testing.T) {
+	_, store := newS3Stub(t, testS3Params)
+	ctx := context.Background()
+
+	var want []string
+	for i := range 5 {
+		name := strconv.Itoa(i) + ".mpac"
+		want = append(want, name)
+		if err := store.Put(ctx, "m1", name, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Put(ctx, "m10", "9.mpac", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := store.List(ctx, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("List = %v, want %v", names, want)
+	}
+}
+
+func TestS3StoreKeepsRetention(t *testing.T) {
+	stub, backend := newS3Stub(t, testS3Params)
+	store := Store{Backend: backend, Retention: 2}
+
+	for i := 1; i <= 4; i++ {
+		ob := orderbooks.NewOrderBook("", "", "1-"+strconv.Itoa(i), nil, nil, heap.NewMinHeap(), heap.NewMaxHeap(), 0, nil, orderbooks.FIFO)
+		if err := store.SaveSnapShot("m1", ob); err != nil {
+			t.Fatal(err)
+		}
+		// Snapshot names are their time; keep them apart.
+		time.Sleep(time.Millisecond)
+	}
+
+	if keys := stub.keys(); len(keys) != 2 {
+		t.Fatalf("bucket holds %v, want the 2 newest snapshots", keys)
+	}
+	ob, ok := store.ReadLastSnapShotForMarket("m1")
+	if !ok || ob.LastStreamId != "1-4" {
+		t.Fatalf("read back %v %v, want the snapshot at 1-4", ok, ob)
+	}
+}
@@ -1,9 +1,8 @@
 package snapshots
 
 import (
-	"fmt"
-	"os"
-	"sort"
+	"context"
+	"log/slog"
 	"time"
 
 	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
@@ -19,17 +18,23 @@ type snapshotData struct {
 	LastTradeId  string
 	LastOrderId  string
 	LastStreamId string
+	StopOrders   []orderbooks.StopOrder
+	Policy       orderbooks.MatchingPolicy // policy the book was matched under, reused for replay
+	Breaker      orderbooks.CircuitBreaker
+	Auction      *orderbooks.CallAuction
+	Settled      bool
 }
 
-// toSnapshotData converts a live OrderBook into the serialisable form.
+// toSnapshotData converts a live OrderBook into the serialisable form. Order ids per
+// price are stored oldest first so a restored book keeps its time priority.
 func toSnapshotData(ob orderbooks.OrderBook) snapshotData {
 	seen := make(map[string]bool)
 	var orders []orderbooks.Order
 
 	bidOrderIds := make(map[int][]string, len(ob.Bids))
-	for price, priceOrders := range ob.Bids {
-		ids := make([]string, 0, len(priceOrders))
-		for _, o := range priceOrders {
+	for price, level := range ob.Bids {
+		ids := make([]string, 0, level.Len())
+		for _, o := range level.Orders() {
 			ids = append(ids, o.Id)
 			if !seen[o.Id] {
 				seen[o.Id] = true
@@ -40,9 +45,9 @@ func toSnapshotData(ob orderbooks.OrderBook) snapshotData {
 	}
 
 	askOrderIds := make(map[int][]string, len(ob.Asks))
-	for price, priceOrders := range ob.Asks {
-		ids := make([]string, 0, len(priceOrders))
-		for _, o := range priceOrders {
+	for price, level := range ob.Asks {
+		ids := make([]string, 0, level.Len())
+		for _, o := range level.Orders() {
 			ids = append(ids, o.Id)
 			if !seen[o.Id] {
 				seen[o.Id] = true
@@ -52,7 +57,13 @@ func toSnapshotData(ob orderbooks.OrderBook) snapshotData {
 		askOrderIds[price] = ids
 	}
 
+	var stops []orderbooks.StopOrder
+	for _, s := range ob.Triggers.Orders() {
+		stops = append(stops, *s)
+	}
+
 	return snapshotData{
+		StopOrders:   stops,
 		Orders:       orders,
 		BidOrderIds:  bidOrderIds,
 		AskOrderIds:  askOrderIds,
@@ -60,6 +71,10 @@ func toSnapshotData(ob orderbooks.OrderBook) snapshotData {
 		LastTradeId:  ob.LastTradeId,
 		LastOrderId:  ob.LastOrderId,
 		LastStreamId: ob.LastStreamId,
+		Policy:       ob.Policy,
+		Breaker:      *ob.Breaker,
+		Auction:      ob.Auction,
+		Settled:      ob.Settled,
 	}
 }
 
@@ -99,7 +114,7 @@ func fromSnapshotData(sd snapshotData) orderbooks.OrderBook {
 		}
 	}
 
-	return orderbooks.NewOrderBook(
+	ob := orderbooks.NewOrderBook(
 		sd.LastTradeId,
 		sd.LastOrderId,
 		sd.LastStreamId,
@@ -108,112 +123,113 @@ func fromSnapshotData(sd snapshotData) orderbooks.OrderBook {
 		askHeap,
 		bidHeap,
 		sd.CurrentPrice,
+		sd.StopOrders,
+		sd.Policy,
 	)
+	ob.Breaker = &sd.Breaker
+	ob.Auction = sd.Auction
+	ob.Settled = sd.Settled
+	return ob
 }
 
-const maxSnapshotsToKeep = 3
+// defaultRetention is how many snapshots of a market are kept when Store.Retention is unset.
+const defaultRetention = 3
 
-// SaveSnapShot serialises ob (which should already be an independent snapshot copy)
-// to a msgpack file under ../snapshots/<marketId>/ and prunes old files.
-func SaveSnapShot(marketId string, ob orderbooks.OrderBook) {
-	dir := fmt.Sprintf("../snapshots/%s", marketId)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return
-	}
+// storeTimeout bounds one call to a snapshot backend.
+const storeTimeout = 30 * time.Second
+
+// Store saves order book snapshots to a SnapshotStore backend and reads them back, keeping
+// the newest Retention snapshots of each market.
+type Store struct {
+	Backend   SnapshotStore
+	Retention int
+}
 
-	sd := toSnapshotData(ob)
+// SaveSnapShot serialises ob (which should already be an independent snapshot copy) to the
+// backend and prunes old snapshots. Backends write atomically, so once it returns nil the
+// snapshot survives a crash and the order stream before its LastStreamId is no longer
+// needed.
+func (s Store) SaveSnapShot(marketId string, ob orderbooks.OrderBook) error {
+	return s.save(marketId, toSnapshotData(ob))
+}
+
+// ReadLastSnapShotForMarket reads the most recent intact snapshot for a market and returns
+// a fully reconstructed OrderBook. A snapshot that fails its checksum is skipped for the
+// one before it. Returns (nil, false) if no snapshot can be read.
+func (s Store) ReadLastSnapShotForMarket(marketId string) (*orderbooks.OrderBook, bool) {
+	var sd snapshotData
+	if !s.readLast(marketId, &sd) {
+		return nil, false
+	}
+	ob := fromSnapshotData(sd)
+	return &ob, true
+}
 
-	data, err := msgpack.Marshal(sd)
+// save encodes v as the newest snapshot of owner and prunes the ones past retention.
+func (s Store) save(owner string, v interface{}) error {
+	data, err := msgpack.Marshal(v)
 	if err != nil {
-		return
+		return err
 	}
 
-	fileName := fmt.Sprintf("%s/%d.mpac", dir, time.Now().Unix())
-	if err := os.WriteFile(fileName, data, 0644); err != nil {
-		return
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := s.Backend.Put(ctx, owner, snapshotName(time.Now()), encodeSnapshot(data)); err != nil {
+		return err
 	}
 
-	pruneSnapshots(dir)
+	s.pruneSnapshots(ctx, owner)
+	return nil
 }
 
-// pruneSnapshots removes all but the most recent maxSnapshotsToKeep files.
-func pruneSnapshots(dir string) {
-	entries, err := os.ReadDir(dir)
-	if err != nil || len(entries) <= maxSnapshotsToKeep {
-		return
+// pruneSnapshots removes all but the most recent Retention snapshots of owner.
+func (s Store) pruneSnapshots(ctx context.Context, owner string) {
+	keep := s.Retention
+	if keep <= 0 {
+		keep = defaultRetention
 	}
-
-	type fileInfo struct {
-		name    string
-		modTime time.Time
+	names, err := s.Backend.List(ctx, owner)
+	if err != nil {
+		slog.Error("Unable to list snapshots for pruning", "owner", owner, "err", err)
+		return
 	}
-
-	var files []fileInfo
-	for _, e := range entries {
-		if e.IsDir() {
-			continue
+	names = sortSnapshotNames(names)
+	for i := 0; i < len(names)-keep; i++ {
+		if err := s.Backend.Delete(ctx, owner, names[i]); err != nil {
+			slog.Error("Unable to remove old snapshot", "owner", owner, "name", names[i], "err", err)
 		}
-		info, err := e.Info()
-		if err != nil {
-			continue
-		}
-		files = append(files, fileInfo{e.Name(), info.ModTime()})
-	}
-
-	// oldest first
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].modTime.Before(files[j].modTime)
-	})
-
-	for i := 0; i < len(files)-maxSnapshotsToKeep; i++ {
-		path := fmt.Sprintf("%s/%s", dir, files[i].name)
-		os.Remove(path)
 	}
 }
 
-// ReadLastSnapShotForMarket reads the most recent snapshot for a market and
-// returns a fully reconstructed OrderBook. Returns (nil, false) if no snapshot exists.
-func ReadLastSnapShotForMarket(marketId string) (*orderbooks.OrderBook, bool) {
-	parentDir := fmt.Sprintf("../snapshots/%s", marketId)
+// readLast decodes the newest intact snapshot of owner into v, skipping snapshots that
+// fail their checksum or do not decode. It reports whether one was found.
+func (s Store) readLast(owner string, v interface{}) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
 
-	entries, err := os.ReadDir(parentDir)
+	names, err := s.Backend.List(ctx, owner)
 	if err != nil {
-		return nil, false
+		slog.Error("Unable to list snapshots", "owner", owner, "err", err)
+		return false
 	}
+	names = sortSnapshotNames(names)
 
-	var latestFile os.DirEntry
-	var latestTime time.Time
-
-	for _, entry := range entries {
-		if entry.IsDir() {
+	for i := len(names) - 1; i >= 0; i-- {
+		raw, err := s.Backend.Get(ctx, owner, names[i])
+		if err != nil {
+			slog.Error("Unable to read snapshot", "owner", owner, "name", names[i], "err", err)
 			continue
 		}
-		info, err := entry.Info()
+		data, err := decodeSnapshot(raw)
 		if err != nil {
+			slog.Error("Skipping damaged snapshot", "owner", owner, "name", names[i], "err", err)
 			continue
 		}
-		if info.ModTime().After(latestTime) {
-			latestTime = info.ModTime()
-			latestFile = entry
+		if err := msgpack.Unmarshal(data, v); err != nil {
+			slog.Error("Skipping undecodable snapshot", "owner", owner, "name", names[i], "err", err)
+			continue
 		}
+		return true
 	}
-
-	if latestFile == nil {
-		return nil, false
-	}
-
-	snapshotPath := fmt.Sprintf("%s/%s", parentDir, latestFile.Name())
-
-	fileData, err := os.ReadFile(snapshotPath)
-	if err != nil {
-		return nil, false
-	}
-
-	var sd snapshotData
-	if err := msgpack.Unmarshal(fileData, &sd); err != nil {
-		return nil, false
-	}
-
-	ob := fromSnapshotData(sd)
-	return &ob, true
+	return false
 }
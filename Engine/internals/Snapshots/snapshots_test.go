@@ -0,0 +1,86 @@
+package snapshots
+
+import (
+	"reflect"
+	"testing"
+
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	heap "github.com/raiashpanda007/rivon/engine/internals/utils"
+)
+
+func levelIds(level *orderbooks.PriceLevel) []string {
+	if level == nil {
+		return nil
+	}
+	var ids []string
+	for _, o := range level.Orders() {
+		ids = append(ids, o.Id)
+	}
+	return ids
+}
+
+func TestSnapshotRestoreKeepsTimePriority(t *testing.T) {
+	ob := orderbooks.NewOrderBook("", "", "", nil, nil, heap.NewMinHeap(), heap.NewMaxHeap(), 0, nil, orderbooks.FIFO)
+	ob.MarketId = "test-market"
+	for i, o := range []orderbooks.Order{
+		{Id: "a1", UserId: "alice", Side: orderbooks.SELL, Quantity: 5},
+		{Id: "a2", UserId: "bob", Side: orderbooks.SELL, Quantity: 5},
+		{Id: "a3", UserId: "carol", Side: orderbooks.SELL, Quantity: 5},
+		{Id: "b1", UserId: "dave", Side: orderbooks.BUY, Quantity: 2},
+		{Id: "a4", UserId: "alice", Side: orderbooks.SELL, Quantity: 5},
+	} {
+		price := 101
+		if o.Side == orderbooks.BUY {
+			price = 90
+		}
+		o.Price, o.StreamId = price, "1-"+string(rune('0'+i))
+		ob.LastStreamId = o.StreamId
+		if _, _, err := ob.AddOrder(o, price); err != nil {
+			t.Fatalf("AddOrder(%s): %v", o.Id, err)
+		}
+	}
+	// a1 partly filled, a2 cancelled from the middle: a1 must stay at the front.
+	ob.LastStreamId = "1-5"
+	ob.AddOrder(orderbooks.Order{Id: "b2", UserId: "erin", Side: orderbooks.BUY, Quantity: 2, Price: 101, TimeInForce: orderbooks.IOC}, 101)
+	ob.CancelOrder("a2", "bob", 0)
+
+	backend, err := NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := Store{Backend: backend}
+	if err := store.SaveSnapShot("test-market", ob.GetSnapshot()); err != nil {
+		t.Fatal(err)
+	}
+	restored, ok := store.ReadLastSnapShotForMarket("test-market")
+	if !ok {
+		t.Fatal("no snapshot read back")
+	}
+
+	if got, want := levelIds(restored.Asks[101]), levelIds(ob.Asks[101]); !reflect.DeepEqual(got, want) {
+		t.Fatalf("restored ask level %v, want %v", got, want)
+	}
+	if got := levelIds(restored.Asks[101]); !reflect.DeepEqual(got, []string{"a1", "a3", "a4"}) {
+		t.Fatalf("ask level %v, want [a1 a3 a4]", got)
+	}
+	if left := restored.RestingQuantity("alice", "a1"); left != 3 {
+		t.Fatalf("a1 restored with %d left, want 3", left)
+	}
+	if d := restored.AskDepth[101]; d != 13 {
+		t.Fatalf("restored ask depth %d, want 13", d)
+	}
+
+	restored.MarketId = "test-market"
+	restored.LastStreamId = "1-6"
+	fills, _, err := restored.AddOrder(orderbooks.Order{Id: "b3", UserId: "erin", Side: orderbooks.BUY, Quantity: 10, Price: 101}, 101)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range fills {
+		got = append(got, f.OtherOrderId)
+	}
+	if want := []string{"a1", "a3", "a4"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("restored book filled %v, want %v", got, want)
+	}
+}
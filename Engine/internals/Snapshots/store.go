@@ -0,0 +1,94 @@
+package snapshots
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SnapshotStore is where encoded snapshots are kept, per owner: the market whose book they
+// hold. Put must be atomic: a reader sees the whole snapshot under name or none of it.
+type SnapshotStore interface {
+	Put(ctx context.Context, owner, name string, data []byte) error
+	Get(ctx context.Context, owner, name string) ([]byte, error)
+	// List returns the names of owner's snapshots in no particular order.
+	List(ctx context.Context, owner string) ([]string, error)
+	Delete(ctx context.Context, owner, name string) error
+}
+
+const snapshotExt = ".mpac"
+
+// snapshotVersion is written in the header of every snapshot; it changes when the
+// encoding of snapshotData does.
+const snapshotVersion = 1
+
+// snapshotMagic starts every snapshot with a header. Snapshots written before the header
+// was introduced are bare msgpack and are read as they are.
+var snapshotMagic = []byte("RVSN")
+
+// headerSize is the magic, version, payload length and CRC-32 in front of the payload.
+const headerSize = 4 + 2 + 4 + 4
+
+func snapshotName(at time.Time) string {
+	return strconv.FormatInt(at.UnixNano(), 10) + snapshotExt
+}
+
+// sortSnapshotNames keeps the snapshot names among names, oldest first. Names are the time
+// a snapshot was taken, so older second-resolution names sort before the current ones.
+func sortSnapshotNames(names []string) []string {
+	var kept []string
+	stamps := make(map[string]int64, len(names))
+	for _, name := range names {
+		if !strings.HasSuffix(name, snapshotExt) {
+			continue
+		}
+		stamp, err := strconv.ParseInt(strings.TrimSuffix(name, snapshotExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		stamps[name] = stamp
+		kept = append(kept, name)
+	}
+	sort.Slice(kept, func(i, j int) bool { return stamps[kept[i]] < stamps[kept[j]] })
+	return kept
+}
+
+// encodeSnapshot puts the header in front of a msgpack payload.
+func encodeSnapshot(payload []byte) []byte {
+	out := make([]byte, headerSize, headerSize+len(payload))
+	copy(out, snapshotMagic)
+	binary.BigEndian.PutUint16(out[4:6], snapshotVersion)
+	binary.BigEndian.PutUint32(out[6:10], uint32(len(payload)))
+	binary.BigEndian.PutUint32(out[10:14], crc32.ChecksumIEEE(payload))
+	return append(out, payload...)
+}
+
+var errSnapshotCorrupt = errors.New("snapshot checksum mismatch")
+
+// decodeSnapshot checks the header of data and returns its msgpack payload.
+func decodeSnapshot(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, snapshotMagic) {
+		return data, nil
+	}
+	if len(data) < headerSize {
+		return nil, errSnapshotCorrupt
+	}
+	if version := binary.BigEndian.Uint16(data[4:6]); version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	payload := data[headerSize:]
+	if uint32(len(payload)) != binary.BigEndian.Uint32(data[6:10]) {
+		return nil, errSnapshotCorrupt
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(data[10:14]) {
+		return nil, errSnapshotCorrupt
+	}
+	return payload, nil
+}
@@ -0,0 +1,320 @@
+package usermap
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInsufficientFunds  = errors.New("you don't have enough funds for this order")
+	ErrInsufficientAssets = errors.New("you don't have enough assets to place this sell order")
+	ErrNothingToReduce    = errors.New("reduce only order has no position to reduce")
+)
+
+// refusals are the outcomes of a batch turned down by one of its locks, as recorded in the
+// market's op log.
+var refusals = map[string]error{
+	"funds":  ErrInsufficientFunds,
+	"assets": ErrInsufficientAssets,
+	"reduce": ErrNothingToReduce,
+}
+
+// Refused reports whether err is a batch turned down by one of its locks rather than one
+// that could not be applied.
+func Refused(err error) bool {
+	for _, refusal := range refusals {
+		if errors.Is(err, refusal) {
+			return true
+		}
+	}
+	return false
+}
+
+type opKind int
+
+const (
+	lockMoney opKind = iota
+	lockAsset
+	lockPosition
+	unlockMoney
+	unlockAsset
+	trade
+	settle
+)
+
+type op struct {
+	kind     opKind
+	userId   string // the seller of a trade
+	buyerId  string
+	marketId string
+	qty      int // money for lockMoney and unlockMoney, asset units otherwise
+	price    int
+	lotSize  int
+}
+
+// Batch is the wallet side of one market input: every lock, release, trade and payout it
+// makes, applied all at once or not at all. See UserWallet.Apply.
+type Batch struct {
+	ops []op
+}
+
+func (b *Batch) Empty() bool {
+	return b == nil || len(b.ops) == 0
+}
+
+// LockMoney moves amount of userId's balance into escrow, or refuses the batch.
+func (b *Batch) LockMoney(userId string, amount int) {
+	b.ops = append(b.ops, op{kind: lockMoney, userId: userId, qty: amount})
+}
+
+// LockAsset moves qty units of marketId held by userId into escrow, or refuses the batch.
+func (b *Batch) LockAsset(userId, marketId string, qty int) {
+	b.ops = append(b.ops, op{kind: lockAsset, userId: userId, marketId: marketId, qty: qty})
+}
+
+// LockPosition locks as much of a reduce-only SELL for qty as userId's unlocked position
+// in marketId covers, rounded down to whole lots, and refuses the batch when that is
+// nothing. Apply returns the quantity locked.
+func (b *Batch) LockPosition(userId, marketId string, qty, lotSize int) {
+	b.ops = append(b.ops, op{kind: lockPosition, userId: userId, marketId: marketId, qty: qty, lotSize: lotSize})
+}
+
+// UnlockMoney hands amount of escrowed money back to userId.
+func (b *Batch) UnlockMoney(userId string, amount int) {
+	if amount > 0 {
+		b.ops = append(b.ops, op{kind: unlockMoney, userId: userId, qty: amount})
+	}
+}
+
+// UnlockAsset hands qty escrowed units of marketId back to userId.
+func (b *Batch) UnlockAsset(userId, marketId string, qty int) {
+	if qty > 0 {
+		b.ops = append(b.ops, op{kind: unlockAsset, userId: userId, marketId: marketId, qty: qty})
+	}
+}
+
+// Trade pays the seller from the buyer's escrow and hands the buyer the seller's escrowed
+// units, and makes the buyer a holder of marketId.
+func (b *Batch) Trade(buyerId, sellerId, marketId string, qty, price int) {
+	b.ops = append(b.ops, op{kind: trade, buyerId: buyerId, userId: sellerId, marketId: marketId, qty: qty, price: price})
+}
+
+// Settle pays every holder of marketId price per unit and takes the asset out of their
+// wallets. The admin's unsold supply is retired without a payout.
+func (b *Batch) Settle(marketId string, price int) {
+	b.ops = append(b.ops, op{kind: settle, marketId: marketId, price: price})
+}
+
+// users returns every user other than the admin whose wallet the batch changes.
+func (b *Batch) users() []string {
+	var users []string
+	seen := make(map[string]struct{})
+	for _, o := range b.ops {
+		for _, userId := range []string{o.userId, o.buyerId} {
+			if userId == "" || userId == AdminID {
+				continue
+			}
+			if _, ok := seen[userId]; !ok {
+				seen[userId] = struct{}{}
+				users = append(users, userId)
+			}
+		}
+	}
+	return users
+}
+
+// settles returns the markets the batch settles.
+func (b *Batch) settles() []string {
+	var markets []string
+	for _, o := range b.ops {
+		if o.kind == settle {
+			markets = append(markets, o.marketId)
+		}
+	}
+	return markets
+}
+
+// checksAdmin reports whether applying the batch depends on the admin's current funds
+// rather than only moving them.
+func (b *Batch) checksAdmin() bool {
+	for _, o := range b.ops {
+		switch {
+		case o.kind == settle:
+			return true
+		case o.kind == lockMoney || o.kind == lockAsset || o.kind == lockPosition:
+			if o.userId == AdminID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// funds is one user's wallet as Redis holds it: the unlocked balance and the unlocked
+// quantity held per market.
+type funds struct {
+	Balance int
+	Assets  map[string]int
+}
+
+// adminFunds is the admin's own money and supply, with what its own orders have locked of
+// them. Escrow of other users' orders is not the admin's and is not counted.
+type adminFunds struct {
+	Balance      int
+	Locked       int
+	Assets       map[string]int
+	LockedAssets map[string]int
+}
+
+func newAdminFunds() adminFunds {
+	return adminFunds{Assets: make(map[string]int), LockedAssets: make(map[string]int)}
+}
+
+// walletState is everything a batch reads and changes.
+type walletState struct {
+	users   map[string]*funds
+	admin   adminFunds
+	holders map[string][]string // per settled market
+	paid    map[string]int      // what settlement paid each holder
+}
+
+// user returns the wallet of userId, which the batch's reads must have loaded.
+func (s *walletState) user(userId string) *funds {
+	w, ok := s.users[userId]
+	if !ok {
+		w = &funds{Assets: make(map[string]int)}
+		s.users[userId] = w
+	}
+	return w
+}
+
+// apply runs ops against s in order and returns what a LockPosition locked. A lock that
+// cannot be covered refuses the batch; s is then left part way and must not be written.
+func (s *walletState) apply(ops []op) (int, error) {
+	locked := 0
+	for _, o := range ops {
+		switch o.kind {
+		case lockMoney:
+			if o.userId == AdminID {
+				if s.admin.Balance-s.admin.Locked < o.qty {
+					return 0, ErrInsufficientFunds
+				}
+				s.admin.Locked += o.qty
+				continue
+			}
+			w := s.user(o.userId)
+			if w.Balance < o.qty {
+				return 0, ErrInsufficientFunds
+			}
+			w.Balance -= o.qty
+
+		case lockAsset, lockPosition:
+			position := s.position(o.userId, o.marketId)
+			qty := o.qty
+			if o.kind == lockPosition {
+				if o.lotSize > 1 {
+					position -= position % o.lotSize
+				}
+				if position <= 0 {
+					return 0, ErrNothingToReduce
+				}
+				qty = min(qty, position)
+				locked = qty
+			}
+			if position < qty {
+				return 0, ErrInsufficientAssets
+			}
+			if o.userId == AdminID {
+				s.admin.LockedAssets[o.marketId] += qty
+			} else {
+				s.user(o.userId).Assets[o.marketId] -= qty
+			}
+
+		case unlockMoney:
+			if o.userId == AdminID {
+				s.admin.Locked -= o.qty
+			} else {
+				s.user(o.userId).Balance += o.qty
+			}
+
+		case unlockAsset:
+			if o.userId == AdminID {
+				s.admin.LockedAssets[o.marketId] -= o.qty
+			} else {
+				s.user(o.userId).Assets[o.marketId] += o.qty
+			}
+
+		case trade:
+			total := o.qty * o.price
+			if o.buyerId == AdminID {
+				// The admin's own BUY pays from the money its order locked.
+				s.admin.Balance -= total
+				s.admin.Locked -= total
+				s.admin.Assets[o.marketId] += o.qty
+			} else {
+				s.user(o.buyerId).Assets[o.marketId] += o.qty
+			}
+			if o.userId == AdminID {
+				// The admin's own SELL delivers from the supply its order locked.
+				s.admin.Assets[o.marketId] -= o.qty
+				s.admin.LockedAssets[o.marketId] -= o.qty
+				s.admin.Balance += total
+			} else {
+				s.user(o.userId).Balance += total
+			}
+
+		case settle:
+			for _, userId := range s.holders[o.marketId] {
+				w := s.user(userId)
+				held := w.Assets[o.marketId]
+				delete(w.Assets, o.marketId)
+				if amount := held * o.price; amount > 0 {
+					w.Balance += amount
+					s.paid[userId] += amount
+				}
+			}
+			delete(s.admin.Assets, o.marketId)
+			delete(s.admin.LockedAssets, o.marketId)
+		}
+	}
+	return locked, nil
+}
+
+// position is the unlocked quantity of marketId userId holds.
+func (s *walletState) position(userId, marketId string) int {
+	if userId == AdminID {
+		return s.admin.Assets[marketId] - s.admin.LockedAssets[marketId]
+	}
+	return s.user(userId).Assets[marketId]
+}
+
+// outcome encodes the result of a batch for the op log.
+func outcome(locked int, err error) string {
+	for code, refusal := range refusals {
+		if err == refusal {
+			return "refused:" + code
+		}
+	}
+	if locked > 0 {
+		return "ok:" + strconv.Itoa(locked)
+	}
+	return "ok"
+}
+
+// parseOutcome reads back what outcome recorded.
+func parseOutcome(recorded string) (int, error) {
+	if code, ok := strings.CutPrefix(recorded, "refused:"); ok {
+		if refusal, ok := refusals[code]; ok {
+			return 0, refusal
+		}
+		return 0, errors.New("unknown refusal recorded: " + code)
+	}
+	if qty, ok := strings.CutPrefix(recorded, "ok:"); ok {
+		return strconv.Atoi(qty)
+	}
+	if recorded != "ok" {
+		return 0, errors.New("unknown outcome recorded: " + recorded)
+	}
+	return 0, nil
+}
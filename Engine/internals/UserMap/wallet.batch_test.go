@@ -0,0 +1,160 @@
+package usermap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testState(users map[string]*funds, admin adminFunds) *walletState {
+	return &walletState{users: users, admin: admin, holders: make(map[string][]string), paid: make(map[string]int)}
+}
+
+func TestLocksRefuseWhatTheWalletCannotCover(t *testing.T) {
+	tests := []struct {
+		name   string
+		batch  func(b *Batch)
+		locked int
+		err    error
+	}{
+		{"money", func(b *Batch) { b.LockMoney("alice", 100) }, 0, nil},
+		{"too much money", func(b *Batch) { b.LockMoney("alice", 101) }, 0, ErrInsufficientFunds},
+		{"asset", func(b *Batch) { b.LockAsset("alice", "m1", 7) }, 0, nil},
+		{"too many units", func(b *Batch) { b.LockAsset("alice", "m1", 8) }, 0, ErrInsufficientAssets},
+		{"position cut to whole lots", func(b *Batch) { b.LockPosition("alice", "m1", 10, 5) }, 5, nil},
+		{"position within it", func(b *Batch) { b.LockPosition("alice", "m1", 3, 1) }, 3, nil},
+		{"no position", func(b *Batch) { b.LockPosition("alice", "m2", 3, 1) }, 0, ErrNothingToReduce},
+		{"admin money", func(b *Batch) { b.LockMoney(AdminID, 40) }, 0, nil},
+		{"admin money already locked", func(b *Batch) { b.LockMoney(AdminID, 41) }, 0, ErrInsufficientFunds},
+		{"admin supply", func(b *Batch) { b.LockAsset(AdminID, "m1", 18) }, 0, nil},
+		{"admin supply already locked", func(b *Batch) { b.LockAsset(AdminID, "m1", 19) }, 0, ErrInsufficientAssets},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admin := newAdminFunds()
+			admin.Balance, admin.Locked = 50, 10
+			admin.Assets["m1"], admin.LockedAssets["m1"] = 20, 2
+			s := testState(map[string]*funds{"alice": {Balance: 100, Assets: map[string]int{"m1": 7}}}, admin)
+			var b Batch
+			tt.batch(&b)
+
+			locked, err := s.apply(b.ops)
+			if locked != tt.locked || err != tt.err {
+				t.Fatalf("locked %d err %v, want %d %v", locked, err, tt.locked, tt.err)
+			}
+			if err != nil && !Refused(err) {
+				t.Fatalf("%v is not a refusal", err)
+			}
+		})
+	}
+}
+
+func TestTradeMovesEscrowToTheOtherSide(t *testing.T) {
+	s := testState(map[string]*funds{
+		"buyer":  {Balance: 100, Assets: map[string]int{}},
+		"seller": {Balance: 0, Assets: map[string]int{"m1": 5}},
+	}, newAdminFunds())
+	var b Batch
+	b.LockMoney("buyer", 60)
+	b.LockAsset("seller", "m1", 5)
+	b.Trade("buyer", "seller", "m1", 4, 12)
+	b.UnlockMoney("buyer", 60-48)
+	b.UnlockAsset("seller", "m1", 1)
+
+	if _, err := s.apply(b.ops); err != nil {
+		t.Fatal(err)
+	}
+	if w := s.users["buyer"]; w.Balance != 52 || w.Assets["m1"] != 4 {
+		t.Fatalf("buyer has %+v", w)
+	}
+	if w := s.users["seller"]; w.Balance != 48 || w.Assets["m1"] != 1 {
+		t.Fatalf("seller has %+v", w)
+	}
+	if s.admin.Balance != 0 || len(s.admin.Assets) != 0 {
+		t.Fatalf("admin took part in a trade between users: %+v", s.admin)
+	}
+}
+
+func TestTradeAgainstTheAdmin(t *testing.T) {
+	s := testState(map[string]*funds{
+		"alice": {Balance: 100, Assets: map[string]int{"m1": 3}},
+	}, newAdminFunds())
+	var b Batch
+	// The admin's own orders locked 30 and 2 units before; only the increments are written.
+	b.Trade("alice", AdminID, "m1", 2, 10)
+	b.Trade(AdminID, "alice", "m1", 3, 10)
+
+	if _, err := s.apply(b.ops); err != nil {
+		t.Fatal(err)
+	}
+	if w := s.users["alice"]; w.Balance != 130 || w.Assets["m1"] != 5 {
+		t.Fatalf("alice has %+v", w)
+	}
+	want := adminFunds{
+		Balance:      20 - 30,
+		Locked:       -30,
+		Assets:       map[string]int{"m1": 3 - 2},
+		LockedAssets: map[string]int{"m1": -2},
+	}
+	if !reflect.DeepEqual(s.admin, want) {
+		t.Fatalf("admin moved by %+v, want %+v", s.admin, want)
+	}
+}
+
+func TestSettlePaysHoldersAndRetiresTheSupply(t *testing.T) {
+	admin := newAdminFunds()
+	admin.Assets["m1"], admin.LockedAssets["m1"] = 20, 5
+	admin.Assets["m2"] = 9
+	s := testState(map[string]*funds{
+		"alice": {Balance: 50, Assets: map[string]int{"m1": 3, "m2": 4}},
+		"bob":   {Balance: 0, Assets: map[string]int{"m2": 1}},
+	}, admin)
+	s.holders["m1"] = []string{"alice", "bob"}
+	var b Batch
+	b.UnlockAsset("bob", "m1", 2)
+	b.Settle("m1", 10)
+
+	if _, err := s.apply(b.ops); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"alice": 30, "bob": 20}; !reflect.DeepEqual(s.paid, want) {
+		t.Fatalf("paid %v, want %v", s.paid, want)
+	}
+	if w := s.users["alice"]; w.Balance != 80 || !reflect.DeepEqual(w.Assets, map[string]int{"m2": 4}) {
+		t.Fatalf("alice has %+v after settlement", w)
+	}
+	if _, ok := s.admin.Assets["m1"]; ok || s.admin.LockedAssets["m1"] != 0 || s.admin.Assets["m2"] != 9 {
+		t.Fatalf("admin has %+v after settlement", s.admin)
+	}
+}
+
+func TestBatchUsersLeaveOutTheAdmin(t *testing.T) {
+	var b Batch
+	b.LockMoney("alice", 1)
+	b.Trade("bob", AdminID, "m1", 1, 1)
+	b.Trade("alice", "carol", "m1", 1, 1)
+	if got, want := b.users(), []string{"alice", "bob", "carol"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("users %v, want %v", got, want)
+	}
+	if b.checksAdmin() {
+		t.Fatal("a trade with the admin needs no read of its funds")
+	}
+	b.LockAsset(AdminID, "m1", 1)
+	if !b.checksAdmin() {
+		t.Fatal("a lock of the admin's supply checks its funds")
+	}
+}
+
+func TestOutcomeRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		locked int
+		err    error
+	}{{0, nil}, {7, nil}, {0, ErrInsufficientFunds}, {0, ErrInsufficientAssets}, {0, ErrNothingToReduce}} {
+		locked, err := parseOutcome(outcome(tt.locked, tt.err))
+		if locked != tt.locked || err != tt.err {
+			t.Fatalf("%q read back as %d %v, want %d %v", outcome(tt.locked, tt.err), locked, err, tt.locked, tt.err)
+		}
+	}
+	if _, err := parseOutcome("refused:other"); err == nil {
+		t.Fatal("unknown refusal read back without an error")
+	}
+}
@@ -6,11 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"sync"
-	"time"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5"
 	database "github.com/raiashpanda007/rivon/engine/internals/Database"
+	redisStream "github.com/raiashpanda007/rivon/engine/internals/Redis"
 )
 
 const AdminID = "00000000-0000-0000-0000-000000000001"
@@ -27,396 +30,451 @@ type redisMessageAssetStruct struct {
 	Quantity int    `json:"quantity"`
 }
 
-type wallet struct {
-	Balance int `json:"balance"`
-}
-
 type redisMessageStruct struct {
 	Balance int                       `json:"balance"`
 	Assets  []redisMessageAssetStruct `json:"assets"`
 }
 
-type asset struct {
-	Quantity int `json:"quantity"`
+// UserWallet is the wallet store every Engine node shares. Each user's wallet is the Redis
+// key <userId>, the JSON the Server reads; the admin's own funds are the adminKey hash.
+// Escrow leaves a wallet when an order locks it and comes back, or goes to the other side
+// of a trade, as the market applies the order's later inputs.
+//
+// Wallets change only through Apply, one market input at a time, and each input's changes
+// are recorded in its market's op log, so a market replaying inputs after a crash or a move
+// to another node never applies one twice.
+type UserWallet struct {
+	redisClient *redis.Client
+	db          *database.Database
 }
 
-type UserAssetsStruct struct {
-	Assets map[string]asset
-	mutex  sync.Mutex
-}
+// ErrWalletNotCached is returned when a user's wallet is in neither Redis nor Postgres.
+var ErrWalletNotCached = errors.New("can't load your wallet, please try again later")
+
+// applyAttempts bounds how often Apply starts over because a wallet it read changed.
+const applyAttempts = 20
 
-type UserWalletStruct struct {
-	Wallet wallet
-	mutex  sync.Mutex
+// adminKey holds the admin's own funds: balance, locked, asset:<marketId> and
+// lockedAsset:<marketId>.
+const adminKey = "WALLET_ADMIN"
+
+// opsKey is the op log of marketId: the outcome of every input whose wallet side has been
+// applied, by op id.
+func opsKey(marketId string) string {
+	return "WALLET_OPS:" + marketId
 }
 
-type UserWallet struct {
-	mu                   sync.RWMutex
-	WalletMap            map[string]*UserWalletStruct
-	AssetMap             map[string]*UserAssetsStruct
-	AdminWallet          *UserWalletStruct
-	AdminAssets          *UserAssetsStruct
-	adminEscrowBalance   int            // funds held from user BUY orders
-	adminOwnLocked       int            // admin's own pending BUY commitments
-	adminEscrowAssets    map[string]int // assets held from user SELL orders per market
-	adminOwnLockedAssets map[string]int // admin's own pending SELL commitments per market
-	redisClient          *redis.Client
-	ctx                  context.Context
+// holdersKey is the Redis set of the users who have bought into marketId, which
+// settlement pays out. Only a trade gives a user an asset, so the set covers every holder.
+// Buyers are added by the batch of their trade.
+func holdersKey(marketId string) string {
+	return "HOLDERS_" + marketId
 }
 
 //////////////////// INIT ////////////////////
 
+// InitUserMap connects the wallet store, loading the admin's funds from the database
+// unless another node already has.
 func InitUserMap(redisClient *redis.Client, ctx context.Context, db *database.Database) (*UserWallet, error) {
-	uw := &UserWallet{
-		WalletMap:            make(map[string]*UserWalletStruct),
-		AssetMap:             make(map[string]*UserAssetsStruct),
-		adminEscrowAssets:    make(map[string]int),
-		adminOwnLockedAssets: make(map[string]int),
-		redisClient:          redisClient,
-		ctx:                  ctx,
-	}
+	uw := &UserWallet{redisClient: redisClient, db: db}
 
-	if err := uw.loadAdminFromDB(db); err != nil {
+	exists, err := redisClient.Exists(ctx, adminKey).Result()
+	if err != nil {
 		return nil, err
 	}
-
-	uw.AdminWallet = uw.WalletMap[AdminID]
-	uw.AdminAssets = uw.AssetMap[AdminID]
-
+	if exists == 0 {
+		if err := uw.loadAdminFromDB(ctx, db); err != nil {
+			return nil, err
+		}
+	}
 	return uw, nil
 }
 
-func (r *UserWallet) loadAdminFromDB(db *database.Database) error {
+// loadAdminFromDB writes the admin's own funds from the database to adminKey, unless a
+// node starting alongside has just done so.
+func (r *UserWallet) loadAdminFromDB(ctx context.Context, db *database.Database) error {
 	data, err := db.GetAdminData(AdminID)
 	if err != nil {
 		return errors.New("failed to load admin data from database: " + err.Error())
-	}	
-
-	assetMap := make(map[string]asset)
-	for _, a := range data.Assets {
-		assetMap[a.MarketID] = asset{Quantity: a.Quantity}
-		r.adminEscrowAssets[a.MarketID] = a.LockedQty
 	}
 
-	r.WalletMap[AdminID] = &UserWalletStruct{
-		Wallet: wallet{Balance: data.Balance},
+	// The locked columns are escrow of other users' orders, which is not the admin's.
+	fields := map[string]interface{}{
+		"balance": data.Balance - data.LockedBalance,
+		"locked":  0,
 	}
-	r.AssetMap[AdminID] = &UserAssetsStruct{
-		Assets: assetMap,
+	for _, a := range data.Assets {
+		fields["asset:"+a.MarketID] = a.Quantity - a.LockedQty
 	}
-	r.adminEscrowBalance = data.LockedBalance
-	return nil
-}
-
-//////////////////// EVICT USER ////////////////////
 
-func (r *UserWallet) RemoveUser(userId string) {
-	if userId == AdminID {
-		return
+	err = r.redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		exists, err := tx.Exists(ctx, adminKey).Result()
+		if err != nil || exists > 0 {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, adminKey, fields)
+			return nil
+		})
+		return err
+	}, adminKey)
+	if errors.Is(err, redis.TxFailedErr) {
+		return nil
 	}
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	delete(r.WalletMap, userId)
-	delete(r.AssetMap, userId)
+	return err
 }
 
 //////////////////// LOAD USER ////////////////////
 
-func (r *UserWallet) addUserWallet(userID string) error {
-	val, err := r.redisClient.Get(r.ctx, userID).Result()
-	if err != nil {
-		if err == redis.Nil {
-			return errors.New("can't load your wallet, please try again later")
-		}
-		return err
+// Load makes sure userId's wallet is in Redis, loading it from Postgres if it is not.
+func (r *UserWallet) Load(userId string) error {
+	if userId == AdminID {
+		return nil
 	}
-
-	var user redisMessageStruct
-	if err := json.Unmarshal([]byte(val), &user); err != nil {
+	ctx := context.Background()
+	exists, err := r.redisClient.Exists(ctx, userId).Result()
+	if err != nil || exists > 0 {
 		return err
 	}
+	return r.loadUserFromDB(ctx, userId)
+}
 
-	r.WalletMap[userID] = &UserWalletStruct{
-		Wallet: wallet{Balance: user.Balance},
+// loadUserFromDB writes the wallet DBWritter last stored for userId to Redis, unless it has
+// been loaded meanwhile. A user Postgres has no wallet for gets an empty one.
+func (r *UserWallet) loadUserFromDB(ctx context.Context, userId string) error {
+	if r.db == nil {
+		return ErrWalletNotCached
 	}
-
-	assetMap := make(map[string]asset)
-	for _, a := range user.Assets {
-		assetMap[a.MarketID] = asset{Quantity: a.Quantity}
+	data, err := r.db.GetUserWallet(userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		slog.Warn("No wallet in the database, starting an empty one", "userId", userId)
+		data, err = &database.WalletData{}, nil
 	}
-
-	r.AssetMap[userID] = &UserAssetsStruct{
-		Assets: assetMap,
+	if err != nil {
+		return fmt.Errorf("load wallet of %s: %w", userId, err)
 	}
 
-	return nil
-}
-
-//////////////////// GET USER ////////////////////
-
-func (r *UserWallet) GetUser(userId string) (*UserWalletStruct, *UserAssetsStruct, error) {
-	r.mu.RLock()
-	w, ok1 := r.WalletMap[userId]
-	a, ok2 := r.AssetMap[userId]
-	r.mu.RUnlock()
-
-	if ok1 && ok2 {
-		return w, a, nil
+	raw, err := encodeFunds(&funds{Balance: data.Balance, Assets: data.Assets})
+	if err != nil {
+		return err
 	}
+	return r.redisClient.SetNX(ctx, userId, raw, 0).Err()
+}
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	if _, ok := r.WalletMap[userId]; !ok {
-		if err := r.addUserWallet(userId); err != nil {
-			return nil, nil, err
+func encodeFunds(w *funds) (string, error) {
+	msg := redisMessageStruct{Balance: w.Balance, Assets: make([]redisMessageAssetStruct, 0, len(w.Assets))}
+	for marketId, qty := range w.Assets {
+		if qty != 0 {
+			msg.Assets = append(msg.Assets, redisMessageAssetStruct{MarketID: marketId, Quantity: qty})
 		}
 	}
-
-	return r.WalletMap[userId], r.AssetMap[userId], nil
-}
-
-//////////////////// WALLET OPS ////////////////////
-
-func (w *UserWalletStruct) Add(amount int) {
-	w.mutex.Lock()
-	defer w.mutex.Unlock()
-	w.Wallet.Balance += amount
+	sort.Slice(msg.Assets, func(i, j int) bool { return msg.Assets[i].MarketID < msg.Assets[j].MarketID })
+	data, err := json.Marshal(msg)
+	return string(data), err
 }
 
-func (w *UserWalletStruct) Sub(amount int) error {
-	w.mutex.Lock()
-	defer w.mutex.Unlock()
-
-	if w.Wallet.Balance < amount {
-		return errors.New("you don't have enough funds for this order")
+func decodeFunds(raw string) (*funds, error) {
+	var msg redisMessageStruct
+	if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+		return nil, err
 	}
-	w.Wallet.Balance -= amount
-	return nil
-}
-
-// SubEscrow releases escrowed funds — no balance check (escrow is guaranteed to exist).
-func (w *UserWalletStruct) SubEscrow(amount int) {
-	w.mutex.Lock()
-	defer w.mutex.Unlock()
-	w.Wallet.Balance -= amount
-}
-
-//////////////////// ASSET OPS ////////////////////
-
-func (a *UserAssetsStruct) Add(marketId string, qty int) {
-	a.mutex.Lock()
-	defer a.mutex.Unlock()
-
-	val := a.Assets[marketId]
-	val.Quantity += qty
-	a.Assets[marketId] = val
-}
-
-func (a *UserAssetsStruct) Sub(marketId string, qty int) error {
-	a.mutex.Lock()
-	defer a.mutex.Unlock()
-
-	val, ok := a.Assets[marketId]
-	if !ok || val.Quantity < qty {
-		return errors.New("you don't have enough assets to place this sell order")
+	w := &funds{Balance: msg.Balance, Assets: make(map[string]int, len(msg.Assets))}
+	for _, a := range msg.Assets {
+		w.Assets[a.MarketID] += a.Quantity
 	}
-
-	val.Quantity -= qty
-	a.Assets[marketId] = val
-	return nil
+	return w, nil
 }
 
-// SubEscrowAsset releases escrowed assets — no quantity check (escrow is guaranteed to exist).
-func (a *UserAssetsStruct) SubEscrowAsset(marketId string, qty int) {
-	a.mutex.Lock()
-	defer a.mutex.Unlock()
-
-	val := a.Assets[marketId]
-	val.Quantity -= qty
-	a.Assets[marketId] = val
+func decodeAdminFunds(fields map[string]string) (adminFunds, error) {
+	admin := newAdminFunds()
+	for field, raw := range fields {
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			return admin, fmt.Errorf("admin wallet field %s: %w", field, err)
+		}
+		switch {
+		case field == "balance":
+			admin.Balance = value
+		case field == "locked":
+			admin.Locked = value
+		case strings.HasPrefix(field, "asset:"):
+			admin.Assets[strings.TrimPrefix(field, "asset:")] = value
+		case strings.HasPrefix(field, "lockedAsset:"):
+			admin.LockedAssets[strings.TrimPrefix(field, "lockedAsset:")] = value
+		}
+	}
+	return admin, nil
 }
 
-//////////////////// FLOW ////////////////////
+//////////////////// BALANCES ////////////////////
 
-// Step 1: Lock money (BUY order)
-func (r *UserWallet) LockMoney(userId string, amount int) error {
+// Balances returns the unlocked balance and the unlocked quantity of every market a user
+// holds, or false when the user's wallet is not in Redis.
+func (r *UserWallet) Balances(userId string) (int, map[string]int, bool) {
+	ctx := context.Background()
 	if userId == AdminID {
-		r.mu.Lock()
-		defer r.mu.Unlock()
-		available := r.AdminWallet.Wallet.Balance - r.adminEscrowBalance - r.adminOwnLocked
-		if available < amount {
-			return errors.New("you don't have enough funds for this order")
+		fields, err := r.redisClient.HGetAll(ctx, adminKey).Result()
+		if err != nil {
+			slog.Error("Unable to read the admin wallet", "err", err)
+			return 0, nil, false
 		}
-		r.adminOwnLocked += amount
-		return nil
+		admin, err := decodeAdminFunds(fields)
+		if err != nil {
+			slog.Error("Unable to read the admin wallet", "err", err)
+			return 0, nil, false
+		}
+		assets := make(map[string]int, len(admin.Assets))
+		for marketId, qty := range admin.Assets {
+			assets[marketId] = qty - admin.LockedAssets[marketId]
+		}
+		return admin.Balance - admin.Locked, assets, true
 	}
 
-	userWallet, _, err := r.GetUser(userId)
+	raw, err := r.redisClient.Get(ctx, userId).Result()
 	if err != nil {
-		return err
+		if err != redis.Nil {
+			slog.Error("Unable to read wallet", "userId", userId, "err", err)
+		}
+		return 0, nil, false
 	}
-	if err := userWallet.Sub(amount); err != nil {
-		return err
+	w, err := decodeFunds(raw)
+	if err != nil {
+		slog.Error("Unable to decode wallet", "userId", userId, "err", err)
+		return 0, nil, false
 	}
-	r.mu.Lock()
-	r.AdminWallet.Add(amount)
-	r.adminEscrowBalance += amount
-	r.mu.Unlock()
-	return nil
+	return w.Balance, w.Assets, true
 }
 
-// Step 1b: Unlock money (BUY order cancelled)
-func (r *UserWallet) UnlockMoney(userId string, amount int) error {
-	if userId == AdminID {
-		r.mu.Lock()
-		r.adminOwnLocked -= amount
-		r.mu.Unlock()
-		return nil
-	}
+//////////////////// APPLY ////////////////////
 
-	r.mu.Lock()
-	r.AdminWallet.SubEscrow(amount)
-	r.adminEscrowBalance -= amount
-	r.mu.Unlock()
+// errWalletsMissing names the wallets a batch needs that are not in Redis.
+type errWalletsMissing []string
 
-	userWallet, _, err := r.GetUser(userId)
-	if err != nil {
-		return err
-	}
-	userWallet.Add(amount)
-	return nil
+func (e errWalletsMissing) Error() string {
+	return "wallets not in redis: " + strings.Join(e, ", ")
 }
 
-// Step 2: Lock asset (SELL order)
-func (r *UserWallet) LockAsset(userId, marketId string, qty int) error {
-	if userId == AdminID {
-		r.mu.Lock()
-		defer r.mu.Unlock()
-		available := r.AdminAssets.Assets[marketId].Quantity - r.adminEscrowAssets[marketId] - r.adminOwnLockedAssets[marketId]
-		if available < qty {
-			return errors.New("you don't have enough assets to place this sell order")
+// Apply applies b as the input opId of marketId and returns what a LockPosition in it
+// locked. A lock that cannot be covered refuses the whole batch with ErrInsufficientFunds,
+// ErrInsufficientAssets or ErrNothingToReduce, see Refused. Either outcome is recorded, so
+// applying the same opId again changes nothing and returns the first outcome. Wallets that
+// left Redis are loaded from Postgres first.
+//
+// Any other error means nothing was applied; the caller tries again.
+func (r *UserWallet) Apply(marketId, opId string, b *Batch) (int, error) {
+	ctx := context.Background()
+	for range applyAttempts {
+		locked, refused, err := r.tryApply(ctx, marketId, opId, b)
+		var missing errWalletsMissing
+		switch {
+		case errors.Is(err, redis.TxFailedErr):
+			continue
+		case errors.As(err, &missing):
+			for _, userId := range missing {
+				if err := r.loadUserFromDB(ctx, userId); err != nil {
+					return 0, err
+				}
+			}
+			continue
+		case err != nil:
+			return 0, err
 		}
-		r.adminOwnLockedAssets[marketId] += qty
-		return nil
+		return locked, refused
 	}
+	return 0, fmt.Errorf("wallets of %s kept changing under op %s", marketId, opId)
+}
 
-	_, assets, err := r.GetUser(userId)
+// PruneOps drops the op log entries of marketId's inputs up to and including throughId,
+// which a stored snapshot covers, and returns how many it dropped. Only call it once every
+// run of the market restores that snapshot or a later one: an input replayed after its
+// entry is gone is applied again.
+func (r *UserWallet) PruneOps(marketId, throughId string) (int, error) {
+	ctx := context.Background()
+	opIds, err := r.redisClient.HKeys(ctx, opsKey(marketId)).Result()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	if err := assets.Sub(marketId, qty); err != nil {
-		return err
+	var covered []string
+	for _, opId := range opIds {
+		if opCovered(opId, throughId) {
+			covered = append(covered, opId)
+		}
 	}
-	r.mu.Lock()
-	r.AdminAssets.Add(marketId, qty)
-	r.adminEscrowAssets[marketId] += qty
-	r.mu.Unlock()
-	return nil
+	if len(covered) == 0 {
+		return 0, nil
+	}
+	dropped, err := r.redisClient.HDel(ctx, opsKey(marketId), covered...).Result()
+	return int(dropped), err
 }
 
-// Step 2b: Unlock asset (SELL order cancelled)
-func (r *UserWallet) UnlockAsset(userId, marketId string, qty int) error {
-	if userId == AdminID {
-		r.mu.Lock()
-		r.adminOwnLockedAssets[marketId] -= qty
-		r.mu.Unlock()
-		return nil
+// opCovered reports whether opId, the stream id of an input with an optional ":lock"
+// suffix, is at or before throughId.
+func opCovered(opId, throughId string) bool {
+	streamId, _, _ := strings.Cut(opId, ":")
+	return !redisStream.StreamIdAfter(streamId, throughId)
+}
+
+// tryApply is one attempt at Apply under WATCH of everything b reads. refused is the
+// outcome of b; err is set when it could not be applied.
+func (r *UserWallet) tryApply(ctx context.Context, marketId, opId string, b *Batch) (locked int, refused, err error) {
+	keys := append([]string{opsKey(marketId)}, b.users()...)
+	for _, m := range b.settles() {
+		keys = append(keys, holdersKey(m))
+	}
+	if b.checksAdmin() {
+		keys = append(keys, adminKey)
 	}
 
-	r.mu.Lock()
-	r.AdminAssets.SubEscrowAsset(marketId, qty)
-	r.adminEscrowAssets[marketId] -= qty
-	r.mu.Unlock()
+	err = r.redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		recorded, err := tx.HGet(ctx, opsKey(marketId), opId).Result()
+		if err == nil {
+			locked, refused = parseOutcome(recorded)
+			return nil
+		}
+		if err != redis.Nil {
+			return err
+		}
 
-	_, assets, err := r.GetUser(userId)
-	if err != nil {
+		s, before, err := r.read(ctx, tx, b)
+		if err != nil {
+			return err
+		}
+		locked, refused = s.apply(b.ops)
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, opsKey(marketId), opId, outcome(locked, refused))
+			if refused != nil {
+				return nil
+			}
+			return r.write(ctx, pipe, b, s, before)
+		})
+		if err == nil && len(s.paid) > 0 {
+			total := 0
+			for _, amount := range s.paid {
+				total += amount
+			}
+			slog.Info("market settled", "marketId", marketId, "holdersPaid", len(s.paid), "totalPaid", total)
+		}
 		return err
-	}
-	assets.Add(marketId, qty)
-	return nil
+	}, keys...)
+	return locked, refused, err
 }
 
-// Step 3: Execute Trade
-func (r *UserWallet) ExecuteTrade(buyerId, sellerId, marketId string, qty, price int) error {
-	total := qty * price
-
-	_, buyerAssets, buyerErr := r.GetUser(buyerId)
-	sellerWallet, _, sellerErr := r.GetUser(sellerId)
-
-	// Guard: sellerWallet is used whenever seller is a normal user.
-	if sellerId != AdminID && sellerWallet == nil {
-		return fmt.Errorf("seller wallet not loaded (userId=%s): %w", sellerId, sellerErr)
+// read loads every wallet b changes, watching the settled markets' holders before they
+// are read. It returns the state and the admin's funds as read, which the admin's changes
+// are written against.
+func (r *UserWallet) read(ctx context.Context, tx *redis.Tx, b *Batch) (*walletState, adminFunds, error) {
+	s := &walletState{
+		users:   make(map[string]*funds),
+		admin:   newAdminFunds(),
+		holders: make(map[string][]string),
+		paid:    make(map[string]int),
+	}
+	users := b.users()
+	seen := make(map[string]struct{}, len(users))
+	for _, userId := range users {
+		seen[userId] = struct{}{}
+	}
+	var holders []string
+	for _, m := range b.settles() {
+		members, err := tx.SMembers(ctx, holdersKey(m)).Result()
+		if err != nil {
+			return nil, adminFunds{}, err
+		}
+		for _, userId := range members {
+			if userId == AdminID {
+				continue
+			}
+			s.holders[m] = append(s.holders[m], userId)
+			if _, ok := seen[userId]; !ok {
+				seen[userId] = struct{}{}
+				holders = append(holders, userId)
+			}
+		}
 	}
-	// Guard: buyerAssets is used whenever buyer is a normal user.
-	if buyerId != AdminID && buyerAssets == nil {
-		return fmt.Errorf("buyer assets not loaded (userId=%s): %w", buyerId, buyerErr)
+	if len(holders) > 0 {
+		if err := tx.Watch(ctx, holders...).Err(); err != nil {
+			return nil, adminFunds{}, err
+		}
+		users = append(users, holders...)
 	}
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	switch {
-	case buyerId == AdminID:
-		// Admin's own BUY filled: pay seller from admin's own committed funds.
-		if err := r.AdminWallet.Sub(total); err != nil {
-			return err
+	if len(users) > 0 {
+		vals, err := tx.MGet(ctx, users...).Result()
+		if err != nil {
+			return nil, adminFunds{}, err
+		}
+		var missing errWalletsMissing
+		for i, v := range vals {
+			raw, ok := v.(string)
+			if !ok {
+				missing = append(missing, users[i])
+				continue
+			}
+			w, err := decodeFunds(raw)
+			if err != nil {
+				return nil, adminFunds{}, fmt.Errorf("decode wallet of %s: %w", users[i], err)
+			}
+			s.users[users[i]] = w
+		}
+		if len(missing) > 0 {
+			return nil, adminFunds{}, missing
 		}
-		r.adminOwnLocked -= total
-		sellerWallet.Add(total)
-		r.AdminAssets.Add(marketId, qty)
-
-	case sellerId == AdminID:
-		// Admin's own SELL filled: release asset, collect buyer's escrowed payment.
-		r.AdminAssets.SubEscrowAsset(marketId, qty)
-		r.adminOwnLockedAssets[marketId] -= qty
-		buyerAssets.Add(marketId, qty)
-		r.AdminWallet.SubEscrow(total)
-		r.adminEscrowBalance -= total
-		r.AdminWallet.Add(total) // net: Balance unchanged, escrow drops; admin retains funds
-
-	default:
-		// Normal trade: release buyer's escrowed money to seller, seller's escrowed asset to buyer.
-		r.AdminWallet.SubEscrow(total)
-		r.adminEscrowBalance -= total
-		sellerWallet.Add(total)
-		r.AdminAssets.SubEscrowAsset(marketId, qty)
-		r.adminEscrowAssets[marketId] -= qty
-		buyerAssets.Add(marketId, qty)
 	}
 
-	return nil
+	// Only a batch that checks the admin's funds reads them; the others move them by
+	// increments, whatever they stand at.
+	if b.checksAdmin() {
+		fields, err := tx.HGetAll(ctx, adminKey).Result()
+		if err != nil {
+			return nil, adminFunds{}, err
+		}
+		if s.admin, err = decodeAdminFunds(fields); err != nil {
+			return nil, adminFunds{}, err
+		}
+	}
+	before := newAdminFunds()
+	before.Balance, before.Locked = s.admin.Balance, s.admin.Locked
+	for m, qty := range s.admin.Assets {
+		before.Assets[m] = qty
+	}
+	for m, qty := range s.admin.LockedAssets {
+		before.LockedAssets[m] = qty
+	}
+	return s, before, nil
 }
 
-//////////////////// REDIS FLUSH ////////////////////
-
-func (r *UserWallet) FlushWalletToRedis(userId string) {
-	r.mu.RLock()
-	walletStruct, wOk := r.WalletMap[userId]
-	assetStruct, aOk := r.AssetMap[userId]
-	r.mu.RUnlock()
-
-	if !wOk || !aOk {
-		return
+// write queues the changes apply made to s on pipe.
+func (r *UserWallet) write(ctx context.Context, pipe redis.Pipeliner, b *Batch, s *walletState, before adminFunds) error {
+	for userId, w := range s.users {
+		raw, err := encodeFunds(w)
+		if err != nil {
+			return err
+		}
+		pipe.Set(ctx, userId, raw, 0)
 	}
 
-	walletStruct.mutex.Lock()
-	balance := walletStruct.Wallet.Balance
-	walletStruct.mutex.Unlock()
-
-	assetStruct.mutex.Lock()
-	assets := make([]redisMessageAssetStruct, 0, len(assetStruct.Assets))
-	for mktId, a := range assetStruct.Assets {
-		assets = append(assets, redisMessageAssetStruct{MarketID: mktId, Quantity: a.Quantity})
+	incr := func(field string, delta int) {
+		if delta != 0 {
+			pipe.HIncrBy(ctx, adminKey, field, int64(delta))
+		}
 	}
-	assetStruct.mutex.Unlock()
-
-	data, err := json.Marshal(redisMessageStruct{Balance: balance, Assets: assets})
-	if err != nil {
-		slog.Error("FlushWalletToRedis marshal error", "userId", userId, "err", err)
-		return
+	incr("balance", s.admin.Balance-before.Balance)
+	incr("locked", s.admin.Locked-before.Locked)
+	for m, qty := range s.admin.Assets {
+		incr("asset:"+m, qty-before.Assets[m])
 	}
-	if err := r.redisClient.Set(r.ctx, userId, string(data), 5*time.Minute).Err(); err != nil {
-		slog.Error("FlushWalletToRedis redis set error", "userId", userId, "err", err)
+	for m, qty := range s.admin.LockedAssets {
+		incr("lockedAsset:"+m, qty-before.LockedAssets[m])
 	}
+
+	for _, o := range b.ops {
+		switch {
+		case o.kind == trade && o.buyerId != AdminID:
+			pipe.SAdd(ctx, holdersKey(o.marketId), o.buyerId)
+		case o.kind == settle:
+			pipe.HDel(ctx, adminKey, "asset:"+o.marketId, "lockedAsset:"+o.marketId)
+			pipe.Del(ctx, holdersKey(o.marketId))
+		}
+	}
+	return nil
 }
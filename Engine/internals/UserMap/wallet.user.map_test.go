@@ -0,0 +1,91 @@
+package usermap
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// offlineWallet is a wallet store against a Redis nothing listens on, so every Redis call fails.
+func offlineWallet(t *testing.T) *UserWallet {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	return &UserWallet{redisClient: client}
+}
+
+func TestFundsKeepTheServerFormat(t *testing.T) {
+	raw, err := encodeFunds(&funds{Balance: 80, Assets: map[string]int{"m2": 4, "m1": 3, "gone": 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"balance":80,"assets":[{"marketId":"m1","quantity":3},{"marketId":"m2","quantity":4}]}`; raw != want {
+		t.Fatalf("encoded %s, want %s", raw, want)
+	}
+	if raw, _ := encodeFunds(&funds{}); raw != `{"balance":0,"assets":[]}` {
+		t.Fatalf("an empty wallet encodes as %s", raw)
+	}
+
+	w, err := decodeFunds(`{"balance":5,"assets":null}`)
+	if err != nil || w.Balance != 5 || len(w.Assets) != 0 {
+		t.Fatalf("decoded %+v, %v", w, err)
+	}
+}
+
+func TestDecodeAdminFunds(t *testing.T) {
+	admin, err := decodeAdminFunds(map[string]string{"balance": "100", "locked": "30", "asset:m1": "20", "lockedAsset:m1": "5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := adminFunds{Balance: 100, Locked: 30, Assets: map[string]int{"m1": 20}, LockedAssets: map[string]int{"m1": 5}}
+	if !reflect.DeepEqual(admin, want) {
+		t.Fatalf("decoded %+v, want %+v", admin, want)
+	}
+	if _, err := decodeAdminFunds(map[string]string{"balance": "x"}); err == nil {
+		t.Fatal("a field that is not a number decoded")
+	}
+}
+
+func TestApplyFailsWithoutRedis(t *testing.T) {
+	uw := offlineWallet(t)
+	var b Batch
+	b.LockMoney("alice", 10)
+	_, err := uw.Apply("m1", "1-0:lock", &b)
+	if err == nil || Refused(err) {
+		t.Fatalf("Apply without Redis: err %v, want a failure to retry", err)
+	}
+}
+
+func TestLoadUserFromDBNeedsADatabase(t *testing.T) {
+	uw := offlineWallet(t)
+	if err := uw.loadUserFromDB(context.Background(), "gone"); err != ErrWalletNotCached {
+		t.Fatalf("loadUserFromDB without a database: err %v, want ErrWalletNotCached", err)
+	}
+}
+
+func TestOpCovered(t *testing.T) {
+	tests := []struct {
+		opId    string
+		covered bool
+	}{
+		{"1700-0", true},
+		{"1700-0:lock", true},
+		{"1699-5", true},
+		{"1700-1", false},
+		{"1700-1:lock", false},
+		{"1701-0", false},
+	}
+	for _, tt := range tests {
+		if got := opCovered(tt.opId, "1700-0"); got != tt.covered {
+			t.Errorf("opCovered(%s, 1700-0) = %v, want %v", tt.opId, got, tt.covered)
+		}
+	}
+}
+
+func TestPruneOpsFailsWithoutRedis(t *testing.T) {
+	if dropped, err := offlineWallet(t).PruneOps("m1", "5-0"); err == nil || dropped != 0 {
+		t.Fatalf("PruneOps without Redis: dropped %d err %v", dropped, err)
+	}
+}
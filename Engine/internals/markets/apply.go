@@ -0,0 +1,653 @@
+package markets
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	journal "github.com/raiashpanda007/rivon/engine/internals/Journal"
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	pubsub "github.com/raiashpanda007/rivon/engine/internals/PubSub"
+	usermap "github.com/raiashpanda007/rivon/engine/internals/UserMap"
+	tradestream "github.com/raiashpanda007/rivon/engine/internals/utils/TradeStream"
+	wsmessagestypes "github.com/raiashpanda007/rivon/engine/internals/utils/WsMessagesTypes"
+)
+
+// inputMode is how much of what an input does goes out when it is applied.
+type inputMode int
+
+const (
+	// applyLive sends everything: TRADES entries, API replies, the owners' private events
+	// and the book feeds.
+	applyLive inputMode = iota
+	// applyReplay sends the TRADES entries and API replies of a replayed input again, as a
+	// crash may have cut them off.
+	applyReplay
+	// applySilent sends nothing; the input's events went out before the crash.
+	applySilent
+)
+
+// market is a running market: its book and everything applying an input changes or tells.
+// Live inputs and replayed ones go through the same apply, so replay leaves the book and
+// the wallets as the live path did.
+type market struct {
+	ctx        context.Context
+	id         string
+	params     MarketParams
+	book       *orderbooks.OrderBook
+	tradeRedis *redis.Client
+	pubsub     pubsub.PubSubService
+	wsOut      chan wsmessagestypes.WSOutMessageStruct
+	trades     *tradesFeed
+	wallets    *walletQueue
+	record     *journalWriter
+	private    *privateFeed
+	depth      *depthFeed // set once replay is done, like l3
+	l3         *l3Feed
+
+	mode    inputMode      // of the input being applied; applyLive once replay is done
+	entry   *usermap.Batch // the wallet side of the input being applied
+	entryId string
+}
+
+func (m *market) live() bool {
+	return m.mode == applyLive
+}
+
+// announced reports whether the TRADES entries and API replies of the input go out.
+func (m *market) announced() bool {
+	return m.mode != applySilent
+}
+
+// settleEntry queues the wallet side of the input applied last under its stream id.
+func (m *market) settleEntry() *walletOp {
+	op := m.wallets.submit(m.entryId, m.entry)
+	m.entry, m.entryId = &usermap.Batch{}, ""
+	return op
+}
+
+// walletsFailed reports whether the market has to stop because the wallet side of an
+// input could not be applied. Nothing more is saved or acked, so the next run replays
+// every input from the last snapshot and applies what is missing.
+func (m *market) walletsFailed() bool {
+	err := m.wallets.failure()
+	if err != nil {
+		slog.Error("Stopping the market, its wallets could not be updated", "marketId", m.id, "err", err)
+	}
+	return err != nil
+}
+
+// publish queues fn on the TRADES feed.
+func (m *market) publish(fn func()) {
+	if m.announced() {
+		m.trades.publish(fn)
+	}
+}
+
+// cancelled queues the TRADES entry that closes orderId.
+func (m *market) cancelled(orderId string) {
+	if m.announced() {
+		m.trades.cancelled(orderId, m.book.LastOrderId, m.book.LastTradeId)
+	}
+}
+
+// reply answers the API caller of an input.
+func (m *market) reply(msg pubsub.PubSubOrderMessage) {
+	if m.announced() {
+		go m.pubsub.Api().Publish(msg)
+	}
+}
+
+// reject answers the API caller of an order the market refused, and its owner.
+func (m *market) reject(order OrderMessages, err error, reason pubsub.RejectReason) {
+	m.reply(pubsub.PubSubOrderMessage{
+		OrderId:     order.OrderId,
+		MessageType: pubsub.ORDER_REJECTED,
+		Error:       err.Error(),
+		Reason:      reason,
+	})
+	if m.live() {
+		m.private.rejected(order.OrderId, order.UserId, string(reason), err)
+	}
+}
+
+// pushBookChanges sends what the input did to the book to both feeds.
+func (m *market) pushBookChanges(fills []orderbooks.Fills) {
+	if m.live() {
+		m.depth.push(m.book, fills)
+		m.l3.push(m.book)
+	}
+}
+
+func (m *market) pushAuctionState() {
+	if m.live() {
+		pushAuctionState(m.wsOut, m.book)
+	}
+}
+
+// announceStatus tells the market whether it is halted. Replay leaves it to the end, when
+// the market announces the status it was rebuilt with.
+func (m *market) announceStatus() {
+	if m.live() {
+		announceMarketStatus(m.trades, m.wsOut, m.book.Breaker.Halted(), m.book.Breaker.ReferencePrice(), m.book.CurrentPrice, m.book.Breaker.HaltedUntil)
+	}
+}
+
+// apply applies one stream entry to the book. journaled is the entry's record when replay
+// read it back from the journal, whose fills the book has to make again.
+func (m *market) apply(order OrderMessages, mode inputMode, journaled *journal.Record) {
+	t0 := time.Now()
+	m.mode = mode
+	m.record.begin(journalInput(order), m.book.LastStreamId, journaled)
+	m.book.LastStreamId = order.StreamId
+	m.entryId = order.StreamId
+
+	// Orders that had expired by the time the input was queued go first, judged on stream
+	// time so replay expires them at the same point.
+	now := streamTime(order.StreamId)
+	if expired := expireOrders(m.book, now); len(expired) > 0 {
+		for _, o := range expired {
+			releaseEscrow(m.entry, m.id, o.UserId, o.Side, o.Price, o.Quantity-o.Filled)
+			if m.live() {
+				slog.Info("GTD order expired", "orderId", o.Id)
+				m.private.cancelled(o.Id, o.UserId, o.Quantity-o.Filled)
+			}
+		}
+		if m.announced() {
+			announceExpired(m.trades, m.pubsub, expired, m.book.LastOrderId, m.book.LastTradeId)
+		}
+		m.pushBookChanges(nil)
+		m.pushAuctionState()
+	}
+
+	switch order.OrderType {
+	case "EXPIRE_ORDERS":
+		return
+	case "CANCEL_ORDER":
+		m.cancel(order)
+		return
+	case "SETTLE_MARKET":
+		m.settle(order)
+		return
+	}
+	if m.book.Settled && order.OrderType != "SUSPEND_MARKET" && order.OrderType != "END_AUCTION" {
+		slog.Warn("Market settled, rejecting order", "orderId", order.OrderId)
+		if order.OrderType != "REPLACE_ORDER" {
+			m.cancelled(order.OrderId)
+		}
+		m.reject(order, ErrMarketSettled, pubsub.REJECT_MARKET_CLOSED)
+		return
+	}
+
+	// A call period that ran out is uncrossed before the next order joins the book. Auctions
+	// are timed on stream time, so replay uncrosses them exactly where the live path did.
+	armAuction(m.book, m.params, now)
+	if m.book.AuctionDue(now) {
+		m.uncross(now)
+	}
+
+	switch order.OrderType {
+	case "END_AUCTION":
+	case "SUSPEND_MARKET":
+		m.suspend(now)
+	case "REPLACE_ORDER":
+		m.replace(order, now)
+	default:
+		m.place(order, now, t0)
+	}
+}
+
+// cancel takes an order off the book. Cancels sent over WS come through the stream too, so
+// the book only ever changes on a stream entry; they are answered on the connection instead
+// of the API.
+func (m *market) cancel(order OrderMessages) {
+	cancelledOrder, cancelled := m.book.CancelOrder(order.OrderId, order.UserId, order.CancelQty)
+	if cancelled && cancelledOrder != nil {
+		remaining := cancelledOrder.Quantity - cancelledOrder.Filled
+		releaseEscrow(m.entry, m.id, cancelledOrder.UserId, cancelledOrder.Side, cancelledOrder.Price, remaining)
+		if m.live() {
+			m.private.cancelled(cancelledOrder.Id, cancelledOrder.UserId, remaining)
+		}
+	}
+
+	if order.ConnectionId == "" {
+		m.cancelled(order.OrderId)
+		m.reply(pubsub.PubSubOrderMessage{
+			OrderId:     order.OrderId,
+			MessageType: pubsub.ORDER_CANCEL,
+		})
+	} else if cancelled {
+		m.cancelled(order.OrderId)
+	} else if m.live() {
+		go func() {
+			m.wsOut <- wsmessagestypes.WSOutMessageStruct{
+				MessageType: wsmessagestypes.ORDER_CANCELLED,
+				Payload: wsmessagestypes.OrderCancelledPayload{
+					OrderId: order.OrderId,
+					Success: false,
+				},
+				UserId:       order.UserId,
+				ConnectionId: order.ConnectionId,
+			}
+		}()
+	}
+	m.pushBookChanges(nil)
+	m.pushAuctionState()
+}
+
+// settle closes the market for good: the book is emptied, escrow released and every holder
+// paid out, after which orders and replaces are refused.
+func (m *market) settle(order OrderMessages) {
+	if m.book.Settled {
+		slog.Warn("Market already settled, rejecting settlement", "marketId", m.id, "settlementId", order.OrderId)
+		m.reject(order, ErrMarketSettled, pubsub.REJECT_MARKET_CLOSED)
+		return
+	}
+	orders, stops := m.book.Settle(order.Price)
+	slog.Info("settling market", "marketId", m.id, "price", order.Price, "cancelledOrders", len(orders), "cancelledStops", len(stops))
+	price := order.Price
+	payoutSettlement(m.entry, m.id, price, orders, stops)
+	// Queued behind every TRADES entry before it, so DBWritter pays out the positions those
+	// entries leave.
+	ctx, tradeRedis, marketId := m.ctx, m.tradeRedis, m.id
+	lastOId, lastTId := m.book.LastOrderId, m.book.LastTradeId
+	m.publish(func() {
+		publishSettlement(ctx, tradeRedis, marketId, order.OrderId, price, orders, stops, lastOId, lastTId)
+	})
+	for _, o := range orders {
+		m.reply(pubsub.PubSubOrderMessage{
+			OrderId:     o.Id,
+			MessageType: pubsub.ORDER_CANCEL,
+		})
+		if m.live() {
+			m.private.cancelled(o.Id, o.UserId, o.Quantity-o.Filled)
+		}
+	}
+	for _, stop := range stops {
+		m.reply(pubsub.PubSubOrderMessage{
+			OrderId:     stop.Id,
+			MessageType: pubsub.ORDER_CANCEL,
+		})
+		if m.live() {
+			m.private.cancelled(stop.Id, stop.UserId, stop.Quantity)
+		}
+	}
+	m.reply(pubsub.PubSubOrderMessage{
+		OrderId:     order.OrderId,
+		MessageType: pubsub.MARKET_SETTLED,
+	})
+	m.pushBookChanges(nil)
+	if m.live() {
+		announceSettlement(m.wsOut, order.Price)
+	}
+}
+
+// uncross ends a call auction whose period has run out. Bids are the incoming side of every
+// auction match and were escrowed at their own price, so each is settled like a taker; the
+// stops the clearing price crossed are released afterwards and may trip the breaker straight
+// back into a new auction.
+func (m *market) uncross(now int64) {
+	auction, ended := endAuction(m.book, m.params, now)
+	if !ended {
+		slog.Info("opening auction has nothing to uncross, extending the call", "marketId", m.id, "endsAt", m.book.Auction.EndsAt)
+		m.pushAuctionState()
+		return
+	}
+	slog.Info("call auction uncrossed", "marketId", m.id, "price", auction.Price, "matches", len(auction.Matches))
+
+	settleAuction(m.entry, m.id, auction)
+	if m.live() {
+		m.private.auction(m.book, auction)
+	}
+	ctx, tradeRedis, marketId := m.ctx, m.tradeRedis, m.id
+	lastOId, lastTId := m.book.LastOrderId, m.book.LastTradeId
+	m.publish(func() {
+		for _, match := range auction.Matches {
+			publishAuctionMatch(ctx, tradeRedis, marketId, match, lastOId, lastTId)
+		}
+		for _, exec := range auction.Triggered {
+			publishTriggeredExecution(ctx, tradeRedis, marketId, exec, lastOId, lastTId)
+		}
+		publishSelfTradeCancels(ctx, tradeRedis, marketId, auction.STPCancels, lastOId, lastTId)
+	})
+
+	if auction.WasHalted || m.book.Breaker.Halted() {
+		m.announceStatus()
+	}
+	m.pushBookChanges(auction.fills())
+	m.pushAuctionState()
+}
+
+// suspend halts the market like a breaker trip: orders queue in a call auction that
+// uncrosses once the cooldown has run.
+func (m *market) suspend(now int64) {
+	if m.book.Settled || m.book.Breaker.Halted() {
+		return
+	}
+	m.book.Halt(m.params.CircuitBreaker, now)
+	slog.Info("market suspended", "marketId", m.id, "resumesAt", m.book.Breaker.HaltedUntil)
+	m.announceStatus()
+	m.pushAuctionState()
+}
+
+// tripped announces a halt the input's fills tripped.
+func (m *market) tripped(wasHalted bool) {
+	if !wasHalted && m.book.Breaker.Halted() {
+		slog.Warn("circuit breaker tripped, halting market", "marketId", m.id, "referencePrice", m.book.Breaker.ReferencePrice(), "price", m.book.CurrentPrice)
+		m.announceStatus()
+	}
+}
+
+// replace changes the price and quantity of a resting order.
+func (m *market) replace(order OrderMessages, now int64) {
+	wasHalted := m.book.Breaker.Halted()
+	if err := m.params.Rules.ValidateReplace(order.Price, order.Quantity); err != nil {
+		slog.Warn("Replace breaks market trading rules, rejecting replace", "orderId", order.OrderId, "err", err)
+		m.reject(order, err, rejectReason(err))
+		return
+	}
+
+	// Extra escrow is locked before the book changes and handed back if the replace is
+	// refused, so the order is never left under-collateralised.
+	existing, ok := m.book.UserOrderMap[order.UserId][order.OrderId]
+	ok = ok && existing.Filled < existing.Quantity
+	var delta int
+	var side orderbooks.OrderSide
+	err := orderbooks.ErrOrderNotFound
+	if ok {
+		side = existing.Side
+		delta = replaceEscrowDelta(existing, order.Price, order.Quantity)
+		err = nil
+		if delta > 0 {
+			var lock usermap.Batch
+			adjustEscrow(&lock, m.id, order.UserId, side, delta)
+			_, err = m.wallets.lock(order.StreamId, &lock)
+		}
+	}
+	if m.wallets.failure() != nil {
+		return // stopped before the next input
+	}
+	var replaced orderbooks.Order
+	var replaceFills []orderbooks.Fills
+	if err == nil {
+		replaced, replaceFills, _, err = m.book.ReplaceOrder(order.OrderId, order.UserId, order.Price, order.Quantity, order.StreamId)
+		if err != nil && delta > 0 {
+			adjustEscrow(m.entry, m.id, order.UserId, side, -delta)
+		}
+	}
+	if err != nil {
+		slog.Warn("Replace rejected", "orderId", order.OrderId, "err", err)
+		m.reject(order, err, rejectReason(err))
+		return
+	}
+	if delta < 0 {
+		adjustEscrow(m.entry, m.id, order.UserId, side, delta)
+	}
+
+	settleFills(m.entry, m.id, replaceFills, order.UserId, side, replaced.Price)
+	restingQty := m.book.RestingQuantity(order.UserId, order.OrderId)
+	replaceCancelledQty := order.Quantity - replaced.Filled - restingQty
+	releaseEscrow(m.entry, m.id, order.UserId, side, replaced.Price, replaceCancelledQty)
+	m.book.ObserveTrades(m.params.CircuitBreaker, now, replaceFills)
+	triggered := releaseTriggeredOrders(m.book, m.params, now)
+	settleTriggered(m.entry, m.id, triggered)
+	stpCancels := m.book.TakeSelfTradeCancels()
+	releaseSelfTradeCancels(m.entry, m.id, stpCancels)
+	if m.live() {
+		m.private.accepted(order.OrderId, order.UserId, side, order.Price, order.Quantity)
+		m.private.fills(m.book, replaceFills, order.UserId, side, order.Quantity-replaced.Filled)
+		m.private.cancelled(order.OrderId, order.UserId, replaceCancelledQty)
+		m.private.triggered(m.book, triggered)
+		m.private.selfTradeCancels(stpCancels)
+	}
+
+	m.reply(pubsub.PubSubOrderMessage{
+		OrderId:           order.OrderId,
+		Fills:             replaceFills,
+		ExecutedQuantity:  replaced.Filled,
+		CancelledQuantity: replaceCancelledQty,
+		MessageType:       pubsub.ORDER_REPLACED,
+	})
+	ctx, tradeRedis, marketId := m.ctx, m.tradeRedis, m.id
+	lastOId, lastTId := m.book.LastOrderId, m.book.LastTradeId
+	m.publish(func() {
+		publishReplacedOrder(ctx, tradeRedis, marketId, replaced, replaceFills, replaceCancelledQty, restingQty, lastOId, lastTId)
+		for _, exec := range triggered {
+			publishTriggeredExecution(ctx, tradeRedis, marketId, exec, lastOId, lastTId)
+		}
+		publishSelfTradeCancels(ctx, tradeRedis, marketId, stpCancels, lastOId, lastTId)
+	})
+
+	allFills := replaceFills
+	for _, exec := range triggered {
+		allFills = append(allFills, exec.Fills...)
+	}
+	m.pushBookChanges(allFills)
+	m.pushAuctionState()
+	m.tripped(wasHalted)
+}
+
+// place puts a new order on the book: it is checked, its escrow locked, and it is matched,
+// rested or, for a STOP that has not triggered, parked.
+func (m *market) place(order OrderMessages, now int64, t0 time.Time) {
+	wasHalted := m.book.Breaker.Halted()
+	side := orderbooks.OrderSide(order.OrderType)
+	kind := order.OrderKind
+	reduceOnly := order.ReduceOnly && side == orderbooks.SELL
+
+	if order.TimeInForce == orderbooks.GTD && order.ExpiresAt <= now {
+		slog.Warn("GTD order already expired, rejecting order", "orderId", order.OrderId)
+		m.cancelled(order.OrderId)
+		m.reject(order, ErrOrderExpired, pubsub.REJECT_ORDER_EXPIRED)
+		return
+	}
+
+	// The journal holds a reduce-only SELL with the quantity it was cut to, which the rules
+	// were not checked against: 0 when there was nothing to reduce.
+	if reduceOnly && m.record.journaled() {
+		if order.Quantity == 0 {
+			m.cancelled(order.OrderId)
+			m.reject(order, ErrNothingToReduce, rejectReason(ErrNothingToReduce))
+			return
+		}
+	} else if err := m.params.Rules.Validate(order); err != nil {
+		slog.Warn("Order breaks market trading rules, rejecting order", "orderId", order.OrderId, "err", err)
+		m.cancelled(order.OrderId)
+		m.reject(order, err, rejectReason(err))
+		return
+	}
+
+	// Reduce-only SELLs are trimmed to the position, rounded down to whole lots, rather than
+	// rejected outright. Their lock takes what is left of the order; on replay it reads back
+	// the quantity it took live from the op log.
+	trimmedQty := 0
+	escrowed := false
+	if reduceOnly {
+		var lock usermap.Batch
+		lock.LockPosition(order.UserId, m.id, order.Quantity, m.params.Rules.LotSize)
+		qty, err := m.wallets.lock(order.StreamId, &lock)
+		if m.wallets.failure() != nil {
+			return // stopped before the next input
+		}
+		m.record.trim(qty)
+		if err != nil {
+			slog.Warn("Reduce only order cannot be placed, rejecting order", "orderId", order.OrderId, "err", err)
+			m.cancelled(order.OrderId)
+			m.reject(order, err, rejectReason(err))
+			return
+		}
+		trimmedQty = order.Quantity - qty
+		order.Quantity = qty
+		escrowed = true
+	}
+
+	var stop orderbooks.StopOrder
+	if isStopKind(kind) {
+		stop = newStopOrder(order, m.params.MaxSlippageBps)
+		order.Price = stop.Price
+	} else if kind == orderbooks.MARKET {
+		price, tif, err := applyMarketProtection(m.book, side, order.TimeInForce, m.params.MaxSlippageBps)
+		if err != nil {
+			slog.Warn("Market order has no reference price, rejecting order", "orderId", order.OrderId)
+			if escrowed {
+				releaseEscrow(m.entry, m.id, order.UserId, side, order.Price, order.Quantity)
+			}
+			m.cancelled(order.OrderId)
+			m.reject(order, err, rejectReason(err))
+			return
+		}
+		order.Price, order.TimeInForce = price, tif
+	}
+	// Escrow is locked at lockPrice; a triggered STOP may execute below it.
+	lockPrice := order.Price
+	releaseOrderEscrow := func(qty int) {
+		releaseEscrow(m.entry, m.id, order.UserId, side, lockPrice, qty)
+	}
+
+	if !escrowed {
+		var lock usermap.Batch
+		lockEscrow(&lock, m.id, order.UserId, side, lockPrice, order.Quantity)
+		if _, err := m.wallets.lock(order.StreamId, &lock); err != nil {
+			if m.wallets.failure() != nil {
+				return // stopped before the next input
+			}
+			slog.Warn("Escrow lock refused, rejecting order", "orderId", order.OrderId, "err", err)
+			m.reject(order, err, pubsub.REJECT_INSUFFICIENT_BALANCE)
+			return
+		}
+	}
+
+	if isStopKind(kind) {
+		if !orderbooks.ShouldTrigger(stop.Side, stop.TriggerPrice, m.book.CurrentPrice) {
+			m.book.Triggers.Add(&stop)
+			if m.live() {
+				m.private.accepted(order.OrderId, order.UserId, side, stop.Price, order.Quantity+trimmedQty)
+				m.private.cancelled(order.OrderId, order.UserId, trimmedQty)
+			}
+			m.reply(pubsub.PubSubOrderMessage{
+				OrderId:           order.OrderId,
+				CancelledQuantity: trimmedQty,
+				MessageType:       pubsub.ORDER_UPDATE,
+			})
+			return
+		}
+		// Already crossed on arrival: activate it straight away under the same id.
+		activated, err := activateStop(m.book, &stop, m.params.MaxSlippageBps)
+		if err != nil {
+			slog.Warn("Stop order has no reference price, rejecting order", "orderId", order.OrderId)
+			releaseOrderEscrow(order.Quantity)
+			m.cancelled(order.OrderId)
+			m.reject(order, err, rejectReason(err))
+			return
+		}
+		order.Price, order.TimeInForce = activated.Price, activated.TimeInForce
+	}
+
+	inputOrder := orderbooks.Order{
+		Id:          order.OrderId,
+		Quantity:    order.Quantity,
+		Side:        side,
+		Price:       order.Price,
+		UserId:      order.UserId,
+		Filled:      0,
+		StreamId:    order.StreamId,
+		TimeInForce: order.TimeInForce,
+		ExpiresAt:   order.ExpiresAt,
+		PostOnly:    order.PostOnly,
+		STP:         order.STPMode,
+	}
+
+	fills, executedQty, err := m.book.AddOrder(inputOrder, order.Price)
+	if errors.Is(err, orderbooks.ErrFOKNotFillable) || errors.Is(err, orderbooks.ErrPostOnlyWouldCross) || errors.Is(err, orderbooks.ErrAuctionImmediateOrder) {
+		slog.Info("Order rejected by book, rejecting order", "orderId", order.OrderId, "reason", err)
+		releaseOrderEscrow(order.Quantity)
+		m.cancelled(order.OrderId)
+		m.reject(order, err, rejectReason(err))
+		return
+	}
+	if err != nil {
+		slog.Error("Error adding order", "orderId", order.OrderId, "err", err)
+		return
+	}
+	matchDur := time.Since(t0)
+
+	// Whatever neither filled nor rests was cut by IOC or self-trade prevention.
+	restingQty := m.book.RestingQuantity(order.UserId, order.OrderId)
+	cancelledQty := order.Quantity - executedQty - restingQty
+	releaseOrderEscrow(cancelledQty)
+	settleFills(m.entry, m.id, fills, order.UserId, side, lockPrice)
+
+	// Fills may have tripped the circuit breaker or moved CurrentPrice across pending stop
+	// triggers; stops wait for the market to resume if it halted.
+	m.book.ObserveTrades(m.params.CircuitBreaker, now, fills)
+	triggered := releaseTriggeredOrders(m.book, m.params, now)
+	for _, exec := range triggered {
+		slog.Info("stop order triggered", "orderId", exec.Stop.Id, "triggerPrice", exec.Stop.TriggerPrice)
+		settleTriggeredExecution(m.entry, m.id, exec)
+	}
+	stpCancels := m.book.TakeSelfTradeCancels()
+	releaseSelfTradeCancels(m.entry, m.id, stpCancels)
+	if m.live() {
+		m.private.accepted(order.OrderId, order.UserId, side, order.Price, order.Quantity+trimmedQty)
+		m.private.fills(m.book, fills, order.UserId, side, order.Quantity-executedQty)
+		m.private.cancelled(order.OrderId, order.UserId, cancelledQty+trimmedQty)
+		m.private.triggered(m.book, triggered)
+		m.private.selfTradeCancels(stpCancels)
+	}
+
+	apiMsgType := pubsub.ORDER_UPDATE
+	if cancelledQty > 0 && restingQty == 0 {
+		apiMsgType = pubsub.ORDER_CANCEL
+	}
+	reply := pubsub.PubSubOrderMessage{
+		OrderId:           order.OrderId,
+		Fills:             fills,
+		ExecutedQuantity:  executedQty,
+		CancelledQuantity: cancelledQty + trimmedQty,
+		MessageType:       apiMsgType,
+	}
+	if m.live() {
+		pubsubStart := time.Now()
+		go func() {
+			m.pubsub.Api().Publish(reply)
+			slog.Info("latency",
+				"orderId", reply.OrderId,
+				"matchUs", matchDur.Microseconds(),
+				"pubsubUs", time.Since(pubsubStart).Microseconds(),
+				"totalUs", time.Since(t0).Microseconds(),
+			)
+		}()
+	} else {
+		m.reply(reply)
+	}
+
+	ctx, tradeRedis, marketId := m.ctx, m.tradeRedis, m.id
+	orderId, userId, qty, price := order.OrderId, order.UserId, order.Quantity, order.Price
+	lastOId, lastTId := m.book.LastOrderId, m.book.LastTradeId
+	m.publish(func() {
+		tradestream.TradeRedisStreamPublisher(
+			ctx, tradestream.ORDER_UPDATED, orderId, marketId,
+			lastOId, lastTId, fills, executedQty, price,
+			userId, qty, string(side),
+			tradeRedis,
+		)
+		// Published after the update so DBWritter records the fills before the cancel.
+		if cancelledQty > 0 {
+			publishIncomingRemainder(ctx, tradeRedis, marketId, inputOrder, executedQty, restingQty, lastOId, lastTId)
+		}
+		for _, exec := range triggered {
+			publishTriggeredExecution(ctx, tradeRedis, marketId, exec, lastOId, lastTId)
+		}
+		publishSelfTradeCancels(ctx, tradeRedis, marketId, stpCancels, lastOId, lastTId)
+	})
+
+	allFills := fills
+	for _, exec := range triggered {
+		allFills = append(allFills, exec.Fills...)
+	}
+	m.pushBookChanges(allFills)
+	m.pushAuctionState()
+	m.tripped(wasHalted)
+}
@@ -0,0 +1,283 @@
+package markets
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	journal "github.com/raiashpanda007/rivon/engine/internals/Journal"
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	pubsub "github.com/raiashpanda007/rivon/engine/internals/PubSub"
+	usermap "github.com/raiashpanda007/rivon/engine/internals/UserMap"
+	wsmessagestypes "github.com/raiashpanda007/rivon/engine/internals/utils/WsMessagesTypes"
+)
+
+// recordingStore takes every batch and keeps it by op id, and every op log prune.
+type recordingStore struct {
+	mu      sync.Mutex
+	batches map[string]*usermap.Batch
+	pruned  []string
+}
+
+func (s *recordingStore) Apply(marketId, opId string, b *usermap.Batch) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches[opId] = b
+	return 0, nil
+}
+
+func (s *recordingStore) PruneOps(marketId, throughId string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruned = append(s.pruned, throughId)
+	return 0, nil
+}
+
+type recordingApi struct {
+	mu      sync.Mutex
+	replies []string
+}
+
+func (a *recordingApi) Publish(msg pubsub.PubSubOrderMessage) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.replies = append(a.replies, fmt.Sprintf("%s %s exec=%d cancelled=%d fills=%d", msg.OrderId, msg.MessageType, msg.ExecutedQuantity, msg.CancelledQuantity, len(msg.Fills)))
+	return nil
+}
+
+func (a *recordingApi) Subscribe() (any, error) { return nil, nil }
+
+// sorted returns the replies in a fixed order once there are n of them, or after a second;
+// they are published from goroutines.
+func (a *recordingApi) sorted(n int) []string {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		a.mu.Lock()
+		got := len(a.replies)
+		a.mu.Unlock()
+		if got >= n {
+			break
+		}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	replies := append([]string(nil), a.replies...)
+	sort.Strings(replies)
+	return replies
+}
+
+type testPubSub struct {
+	pubsub.PubSubService
+	api *recordingApi
+}
+
+func (p testPubSub) Api() pubsub.ApiPubSubServices { return p.api }
+
+type testMarket struct {
+	*market
+	store  *recordingStore
+	api    *recordingApi
+	states *[]string // the book after every input
+}
+
+// bookState describes everything on ob that replay has to rebuild: the orders resting at
+// each level in queue order, the parked stops and the market's prices and ids.
+func bookState(ob *orderbooks.OrderBook) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "current=%d lastOrder=%s lastTrade=%s lastStream=%s settled=%v halted=%v auction=%v\n",
+		ob.CurrentPrice, ob.LastOrderId, ob.LastTradeId, ob.LastStreamId, ob.Settled, ob.Breaker.Halted(), ob.Auction != nil)
+	for _, side := range []map[int]*orderbooks.PriceLevel{ob.Bids, ob.Asks} {
+		prices := make([]int, 0, len(side))
+		for price := range side {
+			prices = append(prices, price)
+		}
+		sort.Ints(prices)
+		for _, price := range prices {
+			for _, o := range side[price].Orders() {
+				fmt.Fprintf(&b, "%d: %+v\n", price, *o)
+			}
+		}
+	}
+	for _, stops := range []map[int][]*orderbooks.StopOrder{ob.Triggers.BuyStops, ob.Triggers.SellStops} {
+		for price, parked := range stops {
+			for _, stop := range parked {
+				fmt.Fprintf(&b, "stop %d: %+v\n", price, *stop)
+			}
+		}
+	}
+	return b.String()
+}
+
+func newTestMarket(t *testing.T, wal *journal.Journal) testMarket {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	// TRADES entries go nowhere; nothing listens on the address.
+	tradeRedis := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	t.Cleanup(func() { tradeRedis.Close() })
+
+	store := &recordingStore{batches: make(map[string]*usermap.Batch)}
+	api := &recordingApi{}
+	wsOut := make(chan wsmessagestypes.WSOutMessageStruct, 1000)
+	book := newTestBook()
+	wallets := newWalletQueue(store, book.MarketId)
+	t.Cleanup(wallets.close)
+
+	m := &market{
+		ctx:        ctx,
+		id:         book.MarketId,
+		params:     MarketParams{MaxSlippageBps: 500, Rules: TradingRules{LotSize: 1, TickSize: 1}},
+		book:       book,
+		tradeRedis: tradeRedis,
+		pubsub:     testPubSub{api: api},
+		wsOut:      wsOut,
+		trades:     newTradesFeed(ctx, tradeRedis, book.MarketId),
+		wallets:    wallets,
+		record:     &journalWriter{wal: wal},
+		private:    newPrivateFeed(book.MarketId, wsOut, nil),
+		depth:      &depthFeed{marketId: book.MarketId, out: wsOut, view: newBookView(book)},
+		l3:         &l3Feed{marketId: book.MarketId, out: make(chan pubsub.L3Message, l3Buffer)},
+		entry:      &usermap.Batch{},
+	}
+	return testMarket{market: m, store: store, api: api, states: new([]string)}
+}
+
+// run applies inputs the way StarMarketProcess does, settling each input's wallet side and
+// journaling it before the next.
+func (m testMarket) run(t *testing.T, inputs []OrderMessages, mode inputMode, records []journal.Record) {
+	t.Helper()
+	for i, order := range inputs {
+		var rec *journal.Record
+		if records != nil {
+			rec = &records[i]
+		}
+		m.apply(order, mode, rec)
+		m.settleEntry()
+		m.record.commit(m.book)
+		*m.states = append(*m.states, bookState(m.book))
+	}
+	if err := m.wallets.barrier(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func openJournal(t *testing.T, root string) *journal.Journal {
+	t.Helper()
+	wal, err := journal.Open(root, "test-market")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wal
+}
+
+func testInputs() []OrderMessages {
+	limit := func(id, stream, userId, side string, price, qty int) OrderMessages {
+		return OrderMessages{OrderId: id, StreamId: stream, UserId: userId, MarketId: "test-market", OrderType: side, Price: price, Quantity: qty, OrderKind: orderbooks.LIMIT}
+	}
+	inputs := []OrderMessages{
+		limit("o1", "1000-0", "alice", "SELL", 101, 5),
+		limit("o2", "1001-0", "bob", "SELL", 102, 3),
+		limit("o3", "1002-0", "carol", "BUY", 102, 6),
+		{OrderId: "o2", StreamId: "1003-0", UserId: "bob", MarketId: "test-market", OrderType: "REPLACE_ORDER", Price: 103, Quantity: 4},
+		{OrderId: "o4", StreamId: "1004-0", UserId: "dave", MarketId: "test-market", OrderType: "BUY", Price: 104, Quantity: 1, OrderKind: orderbooks.STOP_LIMIT, TriggerPrice: 103},
+		limit("o5", "1005-0", "erin", "BUY", 103, 3),
+		limit("o6", "1006-0", "carol", "BUY", 90, 2),
+		{OrderId: "o6", StreamId: "1007-0", UserId: "carol", MarketId: "test-market", OrderType: "CANCEL_ORDER"},
+		{OrderId: "o7", StreamId: "1008-0", UserId: "alice", MarketId: "test-market", OrderType: "SELL", Price: 110, Quantity: 2, OrderKind: orderbooks.LIMIT, TimeInForce: orderbooks.GTD, ExpiresAt: 1500},
+		{OrderId: "o8", StreamId: "1009-0", UserId: "frank", MarketId: "test-market", OrderType: "BUY", Quantity: 1, OrderKind: orderbooks.MARKET},
+		{OrderId: "o9", StreamId: "1010-0", UserId: "frank", MarketId: "test-market", OrderType: "BUY", Price: 110, Quantity: 9, OrderKind: orderbooks.LIMIT, TimeInForce: orderbooks.FOK},
+		{OrderId: "expire", StreamId: "2000-0", MarketId: "test-market", OrderType: "EXPIRE_ORDERS"},
+		limit("o10", "2001-0", "alice", "SELL", 120, 1),
+		{OrderId: "settle", StreamId: "3000-0", MarketId: "test-market", OrderType: "SETTLE_MARKET", Price: 100},
+		limit("o11", "3001-0", "carol", "BUY", 100, 1),
+	}
+	return inputs
+}
+
+// sameMarket compares what two markets rebuilt: the book after every input, and every
+// wallet batch by op id.
+func sameMarket(t *testing.T, name string, got, want testMarket) {
+	t.Helper()
+	for i := range *want.states {
+		if g, w := (*got.states)[i], (*want.states)[i]; g != w {
+			t.Errorf("%s: book after input %d\n%s\nwant\n%s", name, i, g, w)
+			break
+		}
+	}
+	if !reflect.DeepEqual(got.store.batches, want.store.batches) {
+		t.Errorf("%s: wallet batches differ from the live run", name)
+		for opId, b := range want.store.batches {
+			if !reflect.DeepEqual(got.store.batches[opId], b) {
+				t.Errorf("  %s: %+v, want %+v", opId, got.store.batches[opId], b)
+			}
+		}
+	}
+}
+
+// liveReplies is how many API replies the live run of testInputs publishes.
+const liveReplies = 17
+
+func TestReplayRebuildsWhatTheLiveRunDid(t *testing.T) {
+	inputs := testInputs()
+	root := t.TempDir()
+
+	live := newTestMarket(t, openJournal(t, root))
+	live.run(t, inputs, applyLive, nil)
+	if err := live.record.wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !live.book.Settled || len(live.store.batches) == 0 {
+		t.Fatalf("live run settled %v with %d batches; the inputs do not exercise the market", live.book.Settled, len(live.store.batches))
+	}
+
+	records, err := journal.ReadAfter(root, "test-market", "0")
+	if err != nil || len(records) != len(inputs) {
+		t.Fatalf("journal holds %d records, err %v, want %d", len(records), err, len(inputs))
+	}
+
+	fromJournal := newTestMarket(t, openJournal(t, t.TempDir()))
+	var journaled []OrderMessages
+	for _, rec := range records {
+		journaled = append(journaled, orderMessage(*rec.Input))
+	}
+	fromJournal.run(t, journaled, applySilent, records)
+	sameMarket(t, "replay from the journal", fromJournal, live)
+	if fromJournal.record.diverged != 0 {
+		t.Errorf("%d replayed inputs made other fills than the journal", fromJournal.record.diverged)
+	}
+	if replies := fromJournal.api.sorted(0); len(replies) != 0 {
+		t.Errorf("silent replay answered %v", replies)
+	}
+
+	fromStream := newTestMarket(t, nil)
+	fromStream.run(t, inputs, applyReplay, nil)
+	sameMarket(t, "replay from the stream", fromStream, live)
+	if got, want := fromStream.api.sorted(liveReplies), live.api.sorted(liveReplies); !reflect.DeepEqual(got, want) {
+		t.Errorf("replay answered\n%v\nwant the live answers\n%v", got, want)
+	}
+}
+
+func TestReplayReportsFillsTheJournalDoesNotHave(t *testing.T) {
+	inputs := testInputs()[:3]
+	root := t.TempDir()
+	live := newTestMarket(t, openJournal(t, root))
+	live.run(t, inputs, applyLive, nil)
+	live.record.wal.Close()
+
+	records, err := journal.ReadAfter(root, "test-market", "0")
+	if err != nil || len(records) != 3 || len(records[2].Fills) == 0 {
+		t.Fatalf("journal %+v, err %v", records, err)
+	}
+	records[2].Fills[0].Quantity++
+
+	replay := newTestMarket(t, openJournal(t, t.TempDir()))
+	replay.run(t, inputs, applySilent, records)
+	if replay.record.diverged != 1 {
+		t.Fatalf("diverged %d, want the tampered record", replay.record.diverged)
+	}
+}
@@ -0,0 +1,112 @@
+package markets
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	usermap "github.com/raiashpanda007/rivon/engine/internals/UserMap"
+	tradestream "github.com/raiashpanda007/rivon/engine/internals/utils/TradeStream"
+	wsmessagestypes "github.com/raiashpanda007/rivon/engine/internals/utils/WsMessagesTypes"
+)
+
+// auctionOutcome is everything ending one call period did to the book.
+type auctionOutcome struct {
+	Price      int
+	Matches    []orderbooks.AuctionMatch
+	Triggered  []triggeredExecution
+	STPCancels []orderbooks.SelfTradeCancel
+	WasHalted  bool // the auction was a circuit breaker halt
+}
+
+// fills returns every fill of the uncross and of the stops it released.
+func (o auctionOutcome) fills() []orderbooks.Fills {
+	var all []orderbooks.Fills
+	for _, m := range o.Matches {
+		all = append(all, m.Fills...)
+	}
+	for _, exec := range o.Triggered {
+		all = append(all, exec.Fills...)
+	}
+	return all
+}
+
+// armAuction starts the clock of an opening auction when its first order arrives at
+// stream time now.
+func armAuction(ob *orderbooks.OrderBook, params MarketParams, now int64) {
+	if ob.Auction != nil && ob.Auction.EndsAt == 0 {
+		ob.Auction.EndsAt = now + params.AuctionCallMs
+	}
+}
+
+// queueUncross writes an END_AUCTION entry on the market's order stream. A call period that
+// has run out is uncrossed when the market applies the entry, so the live market and replay
+// uncross at the same stream time even with no orders coming in.
+func queueUncross(ctx context.Context, orderRedis *redis.Client, marketId string) error {
+	return queueMarketInput(ctx, orderRedis, marketId, "END_AUCTION")
+}
+
+// endAuction uncrosses the book once its call period has run out at stream time now and
+// returns to continuous matching, releasing the stops the clearing price crossed. An opening
+// auction with nothing to execute calls for another period instead and reports false.
+func endAuction(ob *orderbooks.OrderBook, params MarketParams, now int64) (auctionOutcome, bool) {
+	if ob.Auction.Opening && params.AuctionCallMs > 0 {
+		if _, volume := ob.IndicativeUncross(); volume == 0 {
+			ob.Auction.EndsAt = now + params.AuctionCallMs
+			return auctionOutcome{}, false
+		}
+	}
+	out := auctionOutcome{WasHalted: ob.Breaker.Halted()}
+	// The fills are named after the auction rather than the entry that ended it.
+	ob.AttributeFills("auction-"+strconv.FormatInt(ob.Auction.EndsAt, 10), func() {
+		out.Price, out.Matches = ob.Uncross()
+		// The band restarts from the clearing price, so the uncross itself cannot trip it.
+		ob.Breaker.Resume(now, ob.CurrentPrice)
+		out.Triggered = releaseTriggeredOrders(ob, params, now)
+	})
+	out.STPCancels = ob.TakeSelfTradeCancels()
+	return out, true
+}
+
+// settleAuction applies the wallet side of an uncross. Bids are the incoming side of every
+// match and were escrowed at their own price, so each is settled like a taker.
+func settleAuction(b *usermap.Batch, marketId string, out auctionOutcome) {
+	for _, m := range out.Matches {
+		settleFills(b, marketId, m.Fills, m.Buy.UserId, m.Buy.Side, m.Buy.Price)
+	}
+	settleTriggered(b, marketId, out.Triggered)
+	releaseSelfTradeCancels(b, marketId, out.STPCancels)
+}
+
+// publishAuctionMatch writes the fills one bid received in an uncross to TRADES. The
+// executed quantity is the bid's running total, as for any other order update.
+func publishAuctionMatch(ctx context.Context, tradeRedis *redis.Client, marketId string, m orderbooks.AuctionMatch, lastOrderId, lastTradeId string) {
+	tradestream.TradeRedisStreamPublisher(
+		ctx, tradestream.ORDER_UPDATED, m.Buy.Id, marketId,
+		lastOrderId, lastTradeId, m.Fills, m.Buy.Filled, m.Buy.Price,
+		m.Buy.UserId, m.Buy.Quantity, string(m.Buy.Side),
+		tradeRedis,
+	)
+}
+
+// pushAuctionState sends the indicative uncross of an open call auction to wsOutChannel
+// without blocking the caller. It does nothing while the market matches continuously.
+func pushAuctionState(wsOutChannel chan wsmessagestypes.WSOutMessageStruct, ob *orderbooks.OrderBook) {
+	if ob.Auction == nil {
+		return
+	}
+	price, volume := ob.IndicativeUncross()
+	payload := wsmessagestypes.AuctionPayload{
+		IndicativePrice:  price,
+		IndicativeVolume: volume,
+		EndsAt:           ob.Auction.EndsAt,
+		Opening:          ob.Auction.Opening,
+	}
+	go func() {
+		wsOutChannel <- wsmessagestypes.WSOutMessageStruct{
+			MessageType: wsmessagestypes.AUCTION_STATE,
+			Payload:     payload,
+		}
+	}()
+}
@@ -0,0 +1,42 @@
+package markets
+
+import (
+	"strconv"
+	"strings"
+
+	tradestream "github.com/raiashpanda007/rivon/engine/internals/utils/TradeStream"
+	wsmessagestypes "github.com/raiashpanda007/rivon/engine/internals/utils/WsMessagesTypes"
+)
+
+// streamTime is the unix ms part of a Redis stream id ("<ms>-<seq>"). The circuit breaker
+// runs on it instead of the wall clock so replay sees the same times as the live path.
+func streamTime(streamId string) int64 {
+	ms, _, _ := strings.Cut(streamId, "-")
+	t, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return t
+}
+
+// announceMarketStatus broadcasts MARKET_HALTED / MARKET_RESUMED to the market's WS
+// subscribers and records the status on TRADES so DBWritter updates markets.status.
+func announceMarketStatus(trades *tradesFeed, wsOutChannel chan wsmessagestypes.WSOutMessageStruct, halted bool, referencePrice, currentPrice int, resumesAt int64) {
+	msgType, status := wsmessagestypes.MARKET_RESUMED, "open"
+	if halted {
+		msgType, status = wsmessagestypes.MARKET_HALTED, "suspended"
+	} else {
+		resumesAt = 0
+	}
+	trades.publish(func() { tradestream.MarketStatusPublisher(trades.ctx, trades.marketId, status, trades.redis) })
+	go func() {
+		wsOutChannel <- wsmessagestypes.WSOutMessageStruct{
+			MessageType: msgType,
+			Payload: wsmessagestypes.MarketStatusPayload{
+				ReferencePrice: referencePrice,
+				CurrentPrice:   currentPrice,
+				ResumesAt:      resumesAt,
+			},
+		}
+	}()
+}
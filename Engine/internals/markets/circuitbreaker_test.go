@@ -0,0 +1,18 @@
+package markets
+
+import "testing"
+
+func TestStreamTime(t *testing.T) {
+	tests := map[string]int64{
+		"1700000000123-0": 1700000000123,
+		"1700000000123-7": 1700000000123,
+		"42":              42,
+		"":                0,
+		"bad-0":           0,
+	}
+	for id, want := range tests {
+		if got := streamTime(id); got != want {
+			t.Errorf("streamTime(%q) = %d, want %d", id, got, want)
+		}
+	}
+}
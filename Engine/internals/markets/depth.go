@@ -0,0 +1,67 @@
+package markets
+
+import (
+	"log/slog"
+
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	wsmessagestypes "github.com/raiashpanda007/rivon/engine/internals/utils/WsMessagesTypes"
+)
+
+// depthFeed publishes the levels a market changes as DEPTH_DELTA messages numbered for
+// the run of the market, and the full book tagged with the last number sent.
+type depthFeed struct {
+	marketId string
+	out      chan wsmessagestypes.WSOutMessageStruct
+	view     *bookView
+	seq      int64
+}
+
+// push sends the levels ob changed since the last push, with the fills that changed them.
+// Deltas go out from the market goroutine in order; one that finds out full is dropped, and
+// the gap it leaves makes subscribers resync.
+func (d *depthFeed) push(ob *orderbooks.OrderBook, fills []orderbooks.Fills) {
+	changes := ob.TakeDepthChanges()
+	d.view.apply(changes, ob)
+	if len(changes) == 0 && len(fills) == 0 {
+		return
+	}
+	d.seq++
+	payload := wsmessagestypes.DepthDeltaPayload{
+		DepthSeq:     d.seq,
+		CurrentPrice: ob.CurrentPrice,
+		Fills:        toPublicFills(fills),
+	}
+	for _, c := range changes {
+		level := wsmessagestypes.LevelDelta{Price: c.Price, Quantity: c.Quantity}
+		if c.Side == orderbooks.BUY {
+			payload.Bids = append(payload.Bids, level)
+		} else {
+			payload.Asks = append(payload.Asks, level)
+		}
+	}
+	select {
+	case d.out <- wsmessagestypes.WSOutMessageStruct{MessageType: wsmessagestypes.DEPTH_DELTA, Payload: payload}:
+	default:
+		slog.Warn("WS_OUT full, depth delta dropped", "marketId", d.marketId, "depthSeq", d.seq)
+	}
+}
+
+// sendBook sends the full book of ob to one connection without blocking the caller, as of
+// the last delta: changes not pushed yet are pushed first.
+func (d *depthFeed) sendBook(ob *orderbooks.OrderBook, userId, connId string) {
+	d.push(ob, nil)
+	payload := wsmessagestypes.OrderbookPayload{
+		BidDepth:     copyDepth(ob.BidDepth),
+		AskDepth:     copyDepth(ob.AskDepth),
+		CurrentPrice: ob.CurrentPrice,
+		DepthSeq:     d.seq,
+	}
+	go func() {
+		d.out <- wsmessagestypes.WSOutMessageStruct{
+			MessageType:  wsmessagestypes.ORDERBOOK_DATA,
+			Payload:      payload,
+			UserId:       userId,
+			ConnectionId: connId,
+		}
+	}()
+}
@@ -0,0 +1,138 @@
+package markets
+
+import (
+	"reflect"
+	"testing"
+
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	wsmessagestypes "github.com/raiashpanda007/rivon/engine/internals/utils/WsMessagesTypes"
+)
+
+func newTestDepthFeed(ob *orderbooks.OrderBook, buffer int) *depthFeed {
+	return &depthFeed{marketId: ob.MarketId, out: make(chan wsmessagestypes.WSOutMessageStruct, buffer), view: newBookView(ob)}
+}
+
+func addOrder(t *testing.T, ob *orderbooks.OrderBook, id, userId string, side orderbooks.OrderSide, price, qty int) []orderbooks.Fills {
+	t.Helper()
+	fills, _, err := ob.AddOrder(orderbooks.Order{Id: id, UserId: userId, Side: side, Price: price, Quantity: qty}, price)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fills
+}
+
+func nextDelta(t *testing.T, d *depthFeed) wsmessagestypes.DepthDeltaPayload {
+	t.Helper()
+	select {
+	case msg := <-d.out:
+		if msg.MessageType != wsmessagestypes.DEPTH_DELTA {
+			t.Fatalf("got %s, want a depth delta", msg.MessageType)
+		}
+		return msg.Payload.(wsmessagestypes.DepthDeltaPayload)
+	default:
+		t.Fatal("no depth delta sent")
+		return wsmessagestypes.DepthDeltaPayload{}
+	}
+}
+
+// applyDeltas applies levels to depth the way a subscriber does.
+func applyDeltas(depth map[int]int, levels []wsmessagestypes.LevelDelta) {
+	for _, l := range levels {
+		if l.Quantity == 0 {
+			delete(depth, l.Price)
+		} else {
+			depth[l.Price] = l.Quantity
+		}
+	}
+}
+
+func TestDepthDeltasRebuildTheBook(t *testing.T) {
+	ob := newTestBook()
+	d := newTestDepthFeed(ob, 16)
+	bids, asks := map[int]int{}, map[int]int{}
+
+	steps := []func() []orderbooks.Fills{
+		func() []orderbooks.Fills { return addOrder(t, ob, "b1", "alice", orderbooks.BUY, 99, 5) },
+		func() []orderbooks.Fills { return addOrder(t, ob, "b2", "alice", orderbooks.BUY, 98, 2) },
+		func() []orderbooks.Fills { return addOrder(t, ob, "a1", "bob", orderbooks.SELL, 101, 4) },
+		// Takes all of 99 and part of 98.
+		func() []orderbooks.Fills { return addOrder(t, ob, "a2", "bob", orderbooks.SELL, 98, 6) },
+		func() []orderbooks.Fills {
+			if _, ok := ob.CancelOrder("a1", "bob", 0); !ok {
+				t.Fatal("a1 not cancelled")
+			}
+			return nil
+		},
+	}
+	for i, step := range steps {
+		fills := step()
+		d.push(ob, fills)
+		delta := nextDelta(t, d)
+		if delta.DepthSeq != int64(i+1) {
+			t.Fatalf("step %d sent depthSeq %d", i, delta.DepthSeq)
+		}
+		if len(delta.Fills) != len(fills) || delta.CurrentPrice != ob.CurrentPrice {
+			t.Fatalf("step %d sent %d fills at %d, want %d at %d", i, len(delta.Fills), delta.CurrentPrice, len(fills), ob.CurrentPrice)
+		}
+		applyDeltas(bids, delta.Bids)
+		applyDeltas(asks, delta.Asks)
+		if !reflect.DeepEqual(bids, copyDepth(ob.BidDepth)) || !reflect.DeepEqual(asks, copyDepth(ob.AskDepth)) {
+			t.Fatalf("after step %d the deltas give bids %v asks %v, the book has %v %v", i, bids, asks, ob.BidDepth, ob.AskDepth)
+		}
+	}
+	if _, ok := bids[99]; ok || bids[98] != 1 || len(asks) != 0 {
+		t.Fatalf("deltas ended at bids %v asks %v", bids, asks)
+	}
+}
+
+func TestUnchangedBookSendsNoDelta(t *testing.T) {
+	ob := newTestBook()
+	d := newTestDepthFeed(ob, 4)
+	d.push(ob, nil)
+	if len(d.out) != 0 || d.seq != 0 {
+		t.Fatalf("sent %d deltas up to %d for no change", len(d.out), d.seq)
+	}
+}
+
+func TestDroppedDeltaLeavesAGap(t *testing.T) {
+	ob := newTestBook()
+	d := newTestDepthFeed(ob, 1)
+	addOrder(t, ob, "b1", "alice", orderbooks.BUY, 99, 5)
+	d.push(ob, nil)
+	addOrder(t, ob, "b2", "alice", orderbooks.BUY, 98, 5)
+	d.push(ob, nil) // out is full
+
+	if got := nextDelta(t, d).DepthSeq; got != 1 {
+		t.Fatalf("first delta numbered %d", got)
+	}
+	addOrder(t, ob, "b3", "alice", orderbooks.BUY, 97, 5)
+	d.push(ob, nil)
+	if got := nextDelta(t, d).DepthSeq; got != 3 {
+		t.Fatalf("delta after the dropped one numbered %d, want 3 so subscribers see the gap", got)
+	}
+}
+
+func TestBookIsTaggedWithTheLastDelta(t *testing.T) {
+	ob := newTestBook()
+	d := newTestDepthFeed(ob, 4)
+	addOrder(t, ob, "b1", "alice", orderbooks.BUY, 99, 5)
+	d.push(ob, nil)
+	addOrder(t, ob, "a1", "bob", orderbooks.SELL, 101, 3)
+
+	// The ask is not pushed yet: the book goes out behind its delta.
+	d.sendBook(ob, "carol", "conn-1")
+	if delta := nextDelta(t, d); delta.DepthSeq != 1 {
+		t.Fatalf("first delta numbered %d", delta.DepthSeq)
+	}
+	if delta := nextDelta(t, d); delta.DepthSeq != 2 || len(delta.Asks) != 1 {
+		t.Fatalf("pending change went out as %+v", delta)
+	}
+	msg := <-d.out
+	book, ok := msg.Payload.(wsmessagestypes.OrderbookPayload)
+	if msg.MessageType != wsmessagestypes.ORDERBOOK_DATA || !ok || msg.ConnectionId != "conn-1" {
+		t.Fatalf("got %+v, want the book for conn-1", msg)
+	}
+	if book.DepthSeq != 2 || book.BidDepth[99] != 5 || book.AskDepth[101] != 3 {
+		t.Fatalf("book %+v, want both levels as of delta 2", book)
+	}
+}
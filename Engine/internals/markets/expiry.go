@@ -0,0 +1,52 @@
+package markets
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	pubsub "github.com/raiashpanda007/rivon/engine/internals/PubSub"
+)
+
+// expireOrders takes every GTD order and stop that has expired by now off ob and returns
+// them. now is the stream time of the input about to be applied, so replay expires the
+// same orders at the same point the live market did.
+func expireOrders(ob *orderbooks.OrderBook, now int64) []*orderbooks.Order {
+	expired := ob.ExpireOrders(now)
+	for _, stop := range ob.Triggers.ExpireStops(now) {
+		expired = append(expired, &stop.Order)
+	}
+	return expired
+}
+
+// announceExpired records expired orders as cancelled on TRADES and tells their API callers.
+func announceExpired(trades *tradesFeed, pubsubSvc pubsub.PubSubService, expired []*orderbooks.Order, lastOrderId, lastTradeId string) {
+	for _, o := range expired {
+		trades.cancelled(o.Id, lastOrderId, lastTradeId)
+		go pubsubSvc.Api().Publish(pubsub.PubSubOrderMessage{
+			OrderId:     o.Id,
+			MessageType: pubsub.ORDER_CANCEL,
+		})
+	}
+}
+
+// queueExpiry writes an EXPIRE_ORDERS entry on the market's order stream. A market with no
+// other input coming expires its orders when it applies the entry.
+func queueExpiry(ctx context.Context, orderRedis *redis.Client, marketId string) error {
+	return queueMarketInput(ctx, orderRedis, marketId, "EXPIRE_ORDERS")
+}
+
+// queueMarketInput writes an input of the market's own, one carrying no order, on its order stream.
+func queueMarketInput(ctx context.Context, orderRedis *redis.Client, marketId, orderType string) error {
+	return orderRedis.XAdd(ctx, &redis.XAddArgs{
+		Stream: "ORDERS_" + marketId,
+		Values: map[string]interface{}{
+			"orderId":   "",
+			"userId":    "",
+			"marketId":  marketId,
+			"orderType": orderType,
+			"price":     0,
+			"quantity":  0,
+		},
+	}).Err()
+}
@@ -0,0 +1,53 @@
+package markets
+
+import (
+	"reflect"
+	"testing"
+
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	heap "github.com/raiashpanda007/rivon/engine/internals/utils"
+)
+
+func newTestBook() *orderbooks.OrderBook {
+	ob := orderbooks.NewOrderBook("", "", "", nil, nil, heap.NewMinHeap(), heap.NewMaxHeap(), 0, nil, orderbooks.FIFO)
+	ob.MarketId = "test-market"
+	return &ob
+}
+
+func TestExpireOrdersTakesRestingOrdersAndStops(t *testing.T) {
+	ob := newTestBook()
+	ob.LastStreamId = "1-0"
+	resting := orderbooks.Order{Id: "r1", UserId: "alice", Side: orderbooks.BUY, Price: 99, Quantity: 5, TimeInForce: orderbooks.GTD, ExpiresAt: 1500}
+	if _, _, err := ob.AddOrder(resting, 99); err != nil {
+		t.Fatal(err)
+	}
+	ob.Triggers.Add(&orderbooks.StopOrder{
+		Order:        orderbooks.Order{Id: "s1", UserId: "bob", Side: orderbooks.SELL, Price: 90, Quantity: 5, TimeInForce: orderbooks.GTD, ExpiresAt: 1000},
+		TriggerPrice: 95,
+		Kind:         orderbooks.STOP_LIMIT,
+	})
+	ob.Triggers.Add(&orderbooks.StopOrder{
+		Order:        orderbooks.Order{Id: "s2", UserId: "bob", Side: orderbooks.SELL, Price: 90, Quantity: 5, TimeInForce: orderbooks.GTD, ExpiresAt: 5000},
+		TriggerPrice: 95,
+		Kind:         orderbooks.STOP_LIMIT,
+	})
+
+	if expired := expireOrders(ob, 999); len(expired) != 0 {
+		t.Fatalf("expired %d orders before any expiry", len(expired))
+	}
+
+	var got []string
+	for _, o := range expireOrders(ob, 2000) {
+		got = append(got, o.Id)
+	}
+	// Resting orders first, then stops, each earliest expiry first.
+	if want := []string{"r1", "s1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expired %v, want %v", got, want)
+	}
+	if stops := ob.Triggers.Orders(); len(stops) != 1 || stops[0].Id != "s2" {
+		t.Fatalf("pending stops %v, want s2", stops)
+	}
+	if ob.HasExpired(2000) {
+		t.Fatal("HasExpired after expiring everything due")
+	}
+}
@@ -0,0 +1,134 @@
+package markets
+
+import (
+	"log/slog"
+
+	journal "github.com/raiashpanda007/rivon/engine/internals/Journal"
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	redisStream "github.com/raiashpanda007/rivon/engine/internals/Redis"
+)
+
+// journalWriter writes one journal record per input the market applies, once the input is
+// done and its fills are known. Events the input publishes meanwhile wait in the journal
+// until the record is durable.
+type journalWriter struct {
+	wal     *journal.Journal // nil when the market runs without a journal
+	pending *redisStream.ReplayOrderStreamMessage
+	prev    string // the stream entry applied before pending
+
+	// replayed is the record of the input being applied when it was read back from the
+	// journal. It is not written again; the fills the book makes are checked against it.
+	replayed *journal.Record
+	diverged int // replayed inputs whose fills differ from the journal's
+}
+
+// begin starts the record of input, which the market applies after the stream entry prev.
+// journaled is the input's record when it was read back from the journal.
+func (w *journalWriter) begin(input redisStream.ReplayOrderStreamMessage, prev string, journaled *journal.Record) {
+	if w.wal == nil {
+		return
+	}
+	if journaled != nil {
+		w.replayed = journaled
+		return
+	}
+	w.wal.Begin()
+	w.pending, w.prev = &input, prev
+}
+
+// journaled reports whether the input being applied was read back from the journal.
+func (w *journalWriter) journaled() bool {
+	return w.replayed != nil
+}
+
+// commit writes the record of the input begun last with the fills the book made since.
+// Fills made with no input, by an auction ended off the clock, get a record of their own.
+func (w *journalWriter) commit(ob *orderbooks.OrderBook) {
+	fills := ob.TakeFills()
+	if w.wal == nil {
+		return
+	}
+	if rec := w.replayed; rec != nil {
+		w.replayed = nil
+		if !sameFills(fills, rec.Fills) {
+			w.diverged++
+			slog.Error("Replay made other fills than the journal recorded", "marketId", ob.MarketId, "streamId", rec.Input.StreamId, "fills", fills, "journaled", rec.Fills)
+		}
+		return
+	}
+	if w.pending == nil && len(fills) == 0 {
+		return
+	}
+	rec := journal.Record{Input: w.pending, Fills: fills}
+	if w.pending != nil {
+		rec.Prev = w.prev
+	}
+	if err := w.wal.Append(rec); err != nil {
+		slog.Error("Unable to journal input", "marketId", ob.MarketId, "err", err)
+	}
+	w.pending = nil
+}
+
+func sameFills(a, b []orderbooks.Fills) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// trim records the quantity a reduce-only input begun last was cut to, 0 when there was
+// nothing to reduce, so replay from the journal does not need the wallet to cut it again.
+func (w *journalWriter) trim(quantity int) {
+	if w.pending != nil {
+		w.pending.Quantity = quantity
+	}
+}
+
+// journalInput is order in the form replay reads inputs back in.
+func journalInput(order OrderMessages) redisStream.ReplayOrderStreamMessage {
+	return redisStream.ReplayOrderStreamMessage{
+		OrderId:      order.OrderId,
+		UserId:       order.UserId,
+		MarketId:     order.MarketId,
+		Price:        order.Price,
+		Quantity:     order.Quantity,
+		OrderType:    order.OrderType,
+		StreamId:     order.StreamId,
+		TimeInForce:  string(order.TimeInForce),
+		ExpiresAt:    order.ExpiresAt,
+		OrderKind:    string(order.OrderKind),
+		TriggerPrice: order.TriggerPrice,
+		PostOnly:     order.PostOnly,
+		ReduceOnly:   order.ReduceOnly,
+		STPMode:      string(order.STPMode),
+		CancelQty:    order.CancelQty,
+		ConnectionId: order.ConnectionId,
+	}
+}
+
+// orderMessage is input as the market applies it.
+func orderMessage(input redisStream.ReplayOrderStreamMessage) OrderMessages {
+	return OrderMessages{
+		OrderId:      input.OrderId,
+		UserId:       input.UserId,
+		MarketId:     input.MarketId,
+		Price:        input.Price,
+		Quantity:     input.Quantity,
+		OrderType:    input.OrderType,
+		StreamId:     input.StreamId,
+		TimeInForce:  orderbooks.TimeInForce(input.TimeInForce),
+		ExpiresAt:    input.ExpiresAt,
+		OrderKind:    orderbooks.OrderKind(input.OrderKind),
+		TriggerPrice: input.TriggerPrice,
+		PostOnly:     input.PostOnly,
+		ReduceOnly:   input.ReduceOnly,
+		STPMode:      orderbooks.STPMode(input.STPMode),
+		CancelQty:    input.CancelQty,
+		ConnectionId: input.ConnectionId,
+	}
+}
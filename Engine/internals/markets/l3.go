@@ -0,0 +1,85 @@
+package markets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	pubsub "github.com/raiashpanda007/rivon/engine/internals/PubSub"
+	wsmessagestypes "github.com/raiashpanda007/rivon/engine/internals/utils/WsMessagesTypes"
+)
+
+// l3Buffer is how many batches of order events wait for the L3 publisher.
+const l3Buffer = 1000
+
+// publicHandle stands for an order in the L3 feed. Only someone who knows the order id can
+// tie the two together.
+func publicHandle(marketId, orderId string) string {
+	sum := sha256.Sum256([]byte(marketId + "/" + orderId))
+	return hex.EncodeToString(sum[:8])
+}
+
+// l3Feed publishes the order events of a market as numbered batches on L3_<marketId>, and
+// the resting orders tagged with the last number sent.
+type l3Feed struct {
+	marketId string
+	epoch    int64
+	out      chan pubsub.L3Message
+	seq      int64
+}
+
+// push numbers the order events ob made since the last push and hands them to the
+// publisher in order. A batch that finds the publisher behind is dropped, and the gap it
+// leaves makes consumers take a new snapshot.
+func (f *l3Feed) push(ob *orderbooks.OrderBook) {
+	events := ob.TakeL3Events()
+	if len(events) == 0 {
+		return
+	}
+	msg := pubsub.L3Message{MarketId: f.marketId, Epoch: f.epoch, Events: make([]pubsub.L3Event, len(events))}
+	for i, e := range events {
+		f.seq++
+		msg.Events[i] = pubsub.L3Event{
+			Seq:      f.seq,
+			Type:     e.Type,
+			Handle:   publicHandle(f.marketId, e.OrderId),
+			Side:     e.Side,
+			Price:    e.Price,
+			Quantity: e.Quantity,
+		}
+	}
+	select {
+	case f.out <- msg:
+	default:
+		slog.Warn("L3 publisher behind, order events dropped", "marketId", f.marketId, "lastSeq", f.seq)
+	}
+}
+
+// sendBook sends every resting order of ob to one connection without blocking the caller,
+// as of the last event: events not pushed yet are pushed first.
+func (f *l3Feed) sendBook(ob *orderbooks.OrderBook, wsOutChannel chan wsmessagestypes.WSOutMessageStruct, userId, connId string) {
+	f.push(ob)
+	payload := wsmessagestypes.L3SnapshotPayload{
+		Epoch: f.epoch,
+		Seq:   f.seq,
+		Bids:  f.publicOrders(ob.RestingOrders(orderbooks.BUY)),
+		Asks:  f.publicOrders(ob.RestingOrders(orderbooks.SELL)),
+	}
+	go func() {
+		wsOutChannel <- wsmessagestypes.WSOutMessageStruct{
+			MessageType:  wsmessagestypes.L3_SNAPSHOT,
+			Payload:      payload,
+			UserId:       userId,
+			ConnectionId: connId,
+		}
+	}()
+}
+
+func (f *l3Feed) publicOrders(orders []orderbooks.Order) []wsmessagestypes.L3Order {
+	public := make([]wsmessagestypes.L3Order, len(orders))
+	for i, o := range orders {
+		public[i] = wsmessagestypes.L3Order{Handle: publicHandle(f.marketId, o.Id), Price: o.Price, Quantity: o.Quantity - o.Filled}
+	}
+	return public
+}
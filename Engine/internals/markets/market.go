@@ -2,17 +2,20 @@ package markets
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"math/rand"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	journal "github.com/raiashpanda007/rivon/engine/internals/Journal"
 	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
 	pubsub "github.com/raiashpanda007/rivon/engine/internals/PubSub"
 	redisStream "github.com/raiashpanda007/rivon/engine/internals/Redis"
 	snapshots "github.com/raiashpanda007/rivon/engine/internals/Snapshots"
 	usermap "github.com/raiashpanda007/rivon/engine/internals/UserMap"
 	heap "github.com/raiashpanda007/rivon/engine/internals/utils"
+	sequencer "github.com/raiashpanda007/rivon/engine/internals/utils/Sequencer"
 	tradestream "github.com/raiashpanda007/rivon/engine/internals/utils/TradeStream"
 	wsmessagestypes "github.com/raiashpanda007/rivon/engine/internals/utils/WsMessagesTypes"
 )
@@ -39,42 +42,180 @@ func toPublicFills(fills []orderbooks.Fills) []wsmessagestypes.PublicFill {
 	return result
 }
 
-// pushOrderbookUpdate sends current depth + fills to wsOutChannel without blocking the caller.
-func pushOrderbookUpdate(wsOutChannel chan wsmessagestypes.WSOutMessageStruct, bidDepth, askDepth map[int]int, currentPrice int, fills []orderbooks.Fills) {
-	publicFills := toPublicFills(fills)
-	go func() {
-		wsOutChannel <- wsmessagestypes.WSOutMessageStruct{
-			MessageType: wsmessagestypes.ORDERBOOK_UPDATE,
-			Payload: wsmessagestypes.OrderbookUpdatePayload{
-				BidDepth:     bidDepth,
-				AskDepth:     askDepth,
-				CurrentPrice: currentPrice,
-				Fills:        publicFills,
-			},
-		}
-	}()
+type OrderMessages struct {
+	OrderId      string
+	UserId       string
+	MarketId     string
+	Price        int
+	Quantity     int
+	OrderType    string
+	StreamId     string
+	TimeInForce  orderbooks.TimeInForce
+	ExpiresAt    int64
+	OrderKind    orderbooks.OrderKind
+	TriggerPrice int
+	PostOnly     bool
+	ReduceOnly   bool // SELL only: quantity is trimmed to the user's asset position
+	STPMode      orderbooks.STPMode
+	CancelQty    int    // CANCEL_ORDER: how much to cancel, 0 for all of it
+	ConnectionId string // CANCEL_ORDER sent over WS: the connection to answer
 }
 
-type OrderMessages struct {
-	OrderId   string
-	UserId    string
-	MarketId  string
-	Price     int
-	Quantity  int
-	OrderType string
-	StreamId  string
+var ErrNothingToReduce = usermap.ErrNothingToReduce
+
+var ErrOrderExpired = errors.New("order expired before reaching the book")
+
+// MarketParams carries the trading rules StarMarketProcess applies to a market.
+type MarketParams struct {
+	MaxSlippageBps int // price protection band for MARKET orders, in basis points of the reference price
+	MatchingPolicy orderbooks.MatchingPolicy
+	Rules          TradingRules
+	CircuitBreaker orderbooks.BreakerParams
+	AuctionCallMs  int64 // length of an opening call auction; 0 opens new markets straight into continuous matching
+	Suspended      bool  // markets.status was 'suspended' when the market started; it opens halted
+	// Snapshots is where the book is snapshotted and restored from.
+	Snapshots snapshots.Store
+	// TrimOrderStream drops ORDERS_ entries older than each snapshot once it is stored.
+	// Only safe when a market's next owner reads the same snapshot store.
+	TrimOrderStream bool
+	// PruneWalletOps drops the wallet op log entries of the inputs each snapshot covers once
+	// it is stored. Only safe when a market's next owner reads the same snapshot store; with
+	// an older one it replays inputs whose entries are gone and applies them twice.
+	PruneWalletOps bool
+	// JournalDir is where each market keeps a local write-ahead journal of every input, so
+	// recovery reads the order stream only past the journal's tail. Empty runs without one.
+	JournalDir string
 }
 
-func StarMarketProcess(ctx context.Context, ch chan OrderMessages, tradeRedis *redis.Client, pubsubSvc pubsub.PubSubService, marketId string, orderRedis *redis.Client, wsInChannel chan wsmessagestypes.WSInMessageStruct, wsOutChannel chan wsmessagestypes.WSOutMessageStruct, userWallet *usermap.UserWallet) {
+// ackInterval is how often a market acks the stream entries it has applied.
+const ackInterval = 500 * time.Millisecond
 
-	// wsOut publisher — reads from wsOutChannel and publishes to Redis PubSub WS_OUT_<marketId>
-	// in a continuous loop so the WS server always receives the latest orderbook state.
+// applyMarketProtection turns a MARKET order into an IOC (or FOK) limit order at the
+// worst price its protection band allows. Escrow for BUYs is locked at that price and
+// the difference to the actual fill prices is refunded after matching.
+func applyMarketProtection(ob *orderbooks.OrderBook, side orderbooks.OrderSide, tif orderbooks.TimeInForce, maxSlippageBps int) (int, orderbooks.TimeInForce, error) {
+	price, err := ob.MarketProtectionPrice(side, maxSlippageBps)
+	if err != nil {
+		return 0, tif, err
+	}
+	if tif != orderbooks.FOK {
+		tif = orderbooks.IOC
+	}
+	return price, tif, nil
+}
+
+// priceImprovement is the escrow a BUY taker locked at lockPrice but did not spend
+// because its fills executed at better resting prices.
+func priceImprovement(lockPrice int, fills []orderbooks.Fills) int {
+	refund := 0
+	for _, f := range fills {
+		refund += (lockPrice - f.Price) * f.Quantity
+	}
+	return refund
+}
+
+// storeSnapshot saves snap once settled, the wallet op queued when it was taken, is done,
+// so the snapshot covers no input whose wallet side could still be lost. A failed wallet
+// queue leaves the inputs to the next run's replay and nothing is saved. It reports whether
+// snap was stored.
+func storeSnapshot(store snapshots.Store, settled *walletOp, marketId string, snap orderbooks.OrderBook) bool {
+	if _, err := settled.wait(); err != nil {
+		slog.Error("Wallets are behind the book, not saving a snapshot", "marketId", marketId, "err", err)
+		return false
+	}
+	if err := store.SaveSnapShot(marketId, snap); err != nil {
+		slog.Error("Failed to save snapshot", "marketId", marketId, "err", err)
+		return false
+	}
+	return true
+}
+
+// closeMarket saves ob behind the wallet side of every input it applied. Escrow of the
+// orders resting on it stays locked for when the market runs again.
+func closeMarket(store snapshots.Store, wallets *walletQueue, marketId string, ob *orderbooks.OrderBook) {
+	storeSnapshot(store, wallets.mark(), marketId, ob.GetSnapshot())
+}
+
+// publishCancelledOrder records a cancellation on the TRADES stream so DBWritter marks the order cancelled.
+func publishCancelledOrder(ctx context.Context, tradeRedis *redis.Client, orderId, marketId, lastOrderId, lastTradeId string) {
+	tradestream.TradeRedisStreamPublisher(
+		ctx,
+		tradestream.CANCELLED_ORDER,
+		orderId,
+		marketId,
+		lastOrderId,
+		lastTradeId,
+		nil, 0, 0,
+		"", 0, "",
+		tradeRedis,
+	)
+}
+
+// queueWSCancel writes a cancel a user sent over WS to the market's order stream. The
+// connection hears back from the market once it applies the entry, or now if it cannot be
+// queued.
+func queueWSCancel(ctx context.Context, orderRedis *redis.Client, wsOutChannel chan wsmessagestypes.WSOutMessageStruct, marketId string, msg wsmessagestypes.WSInMessageStruct) {
+	err := orderRedis.XAdd(ctx, &redis.XAddArgs{
+		Stream: "ORDERS_" + marketId,
+		Values: map[string]interface{}{
+			"orderId":      msg.OrderId,
+			"userId":       msg.UserId,
+			"marketId":     marketId,
+			"orderType":    "CANCEL_ORDER",
+			"price":        0,
+			"quantity":     0,
+			"cancelQty":    msg.CancelQty,
+			"connectionId": msg.ConnectionId,
+		},
+	}).Err()
+	if err == nil {
+		return
+	}
+	slog.Error("Unable to queue WS cancel", "marketId", marketId, "orderId", msg.OrderId, "err", err)
+	wsOutChannel <- wsmessagestypes.WSOutMessageStruct{
+		MessageType: wsmessagestypes.ORDER_CANCELLED,
+		Payload: wsmessagestypes.OrderCancelledPayload{
+			OrderId: msg.OrderId,
+			Success: false,
+		},
+		UserId:       msg.UserId,
+		ConnectionId: msg.ConnectionId,
+	}
+}
+
+func StarMarketProcess(ctx context.Context, ch chan OrderMessages, tradeRedis *redis.Client, pubsubSvc pubsub.PubSubService, marketId string, orderRedis *redis.Client, wsInChannel chan wsmessagestypes.WSInMessageStruct, wsOutChannel chan wsmessagestypes.WSOutMessageStruct, userWallet *usermap.UserWallet, params MarketParams) {
+	// The journal records every input before its effects go out, so the book can be rebuilt
+	// without the order stream. Without it the market recovers from the stream alone.
+	record := journalWriter{}
+	var gate sequencer.Gate
+	if params.JournalDir != "" {
+		if wal, err := journal.Open(params.JournalDir, marketId); err != nil {
+			slog.Error("Unable to open the market journal, running without it", "marketId", marketId, "err", err)
+		} else {
+			defer wal.Close()
+			record.wal, gate = wal, wal
+		}
+	}
+
+	// Every TRADES entry, API reply and WS_OUT message of the market carries its sequence
+	// number, so consumers can spot lost and repeated events.
+	ctx = tradestream.WithSequence(ctx, marketId, tradeRedis, gate)
+	trades := newTradesFeed(ctx, tradeRedis, marketId)
+	epoch := time.Now().UnixMilli()
+	pubsubSvc = pubsub.ForMarket(pubsubSvc, marketId, epoch, gate)
+
+	// L3 publisher — the order events of every input go out on L3_<marketId> in the order the
+	// market made them, once the input is durable.
+	l3Out := make(chan pubsub.L3Message, l3Buffer)
 	go func() {
 		for {
 			select {
-			case msg := <-wsOutChannel:
-				if err := pubsubSvc.WSOut().Publish(marketId, msg); err != nil {
-					slog.Error("wsOut publish failed", "marketId", marketId, "err", err)
+			case msg := <-l3Out:
+				if gate != nil {
+					gate.Wait()
+				}
+				if err := pubsubSvc.L3().Publish(msg); err != nil {
+					slog.Error("L3 publish failed", "marketId", marketId, "err", err)
 				}
 			case <-ctx.Done():
 				return
@@ -82,12 +223,38 @@ func StarMarketProcess(ctx context.Context, ch chan OrderMessages, tradeRedis *r
 		}
 	}()
 
-	// Restore orderbook from latest snapshot, or start fresh.
+	// The wallet side of every input goes to the shared wallet store in the order the market
+	// applies the inputs; see market.settleEntry.
+	wallets := newWalletQueue(userWallet, marketId)
+	defer wallets.close()
+
+	// Restore orderbook from the latest snapshot, or start fresh.
 	var OrderBook orderbooks.OrderBook
-	if snap, ok := snapshots.ReadLastSnapShotForMarket(marketId); ok {
+	if snap, ok := params.Snapshots.ReadLastSnapShotForMarket(marketId); ok {
 		OrderBook = *snap
 	} else {
-		OrderBook = orderbooks.NewOrderBook("", "", "", nil, nil, heap.NewMinHeap(), heap.NewMaxHeap(), 0)
+		OrderBook = orderbooks.NewOrderBook("", "", "", nil, nil, heap.NewMinHeap(), heap.NewMaxHeap(), 0, nil, params.MatchingPolicy)
+		// A new market opens with a call auction so its first price is discovered in one uncross.
+		if params.AuctionCallMs > 0 {
+			OrderBook.StartAuction(0, true)
+		}
+	}
+	OrderBook.MarketId = marketId
+
+	private := newPrivateFeed(marketId, wsOutChannel, userWallet)
+	m := &market{
+		ctx:        ctx,
+		id:         marketId,
+		params:     params,
+		book:       &OrderBook,
+		tradeRedis: tradeRedis,
+		pubsub:     pubsubSvc,
+		wsOut:      wsOutChannel,
+		trades:     trades,
+		wallets:    wallets,
+		record:     &record,
+		private:    private,
+		entry:      &usermap.Batch{},
 	}
 
 	replayStartId := OrderBook.LastStreamId
@@ -97,15 +264,40 @@ func StarMarketProcess(ctx context.Context, ch chan OrderMessages, tradeRedis *r
 
 	pivotOrderId, hasPivot := redisStream.ReadLastTradeOrderIdForMarket(ctx, tradeRedis, marketId)
 
-	replayMsgs, replayErr := redisStream.ReplayOrderStream(ctx, orderRedis, "ORDERS_"+marketId, replayStartId)
+	// Inputs after the snapshot come from the journal first; the stream is only read past
+	// the journal's tail.
+	var replayMsgs []redisStream.ReplayOrderStreamMessage
+	var records []journal.Record
+	if record.wal != nil {
+		// A journal with a gap is trusted up to it; the stream still has the entries after
+		// it unless it was trimmed, which only happens behind a snapshot.
+		var err error
+		records, err = journal.ReadAfter(params.JournalDir, marketId, replayStartId)
+		if err != nil {
+			slog.Error("Unable to read all of the market journal, replaying the stream past it", "marketId", marketId, "journaled", len(records), "err", err)
+		}
+		for _, rec := range records {
+			replayMsgs = append(replayMsgs, *rec.Input)
+		}
+	}
+	journaled := len(replayMsgs)
+	streamStartId := replayStartId
+	if journaled > 0 {
+		streamStartId = replayMsgs[journaled-1].StreamId
+	}
+
+	streamMsgs, replayErr := redisStream.ReplayOrderStream(ctx, orderRedis, "ORDERS_"+marketId, streamStartId)
 	if replayErr != nil {
-		slog.Error("Replay failed, starting from snapshot state", "marketId", marketId, "err", replayErr)
-	} else if len(replayMsgs) > 0 {
+		slog.Error("Replay of the order stream failed, starting from the snapshot and journal", "marketId", marketId, "err", replayErr)
+	}
+	replayMsgs = append(replayMsgs, streamMsgs...)
+	slog.Info("replaying market inputs", "marketId", marketId, "fromJournal", journaled, "fromStream", len(streamMsgs))
+	if len(replayMsgs) > 0 {
 		// Determine whether the pivot actually falls inside this replay window.
 		pivotInReplay := false
 		if hasPivot {
-			for _, m := range replayMsgs {
-				if m.OrderId == pivotOrderId {
+			for _, msg := range replayMsgs {
+				if msg.OrderId == pivotOrderId {
 					pivotInReplay = true
 					break
 				}
@@ -113,81 +305,24 @@ func StarMarketProcess(ctx context.Context, ch chan OrderMessages, tradeRedis *r
 		}
 
 		silent := pivotInReplay // start silent only when the pivot is in-window
-		silentCount, normalCount := 0, 0
-
-		for _, msg := range replayMsgs {
-			OrderBook.LastStreamId = msg.StreamId
-
-			if msg.OrderType == "CANCEL_ORDER" {
-				OrderBook.CancelOrder(msg.OrderId, msg.UserId, 0)
-				if !silent {
-					normalCount++
-					go tradestream.TradeRedisStreamPublisher(
-						ctx,
-						tradestream.CANCELLED_ORDER,
-						msg.OrderId,
-						marketId,
-						OrderBook.LastOrderId,
-						OrderBook.LastTradeId,
-						nil,
-						0,
-						0,
-						"", 0, "",
-						tradeRedis,
-					)
-					go pubsubSvc.Api().Publish(pubsub.PubSubOrderMessage{
-						OrderId:     msg.OrderId,
-						MessageType: pubsub.ORDER_CANCEL,
-					})
-				} else {
-					silentCount++
-				}
-				if silent && msg.OrderId == pivotOrderId {
-					silent = false
-				}
-				continue
-			}
 
-			inputOrder := orderbooks.Order{
-				Id:       msg.OrderId,
-				Quantity: msg.Quantity,
-				Side:     orderbooks.OrderSide(msg.OrderType),
-				Price:    msg.Price,
-				UserId:   msg.UserId,
-				Filled:   0,
-				StreamId: msg.StreamId,
+		// The wallet side of every input is applied again; the op log of the market hands
+		// back the outcome of whatever was applied before instead of applying it twice.
+		for i, msg := range replayMsgs {
+			m.settleEntry()
+			if m.walletsFailed() {
+				return
 			}
-
-			fills, executedQty, err := OrderBook.AddOrder(inputOrder, msg.Price)
-			if err != nil {
-				slog.Error("Replay AddOrder error", "orderId", msg.OrderId, "err", err)
-				continue
+			record.commit(&OrderBook)
+			mode := applyReplay
+			if silent {
+				mode = applySilent
 			}
-
-			if !silent {
-				normalCount++
-				go tradestream.TradeRedisStreamPublisher(
-					ctx,
-					tradestream.ORDER_UPDATED,
-					msg.OrderId,
-					marketId,
-					OrderBook.LastOrderId,
-					OrderBook.LastTradeId,
-					fills,
-					executedQty,
-					msg.Price,
-					msg.UserId, msg.Quantity, msg.OrderType,
-					tradeRedis,
-				)
-				go pubsubSvc.Api().Publish(pubsub.PubSubOrderMessage{
-					OrderId:          msg.OrderId,
-					Fills:            fills,
-					ExecutedQuantity: executedQty,
-					MessageType:      pubsub.ORDER_UPDATE,
-				})
-			} else {
-				silentCount++
+			var journaledRec *journal.Record
+			if i < journaled {
+				journaledRec = &records[i]
 			}
+			m.apply(orderMessage(msg), mode, journaledRec)
 
 			// After processing the pivot, switch to normal mode for all subsequent messages.
 			if silent && msg.OrderId == pivotOrderId {
@@ -195,289 +330,222 @@ func StarMarketProcess(ctx context.Context, ch chan OrderMessages, tradeRedis *r
 			}
 		}
 
+		m.settleEntry()
+		if m.walletsFailed() {
+			return
+		}
+		record.commit(&OrderBook)
+		if record.diverged > 0 {
+			slog.Error("Replay did not make the fills the journal recorded", "marketId", marketId, "inputs", record.diverged)
+		}
+
 		// Advance consumer group past replayed messages so the batch consumer
 		// doesn't re-deliver them and process each order twice.
-		if len(replayMsgs) > 0 {
-			lastReplayedId := replayMsgs[len(replayMsgs)-1].StreamId
-			if err := orderRedis.XGroupSetID(ctx, "ORDERS_"+marketId, "engine", lastReplayedId).Err(); err != nil {
-				slog.Error("Failed to advance consumer group after replay", "marketId", marketId, "err", err)
-			}
+		lastReplayedId := replayMsgs[len(replayMsgs)-1].StreamId
+		if err := orderRedis.XGroupSetID(ctx, "ORDERS_"+marketId, "engine", lastReplayedId).Err(); err != nil {
+			slog.Error("Failed to advance consumer group after replay", "marketId", marketId, "err", err)
 		}
-	} else {
 	}
+	m.mode = applyLive
 	// --- end replay ---
 
-	// Restore wallet escrow for every order that survived replay so the
-	// in-memory wallet reflects locked quantities (guards against stale Redis).
-	for uid, userOrders := range OrderBook.UserOrderMap {
-		for _, o := range userOrders {
-			remaining := o.Quantity - o.Filled
-			if remaining <= 0 {
-				continue
-			}
-			if o.Side == orderbooks.BUY {
-				if err := userWallet.LockMoney(uid, remaining*o.Price); err != nil {
-					slog.Warn("replay: LockMoney failed", "orderId", o.Id, "err", err)
+	// Entries left pending by an earlier run are either in the snapshot or were re-applied
+	// by the replay above, so everything up to the book's last entry is acked now.
+	if OrderBook.LastStreamId != "" {
+		acked, err := redisStream.AckPendingThrough(ctx, orderRedis, "ORDERS_"+marketId, OrderBook.LastStreamId)
+		if err != nil {
+			slog.Error("Failed to ack pending entries after replay", "marketId", marketId, "err", err)
+		} else if acked > 0 {
+			slog.Info("acked pending entries after replay", "marketId", marketId, "count", acked)
+		}
+	}
+
+	// Replay ran under the policy stored in the snapshot so it reproduces the original fills;
+	// from here on the market's configured policy applies.
+	OrderBook.Policy = params.MatchingPolicy
+
+	// A market stored as suspended opens halted and reopens through an auction after one
+	// cooldown; a halt rebuilt by replay keeps the cooldown it was given live.
+	if params.Suspended && !OrderBook.Breaker.Halted() {
+		OrderBook.Halt(params.CircuitBreaker, time.Now().UnixMilli())
+	}
+	if params.Suspended || OrderBook.Breaker.Halted() {
+		announceMarketStatus(trades, wsOutChannel, OrderBook.Breaker.Halted(), OrderBook.Breaker.ReferencePrice(), OrderBook.CurrentPrice, OrderBook.Breaker.HaltedUntil)
+	}
+	pushAuctionState(wsOutChannel, &OrderBook)
+
+	// Subscribers take the book as restored and replayed from ORDERBOOK_DATA and L3_SNAPSHOT,
+	// so deltas and order events start from there.
+	OrderBook.TakeDepthChanges()
+	OrderBook.TakeL3Events()
+	view := newBookView(&OrderBook)
+	depth := &depthFeed{marketId: marketId, out: wsOutChannel, view: view}
+	l3 := &l3Feed{marketId: marketId, epoch: epoch, out: l3Out}
+	m.depth, m.l3 = depth, l3
+
+	// wsOut publisher — reads from wsOutChannel and publishes to Redis PubSub WS_OUT_<marketId>
+	// in a continuous loop so the WS server always receives the latest orderbook state. It
+	// also serves the throttled subscriptions from view, each at most once per interval with
+	// whatever changed in between.
+	subUpdates := make(chan subscriptionUpdate, 100)
+	go func() {
+		subs := make(subscriptions)
+		tick := time.NewTicker(throttleTick)
+		defer tick.Stop()
+		for {
+			select {
+			case msg := <-wsOutChannel:
+				if err := pubsubSvc.WSOut().Publish(marketId, msg); err != nil {
+					slog.Error("wsOut publish failed", "marketId", marketId, "err", err)
 				}
-			} else {
-				if err := userWallet.LockAsset(uid, marketId, remaining); err != nil {
-					slog.Warn("replay: LockAsset failed", "orderId", o.Id, "err", err)
+			case u := <-subUpdates:
+				subs.update(u)
+			case now := <-tick.C:
+				for _, msg := range subs.due(view, now) {
+					if err := pubsubSvc.WSOut().Publish(marketId, msg); err != nil {
+						slog.Error("wsOut publish failed", "marketId", marketId, "err", err)
+					}
 				}
+			case <-ctx.Done():
+				return
 			}
 		}
-	}
+	}()
 
 	baseInterval := 1 * time.Minute
 	timer := time.NewTimer(baseInterval + time.Duration(rand.Intn(10))*time.Second)
 
+	// Orders expire, and call periods end, at the stream time of the input that finds them
+	// lapsed. Once a second a market with lapsed orders queues an EXPIRE_ORDERS input, and
+	// one whose auction has run out an END_AUCTION input, so they go even with no orders
+	// coming in, and replay sees the same input.
+	expiryTicker := time.NewTicker(time.Second)
+	defer expiryTicker.Stop()
+	expiryQueued, uncrossQueued := false, false
+
+	// Applied entries are acked in batches. By the time a batch goes out the TRADES entries
+	// of its orders have been written; replay republishes whatever a crash cut off.
+	var unacked []string
+	ackTicker := time.NewTicker(ackInterval)
+	defer ackTicker.Stop()
+	flushAcks := func(ctx context.Context) {
+		if len(unacked) == 0 {
+			return
+		}
+		if err := redisStream.AckOrderEntries(ctx, orderRedis, "ORDERS_"+marketId, unacked); err != nil {
+			slog.Error("Failed to ack applied entries", "marketId", marketId, "count", len(unacked), "err", err)
+			return
+		}
+		unacked = nil
+	}
+
+	// saveSnapshot stores snap once settled is done and drops the journal, wallet op log and
+	// stream entries it covers.
+	saveSnapshot := func(snap orderbooks.OrderBook, settled *walletOp) {
+		if !storeSnapshot(params.Snapshots, settled, marketId, snap) || snap.LastStreamId == "" {
+			return
+		}
+		if record.wal != nil {
+			record.wal.Prune(snap.LastStreamId)
+		}
+		if params.PruneWalletOps {
+			wallets.prune(snap.LastStreamId)
+		}
+		if !params.TrimOrderStream {
+			return
+		}
+		trimmed, err := redisStream.TrimOrderStream(ctx, orderRedis, "ORDERS_"+marketId, snap.LastStreamId)
+		if err != nil {
+			slog.Error("Failed to trim order stream", "marketId", marketId, "err", err)
+		} else if trimmed > 0 {
+			slog.Info("trimmed order stream", "marketId", marketId, "minId", snap.LastStreamId, "count", trimmed)
+		}
+	}
+
 	for {
+		// Whatever the last case applied is journaled before the market waits again, and
+		// only then are its owners told about it, once its wallet side is applied.
+		private.settle(m.settleEntry())
+		if m.walletsFailed() {
+			return
+		}
+		record.commit(&OrderBook)
+		private.flush()
+
 		select {
 
 		case order := <-ch:
-			slog.Info("processor received", "orderId", order.OrderId)
-			t0 := time.Now()
-			OrderBook.LastStreamId = order.StreamId
-
-			if order.OrderType == "CANCEL_ORDER" {
-				cancelledOrder, cancelled := OrderBook.CancelOrder(order.OrderId, order.UserId, 0)
-				if cancelled && cancelledOrder != nil {
-					remaining := cancelledOrder.Quantity - cancelledOrder.Filled
-					go func(o *orderbooks.Order, rem int) {
-						if o.Side == orderbooks.BUY {
-							if err := userWallet.UnlockMoney(o.UserId, rem*o.Price); err != nil {
-								slog.Error("UnlockMoney failed on cancel", "orderId", o.Id, "err", err)
-								return
-							}
-						} else {
-							if err := userWallet.UnlockAsset(o.UserId, marketId, rem); err != nil {
-								slog.Error("UnlockAsset failed on cancel", "orderId", o.Id, "err", err)
-								return
-							}
-						}
-						userWallet.FlushWalletToRedis(o.UserId)
-					}(cancelledOrder, remaining)
-				}
-
-				go tradestream.TradeRedisStreamPublisher(
-					ctx,
-					tradestream.CANCELLED_ORDER,
-					order.OrderId,
-					order.MarketId,
-					OrderBook.LastOrderId,
-					OrderBook.LastTradeId,
-					nil,
-					0,
-					0,
-					"", 0, "",
-					tradeRedis,
-				)
-				go pubsubSvc.Api().Publish(pubsub.PubSubOrderMessage{
-					OrderId:     order.OrderId,
-					MessageType: pubsub.ORDER_CANCEL,
-				})
-				pushOrderbookUpdate(wsOutChannel, copyDepth(OrderBook.BidDepth), copyDepth(OrderBook.AskDepth), OrderBook.CurrentPrice, nil)
+			unacked = append(unacked, order.StreamId)
+			// Entries claimed back from the consumer group's pending list may already be
+			// in the book; applying one twice would repeat its fills.
+			if OrderBook.LastStreamId != "" && !redisStream.StreamIdAfter(order.StreamId, OrderBook.LastStreamId) {
+				slog.Info("processor skipped applied entry", "orderId", order.OrderId, "streamId", order.StreamId)
 				continue
 			}
-
-			if order.OrderType == string(orderbooks.BUY) {
-				if err := userWallet.LockMoney(order.UserId, order.Price*order.Quantity); err != nil {
-					slog.Warn("LockMoney failed, rejecting order", "orderId", order.OrderId, "err", err)
-					go pubsubSvc.Api().Publish(pubsub.PubSubOrderMessage{
-						OrderId:     order.OrderId,
-						MessageType: pubsub.ORDER_REJECTED,
-						Error:       err.Error(),
-					})
-					continue
-				}
-				userWallet.FlushWalletToRedis(order.UserId)
-			} else {
-				if err := userWallet.LockAsset(order.UserId, order.MarketId, order.Quantity); err != nil {
-					slog.Warn("LockAsset failed, rejecting order", "orderId", order.OrderId, "err", err)
-					go pubsubSvc.Api().Publish(pubsub.PubSubOrderMessage{
-						OrderId:     order.OrderId,
-						MessageType: pubsub.ORDER_REJECTED,
-						Error:       err.Error(),
-					})
-					continue
+			slog.Info("processor received", "orderId", order.OrderId)
+			expiryQueued, uncrossQueued = false, false
+			m.apply(order, applyLive, nil)
+
+		case <-expiryTicker.C:
+			now := time.Now().UnixMilli()
+			if !uncrossQueued && OrderBook.AuctionDue(now) {
+				if err := queueUncross(ctx, orderRedis, marketId); err != nil {
+					slog.Error("Unable to queue auction uncross", "marketId", marketId, "err", err)
+				} else {
+					uncrossQueued = true
 				}
-				userWallet.FlushWalletToRedis(order.UserId)
 			}
-
-			inputOrder := orderbooks.Order{
-				Id:       order.OrderId,
-				Quantity: order.Quantity,
-				Side:     orderbooks.OrderSide(order.OrderType),
-				Price:    order.Price,
-				UserId:   order.UserId,
-				Filled:   0,
-				StreamId: order.StreamId,
-			}
-
-			Fills, executedQty, err := OrderBook.AddOrder(inputOrder, order.Price)
-			if err != nil {
-				slog.Error("Error adding order", "err", err)
+			if expiryQueued || !OrderBook.HasExpired(now) {
 				continue
 			}
-			matchDur := time.Since(t0)
-
-			if len(Fills) > 0 {
-				side := orderbooks.OrderSide(order.OrderType)
-				go func(fills []orderbooks.Fills, userId string, side orderbooks.OrderSide) {
-					for _, f := range fills {
-						var buyerId, sellerId string
-						if side == orderbooks.BUY {
-							buyerId, sellerId = userId, f.OtherUserId
-						} else {
-							buyerId, sellerId = f.OtherUserId, userId
-						}
-						if err := userWallet.ExecuteTrade(buyerId, sellerId, marketId, f.Quantity, f.Price); err != nil {
-							slog.Error("ExecuteTrade failed", "tradeId", f.TradeId, "err", err)
-						}
-					}
-				}(Fills, order.UserId, side)
+			if err := queueExpiry(ctx, orderRedis, marketId); err != nil {
+				slog.Error("Unable to queue order expiry", "marketId", marketId, "err", err)
+				continue
 			}
+			expiryQueued = true
 
-			pubsubStart := time.Now()
-			orderId := order.OrderId
-			go func() {
-				pubsubSvc.Api().Publish(pubsub.PubSubOrderMessage{
-					OrderId:          orderId,
-					Fills:            Fills,
-					ExecutedQuantity: executedQty,
-					MessageType:      pubsub.ORDER_UPDATE,
-				})
-				slog.Info("latency",
-					"orderId", orderId,
-					"matchUs", matchDur.Microseconds(),
-					"pubsubUs", time.Since(pubsubStart).Microseconds(),
-					"totalUs", time.Since(t0).Microseconds(),
-				)
-			}()
-
-			go func(fills []orderbooks.Fills, userId string, side orderbooks.OrderSide,
-				oid string, exQty, qty, price int, lastOId, lastTId string) {
-				tradestream.TradeRedisStreamPublisher(
-					ctx, tradestream.ORDER_UPDATED, oid, marketId,
-					lastOId, lastTId, fills, exQty, price,
-					userId, qty, string(side),
-					tradeRedis,
-				)
-				for _, f := range fills {
-					var buyerId, sellerId string
-					if side == orderbooks.BUY {
-						buyerId, sellerId = userId, f.OtherUserId
-					} else {
-						buyerId, sellerId = f.OtherUserId, userId
-					}
-					if buyerId != usermap.AdminID {
-						userWallet.FlushWalletToRedis(buyerId)
-					}
-					if sellerId != usermap.AdminID {
-						userWallet.FlushWalletToRedis(sellerId)
-					}
-				}
-			}(Fills, order.UserId, orderbooks.OrderSide(order.OrderType), orderId, executedQty, order.Quantity, order.Price, OrderBook.LastOrderId, OrderBook.LastTradeId)
-			pushOrderbookUpdate(wsOutChannel, copyDepth(OrderBook.BidDepth), copyDepth(OrderBook.AskDepth), OrderBook.CurrentPrice, Fills)
-
-		case <-timer.C:
+		case <-ctx.Done():
+			// The Engine stopped the market. The book is kept in a snapshot; its escrow stays
+			// in the wallets for the next run.
+			closeMarket(params.Snapshots, wallets, marketId, &OrderBook)
+			flushAcks(context.Background())
+			slog.Info("market process stopped", "marketId", marketId)
+			return
 
-			snap := OrderBook.GetSnapshot()
-			go snapshots.SaveSnapShot(marketId, snap)
+		case <-ackTicker.C:
+			flushAcks(ctx)
 
+		case <-timer.C:
+			// The op marks the wallet side of every input the snapshot covers.
+			go saveSnapshot(OrderBook.GetSnapshot(), wallets.mark())
 			timer.Reset(baseInterval + time.Duration(rand.Intn(10))*time.Second)
 
+		case <-wallets.failed:
+			// The market stops at the top of the loop.
+
 		case wsInMsg := <-wsInChannel:
 			switch wsInMsg.MessageType {
 			case wsmessagestypes.WALLET_LOAD:
-				go userWallet.GetUser(wsInMsg.UserId)
-
-			case wsmessagestypes.WALLET_EVICT:
-				userWallet.RemoveUser(wsInMsg.UserId)
-
-			case wsmessagestypes.ORDERBOOK_SUBSCIRBE:
-				bidDepth := copyDepth(OrderBook.BidDepth)
-				askDepth := copyDepth(OrderBook.AskDepth)
-				currentPrice := OrderBook.CurrentPrice
-				connId := wsInMsg.ConnectionId
-				userId := wsInMsg.UserId
-				go func() {
-					wsOutChannel <- wsmessagestypes.WSOutMessageStruct{
-						MessageType: wsmessagestypes.ORDERBOOK_DATA,
-						Payload: wsmessagestypes.OrderbookPayload{
-							BidDepth:     bidDepth,
-							AskDepth:     askDepth,
-							CurrentPrice: currentPrice,
-						},
-						UserId:       userId,
-						ConnectionId: connId,
-					}
-				}()
-
-			case wsmessagestypes.DEPTH_SUBSCRIBE:
-				bidDepth := copyDepth(OrderBook.BidDepth)
-				askDepth := copyDepth(OrderBook.AskDepth)
-				connId := wsInMsg.ConnectionId
-				userId := wsInMsg.UserId
-				go func() {
-					wsOutChannel <- wsmessagestypes.WSOutMessageStruct{
-						MessageType: wsmessagestypes.DEPTH_DATA,
-						Payload: wsmessagestypes.DepthPayload{
-							BidDepth: bidDepth,
-							AskDepth: askDepth,
-						},
-						UserId:       userId,
-						ConnectionId: connId,
-					}
-				}()
+				private.loadWallet(wsInMsg.UserId)
+
+			case wsmessagestypes.ORDERBOOK_SUBSCIRBE, wsmessagestypes.DEPTH_RESYNC:
+				depth.sendBook(&OrderBook, wsInMsg.UserId, wsInMsg.ConnectionId)
+
+			case wsmessagestypes.L3_SUBSCRIBE:
+				l3.sendBook(&OrderBook, wsOutChannel, wsInMsg.UserId, wsInMsg.ConnectionId)
+
+			case wsmessagestypes.DEPTH_SUBSCRIBE, wsmessagestypes.BOOK_TICKER_SUBSCRIBE:
+				// The view is brought up to date first so the answer reflects every input so far.
+				m.pushBookChanges(nil)
+				subUpdates <- subscriptionUpdate{Sub: newSubscription(wsInMsg, wsInMsg.MessageType == wsmessagestypes.BOOK_TICKER_SUBSCRIBE)}
+
+			case wsmessagestypes.DEPTH_UNSUBSCRIBE, wsmessagestypes.BOOK_TICKER_UNSUBSCRIBE:
+				subUpdates <- subscriptionUpdate{Sub: subscription{ConnectionId: wsInMsg.ConnectionId, Ticker: wsInMsg.MessageType == wsmessagestypes.BOOK_TICKER_UNSUBSCRIBE}, Remove: true}
 
 			case wsmessagestypes.CANCEL_ORDER_WS:
-				cancelledOrder, cancelled := OrderBook.CancelOrder(wsInMsg.OrderId, wsInMsg.UserId, wsInMsg.CancelQty)
-				cancelledQty := 0
-				if cancelled && cancelledOrder != nil {
-					cancelledQty = cancelledOrder.Quantity - cancelledOrder.Filled
-					go func(o *orderbooks.Order, rem int) {
-						if o.Side == orderbooks.BUY {
-							if err := userWallet.UnlockMoney(o.UserId, rem*o.Price); err != nil {
-								slog.Error("UnlockMoney failed on WS cancel", "orderId", o.Id, "err", err)
-							}
-						} else {
-							if err := userWallet.UnlockAsset(o.UserId, marketId, rem); err != nil {
-								slog.Error("UnlockAsset failed on WS cancel", "orderId", o.Id, "err", err)
-							}
-						}
-						userWallet.FlushWalletToRedis(o.UserId)
-					}(cancelledOrder, cancelledQty)
-					pushOrderbookUpdate(wsOutChannel, copyDepth(OrderBook.BidDepth), copyDepth(OrderBook.AskDepth), OrderBook.CurrentPrice, nil)
-					go tradestream.TradeRedisStreamPublisher(
-						ctx,
-						tradestream.CANCELLED_ORDER,
-						wsInMsg.OrderId,
-						marketId,
-						OrderBook.LastOrderId,
-						OrderBook.LastTradeId,
-						nil, 0, 0,
-						"", 0, "",
-						tradeRedis,
-					)
-				}
-				orderId := wsInMsg.OrderId
-				userId := wsInMsg.UserId
-				connId := wsInMsg.ConnectionId
-				cQty := cancelledQty
-				go func() {
-					wsOutChannel <- wsmessagestypes.WSOutMessageStruct{
-						MessageType: wsmessagestypes.ORDER_CANCELLED,
-						Payload: wsmessagestypes.OrderCancelledPayload{
-							OrderId:      orderId,
-							Success:      cancelled,
-							CancelledQty: cQty,
-						},
-						UserId:       userId,
-						ConnectionId: connId,
-					}
-				}()
+				// Queued on the order stream like an API cancel, so it is journaled and replayed.
+				go queueWSCancel(ctx, orderRedis, wsOutChannel, marketId, wsInMsg)
 			}
-
 		}
 
 	}
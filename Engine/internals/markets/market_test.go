@@ -0,0 +1,110 @@
+package markets
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	snapshots "github.com/raiashpanda007/rivon/engine/internals/Snapshots"
+	usermap "github.com/raiashpanda007/rivon/engine/internals/UserMap"
+)
+
+func TestApplyMarketProtection(t *testing.T) {
+	tests := []struct {
+		name      string
+		side      orderbooks.OrderSide
+		tif       orderbooks.TimeInForce
+		wantPrice int
+		wantTIF   orderbooks.TimeInForce
+	}{
+		{name: "buy becomes IOC", side: orderbooks.BUY, tif: orderbooks.GTC, wantPrice: 1020, wantTIF: orderbooks.IOC},
+		{name: "sell becomes IOC", side: orderbooks.SELL, wantPrice: 980, wantTIF: orderbooks.IOC},
+		{name: "FOK stays FOK", side: orderbooks.BUY, tif: orderbooks.FOK, wantPrice: 1020, wantTIF: orderbooks.FOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ob := newTestBook()
+			ob.CurrentPrice = 1000
+			price, tif, err := applyMarketProtection(ob, tt.side, tt.tif, 200)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if price != tt.wantPrice || tif != tt.wantTIF {
+				t.Fatalf("got %d %s, want %d %s", price, tif, tt.wantPrice, tt.wantTIF)
+			}
+		})
+	}
+}
+
+func TestPriceImprovementRefundsTheUnspentEscrow(t *testing.T) {
+	fills := []orderbooks.Fills{{Price: 100, Quantity: 2}, {Price: 103, Quantity: 3}, {Price: 105, Quantity: 1}}
+	// Locked at 105: 5*2 + 2*3 + 0*1.
+	if got := priceImprovement(105, fills); got != 16 {
+		t.Fatalf("priceImprovement = %d, want 16", got)
+	}
+	if got := priceImprovement(105, nil); got != 0 {
+		t.Fatalf("priceImprovement without fills = %d, want 0", got)
+	}
+}
+
+func testSnapshots(t *testing.T) snapshots.Store {
+	t.Helper()
+	backend, err := snapshots.NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snapshots.Store{Backend: backend}
+}
+
+func TestSnapshotWaitsForTheWalletSide(t *testing.T) {
+	store := &recordingStore{batches: make(map[string]*usermap.Batch)}
+	wallets := newWalletQueue(store, "m1")
+	defer wallets.close()
+
+	var b usermap.Batch
+	b.LockMoney("alice", 10)
+	wallets.submit("5-0", &b)
+	book := newTestBook()
+	book.LastStreamId = "5-0"
+
+	snaps := testSnapshots(t)
+	if !storeSnapshot(snaps, wallets.mark(), "m1", book.GetSnapshot()) {
+		t.Fatal("snapshot not stored")
+	}
+	store.mu.Lock()
+	_, applied := store.batches["5-0"]
+	store.mu.Unlock()
+	if !applied {
+		t.Fatal("snapshot stored before the wallet side of the input it covers")
+	}
+	if restored, ok := snaps.ReadLastSnapShotForMarket("m1"); !ok || restored.LastStreamId != "5-0" {
+		t.Fatalf("restored %v, %v", restored, ok)
+	}
+}
+
+func TestSnapshotSkippedOnceWalletsFailed(t *testing.T) {
+	wallets := newWalletQueue(&recordingStore{batches: make(map[string]*usermap.Batch)}, "m1")
+	defer wallets.close()
+	wallets.err = errors.New("wallet store unreachable")
+	close(wallets.failed)
+
+	snaps := testSnapshots(t)
+	if storeSnapshot(snaps, wallets.mark(), "m1", newTestBook().GetSnapshot()) {
+		t.Fatal("snapshot stored past wallets that were not applied")
+	}
+	if _, ok := snaps.ReadLastSnapShotForMarket("m1"); ok {
+		t.Fatal("a snapshot was saved")
+	}
+}
+
+func TestPruneDropsTheOpsBehindTheSnapshot(t *testing.T) {
+	store := &recordingStore{batches: make(map[string]*usermap.Batch)}
+	wallets := newWalletQueue(store, "m1")
+	defer wallets.close()
+	wallets.prune("5-0")
+	if want := []string{"5-0"}; !reflect.DeepEqual(store.pruned, want) {
+		t.Fatalf("pruned %v, want %v", store.pruned, want)
+	}
+}
@@ -0,0 +1,218 @@
+package markets
+
+import (
+	"log/slog"
+
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	wsmessagestypes "github.com/raiashpanda007/rivon/engine/internals/utils/WsMessagesTypes"
+)
+
+// privateFeed tells users what happened to their orders and wallets: messages with the
+// owner's UserId, published on the user's own channel for every connection of theirs. The
+// market goroutine collects the events of an input and flushes them once it is applied.
+//
+// Wallets change in the shared store only when the wallet queue applies the input's batch,
+// not when the market applies the input, so WALLET_UPDATED waits for the input's walletOp
+// and shows the wallet after it.
+type privateFeed struct {
+	marketId string
+	out      chan wsmessagestypes.WSOutMessageStruct
+	wallet   walletReader
+
+	events  []wsmessagestypes.WSOutMessageStruct
+	touched map[string]struct{} // users whose wallet the input changed
+	pending *walletOp           // the input's wallet side, nil when it had none
+	sent    chan struct{}       // closed once the previous input's events are out
+}
+
+// walletReader is where the feed reads the wallets it reports: the shared wallet store, see
+// usermap.UserWallet.Balances and Load.
+type walletReader interface {
+	Balances(userId string) (int, map[string]int, bool)
+	Load(userId string) error
+}
+
+func newPrivateFeed(marketId string, out chan wsmessagestypes.WSOutMessageStruct, wallet walletReader) *privateFeed {
+	sent := make(chan struct{})
+	close(sent)
+	return &privateFeed{marketId: marketId, out: out, wallet: wallet, sent: sent}
+}
+
+// settle makes the current input's WALLET_UPDATED events wait for op, its wallet side.
+func (p *privateFeed) settle(op *walletOp) {
+	p.pending = op
+}
+
+func (p *privateFeed) send(userId string, t wsmessagestypes.WSOutMessageType, payload wsmessagestypes.WSOutPayload) {
+	if userId == "" {
+		return
+	}
+	p.events = append(p.events, wsmessagestypes.WSOutMessageStruct{MessageType: t, Payload: payload, UserId: userId})
+	p.touch(userId)
+}
+
+// touch marks userId's wallet as changed by the current input.
+func (p *privateFeed) touch(userId string) {
+	if p.touched == nil {
+		p.touched = make(map[string]struct{})
+	}
+	p.touched[userId] = struct{}{}
+}
+
+func (p *privateFeed) accepted(orderId, userId string, side orderbooks.OrderSide, price, quantity int) {
+	p.send(userId, wsmessagestypes.ORDER_ACCEPTED, wsmessagestypes.OrderEventPayload{
+		OrderId:  orderId,
+		MarketId: p.marketId,
+		Side:     string(side),
+		Price:    price,
+		Quantity: quantity,
+	})
+}
+
+// rejected reports an order that never reached the book. Nothing was locked for it, or the
+// lock has been handed back, so the wallet is not reported.
+func (p *privateFeed) rejected(orderId, userId string, reason string, err error) {
+	if userId == "" {
+		return
+	}
+	p.events = append(p.events, wsmessagestypes.WSOutMessageStruct{
+		MessageType: wsmessagestypes.ORDER_REJECTED,
+		Payload: wsmessagestypes.OrderEventPayload{
+			OrderId:  orderId,
+			MarketId: p.marketId,
+			Reason:   reason,
+			Error:    err.Error(),
+		},
+		UserId: userId,
+	})
+}
+
+// cancelled reports qty of an order taken off the book, or cut before it rested.
+func (p *privateFeed) cancelled(orderId, userId string, qty int) {
+	if qty <= 0 {
+		return
+	}
+	p.send(userId, wsmessagestypes.ORDER_CANCELLED, wsmessagestypes.OrderCancelledPayload{
+		OrderId:      orderId,
+		Success:      true,
+		CancelledQty: qty,
+	})
+}
+
+func (p *privateFeed) selfTradeCancels(cancels []orderbooks.SelfTradeCancel) {
+	for _, c := range cancels {
+		p.cancelled(c.Order.Id, c.Order.UserId, c.Quantity)
+	}
+}
+
+// fills reports every fill of an incoming order to both sides. left is what of the incoming
+// order was unfilled once ob had made them; a resting order's remainder is read off ob.
+func (p *privateFeed) fills(ob *orderbooks.OrderBook, fills []orderbooks.Fills, userId string, side orderbooks.OrderSide, left int) {
+	if len(fills) == 0 {
+		return
+	}
+	makerSide := orderbooks.SELL
+	if side == orderbooks.SELL {
+		makerSide = orderbooks.BUY
+	}
+
+	// Walked from the last fill back, so each one carries what was left right after it.
+	type fillEvent struct {
+		userId  string
+		payload wsmessagestypes.OrderFillPayload
+	}
+	events := make([]fillEvent, 2*len(fills))
+	makerLeft := make(map[string]int)
+	for i := len(fills) - 1; i >= 0; i-- {
+		f := fills[i]
+		resting, seen := makerLeft[f.OtherOrderId]
+		if !seen {
+			resting = ob.RestingQuantity(f.OtherUserId, f.OtherOrderId)
+		}
+		events[2*i] = fillEvent{userId, wsmessagestypes.OrderFillPayload{
+			OrderId: f.OrderId, MarketId: p.marketId, Side: string(side),
+			Price: f.Price, Quantity: f.Quantity, TradeId: f.TradeId, Remaining: left,
+		}}
+		events[2*i+1] = fillEvent{f.OtherUserId, wsmessagestypes.OrderFillPayload{
+			OrderId: f.OtherOrderId, MarketId: p.marketId, Side: string(makerSide),
+			Price: f.Price, Quantity: f.Quantity, TradeId: f.TradeId, Remaining: resting, Maker: true,
+		}}
+		left += f.Quantity
+		makerLeft[f.OtherOrderId] = resting + f.Quantity
+	}
+
+	for _, e := range events {
+		t := wsmessagestypes.ORDER_PARTIALLY_FILLED
+		if e.payload.Remaining == 0 {
+			t = wsmessagestypes.ORDER_FILLED
+		}
+		p.send(e.userId, t, e.payload)
+	}
+}
+
+// triggered reports what the stops a price move released did.
+func (p *privateFeed) triggered(ob *orderbooks.OrderBook, executions []triggeredExecution) {
+	for _, exec := range executions {
+		p.fills(ob, exec.Fills, exec.Stop.UserId, exec.Stop.Side, exec.Stop.Quantity-exec.ExecutedQty)
+		p.cancelled(exec.Stop.Id, exec.Stop.UserId, exec.CancelledQty)
+	}
+}
+
+// auction reports the fills of an uncross and what it released.
+func (p *privateFeed) auction(ob *orderbooks.OrderBook, out auctionOutcome) {
+	for _, m := range out.Matches {
+		p.fills(ob, m.Fills, m.Buy.UserId, m.Buy.Side, ob.RestingQuantity(m.Buy.UserId, m.Buy.Id))
+	}
+	p.triggered(ob, out.Triggered)
+	p.selfTradeCancels(out.STPCancels)
+}
+
+// flush hands the events of the input just applied to the wsOut publisher, followed by the
+// wallet of every user they touched once its updates have run. Inputs go out in the order
+// they were applied without holding up the market.
+func (p *privateFeed) flush() {
+	if len(p.events) == 0 && len(p.touched) == 0 {
+		return
+	}
+	events, touched, pending, prev := p.events, p.touched, p.pending, p.sent
+	sent := make(chan struct{})
+	p.events, p.touched, p.pending, p.sent = nil, nil, nil, sent
+
+	go func() {
+		defer close(sent)
+		<-prev
+		for _, msg := range events {
+			p.out <- msg
+		}
+		if pending != nil {
+			pending.wait()
+		}
+		for userId := range touched {
+			p.sendWallet(userId)
+		}
+	}()
+}
+
+// sendWallet sends the wallet of userId as the shared store holds it.
+func (p *privateFeed) sendWallet(userId string) {
+	balance, assets, ok := p.wallet.Balances(userId)
+	if !ok {
+		return
+	}
+	p.out <- wsmessagestypes.WSOutMessageStruct{
+		MessageType: wsmessagestypes.WALLET_UPDATED,
+		Payload:     wsmessagestypes.WalletPayload{Balance: balance, Assets: assets},
+		UserId:      userId,
+	}
+}
+
+// loadWallet loads the wallet of userId in the background and sends it once loaded.
+func (p *privateFeed) loadWallet(userId string) {
+	go func() {
+		if err := p.wallet.Load(userId); err != nil {
+			slog.Error("Unable to load wallet", "marketId", p.marketId, "userId", userId, "err", err)
+			return
+		}
+		p.sendWallet(userId)
+	}()
+}
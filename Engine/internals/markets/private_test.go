@@ -0,0 +1,118 @@
+package markets
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	wsmessagestypes "github.com/raiashpanda007/rivon/engine/internals/utils/WsMessagesTypes"
+)
+
+// fixedWallets holds every user's balance as the shared store would after the input.
+type fixedWallets map[string]int
+
+func (w fixedWallets) Balances(userId string) (int, map[string]int, bool) {
+	balance, ok := w[userId]
+	return balance, nil, ok
+}
+
+func (w fixedWallets) Load(userId string) error { return nil }
+
+func receive(t *testing.T, out chan wsmessagestypes.WSOutMessageStruct) wsmessagestypes.WSOutMessageStruct {
+	t.Helper()
+	select {
+	case msg := <-out:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no private event sent")
+		return wsmessagestypes.WSOutMessageStruct{}
+	}
+}
+
+func nothingSent(t *testing.T, out chan wsmessagestypes.WSOutMessageStruct) {
+	t.Helper()
+	select {
+	case msg := <-out:
+		t.Fatalf("sent %s to %s early", msg.MessageType, msg.UserId)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestFillsReachBothSides(t *testing.T) {
+	ob := newTestBook()
+	addOrder(t, ob, "a1", "bob", orderbooks.SELL, 101, 2)
+	addOrder(t, ob, "a2", "carol", orderbooks.SELL, 101, 3)
+	fills := addOrder(t, ob, "b1", "alice", orderbooks.BUY, 101, 4)
+
+	p := newPrivateFeed(ob.MarketId, nil, nil)
+	p.fills(ob, fills, "alice", orderbooks.BUY, 0)
+
+	want := []struct {
+		userId    string
+		t         wsmessagestypes.WSOutMessageType
+		orderId   string
+		remaining int
+		maker     bool
+	}{
+		{"alice", wsmessagestypes.ORDER_PARTIALLY_FILLED, "b1", 2, false},
+		{"bob", wsmessagestypes.ORDER_FILLED, "a1", 0, true},
+		{"alice", wsmessagestypes.ORDER_FILLED, "b1", 0, false},
+		{"carol", wsmessagestypes.ORDER_PARTIALLY_FILLED, "a2", 1, true},
+	}
+	if len(p.events) != len(want) {
+		t.Fatalf("%d events, want %d", len(p.events), len(want))
+	}
+	for i, w := range want {
+		e := p.events[i]
+		fill := e.Payload.(wsmessagestypes.OrderFillPayload)
+		if e.UserId != w.userId || e.MessageType != w.t || fill.OrderId != w.orderId || fill.Remaining != w.remaining || fill.Maker != w.maker {
+			t.Errorf("event %d: %s %s %+v, want %+v", i, e.UserId, e.MessageType, fill, w)
+		}
+	}
+	for _, userId := range []string{"alice", "bob", "carol"} {
+		if _, ok := p.touched[userId]; !ok {
+			t.Errorf("wallet of %s not reported", userId)
+		}
+	}
+}
+
+func TestRejectionLeavesTheWalletAlone(t *testing.T) {
+	p := newPrivateFeed("m1", nil, nil)
+	p.rejected("o1", "alice", "FOK", errors.New("not enough liquidity"))
+	if len(p.events) != 1 || p.events[0].MessageType != wsmessagestypes.ORDER_REJECTED || len(p.touched) != 0 {
+		t.Fatalf("events %+v touched %v", p.events, p.touched)
+	}
+}
+
+func TestWalletUpdateWaitsForTheWalletOp(t *testing.T) {
+	out := make(chan wsmessagestypes.WSOutMessageStruct, 16)
+	p := newPrivateFeed("m1", out, fixedWallets{"alice": 90, "bob": 10})
+
+	first := &walletOp{done: make(chan struct{})}
+	p.accepted("o1", "alice", orderbooks.BUY, 10, 1)
+	p.settle(first)
+	p.flush()
+	p.cancelled("o2", "bob", 1)
+	p.settle(nil)
+	p.flush()
+
+	if msg := receive(t, out); msg.MessageType != wsmessagestypes.ORDER_ACCEPTED || msg.UserId != "alice" {
+		t.Fatalf("got %s for %s", msg.MessageType, msg.UserId)
+	}
+	// Neither the wallet of the first input nor anything of the next goes out before the
+	// first input's wallet side is applied.
+	nothingSent(t, out)
+
+	close(first.done)
+	wallet := receive(t, out)
+	if payload, ok := wallet.Payload.(wsmessagestypes.WalletPayload); wallet.MessageType != wsmessagestypes.WALLET_UPDATED || wallet.UserId != "alice" || !ok || payload.Balance != 90 {
+		t.Fatalf("got %+v, want alice's wallet", wallet)
+	}
+	if msg := receive(t, out); msg.MessageType != wsmessagestypes.ORDER_CANCELLED || msg.UserId != "bob" {
+		t.Fatalf("got %s for %s", msg.MessageType, msg.UserId)
+	}
+	if msg := receive(t, out); msg.MessageType != wsmessagestypes.WALLET_UPDATED || msg.UserId != "bob" {
+		t.Fatalf("got %s for %s", msg.MessageType, msg.UserId)
+	}
+}
@@ -0,0 +1,56 @@
+package markets
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	usermap "github.com/raiashpanda007/rivon/engine/internals/UserMap"
+	tradestream "github.com/raiashpanda007/rivon/engine/internals/utils/TradeStream"
+)
+
+// replaceEscrowDelta returns how much extra escrow a replacement needs: money for BUYs,
+// asset units for SELLs. A negative value is escrow the replacement frees up.
+func replaceEscrowDelta(o *orderbooks.Order, price, quantity int) int {
+	if o.Side == orderbooks.BUY {
+		return (quantity-o.Filled)*price - (o.Quantity-o.Filled)*o.Price
+	}
+	return quantity - o.Quantity
+}
+
+// adjustEscrow locks a positive delta or releases a negative one for userId.
+func adjustEscrow(b *usermap.Batch, marketId, userId string, side orderbooks.OrderSide, delta int) {
+	switch {
+	case delta > 0 && side == orderbooks.BUY:
+		b.LockMoney(userId, delta)
+	case delta > 0:
+		b.LockAsset(userId, marketId, delta)
+	case delta < 0 && side == orderbooks.BUY:
+		b.UnlockMoney(userId, -delta)
+	case delta < 0:
+		b.UnlockAsset(userId, marketId, -delta)
+	}
+}
+
+// publishReplacedOrder records the new price and quantity on the TRADES stream, followed by
+// any fills the re-queued order took and a cancel if self-trade prevention left nothing resting.
+func publishReplacedOrder(ctx context.Context, tradeRedis *redis.Client, marketId string, o orderbooks.Order, fills []orderbooks.Fills, cancelledQty, restingQty int, lastOrderId, lastTradeId string) {
+	filled := o.Filled
+	tradestream.TradeRedisStreamPublisher(
+		ctx, tradestream.ORDER_REPLACED, o.Id, marketId,
+		lastOrderId, lastTradeId, nil, filled, o.Price,
+		o.UserId, o.Quantity, string(o.Side),
+		tradeRedis,
+	)
+	if len(fills) > 0 {
+		tradestream.TradeRedisStreamPublisher(
+			ctx, tradestream.ORDER_UPDATED, o.Id, marketId,
+			lastOrderId, lastTradeId, fills, filled, o.Price,
+			o.UserId, o.Quantity, string(o.Side),
+			tradeRedis,
+		)
+	}
+	if cancelledQty > 0 && restingQty == 0 {
+		publishCancelledOrder(ctx, tradeRedis, o.Id, marketId, lastOrderId, lastTradeId)
+	}
+}
@@ -0,0 +1,107 @@
+package markets
+
+import (
+	"errors"
+
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	pubsub "github.com/raiashpanda007/rivon/engine/internals/PubSub"
+	usermap "github.com/raiashpanda007/rivon/engine/internals/UserMap"
+)
+
+var (
+	ErrPriceNotOnTick  = errors.New("price is not a multiple of the market tick size")
+	ErrQtyNotOnLot     = errors.New("quantity is not a multiple of the market lot size")
+	ErrPriceOutOfRange = errors.New("price is outside the market price range")
+	ErrQtyOutOfRange   = errors.New("quantity is outside the market order size range")
+)
+
+// TradingRules are the per-market constraints every incoming order and replace is checked
+// against before escrow is locked. MaxPrice and MaxOrderQty of 0 mean no upper limit.
+type TradingRules struct {
+	TickSize    int
+	LotSize     int
+	MinPrice    int
+	MaxPrice    int
+	MaxOrderQty int
+}
+
+func (r TradingRules) checkPrice(price int) error {
+	if price < r.MinPrice || price <= 0 || (r.MaxPrice > 0 && price > r.MaxPrice) {
+		return ErrPriceOutOfRange
+	}
+	if r.TickSize > 1 && price%r.TickSize != 0 {
+		return ErrPriceNotOnTick
+	}
+	return nil
+}
+
+func (r TradingRules) checkQuantity(qty int) error {
+	if qty <= 0 || (r.MaxOrderQty > 0 && qty > r.MaxOrderQty) {
+		return ErrQtyOutOfRange
+	}
+	if r.LotSize > 1 && qty%r.LotSize != 0 {
+		return ErrQtyNotOnLot
+	}
+	return nil
+}
+
+// Validate checks a new order. MARKET orders carry no price of their own and STOP orders are
+// priced by their trigger, so only the prices the user actually chose are checked.
+func (r TradingRules) Validate(order OrderMessages) error {
+	if err := r.checkQuantity(order.Quantity); err != nil {
+		return err
+	}
+	switch order.OrderKind {
+	case orderbooks.MARKET:
+		return nil
+	case orderbooks.STOP:
+		return r.checkPrice(order.TriggerPrice)
+	case orderbooks.STOP_LIMIT:
+		if err := r.checkPrice(order.TriggerPrice); err != nil {
+			return err
+		}
+	}
+	return r.checkPrice(order.Price)
+}
+
+// ValidateReplace checks the new price and total quantity of a REPLACE_ORDER.
+func (r TradingRules) ValidateReplace(price, quantity int) error {
+	if err := r.checkQuantity(quantity); err != nil {
+		return err
+	}
+	return r.checkPrice(price)
+}
+
+// rejectReason maps the errors an order can be refused with onto the reason code sent with
+// ORDER_REJECTED. Errors without a known cause map to an empty reason.
+func rejectReason(err error) pubsub.RejectReason {
+	switch {
+	case errors.Is(err, ErrPriceNotOnTick):
+		return pubsub.REJECT_PRICE_NOT_ON_TICK
+	case errors.Is(err, ErrQtyNotOnLot):
+		return pubsub.REJECT_QTY_NOT_ON_LOT
+	case errors.Is(err, ErrPriceOutOfRange):
+		return pubsub.REJECT_PRICE_OUT_OF_RANGE
+	case errors.Is(err, ErrQtyOutOfRange):
+		return pubsub.REJECT_QTY_OUT_OF_RANGE
+	case errors.Is(err, ErrMarketSettled):
+		return pubsub.REJECT_MARKET_CLOSED
+	case errors.Is(err, ErrNothingToReduce):
+		return pubsub.REJECT_NOTHING_TO_REDUCE
+	case errors.Is(err, usermap.ErrInsufficientFunds), errors.Is(err, usermap.ErrInsufficientAssets):
+		return pubsub.REJECT_INSUFFICIENT_BALANCE
+	case errors.Is(err, orderbooks.ErrFOKNotFillable):
+		return pubsub.REJECT_FOK_NOT_FILLABLE
+	case errors.Is(err, orderbooks.ErrPostOnlyWouldCross):
+		return pubsub.REJECT_POST_ONLY_WOULD_CROSS
+	case errors.Is(err, orderbooks.ErrAuctionImmediateOrder):
+		return pubsub.REJECT_AUCTION_IMMEDIATE_ORDER
+	case errors.Is(err, orderbooks.ErrNoReferencePrice):
+		return pubsub.REJECT_NO_REFERENCE_PRICE
+	case errors.Is(err, orderbooks.ErrOrderNotFound):
+		return pubsub.REJECT_ORDER_NOT_FOUND
+	case errors.Is(err, orderbooks.ErrInvalidReplace):
+		return pubsub.REJECT_INVALID_REPLACE
+	}
+	return ""
+}
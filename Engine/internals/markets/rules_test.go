@@ -0,0 +1,62 @@
+package markets
+
+import (
+	"testing"
+
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	pubsub "github.com/raiashpanda007/rivon/engine/internals/PubSub"
+)
+
+func TestTradingRulesValidate(t *testing.T) {
+	rules := TradingRules{TickSize: 5, LotSize: 10, MinPrice: 10, MaxPrice: 1000, MaxOrderQty: 500}
+	limit := func(price, qty int) OrderMessages {
+		return OrderMessages{OrderKind: orderbooks.LIMIT, Price: price, Quantity: qty}
+	}
+
+	tests := []struct {
+		name  string
+		order OrderMessages
+		want  error
+	}{
+		{name: "on tick and lot", order: limit(100, 20)},
+		{name: "at the price bounds", order: limit(1000, 10)},
+		{name: "off tick", order: limit(101, 20), want: ErrPriceNotOnTick},
+		{name: "off lot", order: limit(100, 25), want: ErrQtyNotOnLot},
+		{name: "below min price", order: limit(5, 20), want: ErrPriceOutOfRange},
+		{name: "above max price", order: limit(1005, 20), want: ErrPriceOutOfRange},
+		{name: "zero quantity", order: limit(100, 0), want: ErrQtyOutOfRange},
+		{name: "above max quantity", order: limit(100, 510), want: ErrQtyOutOfRange},
+		{name: "market order has no price to check", order: OrderMessages{OrderKind: orderbooks.MARKET, Quantity: 20}},
+		{name: "market order lot is checked", order: OrderMessages{OrderKind: orderbooks.MARKET, Quantity: 15}, want: ErrQtyNotOnLot},
+		{name: "stop checks its trigger", order: OrderMessages{OrderKind: orderbooks.STOP, TriggerPrice: 102, Quantity: 20}, want: ErrPriceNotOnTick},
+		{name: "stop limit checks its trigger", order: OrderMessages{OrderKind: orderbooks.STOP_LIMIT, TriggerPrice: 2000, Price: 100, Quantity: 20}, want: ErrPriceOutOfRange},
+		{name: "stop limit checks its price", order: OrderMessages{OrderKind: orderbooks.STOP_LIMIT, TriggerPrice: 100, Price: 103, Quantity: 20}, want: ErrPriceNotOnTick},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := rules.Validate(tt.order); err != tt.want {
+				t.Fatalf("Validate err %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestZeroRulesOnlyRequirePositiveValues(t *testing.T) {
+	var rules TradingRules
+	if err := rules.ValidateReplace(7, 3); err != nil {
+		t.Fatalf("ValidateReplace with no rules: %v", err)
+	}
+	if err := rules.ValidateReplace(0, 3); err != ErrPriceOutOfRange {
+		t.Fatalf("ValidateReplace at price 0: err %v, want ErrPriceOutOfRange", err)
+	}
+}
+
+func TestRejectReason(t *testing.T) {
+	if got := rejectReason(ErrQtyNotOnLot); got != pubsub.REJECT_QTY_NOT_ON_LOT {
+		t.Fatalf("rejectReason(ErrQtyNotOnLot) = %q", got)
+	}
+	if got := rejectReason(orderbooks.ErrPostOnlyWouldCross); got != pubsub.REJECT_POST_ONLY_WOULD_CROSS {
+		t.Fatalf("rejectReason(ErrPostOnlyWouldCross) = %q", got)
+	}
+}
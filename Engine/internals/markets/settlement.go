@@ -0,0 +1,50 @@
+package markets
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-redis/redis/v8"
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	usermap "github.com/raiashpanda007/rivon/engine/internals/UserMap"
+	tradestream "github.com/raiashpanda007/rivon/engine/internals/utils/TradeStream"
+	wsmessagestypes "github.com/raiashpanda007/rivon/engine/internals/utils/WsMessagesTypes"
+)
+
+var ErrMarketSettled = errors.New("market has been settled and takes no more orders")
+
+// payoutSettlement releases the escrow of every order the settlement took off the book and
+// then pays each holder the settlement price per unit. Escrow goes first so assets held by
+// resting SELLs are back in their owners' wallets before they are converted.
+func payoutSettlement(b *usermap.Batch, marketId string, price int, orders []*orderbooks.Order, stops []*orderbooks.StopOrder) {
+	for _, o := range orders {
+		releaseEscrow(b, marketId, o.UserId, o.Side, o.Price, o.Quantity-o.Filled)
+	}
+	for _, stop := range stops {
+		releaseEscrow(b, marketId, stop.UserId, stop.Side, stop.Price, stop.Quantity)
+	}
+	b.Settle(marketId, price)
+}
+
+// publishSettlement writes a cancel for every order the settlement took off the book, then the
+// settlement itself, to TRADES. DBWritter cancels the orders before it pays out and closes
+// the market.
+func publishSettlement(ctx context.Context, tradeRedis *redis.Client, marketId, settlementId string, price int, orders []*orderbooks.Order, stops []*orderbooks.StopOrder, lastOrderId, lastTradeId string) {
+	for _, o := range orders {
+		publishCancelledOrder(ctx, tradeRedis, o.Id, marketId, lastOrderId, lastTradeId)
+	}
+	for _, stop := range stops {
+		publishCancelledOrder(ctx, tradeRedis, stop.Id, marketId, lastOrderId, lastTradeId)
+	}
+	tradestream.MarketSettledPublisher(ctx, settlementId, marketId, price, lastOrderId, lastTradeId, tradeRedis)
+}
+
+// announceSettlement tells the market's WS subscribers the market has closed at price.
+func announceSettlement(wsOutChannel chan wsmessagestypes.WSOutMessageStruct, price int) {
+	go func() {
+		wsOutChannel <- wsmessagestypes.WSOutMessageStruct{
+			MessageType: wsmessagestypes.MARKET_SETTLED,
+			Payload:     wsmessagestypes.MarketSettledPayload{SettlementPrice: price},
+		}
+	}()
+}
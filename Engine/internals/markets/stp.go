@@ -0,0 +1,49 @@
+package markets
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	usermap "github.com/raiashpanda007/rivon/engine/internals/UserMap"
+	tradestream "github.com/raiashpanda007/rivon/engine/internals/utils/TradeStream"
+)
+
+// releaseSelfTradeCancels hands back the escrow of resting orders cut by self-trade prevention.
+func releaseSelfTradeCancels(b *usermap.Batch, marketId string, cancels []orderbooks.SelfTradeCancel) {
+	for _, c := range cancels {
+		releaseEscrow(b, marketId, c.Order.UserId, c.Order.Side, c.Order.Price, c.Quantity)
+	}
+}
+
+// publishSelfTradeCancels records resting orders cut by self-trade prevention on the TRADES
+// stream: a cancel when the order left the book, its reduced quantity when it was decremented.
+func publishSelfTradeCancels(ctx context.Context, tradeRedis *redis.Client, marketId string, cancels []orderbooks.SelfTradeCancel, lastOrderId, lastTradeId string) {
+	for _, c := range cancels {
+		if c.Removed {
+			publishCancelledOrder(ctx, tradeRedis, c.Order.Id, marketId, lastOrderId, lastTradeId)
+			continue
+		}
+		tradestream.TradeRedisStreamPublisher(
+			ctx, tradestream.ORDER_REPLACED, c.Order.Id, marketId,
+			lastOrderId, lastTradeId, nil, c.Order.Filled, c.Order.Price,
+			c.Order.UserId, c.Order.Quantity, string(c.Order.Side),
+			tradeRedis,
+		)
+	}
+}
+
+// publishIncomingRemainder records what happened to the part of an incoming order that neither
+// filled nor rests: a cancel when nothing rests, or the reduced quantity of the resting order.
+func publishIncomingRemainder(ctx context.Context, tradeRedis *redis.Client, marketId string, o orderbooks.Order, executedQty, restingQty int, lastOrderId, lastTradeId string) {
+	if restingQty == 0 {
+		publishCancelledOrder(ctx, tradeRedis, o.Id, marketId, lastOrderId, lastTradeId)
+		return
+	}
+	tradestream.TradeRedisStreamPublisher(
+		ctx, tradestream.ORDER_REPLACED, o.Id, marketId,
+		lastOrderId, lastTradeId, nil, executedQty, o.Price,
+		o.UserId, executedQty+restingQty, string(o.Side),
+		tradeRedis,
+	)
+}
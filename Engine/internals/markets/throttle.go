@@ -0,0 +1,245 @@
+package markets
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	wsmessagestypes "github.com/raiashpanda007/rivon/engine/internals/utils/WsMessagesTypes"
+)
+
+const (
+	// minUpdateInterval is the shortest IntervalMs a subscription is served at.
+	minUpdateInterval = 100 * time.Millisecond
+	// throttleTick is how often the wsOut publisher looks for subscriptions that are due.
+	throttleTick = 50 * time.Millisecond
+)
+
+// bookView mirrors the depth and best prices of a market for the subscriptions the wsOut
+// publisher serves, so they are answered without touching the book. The market applies
+// every change it pushes as a depth delta.
+type bookView struct {
+	mu            sync.Mutex
+	bids, asks    map[int]int
+	ticker        wsmessagestypes.BookTickerPayload
+	depthVersion  int64 // bumped on every change to bids or asks
+	tickerVersion int64 // bumped when ticker changes
+}
+
+func newBookView(ob *orderbooks.OrderBook) *bookView {
+	v := &bookView{bids: copyDepth(ob.BidDepth), asks: copyDepth(ob.AskDepth)}
+	v.ticker = bookTicker(ob)
+	return v
+}
+
+// bookTicker reads the best bid and ask of ob off its price heaps.
+func bookTicker(ob *orderbooks.OrderBook) wsmessagestypes.BookTickerPayload {
+	var t wsmessagestypes.BookTickerPayload
+	if ob.BidHeap.Size() > 0 {
+		t.BidPrice = ob.BidHeap.Peek()
+		t.BidQty = ob.BidDepth[t.BidPrice]
+	}
+	if ob.AskHeap.Size() > 0 {
+		t.AskPrice = ob.AskHeap.Peek()
+		t.AskQty = ob.AskDepth[t.AskPrice]
+	}
+	return t
+}
+
+// apply records changes, which ob has already made.
+func (v *bookView) apply(changes []orderbooks.LevelChange, ob *orderbooks.OrderBook) {
+	if len(changes) == 0 {
+		return
+	}
+	ticker := bookTicker(ob)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, c := range changes {
+		depth := v.asks
+		if c.Side == orderbooks.BUY {
+			depth = v.bids
+		}
+		if c.Quantity > 0 {
+			depth[c.Price] = c.Quantity
+		} else {
+			delete(depth, c.Price)
+		}
+	}
+	v.depthVersion++
+	if ticker != v.ticker {
+		v.ticker = ticker
+		v.tickerVersion++
+	}
+}
+
+// version is the version of the ticker, or of the depth.
+func (v *bookView) version(ticker bool) int64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if ticker {
+		return v.tickerVersion
+	}
+	return v.depthVersion
+}
+
+// depth returns the best limit levels of each side grouped into granularity wide buckets.
+func (v *bookView) depth(limit, granularity int) wsmessagestypes.DepthPayload {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return wsmessagestypes.DepthPayload{
+		BidDepth:    topLevels(v.bids, limit, granularity, true),
+		AskDepth:    topLevels(v.asks, limit, granularity, false),
+		Limit:       limit,
+		Granularity: granularity,
+	}
+}
+
+func (v *bookView) bookTicker() wsmessagestypes.BookTickerPayload {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.ticker
+}
+
+// topLevels groups depth into granularity wide buckets, bids rounded down and asks up so a
+// bucket never shows a better price than it holds, and keeps the best limit of them.
+func topLevels(depth map[int]int, limit, granularity int, bids bool) map[int]int {
+	buckets := make(map[int]int)
+	for price, qty := range depth {
+		if qty <= 0 {
+			continue
+		}
+		bucket := price
+		if granularity > 1 {
+			bucket = price / granularity * granularity
+			if !bids && bucket != price {
+				bucket += granularity
+			}
+		}
+		buckets[bucket] += qty
+	}
+	if limit == 0 || len(buckets) <= limit {
+		return buckets
+	}
+
+	prices := make([]int, 0, len(buckets))
+	for p := range buckets {
+		prices = append(prices, p)
+	}
+	if bids {
+		sort.Sort(sort.Reverse(sort.IntSlice(prices)))
+	} else {
+		sort.Ints(prices)
+	}
+	top := make(map[int]int, limit)
+	for _, p := range prices[:limit] {
+		top[p] = buckets[p]
+	}
+	return top
+}
+
+// depthLimit rounds a requested number of levels up to 5, 10 or 50; 0 asks for all.
+func depthLimit(requested int) int {
+	switch {
+	case requested <= 0:
+		return 0
+	case requested <= 5:
+		return 5
+	case requested <= 10:
+		return 10
+	default:
+		return 50
+	}
+}
+
+// subscription is one connection's DEPTH_SUBSCRIBE or BOOK_TICKER_SUBSCRIBE.
+type subscription struct {
+	ConnectionId string
+	UserId       string
+	Ticker       bool // BOOK_TICKER instead of DEPTH_DATA
+	Limit        int
+	Granularity  int
+	Interval     time.Duration // 0 answers once
+
+	nextAt time.Time
+	sent   int64 // version last sent, -1 before the first
+}
+
+// subscriptionUpdate adds sub, replacing the connection's subscription of the same kind,
+// or removes it.
+type subscriptionUpdate struct {
+	Sub    subscription
+	Remove bool
+}
+
+func newSubscription(msg wsmessagestypes.WSInMessageStruct, ticker bool) subscription {
+	sub := subscription{
+		ConnectionId: msg.ConnectionId,
+		UserId:       msg.UserId,
+		Ticker:       ticker,
+		sent:         -1,
+	}
+	if !ticker {
+		sub.Limit = depthLimit(msg.Depth)
+		sub.Granularity = max(msg.Granularity, 0)
+	}
+	// A ticker that answers once is of no use, so it is always kept.
+	if msg.IntervalMs > 0 || ticker {
+		sub.Interval = max(time.Duration(msg.IntervalMs)*time.Millisecond, minUpdateInterval)
+	}
+	return sub
+}
+
+type subscriptionKey struct {
+	connectionId string
+	ticker       bool
+}
+
+// subscriptions are the throttled subscriptions of one market. Only the wsOut publisher
+// uses them.
+type subscriptions map[subscriptionKey]*subscription
+
+func (s subscriptions) update(u subscriptionUpdate) {
+	key := subscriptionKey{connectionId: u.Sub.ConnectionId, ticker: u.Sub.Ticker}
+	if u.Remove {
+		delete(s, key)
+		return
+	}
+	sub := u.Sub
+	s[key] = &sub
+}
+
+// due returns the messages of every subscription whose interval has run out at now and whose
+// view has changed since it was last served. Everything that changed in between goes out
+// as one message. A subscription that answers once is dropped once served.
+func (s subscriptions) due(view *bookView, now time.Time) []wsmessagestypes.WSOutMessageStruct {
+	var out []wsmessagestypes.WSOutMessageStruct
+	for key, sub := range s {
+		if now.Before(sub.nextAt) {
+			continue
+		}
+		version := view.version(sub.Ticker)
+		if version == sub.sent {
+			continue
+		}
+		var msg wsmessagestypes.WSOutMessageStruct
+		if sub.Ticker {
+			msg = wsmessagestypes.WSOutMessageStruct{MessageType: wsmessagestypes.BOOK_TICKER, Payload: view.bookTicker()}
+		} else {
+			msg = wsmessagestypes.WSOutMessageStruct{MessageType: wsmessagestypes.DEPTH_DATA, Payload: view.depth(sub.Limit, sub.Granularity)}
+		}
+		msg.UserId, msg.ConnectionId = sub.UserId, sub.ConnectionId
+		out = append(out, msg)
+
+		if sub.Interval == 0 {
+			delete(s, key)
+			continue
+		}
+		// A version read before the payload may be older than it, which only sends the
+		// payload again.
+		sub.sent = version
+		sub.nextAt = now.Add(sub.Interval)
+	}
+	return out
+}
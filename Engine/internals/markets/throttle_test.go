@@ -0,0 +1,142 @@
+package markets
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	wsmessagestypes "github.com/raiashpanda007/rivon/engine/internals/utils/WsMessagesTypes"
+)
+
+func TestTopLevels(t *testing.T) {
+	depth := map[int]int{101: 1, 102: 2, 105: 3, 111: 4, 120: 0}
+	tests := []struct {
+		name               string
+		limit, granularity int
+		bids               bool
+		want               map[int]int
+	}{
+		{"all levels", 0, 0, true, map[int]int{101: 1, 102: 2, 105: 3, 111: 4}},
+		{"best bids", 2, 0, true, map[int]int{111: 4, 105: 3}},
+		{"best asks", 2, 0, false, map[int]int{101: 1, 102: 2}},
+		{"bids round down", 0, 5, true, map[int]int{100: 3, 105: 3, 110: 4}},
+		{"asks round up", 0, 5, false, map[int]int{105: 6, 115: 4}},
+		{"best bucket of asks", 1, 10, false, map[int]int{110: 6}},
+	}
+	for _, tt := range tests {
+		if got := topLevels(depth, tt.limit, tt.granularity, tt.bids); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDepthLimit(t *testing.T) {
+	for requested, want := range map[int]int{-1: 0, 0: 0, 1: 5, 5: 5, 6: 10, 10: 10, 11: 50, 500: 50} {
+		if got := depthLimit(requested); got != want {
+			t.Errorf("depthLimit(%d) = %d, want %d", requested, got, want)
+		}
+	}
+}
+
+func TestNewSubscription(t *testing.T) {
+	once := newSubscription(wsmessagestypes.WSInMessageStruct{ConnectionId: "c1", Depth: 7, Granularity: -3}, false)
+	if once.Limit != 10 || once.Granularity != 0 || once.Interval != 0 {
+		t.Fatalf("depth subscription %+v", once)
+	}
+	fast := newSubscription(wsmessagestypes.WSInMessageStruct{ConnectionId: "c1", IntervalMs: 10}, false)
+	if fast.Interval != minUpdateInterval {
+		t.Fatalf("interval %v, want it raised to %v", fast.Interval, minUpdateInterval)
+	}
+	ticker := newSubscription(wsmessagestypes.WSInMessageStruct{ConnectionId: "c1"}, true)
+	if ticker.Interval != minUpdateInterval || ticker.Limit != 0 {
+		t.Fatalf("ticker subscription %+v, want it kept at the shortest interval", ticker)
+	}
+}
+
+func TestBookTickerTracksTheBestLevels(t *testing.T) {
+	ob := newTestBook()
+	view := newBookView(ob)
+	if view.bookTicker() != (wsmessagestypes.BookTickerPayload{}) {
+		t.Fatalf("empty book has ticker %+v", view.bookTicker())
+	}
+
+	addOrder(t, ob, "b1", "alice", orderbooks.BUY, 99, 5)
+	addOrder(t, ob, "b2", "alice", orderbooks.BUY, 97, 1)
+	addOrder(t, ob, "a1", "bob", orderbooks.SELL, 102, 3)
+	view.apply(ob.TakeDepthChanges(), ob)
+	want := wsmessagestypes.BookTickerPayload{BidPrice: 99, BidQty: 5, AskPrice: 102, AskQty: 3}
+	if got := view.bookTicker(); got != want {
+		t.Fatalf("ticker %+v, want %+v", got, want)
+	}
+	tickerVersion := view.version(true)
+
+	// A level behind the best changes the depth but not the ticker.
+	addOrder(t, ob, "b3", "alice", orderbooks.BUY, 97, 1)
+	view.apply(ob.TakeDepthChanges(), ob)
+	if view.version(true) != tickerVersion || view.version(false) != 2 {
+		t.Fatalf("ticker version %d depth version %d", view.version(true), view.version(false))
+	}
+	if got := view.depth(0, 0); got.BidDepth[97] != 2 {
+		t.Fatalf("depth %+v", got)
+	}
+}
+
+func TestSubscriptionsAreThrottled(t *testing.T) {
+	ob := newTestBook()
+	view := newBookView(ob)
+	subs := subscriptions{}
+	sub := newSubscription(wsmessagestypes.WSInMessageStruct{ConnectionId: "c1", UserId: "alice", Depth: 5, IntervalMs: 200}, false)
+	subs.update(subscriptionUpdate{Sub: sub})
+
+	now := time.Now()
+	if out := subs.due(view, now); len(out) != 1 || out[0].MessageType != wsmessagestypes.DEPTH_DATA || out[0].ConnectionId != "c1" {
+		t.Fatalf("first update %+v, want the depth at once", out)
+	}
+
+	// Changes within the interval wait for it and go out as one message.
+	addOrder(t, ob, "b1", "alice", orderbooks.BUY, 99, 5)
+	view.apply(ob.TakeDepthChanges(), ob)
+	addOrder(t, ob, "b2", "alice", orderbooks.BUY, 98, 5)
+	view.apply(ob.TakeDepthChanges(), ob)
+	if out := subs.due(view, now.Add(100*time.Millisecond)); len(out) != 0 {
+		t.Fatalf("sent %d updates within the interval", len(out))
+	}
+	out := subs.due(view, now.Add(200*time.Millisecond))
+	if len(out) != 1 {
+		t.Fatalf("sent %d updates once the interval ran out", len(out))
+	}
+	if depth := out[0].Payload.(wsmessagestypes.DepthPayload); len(depth.BidDepth) != 2 || depth.Limit != 5 {
+		t.Fatalf("conflated update %+v", depth)
+	}
+
+	// Nothing changed, nothing sent.
+	if out := subs.due(view, now.Add(time.Second)); len(out) != 0 {
+		t.Fatalf("sent %d updates without a change", len(out))
+	}
+
+	subs.update(subscriptionUpdate{Sub: sub, Remove: true})
+	if len(subs) != 0 {
+		t.Fatal("subscription not removed")
+	}
+}
+
+func TestOneShotSubscriptionIsDroppedOnceServed(t *testing.T) {
+	view := newBookView(newTestBook())
+	subs := subscriptions{}
+	subs.update(subscriptionUpdate{Sub: newSubscription(wsmessagestypes.WSInMessageStruct{ConnectionId: "c1"}, false)})
+	subs.update(subscriptionUpdate{Sub: newSubscription(wsmessagestypes.WSInMessageStruct{ConnectionId: "c1"}, true)})
+	if len(subs) != 2 {
+		t.Fatalf("%d subscriptions, want depth and ticker side by side", len(subs))
+	}
+
+	out := subs.due(view, time.Now())
+	if len(out) != 2 || len(subs) != 1 {
+		t.Fatalf("sent %d, kept %d subscriptions", len(out), len(subs))
+	}
+	for key := range subs {
+		if !key.ticker {
+			t.Fatal("the one-shot depth subscription was kept")
+		}
+	}
+}
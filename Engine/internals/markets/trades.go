@@ -0,0 +1,40 @@
+package markets
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// tradesFeed writes the TRADES entries of a market in the order the market made them. Each
+// write runs in the background behind the one before it, so DBWritter applies a settlement
+// only after every fill and cancel that led up to it.
+type tradesFeed struct {
+	ctx      context.Context
+	redis    *redis.Client
+	marketId string
+	written  chan struct{} // closed once the previous write is done
+}
+
+func newTradesFeed(ctx context.Context, tradeRedis *redis.Client, marketId string) *tradesFeed {
+	written := make(chan struct{})
+	close(written)
+	return &tradesFeed{ctx: ctx, redis: tradeRedis, marketId: marketId, written: written}
+}
+
+// publish queues fn behind the writes queued before it without holding up the market. fn
+// must not read the book: whatever it writes is taken from the book before it is queued.
+func (t *tradesFeed) publish(fn func()) {
+	prev, written := t.written, make(chan struct{})
+	t.written = written
+	go func() {
+		defer close(written)
+		<-prev
+		fn()
+	}()
+}
+
+// cancelled queues publishCancelledOrder for orderId.
+func (t *tradesFeed) cancelled(orderId, lastOrderId, lastTradeId string) {
+	t.publish(func() { publishCancelledOrder(t.ctx, t.redis, orderId, t.marketId, lastOrderId, lastTradeId) })
+}
@@ -0,0 +1,149 @@
+package markets
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	usermap "github.com/raiashpanda007/rivon/engine/internals/UserMap"
+	tradestream "github.com/raiashpanda007/rivon/engine/internals/utils/TradeStream"
+)
+
+func isStopKind(kind orderbooks.OrderKind) bool {
+	return kind == orderbooks.STOP || kind == orderbooks.STOP_LIMIT
+}
+
+// newStopOrder builds the trigger book entry for an incoming STOP / STOP_LIMIT message.
+// STOP_LIMIT keeps its limit price. A STOP has no limit, so BUYs get the protection band
+// above the trigger as an escrow cap and SELLs carry the trigger price for reporting.
+func newStopOrder(order OrderMessages, maxSlippageBps int) orderbooks.StopOrder {
+	price := order.Price
+	if order.OrderKind == orderbooks.STOP {
+		price = order.TriggerPrice
+		if orderbooks.OrderSide(order.OrderType) == orderbooks.BUY {
+			price += order.TriggerPrice * maxSlippageBps / 10000
+		}
+	}
+	return orderbooks.StopOrder{
+		Order: orderbooks.Order{
+			Id:          order.OrderId,
+			Quantity:    order.Quantity,
+			Side:        orderbooks.OrderSide(order.OrderType),
+			Price:       price,
+			UserId:      order.UserId,
+			StreamId:    order.StreamId,
+			TimeInForce: order.TimeInForce,
+			ExpiresAt:   order.ExpiresAt,
+			STP:         order.STPMode,
+		},
+		TriggerPrice: order.TriggerPrice,
+		Kind:         order.OrderKind,
+	}
+}
+
+// activateStop returns the order a released stop sends into AddOrder. It keeps the stop's
+// order id but takes the current stream position so LastStreamId never moves backwards.
+// A STOP becomes a MARKET order whose BUY price never exceeds the escrow it locked.
+func activateStop(ob *orderbooks.OrderBook, stop *orderbooks.StopOrder, maxSlippageBps int) (orderbooks.Order, error) {
+	order := stop.Order
+	order.StreamId = ob.LastStreamId
+	if stop.Kind == orderbooks.STOP_LIMIT {
+		return order, nil
+	}
+
+	price, tif, err := applyMarketProtection(ob, order.Side, order.TimeInForce, maxSlippageBps)
+	if err != nil {
+		return order, err
+	}
+	if order.Side == orderbooks.BUY && price > stop.Price {
+		price = stop.Price
+	}
+	order.Price, order.TimeInForce = price, tif
+	return order, nil
+}
+
+// triggeredExecution is the outcome of one stop released into the book.
+type triggeredExecution struct {
+	Stop         *orderbooks.StopOrder
+	Order        orderbooks.Order
+	Fills        []orderbooks.Fills
+	ExecutedQty  int
+	CancelledQty int
+	RestingQty   int
+	Err          error
+}
+
+// releaseTriggeredOrders feeds every stop crossed by CurrentPrice into AddOrder, and keeps
+// going while those fills move the price across further triggers. Nothing is released during
+// a call auction; if a triggered stop trips the circuit breaker, the stops released behind it
+// go back into the trigger book until the halt's auction has uncrossed.
+func releaseTriggeredOrders(ob *orderbooks.OrderBook, params MarketParams, now int64) []triggeredExecution {
+	var executions []triggeredExecution
+	for ob.Auction == nil {
+		released := ob.Triggers.Release(ob.CurrentPrice)
+		if len(released) == 0 {
+			return executions
+		}
+		for i, stop := range released {
+			if ob.Auction != nil {
+				for _, parked := range released[i:] {
+					ob.Triggers.Add(parked)
+				}
+				break
+			}
+			exec := triggeredExecution{Stop: stop}
+			order, err := activateStop(ob, stop, params.MaxSlippageBps)
+			if err == nil {
+				exec.Order = order
+				exec.Fills, exec.ExecutedQty, err = ob.AddOrder(order, order.Price)
+			}
+			if err != nil {
+				exec.Err = err
+				exec.CancelledQty = stop.Quantity
+			} else {
+				exec.RestingQty = ob.RestingQuantity(order.UserId, order.Id)
+				exec.CancelledQty = order.Quantity - exec.ExecutedQty - exec.RestingQty
+				ob.ObserveTrades(params.CircuitBreaker, now, exec.Fills)
+			}
+			executions = append(executions, exec)
+		}
+	}
+	return executions
+}
+
+// settleTriggeredExecution applies the wallet side of a triggered stop. Escrow was locked
+// at the stop's Price when it was parked, so refunds and releases are measured against it.
+func settleTriggeredExecution(b *usermap.Batch, marketId string, exec triggeredExecution) {
+	stop := exec.Stop
+	settleFills(b, marketId, exec.Fills, stop.UserId, stop.Side, stop.Price)
+	releaseEscrow(b, marketId, stop.UserId, stop.Side, stop.Price, exec.CancelledQty)
+}
+
+// settleTriggered applies the wallet side of every execution.
+func settleTriggered(b *usermap.Batch, marketId string, executions []triggeredExecution) {
+	for _, exec := range executions {
+		settleTriggeredExecution(b, marketId, exec)
+	}
+}
+
+// publishTriggeredExecution writes the trigger, its fills and any cancelled remainder to TRADES, in that order.
+func publishTriggeredExecution(ctx context.Context, tradeRedis *redis.Client, marketId string, exec triggeredExecution, lastOrderId, lastTradeId string) {
+	stop := exec.Stop
+	tradestream.TradeRedisStreamPublisher(
+		ctx, tradestream.ORDER_TRIGGERED, stop.Id, marketId,
+		lastOrderId, lastTradeId, nil, 0, exec.Order.Price,
+		stop.UserId, stop.Quantity, string(stop.Side),
+		tradeRedis,
+	)
+	if exec.Err == nil {
+		tradestream.TradeRedisStreamPublisher(
+			ctx, tradestream.ORDER_UPDATED, stop.Id, marketId,
+			lastOrderId, lastTradeId, exec.Fills, exec.ExecutedQty, exec.Order.Price,
+			stop.UserId, stop.Quantity, string(stop.Side),
+			tradeRedis,
+		)
+	}
+	if exec.CancelledQty > 0 {
+		publishIncomingRemainder(ctx, tradeRedis, marketId, stop.Order, exec.ExecutedQty, exec.RestingQty, lastOrderId, lastTradeId)
+	}
+}
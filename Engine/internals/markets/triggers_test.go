@@ -0,0 +1,64 @@
+package markets
+
+import (
+	"testing"
+
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+)
+
+func TestReleaseTriggeredOrdersCascades(t *testing.T) {
+	ob := newTestBook()
+	ob.LastStreamId = "1-0"
+	for _, o := range []orderbooks.Order{
+		{Id: "a1", UserId: "alice", Side: orderbooks.SELL, Price: 101, Quantity: 1},
+		{Id: "a2", UserId: "alice", Side: orderbooks.SELL, Price: 103, Quantity: 1},
+	} {
+		if _, _, err := ob.AddOrder(o, o.Price); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ob.CurrentPrice = 100
+
+	// s1 fires at 100 and trades at 101, which fires s2.
+	s1 := newStopOrder(OrderMessages{OrderId: "s1", UserId: "bob", OrderType: "BUY", OrderKind: orderbooks.STOP_LIMIT, Price: 101, TriggerPrice: 100, Quantity: 1}, 0)
+	s2 := newStopOrder(OrderMessages{OrderId: "s2", UserId: "bob", OrderType: "BUY", OrderKind: orderbooks.STOP_LIMIT, Price: 103, TriggerPrice: 101, Quantity: 1}, 0)
+	ob.Triggers.Add(&s1)
+	ob.Triggers.Add(&s2)
+
+	executions := releaseTriggeredOrders(ob, MarketParams{}, 0)
+	if len(executions) != 2 || executions[0].Stop.Id != "s1" || executions[1].Stop.Id != "s2" {
+		t.Fatalf("executions %+v, want s1 then s2", executions)
+	}
+	for _, exec := range executions {
+		if exec.Err != nil || exec.ExecutedQty != 1 || exec.CancelledQty != 0 {
+			t.Fatalf("%s: executed %d cancelled %d err %v", exec.Stop.Id, exec.ExecutedQty, exec.CancelledQty, exec.Err)
+		}
+	}
+	if ob.CurrentPrice != 103 {
+		t.Fatalf("CurrentPrice %d, want 103", ob.CurrentPrice)
+	}
+}
+
+func TestStopBuyNeverExceedsItsEscrow(t *testing.T) {
+	ob := newTestBook()
+	ob.LastStreamId = "1-0"
+	ask := orderbooks.Order{Id: "a1", UserId: "alice", Side: orderbooks.SELL, Price: 104, Quantity: 5}
+	if _, _, err := ob.AddOrder(ask, ask.Price); err != nil {
+		t.Fatal(err)
+	}
+
+	// The stop locks escrow at the trigger plus a 2% band: 102.
+	stop := newStopOrder(OrderMessages{OrderId: "s1", UserId: "bob", OrderType: "BUY", OrderKind: orderbooks.STOP, TriggerPrice: 100, Quantity: 5}, 200)
+	if stop.Price != 102 {
+		t.Fatalf("stop escrow price %d, want 102", stop.Price)
+	}
+	// By the time it fires the price has moved to 104, whose band would allow 106.
+	ob.CurrentPrice = 104
+	order, err := activateStop(ob, &stop, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Price != 102 || order.TimeInForce != orderbooks.IOC {
+		t.Fatalf("activated at %d %s, want 102 IOC", order.Price, order.TimeInForce)
+	}
+}
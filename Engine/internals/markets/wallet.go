@@ -0,0 +1,199 @@
+package markets
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	usermap "github.com/raiashpanda007/rivon/engine/internals/UserMap"
+)
+
+// The wallet side of an input is retried with a growing wait, from walletRetryMin up to
+// walletRetryMax between attempts, for walletGiveUp before the market stops.
+const (
+	walletRetryMin = 100 * time.Millisecond
+	walletRetryMax = 5 * time.Second
+	walletGiveUp   = 30 * time.Second
+)
+
+const walletQueueSize = 1024
+
+// walletQueue applies the wallet side of a market's inputs to the shared wallet store one
+// batch at a time, in the order the market made them, so a lock always sees what the
+// inputs before it released. The market goes on matching while batches are applied; only
+// a lock, which decides whether an order is taken, is waited for.
+//
+// A batch that still cannot be applied after walletGiveUp fails the queue: every later
+// batch is dropped and the market stops without saving its book, so the next run replays
+// the inputs whose wallet side is missing.
+type walletQueue struct {
+	store    walletStore
+	marketId string
+	ops      chan *walletOp
+	failed   chan struct{} // closed once a batch could not be applied
+	err      error         // why, set before failed is closed
+}
+
+// walletStore is where the queue applies batches: the shared wallet store, see
+// usermap.UserWallet.Apply and PruneOps.
+type walletStore interface {
+	Apply(marketId, opId string, b *usermap.Batch) (int, error)
+	PruneOps(marketId, throughId string) (int, error)
+}
+
+// walletOp is one batch on the queue. done is closed once it is applied or refused.
+type walletOp struct {
+	id     string
+	batch  *usermap.Batch
+	locked int
+	err    error
+	done   chan struct{}
+}
+
+func newWalletQueue(store walletStore, marketId string) *walletQueue {
+	q := &walletQueue{
+		store:    store,
+		marketId: marketId,
+		ops:      make(chan *walletOp, walletQueueSize),
+		failed:   make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *walletQueue) run() {
+	for op := range q.ops {
+		switch {
+		case q.err != nil:
+			op.err = q.err
+		case op.batch != nil:
+			op.locked, op.err = q.apply(op)
+			if op.err != nil && !usermap.Refused(op.err) {
+				slog.Error("Giving up on the wallet side of an input, stopping the market", "marketId", q.marketId, "opId", op.id, "err", op.err)
+				q.err = op.err
+				close(q.failed)
+			}
+		}
+		close(op.done)
+	}
+}
+
+func (q *walletQueue) apply(op *walletOp) (int, error) {
+	giveUp := time.Now().Add(walletGiveUp)
+	delay := walletRetryMin
+	for {
+		locked, err := q.store.Apply(q.marketId, op.id, op.batch)
+		if err == nil || usermap.Refused(err) {
+			return locked, err
+		}
+		if time.Now().After(giveUp) {
+			return 0, fmt.Errorf("apply wallet op %s: %w", op.id, err)
+		}
+		slog.Warn("Unable to apply wallet op, retrying", "marketId", q.marketId, "opId", op.id, "err", err, "retryIn", delay)
+		time.Sleep(delay)
+		delay = min(2*delay, walletRetryMax)
+	}
+}
+
+// submit queues b as the wallet side of the input streamId.
+func (q *walletQueue) submit(streamId string, b *usermap.Batch) *walletOp {
+	op := &walletOp{id: streamId, batch: b, done: make(chan struct{})}
+	if b.Empty() {
+		close(op.done)
+		return op
+	}
+	q.ops <- op
+	return op
+}
+
+// lock applies b, the escrow the input streamId locks before the book sees it, behind
+// every batch queued so far and waits for it. It returns what a LockPosition in b locked,
+// or the refusal.
+func (q *walletQueue) lock(streamId string, b *usermap.Batch) (int, error) {
+	return q.submit(streamId+":lock", b).wait()
+}
+
+// barrier waits until every batch queued so far is applied, and returns the queue's
+// failure if one was not.
+func (q *walletQueue) barrier() error {
+	_, err := q.mark().wait()
+	return err
+}
+
+// mark queues an op with no batch behind every batch queued so far. It is done once they
+// are applied, with the queue's failure if one was not.
+func (q *walletQueue) mark() *walletOp {
+	op := &walletOp{done: make(chan struct{})}
+	q.ops <- op
+	return op
+}
+
+// prune drops the op log entries of the inputs up to throughId, which a stored snapshot
+// covers.
+func (q *walletQueue) prune(throughId string) {
+	dropped, err := q.store.PruneOps(q.marketId, throughId)
+	if err != nil {
+		slog.Error("Failed to prune wallet op log", "marketId", q.marketId, "err", err)
+	} else if dropped > 0 {
+		slog.Info("pruned wallet op log", "marketId", q.marketId, "throughId", throughId, "count", dropped)
+	}
+}
+
+// failure returns why the queue failed, or nil.
+func (q *walletQueue) failure() error {
+	select {
+	case <-q.failed:
+		return q.err
+	default:
+		return nil
+	}
+}
+
+// close lets the queue finish the batches already on it and stop.
+func (q *walletQueue) close() {
+	close(q.ops)
+}
+
+func (op *walletOp) wait() (int, error) {
+	<-op.done
+	return op.locked, op.err
+}
+
+// lockEscrow adds the lock of what an order of qty units at lockPrice needs: money for
+// BUYs, asset units for SELLs.
+func lockEscrow(b *usermap.Batch, marketId, userId string, side orderbooks.OrderSide, lockPrice, qty int) {
+	if side == orderbooks.BUY {
+		b.LockMoney(userId, lockPrice*qty)
+	} else {
+		b.LockAsset(userId, marketId, qty)
+	}
+}
+
+// releaseEscrow hands back the funds/assets held for qty unfilled units to userId.
+// lockPrice is the price the BUY escrow was locked at.
+func releaseEscrow(b *usermap.Batch, marketId, userId string, side orderbooks.OrderSide, lockPrice, qty int) {
+	if qty <= 0 {
+		return
+	}
+	if side == orderbooks.BUY {
+		b.UnlockMoney(userId, qty*lockPrice)
+	} else {
+		b.UnlockAsset(userId, marketId, qty)
+	}
+}
+
+// settleFills moves escrow between both sides of every fill of a taker order. The BUY
+// escrow was locked at lockPrice, so whatever the fills saved goes back to the taker.
+func settleFills(b *usermap.Batch, marketId string, fills []orderbooks.Fills, userId string, side orderbooks.OrderSide, lockPrice int) {
+	for _, f := range fills {
+		if side == orderbooks.BUY {
+			b.Trade(userId, f.OtherUserId, marketId, f.Quantity, f.Price)
+		} else {
+			b.Trade(f.OtherUserId, userId, marketId, f.Quantity, f.Price)
+		}
+	}
+	if side == orderbooks.BUY {
+		b.UnlockMoney(userId, priceImprovement(lockPrice, fills))
+	}
+}
@@ -0,0 +1,35 @@
+package sequencer
+
+import "sync"
+
+// Sequencer numbers the events one market publishes on one channel. Numbers are handed
+// out and published one at a time, so they reach the channel in order and a consumer that
+// finds one missing knows an event was lost.
+type Sequencer struct {
+	gate Gate
+	mu   sync.Mutex
+	last int64
+}
+
+// Gate holds events back until it is safe to publish them, such as until the input they
+// follow from is durable.
+type Gate interface {
+	Wait()
+}
+
+// New starts numbering after last. Every event waits for gate first when it is not nil.
+func New(last int64, gate Gate) *Sequencer {
+	return &Sequencer{gate: gate, last: last}
+}
+
+// Publish sends the next event with send. A failed send still uses its number up, so the
+// lost event shows as a gap.
+func (s *Sequencer) Publish(send func(seq int64) error) error {
+	if s.gate != nil {
+		s.gate.Wait()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last++
+	return send(s.last)
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	orderbooks "github.com/raiashpanda007/rivon/engine/internals/Orderbooks"
+	sequencer "github.com/raiashpanda007/rivon/engine/internals/utils/Sequencer"
 )
 
 type TradeStreamTypes string
@@ -14,8 +15,99 @@ type TradeStreamTypes string
 const (
 	ORDER_UPDATED   TradeStreamTypes = "order_updated"
 	CANCELLED_ORDER TradeStreamTypes = "order_cancelled"
+	ORDER_TRIGGERED TradeStreamTypes = "order_triggered"
+	ORDER_REPLACED  TradeStreamTypes = "order_replaced"
+	MARKET_STATUS   TradeStreamTypes = "market_status"
+	MARKET_SETTLED  TradeStreamTypes = "market_settled"
 )
 
+type fencingTokenKey struct{}
+
+// WithFencingToken marks every TRADES entry written under ctx with the token of the market
+// lease it was written under, so DBWritter can drop the writes of an owner that lost it.
+func WithFencingToken(ctx context.Context, token int64) context.Context {
+	return context.WithValue(ctx, fencingTokenKey{}, token)
+}
+
+// withFencing adds the lease token of ctx, if any, to the fields of a TRADES entry.
+func withFencing(ctx context.Context, values map[string]any) map[string]any {
+	if token, ok := ctx.Value(fencingTokenKey{}).(int64); ok && token > 0 {
+		values["fencingToken"] = token
+	}
+	return values
+}
+
+// tradesSeqKey is a hash of the last sequence number each market wrote to TRADES.
+const tradesSeqKey = "TRADES_SEQ"
+
+type sequenceKey struct{}
+
+// WithSequence numbers every TRADES entry written under ctx for marketId with a "seq"
+// field, carrying on from the last number the market wrote, so DBWritter can tell a lost
+// entry from one written twice. Entries wait for gate, if any, before they are written.
+func WithSequence(ctx context.Context, marketId string, tradeRedisClient *redis.Client, gate sequencer.Gate) context.Context {
+	last, err := tradeRedisClient.HGet(ctx, tradesSeqKey, marketId).Int64()
+	if err != nil && err != redis.Nil {
+		slog.Error("Unable to read the market's TRADES sequence", "marketId", marketId, "error", err)
+	}
+	return context.WithValue(ctx, sequenceKey{}, &marketSequence{marketId: marketId, seq: sequencer.New(last, gate)})
+}
+
+type marketSequence struct {
+	marketId string
+	seq      *sequencer.Sequencer
+}
+
+// publish writes one entry to TRADES with the lease token and the next sequence number of
+// ctx. The number is recorded in the same transaction so a restart carries on from it.
+func publish(ctx context.Context, tradeRedisClient *redis.Client, values map[string]any) error {
+	values = withFencing(ctx, values)
+	ms, ok := ctx.Value(sequenceKey{}).(*marketSequence)
+	if !ok {
+		return tradeRedisClient.XAdd(ctx, &redis.XAddArgs{Stream: "TRADES", Values: values}).Err()
+	}
+	return ms.seq.Publish(func(seq int64) error {
+		values["seq"] = seq
+		pipe := tradeRedisClient.TxPipeline()
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: "TRADES", Values: values})
+		pipe.HSet(ctx, tradesSeqKey, ms.marketId, seq)
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+}
+
+// MarketStatusPublisher records a market status change ("open" / "suspended") on TRADES so
+// DBWritter can update markets.status.
+func MarketStatusPublisher(ctx context.Context, marketId, status string, tradeRedisClient *redis.Client) {
+	err := publish(ctx, tradeRedisClient, map[string]any{
+		"tradeType": string(MARKET_STATUS),
+		"marketId":  marketId,
+		"status":    status,
+	})
+
+	if err != nil {
+		slog.Error("Unable to save the market status on the stream", "marketId", marketId, "error :: ", err)
+	}
+}
+
+// MarketSettledPublisher records a market's settlement on TRADES so DBWritter can pay out the
+// holders in the assets table and close the market. settlementId is the id of the
+// SETTLE_MARKET entry, which lets replay find it as its pivot.
+func MarketSettledPublisher(ctx context.Context, settlementId, marketId string, price int, lastOrderId, lastTradeId string, tradeRedisClient *redis.Client) {
+	err := publish(ctx, tradeRedisClient, map[string]any{
+		"tradeType":   string(MARKET_SETTLED),
+		"marketId":    marketId,
+		"orderId":     settlementId,
+		"price":       price,
+		"lastOrderId": lastOrderId,
+		"lastTradeId": lastTradeId,
+	})
+
+	if err != nil {
+		slog.Error("Unable to save the market settlement on the stream", "marketId", marketId, "error :: ", err)
+	}
+}
+
 func TradeRedisStreamPublisher(
 	ctx context.Context,
 	tradeType TradeStreamTypes,
@@ -34,22 +126,19 @@ func TradeRedisStreamPublisher(
 		return
 	}
 
-	_, err = tradeRedisClient.XAdd(ctx, &redis.XAddArgs{
-		Stream: "TRADES",
-		Values: map[string]any{
-			"tradeType":   string(tradeType),
-			"marketId":    marketId,
-			"fills":       string(fillsJSON),
-			"executedQty": executedQty,
-			"price":       price,
-			"orderId":     orderId,
-			"lastOrderId": lastOrderId,
-			"lastTradeId": lastTradeId,
-			"userId":      userId,
-			"quantity":    quantity,
-			"side":        side,
-		},
-	}).Result()
+	err = publish(ctx, tradeRedisClient, map[string]any{
+		"tradeType":   string(tradeType),
+		"marketId":    marketId,
+		"fills":       string(fillsJSON),
+		"executedQty": executedQty,
+		"price":       price,
+		"orderId":     orderId,
+		"lastOrderId": lastOrderId,
+		"lastTradeId": lastTradeId,
+		"userId":      userId,
+		"quantity":    quantity,
+		"side":        side,
+	})
 
 	if err != nil {
 		slog.Error("Unable to save the update on the stream", "error :: ", err)
@@ -14,13 +14,36 @@ const (
 	WALLET_LOAD         WSInMessageType = "WALLET_LOAD"
 	WALLET_EVICT        WSInMessageType = "WALLET_EVICT"
 	CANCEL_ORDER_WS     WSInMessageType = "CANCEL_ORDER_WS"
+	// DEPTH_RESYNC asks for ORDERBOOK_DATA again after a client missed a DEPTH_DELTA.
+	DEPTH_RESYNC WSInMessageType = "DEPTH_RESYNC"
+	// L3_SUBSCRIBE asks for L3_SNAPSHOT, the book the L3_<marketId> feed continues from.
+	L3_SUBSCRIBE WSInMessageType = "L3_SUBSCRIBE"
+	// DEPTH_SUBSCRIBE with an IntervalMs, and BOOK_TICKER_SUBSCRIBE, keep sending updates
+	// to the connection until it unsubscribes.
+	DEPTH_UNSUBSCRIBE       WSInMessageType = "DEPTH_UNSUBSCRIBE"
+	BOOK_TICKER_SUBSCRIBE   WSInMessageType = "BOOK_TICKER_SUBSCRIBE"
+	BOOK_TICKER_UNSUBSCRIBE WSInMessageType = "BOOK_TICKER_UNSUBSCRIBE"
 )
 
 const (
-	ORDERBOOK_DATA   WSOutMessageType = "ORDERBOOK_DATA"
-	DEPTH_DATA       WSOutMessageType = "DEPTH_DATA"
-	ORDERBOOK_UPDATE WSOutMessageType = "ORDERBOOK_UPDATE"
-	ORDER_CANCELLED  WSOutMessageType = "ORDER_CANCELLED"
+	ORDERBOOK_DATA  WSOutMessageType = "ORDERBOOK_DATA"
+	DEPTH_DATA      WSOutMessageType = "DEPTH_DATA"
+	DEPTH_DELTA     WSOutMessageType = "DEPTH_DELTA"
+	ORDER_CANCELLED WSOutMessageType = "ORDER_CANCELLED"
+	MARKET_HALTED   WSOutMessageType = "MARKET_HALTED"
+	MARKET_RESUMED  WSOutMessageType = "MARKET_RESUMED"
+	AUCTION_STATE   WSOutMessageType = "AUCTION_STATE"
+	MARKET_SETTLED  WSOutMessageType = "MARKET_SETTLED"
+	L3_SNAPSHOT     WSOutMessageType = "L3_SNAPSHOT"
+	BOOK_TICKER     WSOutMessageType = "BOOK_TICKER"
+	// Private events of a user's orders and wallet, ORDER_CANCELLED included. They carry
+	// the owner's UserId and go out on WS_USER_<userId>, which every WS server subscribes
+	// to, so each connection of that user gets them whatever market it watches.
+	ORDER_ACCEPTED         WSOutMessageType = "ORDER_ACCEPTED"
+	ORDER_PARTIALLY_FILLED WSOutMessageType = "ORDER_PARTIALLY_FILLED"
+	ORDER_FILLED           WSOutMessageType = "ORDER_FILLED"
+	ORDER_REJECTED         WSOutMessageType = "ORDER_REJECTED"
+	WALLET_UPDATED         WSOutMessageType = "WALLET_UPDATED"
 )
 
 type WSOutMessageStruct struct {
@@ -28,6 +51,22 @@ type WSOutMessageStruct struct {
 	Payload      WSOutPayload     `json:"payload"`
 	UserId       string           `json:"userId,omitempty"`
 	ConnectionId string           `json:"connectionId"`
+	// Epoch and Seq number the messages of one market run on its WS_OUT channel.
+	Epoch int64 `json:"epoch,omitempty"`
+	Seq   int64 `json:"seq,omitempty"`
+}
+
+// Private reports whether m is an event of one user's orders or wallet, published on the
+// user's channel instead of the market's.
+func (m WSOutMessageStruct) Private() bool {
+	if m.UserId == "" {
+		return false
+	}
+	switch m.MessageType {
+	case ORDER_ACCEPTED, ORDER_PARTIALLY_FILLED, ORDER_FILLED, ORDER_CANCELLED, ORDER_REJECTED, WALLET_UPDATED:
+		return true
+	}
+	return false
 }
 
 // WSInMessageStruct carries an inbound WS message.
@@ -39,19 +78,34 @@ type WSInMessageStruct struct {
 	ConnectionId string          `json:"ConnectionId"`
 	OrderId      string          `json:"OrderId,omitempty"`
 	CancelQty    int             `json:"CancelQty,omitempty"`
+	// Subscription parameters of DEPTH_SUBSCRIBE and BOOK_TICKER_SUBSCRIBE. Depth is the
+	// number of levels per side (5, 10 or 50; 0 for all), Granularity groups prices into
+	// buckets that wide, and IntervalMs is the least time between two updates; without it
+	// DEPTH_SUBSCRIBE answers once.
+	Depth       int `json:"Depth,omitempty"`
+	Granularity int `json:"Granularity,omitempty"`
+	IntervalMs  int `json:"IntervalMs,omitempty"`
 }
 
+// OrderbookPayload is the full book as of DEPTH_DELTA DepthSeq: a client applies the deltas
+// numbered after it.
 type OrderbookPayload struct {
 	BidDepth     map[int]int `json:"bidDepth"`
 	AskDepth     map[int]int `json:"askDepth"`
 	CurrentPrice int         `json:"currentPrice"`
+	DepthSeq     int64       `json:"depthSeq"`
 }
 
 func (o OrderbookPayload) wsPaylod() {}
 
+// DepthPayload is the depth of the levels a DEPTH_SUBSCRIBE asked for: the best Limit of
+// each side, or all of them when Limit is 0, with prices grouped into Granularity wide
+// buckets, bids rounded down and asks up.
 type DepthPayload struct {
-	BidDepth map[int]int `json:"bidDepth"`
-	AskDepth map[int]int `json:"askDepth"`
+	BidDepth    map[int]int `json:"bidDepth"`
+	AskDepth    map[int]int `json:"askDepth"`
+	Limit       int         `json:"limit,omitempty"`
+	Granularity int         `json:"granularity,omitempty"`
 }
 
 func (d DepthPayload) wsPaylod() {}
@@ -63,14 +117,24 @@ type PublicFill struct {
 	TradeId  string `json:"tradeId"`
 }
 
-type OrderbookUpdatePayload struct {
-	BidDepth     map[int]int  `json:"bidDepth"`
-	AskDepth     map[int]int  `json:"askDepth"`
+// LevelDelta is the quantity now resting at Price; 0 removes the level.
+type LevelDelta struct {
+	Price    int `json:"price"`
+	Quantity int `json:"quantity"`
+}
+
+// DepthDeltaPayload carries the levels one event changed. DepthSeq numbers the deltas of
+// one market run, under the Epoch of the message: a client that finds one missing sends
+// DEPTH_RESYNC and applies the deltas after the DepthSeq of the ORDERBOOK_DATA it gets back.
+type DepthDeltaPayload struct {
+	DepthSeq     int64        `json:"depthSeq"`
+	Bids         []LevelDelta `json:"bids"`
+	Asks         []LevelDelta `json:"asks"`
 	CurrentPrice int          `json:"currentPrice"`
 	Fills        []PublicFill `json:"fills"`
 }
 
-func (o OrderbookUpdatePayload) wsPaylod() {}
+func (d DepthDeltaPayload) wsPaylod() {}
 
 type OrderCancelledPayload struct {
 	OrderId      string `json:"orderId"`
@@ -79,3 +143,99 @@ type OrderCancelledPayload struct {
 }
 
 func (o OrderCancelledPayload) wsPaylod() {}
+
+// OrderEventPayload is one of the user's orders as it was accepted onto the book, or the
+// reason it was rejected.
+type OrderEventPayload struct {
+	OrderId  string `json:"orderId"`
+	MarketId string `json:"marketId"`
+	Side     string `json:"side,omitempty"`
+	Price    int    `json:"price,omitempty"`
+	Quantity int    `json:"quantity,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (o OrderEventPayload) wsPaylod() {}
+
+// OrderFillPayload is one fill of the user's order. Remaining is what of the order was still
+// unfilled after it; ORDER_FILLED carries the fill that left nothing.
+type OrderFillPayload struct {
+	OrderId   string `json:"orderId"`
+	MarketId  string `json:"marketId"`
+	Side      string `json:"side"`
+	Price     int    `json:"price"`
+	Quantity  int    `json:"quantity"`
+	TradeId   string `json:"tradeId"`
+	Remaining int    `json:"remaining"`
+	Maker     bool   `json:"maker"`
+}
+
+func (o OrderFillPayload) wsPaylod() {}
+
+// WalletPayload is the user's available balance and the quantity held of every market.
+type WalletPayload struct {
+	Balance int            `json:"balance"`
+	Assets  map[string]int `json:"assets"`
+}
+
+func (w WalletPayload) wsPaylod() {}
+
+// MarketStatusPayload is broadcast with MARKET_HALTED and MARKET_RESUMED. ResumesAt is the
+// unix ms the halt lifts at and is 0 once the market has resumed.
+type MarketStatusPayload struct {
+	ReferencePrice int   `json:"referencePrice"`
+	CurrentPrice   int   `json:"currentPrice"`
+	ResumesAt      int64 `json:"resumesAt,omitempty"`
+}
+
+func (m MarketStatusPayload) wsPaylod() {}
+
+// AuctionPayload is broadcast with AUCTION_STATE while a call auction is open: the price the
+// book would uncross at right now and the volume that would trade there. EndsAt is 0 until
+// an opening auction receives its first order.
+type AuctionPayload struct {
+	IndicativePrice  int   `json:"indicativePrice"`
+	IndicativeVolume int   `json:"indicativeVolume"`
+	EndsAt           int64 `json:"endsAt"`
+	Opening          bool  `json:"opening"`
+}
+
+func (a AuctionPayload) wsPaylod() {}
+
+// MarketSettledPayload is broadcast with MARKET_SETTLED once the market has closed and every
+// holder has been paid SettlementPrice per unit.
+type MarketSettledPayload struct {
+	SettlementPrice int `json:"settlementPrice"`
+}
+
+func (m MarketSettledPayload) wsPaylod() {}
+
+// L3Order is an order resting on the book as the L3 feed shows it, under its public handle.
+type L3Order struct {
+	Handle   string `json:"handle"`
+	Price    int    `json:"price"`
+	Quantity int    `json:"quantity"`
+}
+
+// L3SnapshotPayload is every resting order, best price first and in queue order, as of L3
+// event Seq of Epoch: a consumer applies the events numbered after it.
+type L3SnapshotPayload struct {
+	Epoch int64     `json:"epoch"`
+	Seq   int64     `json:"seq"`
+	Bids  []L3Order `json:"bids"`
+	Asks  []L3Order `json:"asks"`
+}
+
+func (l L3SnapshotPayload) wsPaylod() {}
+
+// BookTickerPayload is the best bid and ask and the quantity resting at each; 0 for a side
+// with nothing on it.
+type BookTickerPayload struct {
+	BidPrice int `json:"bidPrice"`
+	BidQty   int `json:"bidQty"`
+	AskPrice int `json:"askPrice"`
+	AskQty   int `json:"askQty"`
+}
+
+func (b BookTickerPayload) wsPaylod() {}
@@ -0,0 +1,7 @@
+BEGIN;
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_price_check;
+-- NOT VALID so existing market order rows with price 0 do not block the rollback.
+ALTER TABLE orders ADD CONSTRAINT orders_price_check CHECK(price > 0) NOT VALID;
+ALTER TABLE orders DROP COLUMN IF EXISTS kind;
+DROP TYPE IF EXISTS order_kind;
+COMMIT;
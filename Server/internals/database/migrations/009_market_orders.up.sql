@@ -0,0 +1,10 @@
+BEGIN;
+CREATE TYPE order_kind AS ENUM(
+  'LIMIT',
+  'MARKET'
+);
+ALTER TABLE orders ADD COLUMN kind order_kind NOT NULL DEFAULT 'LIMIT';
+-- Market orders carry no limit price; the Engine derives one from its protection band.
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_price_check;
+ALTER TABLE orders ADD CONSTRAINT orders_price_check CHECK(price > 0 OR kind = 'MARKET');
+COMMIT;
@@ -0,0 +1,6 @@
+-- Postgres cannot drop enum values, so 'STOP' and 'STOP_LIMIT' stay on order_kind.
+BEGIN;
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_price_check;
+ALTER TABLE orders ADD CONSTRAINT orders_price_check CHECK(price > 0 OR kind = 'MARKET') NOT VALID;
+ALTER TABLE orders DROP COLUMN IF EXISTS trigger_price;
+COMMIT;
@@ -0,0 +1,9 @@
+-- ALTER TYPE ... ADD VALUE cannot run inside a transaction block
+ALTER TYPE order_kind ADD VALUE IF NOT EXISTS 'STOP';
+ALTER TYPE order_kind ADD VALUE IF NOT EXISTS 'STOP_LIMIT';
+BEGIN;
+ALTER TABLE orders ADD COLUMN trigger_price BIGINT CHECK(trigger_price > 0);
+-- STOP orders, like market orders, carry no limit price.
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_price_check;
+ALTER TABLE orders ADD CONSTRAINT orders_price_check CHECK(price > 0 OR kind IN ('MARKET', 'STOP'));
+COMMIT;
@@ -0,0 +1,4 @@
+BEGIN;
+ALTER TABLE markets DROP COLUMN IF EXISTS matching_policy;
+DROP TYPE IF EXISTS matching_policy;
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+CREATE TYPE matching_policy AS ENUM(
+  'FIFO',
+  'PRO_RATA',
+  'PRO_RATA_TOP_FIFO'
+);
+-- Read by the Engine at startup to pick how each market's price levels are allocated.
+ALTER TABLE markets ADD COLUMN matching_policy matching_policy NOT NULL DEFAULT 'FIFO';
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+ALTER TABLE markets
+  DROP COLUMN IF EXISTS max_order_qty,
+  DROP COLUMN IF EXISTS max_price,
+  DROP COLUMN IF EXISTS min_price,
+  DROP COLUMN IF EXISTS lot_size,
+  DROP COLUMN IF EXISTS tick_size;
+COMMIT;
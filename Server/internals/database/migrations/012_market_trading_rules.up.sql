@@ -0,0 +1,10 @@
+BEGIN;
+-- Per-market order rules enforced by the API before the XAdd and again by the Engine.
+-- max_price and max_order_qty of 0 mean no upper limit.
+ALTER TABLE markets
+  ADD COLUMN tick_size BIGINT NOT NULL DEFAULT 1 CHECK (tick_size > 0),
+  ADD COLUMN lot_size BIGINT NOT NULL DEFAULT 1 CHECK (lot_size > 0),
+  ADD COLUMN min_price BIGINT NOT NULL DEFAULT 1 CHECK (min_price > 0),
+  ADD COLUMN max_price BIGINT NOT NULL DEFAULT 0 CHECK (max_price = 0 OR max_price >= min_price),
+  ADD COLUMN max_order_qty BIGINT NOT NULL DEFAULT 0 CHECK (max_order_qty >= 0);
+COMMIT;
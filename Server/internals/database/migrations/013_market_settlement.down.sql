@@ -0,0 +1,13 @@
+BEGIN;
+DELETE FROM transactions WHERE type = 'settle';
+ALTER TABLE transactions DROP CONSTRAINT valid_balance_transition;
+ALTER TABLE transactions ADD CONSTRAINT valid_balance_transition CHECK (
+  (type = 'credit' AND balance_after = balance_before + amount)
+  OR
+  (type = 'debit' AND balance_after = balance_before - amount)
+);
+ALTER TABLE transactions DROP COLUMN IF EXISTS market_id;
+ALTER TABLE markets
+  DROP COLUMN IF EXISTS settled_at,
+  DROP COLUMN IF EXISTS settlement_price;
+COMMIT;
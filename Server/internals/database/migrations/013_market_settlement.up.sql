@@ -0,0 +1,15 @@
+BEGIN;
+-- Set by DBWritter when the Engine settles a market; the market is 'closed' from then on.
+ALTER TABLE markets
+  ADD COLUMN settlement_price BIGINT CHECK (settlement_price >= 0),
+  ADD COLUMN settled_at TIMESTAMPTZ;
+-- Settlement payouts have no order or trade, so they point at their market directly.
+ALTER TABLE transactions ADD COLUMN market_id UUID REFERENCES markets(id);
+-- A 'settle' transaction credits the wallet.
+ALTER TABLE transactions DROP CONSTRAINT valid_balance_transition;
+ALTER TABLE transactions ADD CONSTRAINT valid_balance_transition CHECK (
+  (type IN ('credit', 'settle') AND balance_after = balance_before + amount)
+  OR
+  (type = 'debit' AND balance_after = balance_before - amount)
+);
+COMMIT;
@@ -0,0 +1,10 @@
+BEGIN;
+-- Order book snapshots, written by the Engine when SNAPSHOT_BACKEND is postgres.
+CREATE TABLE market_snapshots (
+  market_id UUID NOT NULL REFERENCES markets(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  data BYTEA NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (market_id, name)
+);
+COMMIT;
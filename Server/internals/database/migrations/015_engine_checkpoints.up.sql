@@ -0,0 +1,11 @@
+BEGIN;
+-- Checkpoints of every market an Engine node runs together with its wallets, written when
+-- SNAPSHOT_BACKEND is postgres.
+CREATE TABLE engine_checkpoints (
+  node_id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  data BYTEA NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (node_id, name)
+);
+COMMIT;
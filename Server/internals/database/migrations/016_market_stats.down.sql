@@ -0,0 +1,6 @@
+BEGIN;
+ALTER TABLE markets
+  DROP COLUMN IF EXISTS trade_count_24h,
+  DROP COLUMN IF EXISTS low_24h,
+  DROP COLUMN IF EXISTS high_24h;
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+-- Rolling 24h statistics DBWritter keeps next to last_price, volume_24h, total_volume and
+-- open_price_24h.
+ALTER TABLE markets
+  ADD COLUMN high_24h BIGINT NOT NULL DEFAULT 0,
+  ADD COLUMN low_24h BIGINT NOT NULL DEFAULT 0,
+  ADD COLUMN trade_count_24h BIGINT NOT NULL DEFAULT 0;
+COMMIT;
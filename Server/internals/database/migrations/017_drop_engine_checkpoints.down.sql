@@ -0,0 +1,9 @@
+BEGIN;
+CREATE TABLE engine_checkpoints (
+  node_id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  data BYTEA NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (node_id, name)
+);
+COMMIT;
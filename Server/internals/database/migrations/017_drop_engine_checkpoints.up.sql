@@ -0,0 +1,5 @@
+BEGIN;
+-- Markets are checkpointed one at a time in market_snapshots, with their wallets in the
+-- shared Redis store; nothing writes node-wide checkpoints any more.
+DROP TABLE IF EXISTS engine_checkpoints;
+COMMIT;
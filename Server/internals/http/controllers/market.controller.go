@@ -5,6 +5,7 @@ import (
 	"errors"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
@@ -21,7 +22,10 @@ import (
 type MarketController interface {
 	GetMarkets(res http.ResponseWriter, req *http.Request)
 	PlaceOrder(res http.ResponseWriter, req *http.Request)
+	ReplaceOrder(res http.ResponseWriter, req *http.Request)
 	GetUserOpenOrders(res http.ResponseWriter, req *http.Request)
+	SettleMarket(res http.ResponseWriter, req *http.Request)
+	SuspendMarket(res http.ResponseWriter, req *http.Request)
 }
 
 type marketControllerUtils struct {
@@ -142,12 +146,58 @@ func (r *marketControllerUtils) PlaceOrder(res http.ResponseWriter, req *http.Re
 		return
 	}
 
-	if order.Quantity <= 0 || order.Price <= 0 || order.OrderType != "BUY" && order.OrderType != "SELL" {
+	isMarketOrder := order.OrderKind == types.MARKET_ORDER
+	isStopOrder := order.OrderKind == types.STOP_ORDER || order.OrderKind == types.STOP_LIMIT_ORDER
+	if order.OrderKind != "" && order.OrderKind != types.LIMIT_ORDER && !isMarketOrder && !isStopOrder {
+		utils.WriteJson(res, http.StatusBadRequest, utils.GenerateError(utils.ErrBadRequest, errors.New("Invalid orderKind")))
+		return
+	}
+	if isMarketOrder && order.TimeInForce == types.TIF_GTD {
+		utils.WriteJson(res, http.StatusBadRequest, utils.GenerateError(utils.ErrBadRequest, errors.New("Market orders never rest and cannot be GTD")))
+		return
+	}
+	if isStopOrder && order.TriggerPrice <= 0 {
+		utils.WriteJson(res, http.StatusBadRequest, utils.GenerateError(utils.ErrBadRequest, errors.New("triggerPrice is required for stop orders")))
+		return
+	}
+	if !isStopOrder && order.TriggerPrice != 0 {
+		utils.WriteJson(res, http.StatusBadRequest, utils.GenerateError(utils.ErrBadRequest, errors.New("triggerPrice is only valid for stop orders")))
+		return
+	}
+	priceOptional := isMarketOrder || order.OrderKind == types.STOP_ORDER
+	if order.PostOnly && (isMarketOrder || isStopOrder || order.TimeInForce == types.TIF_IOC || order.TimeInForce == types.TIF_FOK) {
+		utils.WriteJson(res, http.StatusBadRequest, utils.GenerateError(utils.ErrBadRequest, errors.New("postOnly is only valid for resting LIMIT orders")))
+		return
+	}
+	switch order.STPMode {
+	case "", types.STP_CANCEL_NEWEST, types.STP_CANCEL_OLDEST, types.STP_CANCEL_BOTH, types.STP_DECREMENT_AND_CANCEL:
+	default:
+		utils.WriteJson(res, http.StatusBadRequest, utils.GenerateError(utils.ErrBadRequest, errors.New("Invalid stpMode")))
+		return
+	}
+	if order.ReduceOnly && order.OrderType != types.SELL_ORDER {
+		utils.WriteJson(res, http.StatusBadRequest, utils.GenerateError(utils.ErrBadRequest, errors.New("reduceOnly is only valid for SELL orders")))
+		return
+	}
+
+	if order.Quantity <= 0 || (order.Price <= 0 && !priceOptional) || order.OrderType != "BUY" && order.OrderType != "SELL" {
 		slog.Error("Invalid order parameters", "order", order)
 		utils.WriteJson(res, http.StatusBadRequest, utils.GenerateError(utils.ErrBadRequest, errors.New("Invalid order parameters")))
 		return
 	}
-	fill, err := r.svc.PlaceOrder(req.Context(), userCred.Id, order.MarketId, order.Price, order.Quantity, order.OrderType)
+	switch order.TimeInForce {
+	case "", types.TIF_GTC, types.TIF_IOC, types.TIF_FOK:
+	case types.TIF_GTD:
+		if order.ExpiresAt <= time.Now().UnixMilli() {
+			utils.WriteJson(res, http.StatusBadRequest, utils.GenerateError(utils.ErrBadRequest, errors.New("expiresAt must be in the future for GTD orders")))
+			return
+		}
+	default:
+		utils.WriteJson(res, http.StatusBadRequest, utils.GenerateError(utils.ErrBadRequest, errors.New("Invalid timeInForce")))
+		return
+	}
+
+	fill, err := r.svc.PlaceOrder(req.Context(), userCred.Id, order)
 
 	if err != nil {
 		slog.Error("Error placing order", "error", err)
@@ -156,7 +206,7 @@ func (r *marketControllerUtils) PlaceOrder(res http.ResponseWriter, req *http.Re
 	}
 
 	if fill.Error != "" {
-		utils.WriteJson(res, http.StatusPaymentRequired, utils.GenerateError(utils.ErrBadRequest, errors.New(fill.Error)))
+		writeOrderRejection(res, fill)
 		return
 	}
 
@@ -168,6 +218,9 @@ func (r *marketControllerUtils) PlaceOrder(res http.ResponseWriter, req *http.Re
 	case int64(fill.ExecutedQuantity) >= order.Quantity:
 		status = "filled"
 		message = "Order filled successfully"
+	case int64(fill.ExecutedQuantity+fill.CancelledQuantity) >= order.Quantity:
+		status = "cancelled"
+		message = "Order matched what it could; the unfilled quantity was cancelled"
 	case fill.ExecutedQuantity > 0:
 		status = "partially_filled"
 		message = "Order partially filled; remaining quantity queued in the order book"
@@ -190,6 +243,88 @@ func (r *marketControllerUtils) PlaceOrder(res http.ResponseWriter, req *http.Re
 	})
 }
 
+// writeOrderRejection answers an order the API or Engine refused. Balance failures keep their
+// 402; every other reason is a 400 carrying the reason code.
+func writeOrderRejection(res http.ResponseWriter, fill types.FillResult) {
+	statusCode := http.StatusBadRequest
+	if fill.Reason == types.REJECT_INSUFFICIENT_BALANCE {
+		statusCode = http.StatusPaymentRequired
+	}
+	utils.WriteJson(res, statusCode, utils.Response[types.PlaceOrderResponse]{
+		Status:  statusCode,
+		Heading: "Order Rejected",
+		Message: fill.Error,
+		Data: types.PlaceOrderResponse{
+			OrderId:          fill.OrderId,
+			ExecutedQuantity: fill.ExecutedQuantity,
+			Fills:            fill.Fills,
+			Status:           "rejected",
+			Message:          fill.Error,
+			Reason:           fill.Reason,
+		},
+	})
+}
+
+func (r *marketControllerUtils) ReplaceOrder(res http.ResponseWriter, req *http.Request) {
+	userCred, ok := req.Context().Value("USER").(*auth.User)
+	if !ok {
+		utils.WriteJson(res, http.StatusForbidden, utils.GenerateError(utils.ErrUnauthorized, errors.New("You are not authorized to replace an order")))
+		return
+	}
+	if userCred.Verified == false {
+		utils.WriteJson(res, http.StatusForbidden, utils.GenerateError(utils.ErrUnauthorized, errors.New("Please verify your email to replace an order")))
+		return
+	}
+
+	var replace types.ReplaceOrderRequest
+	if err := json.NewDecoder(req.Body).Decode(&replace); err != nil {
+		slog.Error("Error parsing replace order details", "error", err)
+		utils.WriteJson(res, http.StatusBadRequest, utils.GenerateError(utils.ErrBadRequest, errors.New("Invalid replace order details")))
+		return
+	}
+	if replace.OrderId == uuid.Nil || replace.MarketId == uuid.Nil || replace.Price <= 0 || replace.Quantity <= 0 {
+		utils.WriteJson(res, http.StatusBadRequest, utils.GenerateError(utils.ErrBadRequest, errors.New("Invalid replace order parameters")))
+		return
+	}
+
+	fill, err := r.svc.ReplaceOrder(req.Context(), userCred.Id, replace)
+	if err != nil {
+		slog.Error("Error replacing order", "error", err)
+		utils.WriteJson(res, http.StatusInternalServerError, utils.GenerateError(utils.ErrInternal, errors.New("Replace order processing interrupted")))
+		return
+	}
+	if fill.Error != "" {
+		writeOrderRejection(res, fill)
+		return
+	}
+
+	var status, message string
+	switch {
+	case fill.Fills == nil:
+		status = "replace_pending"
+		message = "Replace request accepted but engine did not respond in time"
+	case int64(fill.ExecutedQuantity) >= replace.Quantity:
+		status = "filled"
+		message = "Order replaced and filled"
+	default:
+		status = "replaced"
+		message = "Order replaced"
+	}
+
+	utils.WriteJson(res, http.StatusOK, utils.Response[types.PlaceOrderResponse]{
+		Status:  200,
+		Heading: "Order Replaced",
+		Message: message,
+		Data: types.PlaceOrderResponse{
+			OrderId:          replace.OrderId.String(),
+			ExecutedQuantity: fill.ExecutedQuantity,
+			Fills:            fill.Fills,
+			Status:           status,
+			Message:          message,
+		},
+	})
+}
+
 func (r *marketControllerUtils) GetUserOpenOrders(res http.ResponseWriter, req *http.Request) {
 	userCred, ok := req.Context().Value("USER").(*auth.User)
 	if !ok {
@@ -214,3 +349,108 @@ func (r *marketControllerUtils) GetUserOpenOrders(res http.ResponseWriter, req *
 		Status:  http.StatusOK,
 	})
 }
+
+// SettleMarket closes a market at its final price. Admin only; see AdminOnlyMiddleware.
+func (r *marketControllerUtils) SettleMarket(res http.ResponseWriter, req *http.Request) {
+	userCred, ok := req.Context().Value("USER").(*auth.User)
+	if !ok {
+		utils.WriteJson(res, http.StatusForbidden, utils.GenerateError(utils.ErrUnauthorized, errors.New("You are not authorized to settle a market")))
+		return
+	}
+
+	var settle types.SettleMarketRequest
+	if err := json.NewDecoder(req.Body).Decode(&settle); err != nil {
+		slog.Error("Error parsing settlement details", "error", err)
+		utils.WriteJson(res, http.StatusBadRequest, utils.GenerateError(utils.ErrBadRequest, errors.New("Invalid settlement details")))
+		return
+	}
+	if settle.MarketId == uuid.Nil || settle.Price < 0 {
+		utils.WriteJson(res, http.StatusBadRequest, utils.GenerateError(utils.ErrBadRequest, errors.New("Invalid settlement parameters")))
+		return
+	}
+
+	fill, err := r.svc.SettleMarket(req.Context(), userCred.Id, settle.MarketId, settle.Price)
+	if err != nil {
+		slog.Error("Error settling market", "error", err)
+		utils.WriteJson(res, http.StatusInternalServerError, utils.GenerateError(utils.ErrInternal, errors.New("Market settlement processing interrupted")))
+		return
+	}
+	if fill.Error != "" {
+		utils.WriteJson(res, http.StatusConflict, utils.Response[types.SettleMarketResponse]{
+			Status:  http.StatusConflict,
+			Heading: "Settlement Rejected",
+			Message: fill.Error,
+			Data: types.SettleMarketResponse{
+				SettlementId: fill.OrderId,
+				MarketId:     settle.MarketId.String(),
+				Price:        settle.Price,
+				Status:       "rejected",
+				Message:      fill.Error,
+				Reason:       fill.Reason,
+			},
+		})
+		return
+	}
+
+	status := "settled"
+	message := "Market settled and holders paid out"
+	if fill.Fills == nil {
+		status = "settle_pending"
+		message = "Settlement accepted but engine did not respond in time; the market closes once it does"
+	}
+
+	utils.WriteJson(res, http.StatusOK, utils.Response[types.SettleMarketResponse]{
+		Status:  200,
+		Heading: "Market Settled",
+		Message: message,
+		Data: types.SettleMarketResponse{
+			SettlementId: fill.OrderId,
+			MarketId:     settle.MarketId.String(),
+			Price:        settle.Price,
+			Status:       status,
+			Message:      message,
+		},
+	})
+}
+
+// SuspendMarket halts a market until the circuit breaker cooldown has run. Admin only; the
+// Engine applies the halt asynchronously.
+func (r *marketControllerUtils) SuspendMarket(res http.ResponseWriter, req *http.Request) {
+	var suspend types.SuspendMarketRequest
+	if err := json.NewDecoder(req.Body).Decode(&suspend); err != nil || suspend.MarketId == uuid.Nil {
+		utils.WriteJson(res, http.StatusBadRequest, utils.GenerateError(utils.ErrBadRequest, errors.New("Invalid suspension details")))
+		return
+	}
+
+	reason, errType, err := r.svc.SuspendMarket(req.Context(), suspend.MarketId)
+	if errType != utils.NoError {
+		utils.WriteJson(res, utils.ErrorMap[errType].StatusCode, utils.GenerateError(errType, err))
+		return
+	}
+	if err != nil {
+		utils.WriteJson(res, http.StatusConflict, utils.Response[types.SuspendMarketResponse]{
+			Status:  http.StatusConflict,
+			Heading: "Suspension Rejected",
+			Message: err.Error(),
+			Data: types.SuspendMarketResponse{
+				MarketId: suspend.MarketId.String(),
+				Status:   "rejected",
+				Message:  err.Error(),
+				Reason:   reason,
+			},
+		})
+		return
+	}
+
+	message := "Suspension sent to the engine"
+	utils.WriteJson(res, http.StatusAccepted, utils.Response[types.SuspendMarketResponse]{
+		Status:  http.StatusAccepted,
+		Heading: "Market Suspending",
+		Message: message,
+		Data: types.SuspendMarketResponse{
+			MarketId: suspend.MarketId.String(),
+			Status:   "suspend_pending",
+			Message:  message,
+		},
+	})
+}
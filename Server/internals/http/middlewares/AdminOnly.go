@@ -0,0 +1,24 @@
+package middlewares
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/raiashpanda007/rivon/internals/services/auth"
+	"github.com/raiashpanda007/rivon/internals/utils"
+)
+
+// AdminOnlyMiddleware lets through only admin users. It must run after the auth middleware,
+// which puts the verified user in the request context.
+func AdminOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		user, ok := req.Context().Value("USER").(*auth.User)
+		if !ok || user.Type != "admin" {
+			slog.Warn("Non admin user tried to reach an admin route", "path", req.URL.Path)
+			utils.WriteJson(res, http.StatusForbidden, utils.GenerateError(utils.ErrForBidden, errors.New("Only admins can use this service")))
+			return
+		}
+		next.ServeHTTP(res, req)
+	})
+}
@@ -10,6 +10,7 @@ import (
 
 type Middlewares struct {
 	AuthVerifyMiddleware func(http.Handler) http.Handler
+	AdminOnlyMiddleware  func(http.Handler) http.Handler
 }
 
 func NewMiddlewares(cfg *config.Config, Db *pgxpool.Pool) Middlewares {
@@ -17,5 +18,6 @@ func NewMiddlewares(cfg *config.Config, Db *pgxpool.Pool) Middlewares {
 	verifyMiddleware := VerifyMiddleware(tokenServices)
 	return Middlewares{
 		AuthVerifyMiddleware: verifyMiddleware,
+		AdminOnlyMiddleware:  AdminOnlyMiddleware,
 	}
 }
@@ -13,6 +13,9 @@ func NewMarketRoutes(cfg *config.Config, pgDb *pgxpool.Pool, Controllers control
 	Middlewares := middlewares.NewMiddlewares(cfg, pgDb)
 	router.Get("/", Controllers.GetMarkets)
 	router.With(Middlewares.AuthVerifyMiddleware).Post("/create-order", Controllers.PlaceOrder)
+	router.With(Middlewares.AuthVerifyMiddleware).Post("/replace-order", Controllers.ReplaceOrder)
 	router.With(Middlewares.AuthVerifyMiddleware).Get("/open-orders", Controllers.GetUserOpenOrders)
+	router.With(Middlewares.AuthVerifyMiddleware, Middlewares.AdminOnlyMiddleware).Post("/settle", Controllers.SettleMarket)
+	router.With(Middlewares.AuthVerifyMiddleware, Middlewares.AdminOnlyMiddleware).Post("/suspend", Controllers.SuspendMarket)
 	return router
 }
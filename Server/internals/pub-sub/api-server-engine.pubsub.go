@@ -34,6 +34,11 @@ func (r *pubsub) Subscribe(ctx context.Context, stream string) error {
 	}
 	slog.Info("PubSub: subscribed", "stream", stream)
 
+	// Messages are numbered per market within each Engine run (epoch); a repeat is dropped and
+	// a skipped number means a reply was lost and its request will time out.
+	type position struct{ epoch, seq int64 }
+	last := map[string]position{}
+
 	ch := sub.Channel()
 	for {
 		select {
@@ -47,11 +52,26 @@ func (r *pubsub) Subscribe(ctx context.Context, stream string) error {
 				slog.Error("PubSub: unmarshal failed", "payload", msg.Payload, "error", err)
 				continue
 			}
+			if orderMsg.Seq > 0 {
+				prev, seen := last[orderMsg.MarketId]
+				if seen && prev.epoch == orderMsg.Epoch {
+					if orderMsg.Seq <= prev.seq {
+						slog.Warn("PubSub: dropping duplicate message", "marketId", orderMsg.MarketId, "seq", orderMsg.Seq)
+						continue
+					}
+					if orderMsg.Seq > prev.seq+1 {
+						slog.Error("PubSub: messages lost", "marketId", orderMsg.MarketId, "from", prev.seq+1, "to", orderMsg.Seq-1)
+					}
+				}
+				last[orderMsg.MarketId] = position{epoch: orderMsg.Epoch, seq: orderMsg.Seq}
+			}
 			r.registry.Resolve(orderMsg.OrderId, types.FillResult{
-				OrderId:          orderMsg.OrderId,
-				ExecutedQuantity: orderMsg.ExecutedQuantity,
-				Fills:            orderMsg.Fills,
-				Error:            orderMsg.Error,
+				OrderId:           orderMsg.OrderId,
+				ExecutedQuantity:  orderMsg.ExecutedQuantity,
+				CancelledQuantity: orderMsg.CancelledQuantity,
+				Fills:             orderMsg.Fills,
+				Error:             orderMsg.Error,
+				Reason:            orderMsg.Reason,
 			})
 		case <-ctx.Done():
 			slog.Info("PubSub: context cancelled, stopping subscriber")
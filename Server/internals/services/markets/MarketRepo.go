@@ -24,7 +24,8 @@ type MarketRepo interface {
 	CreateMarket(ctx context.Context, teamId uuid.UUID, marketName, marketCode string, lastPrice, volume24H, totalVolume, openPrice24H int64) (types.MarketTable, error)
 	GetAllMarkets(ctx context.Context, teamDetails bool) ([]types.MarketTable, error)
 	GetMarket(ctx context.Context, marketID uuid.UUID, teamDetails bool) (types.MarketTable, error)
-	CreateOrder(ctx context.Context, orderId, userId, marketId uuid.UUID, side string, price, quantity int64) error
+	GetTradingRules(ctx context.Context, marketId uuid.UUID) (types.MarketTradingRules, error)
+	CreateOrder(ctx context.Context, orderId, userId, marketId uuid.UUID, side, kind string, price, quantity, triggerPrice int64) error
 	UpdateOrderStatus(ctx context.Context, orderId uuid.UUID, status string, executedQty int64) error
 	GetUserOpenOrders(ctx context.Context, userId, marketId uuid.UUID) ([]UserOrder, error)
 }
@@ -79,12 +80,23 @@ RETURNING
 	return createdMarket, nil
 }
 
-func (r *marketRepo) CreateOrder(ctx context.Context, orderId, userId, marketId uuid.UUID, side string, price, quantity int64) error {
+func (r *marketRepo) GetTradingRules(ctx context.Context, marketId uuid.UUID) (types.MarketTradingRules, error) {
+	var rules types.MarketTradingRules
+	err := r.db.QueryRow(ctx, `
+		SELECT tick_size, lot_size, min_price, max_price, max_order_qty, status::text
+		  FROM markets
+		 WHERE id = $1`,
+		marketId,
+	).Scan(&rules.TickSize, &rules.LotSize, &rules.MinPrice, &rules.MaxPrice, &rules.MaxOrderQty, &rules.Status)
+	return rules, err
+}
+
+func (r *marketRepo) CreateOrder(ctx context.Context, orderId, userId, marketId uuid.UUID, side, kind string, price, quantity, triggerPrice int64) error {
 	_, err := r.db.Exec(ctx, `
-		INSERT INTO orders (id, market_id, user_id, side, price, quantity, executed_qty, status)
-		VALUES ($1, $2, $3, $4::order_side, $5, $6, 0, 'pending')
+		INSERT INTO orders (id, market_id, user_id, side, kind, price, quantity, executed_qty, status, trigger_price)
+		VALUES ($1, $2, $3, $4::order_side, $5::order_kind, $6, $7, 0, 'pending', NULLIF($8, 0))
 		ON CONFLICT (id) DO NOTHING`,
-		orderId, marketId, userId, side, price, quantity,
+		orderId, marketId, userId, side, kind, price, quantity, triggerPrice,
 	)
 	return err
 }
@@ -102,35 +114,21 @@ func (r *marketRepo) UpdateOrderStatus(ctx context.Context, orderId uuid.UUID, s
 	return err
 }
 
-// liveStatsJoins computes last_price, volume_24h (last 24 h), and today's open_price
-// directly from trade_ticks so the markets table never needs manual updates.
-const liveStatsJoins = `
-LEFT JOIN LATERAL (
-    SELECT
-        last(price, time)  AS last_price,
-        sum(quantity)      AS volume_24h
-    FROM trade_ticks
-    WHERE market_id = m.id
-      AND time >= NOW() - INTERVAL '24 hours'
-) day24h ON true
-LEFT JOIN LATERAL (
-    SELECT first(price, time) AS open_price
-    FROM trade_ticks
-    WHERE market_id = m.id
-      AND time >= date_trunc('day', NOW())
-) today ON true`
-
-const liveStatsSelect = `
+// marketSelect reads a market with the rolling 24h statistics DBWritter keeps on it.
+const marketSelect = `
 SELECT
     m.id,
     m.team_id,
     m.market_name,
     m.market_code,
-    COALESCE(day24h.last_price, m.last_price) AS last_price,
+    m.last_price,
     m.status,
-    COALESCE(day24h.volume_24h, 0)            AS volume_24h,
-    m.total_volume,
-    COALESCE(today.open_price, m.open_price_24h) AS open_price_24h,
+    m.volume_24h,
+    COALESCE(m.total_volume, 0),
+    m.open_price_24h,
+    m.high_24h,
+    m.low_24h,
+    m.trade_count_24h,
     m.created_at,
     m.updated_at`
 
@@ -138,13 +136,13 @@ func (r *marketRepo) GetAllMarkets(ctx context.Context, teamDetails bool) ([]typ
 	var markets []types.MarketTable
 	var query string
 	if teamDetails {
-		query = liveStatsSelect + `,
+		query = marketSelect + `,
 		t.id, t.name, t.short_name, t.code, t.tla, t.emblem, t.football_org_id
-		FROM markets m` + liveStatsJoins + `
+		FROM markets m
 		JOIN teams t ON m.team_id = t.id`
 	} else {
-		query = liveStatsSelect + `
-		FROM markets m` + liveStatsJoins
+		query = marketSelect + `
+		FROM markets m`
 	}
 
 	rows, err := r.db.Query(ctx, query)
@@ -158,10 +156,10 @@ func (r *marketRepo) GetAllMarkets(ctx context.Context, teamDetails bool) ([]typ
 		var market types.MarketTable
 		if teamDetails {
 			var team types.TeamDetails
-			err = rows.Scan(&market.Id, &market.TeamID, &market.MarketName, &market.MarketCode, &market.LastPrice, &market.MarketStatus, &market.Volume24H, &market.TotalVolume, &market.OpenPrice24H, &market.CreatedAt, &market.UpdatedAt, &team.ID, &team.Name, &team.ShortName, &team.Code, &team.TLA, &team.Emblem, &team.FootballOrgId)
+			err = rows.Scan(&market.Id, &market.TeamID, &market.MarketName, &market.MarketCode, &market.LastPrice, &market.MarketStatus, &market.Volume24H, &market.TotalVolume, &market.OpenPrice24H, &market.High24H, &market.Low24H, &market.TradeCount24H, &market.CreatedAt, &market.UpdatedAt, &team.ID, &team.Name, &team.ShortName, &team.Code, &team.TLA, &team.Emblem, &team.FootballOrgId)
 			market.TeamDetails = &team
 		} else {
-			err = rows.Scan(&market.Id, &market.TeamID, &market.MarketName, &market.MarketCode, &market.LastPrice, &market.MarketStatus, &market.Volume24H, &market.TotalVolume, &market.OpenPrice24H, &market.CreatedAt, &market.UpdatedAt)
+			err = rows.Scan(&market.Id, &market.TeamID, &market.MarketName, &market.MarketCode, &market.LastPrice, &market.MarketStatus, &market.Volume24H, &market.TotalVolume, &market.OpenPrice24H, &market.High24H, &market.Low24H, &market.TradeCount24H, &market.CreatedAt, &market.UpdatedAt)
 		}
 
 		if err != nil {
@@ -184,24 +182,24 @@ func (r *marketRepo) GetMarket(ctx context.Context, marketID uuid.UUID, teamDeta
 	var market types.MarketTable
 	var query string
 	if teamDetails {
-		query = liveStatsSelect + `,
+		query = marketSelect + `,
 		t.id, t.name, t.short_name, t.code, t.tla, t.emblem, t.football_org_id
-		FROM markets m` + liveStatsJoins + `
+		FROM markets m
 		JOIN teams t ON m.team_id = t.id
 		WHERE m.id = $1`
 	} else {
-		query = liveStatsSelect + `
-		FROM markets m` + liveStatsJoins + `
+		query = marketSelect + `
+		FROM markets m
 		WHERE m.id = $1`
 	}
 
 	var err error
 	if teamDetails {
 		var team types.TeamDetails
-		err = r.db.QueryRow(ctx, query, marketID).Scan(&market.Id, &market.TeamID, &market.MarketName, &market.MarketCode, &market.LastPrice, &market.MarketStatus, &market.Volume24H, &market.TotalVolume, &market.OpenPrice24H, &market.CreatedAt, &market.UpdatedAt, &team.ID, &team.Name, &team.ShortName, &team.Code, &team.TLA, &team.Emblem, &team.FootballOrgId)
+		err = r.db.QueryRow(ctx, query, marketID).Scan(&market.Id, &market.TeamID, &market.MarketName, &market.MarketCode, &market.LastPrice, &market.MarketStatus, &market.Volume24H, &market.TotalVolume, &market.OpenPrice24H, &market.High24H, &market.Low24H, &market.TradeCount24H, &market.CreatedAt, &market.UpdatedAt, &team.ID, &team.Name, &team.ShortName, &team.Code, &team.TLA, &team.Emblem, &team.FootballOrgId)
 		market.TeamDetails = &team
 	} else {
-		err = r.db.QueryRow(ctx, query, marketID).Scan(&market.Id, &market.TeamID, &market.MarketName, &market.MarketCode, &market.LastPrice, &market.MarketStatus, &market.Volume24H, &market.TotalVolume, &market.OpenPrice24H, &market.CreatedAt, &market.UpdatedAt)
+		err = r.db.QueryRow(ctx, query, marketID).Scan(&market.Id, &market.TeamID, &market.MarketName, &market.MarketCode, &market.LastPrice, &market.MarketStatus, &market.Volume24H, &market.TotalVolume, &market.OpenPrice24H, &market.High24H, &market.Low24H, &market.TradeCount24H, &market.CreatedAt, &market.UpdatedAt)
 	}
 
 	if err != nil {
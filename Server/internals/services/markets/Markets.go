@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"log/slog"
+	"strconv"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -19,9 +20,12 @@ type MarketServices interface {
 	GetAllMarkets(ctx context.Context, teamDetails bool) ([]types.MarketTable, utils.ErrorType, error)
 	GetMarket(ctx context.Context, marketID string, teamDetails bool) (types.MarketTable, utils.ErrorType, error)
 	CreateMarket(ctx context.Context, teamID, marketName, marketCode string, lastPrice, volume24H, totalVolume, openPrice24H int64) (types.MarketTable, utils.ErrorType, error)
-	PlaceOrder(ctx context.Context, userId, marketId uuid.UUID, price int64, quantity int64, orderType types.OrderTypes) (types.FillResult, error)
+	PlaceOrder(ctx context.Context, userId uuid.UUID, order types.MarketOrder) (types.FillResult, error)
 	CancelOrder(ctx context.Context, userId, marketId, orderId uuid.UUID) (types.FillResult, error)
+	ReplaceOrder(ctx context.Context, userId uuid.UUID, replace types.ReplaceOrderRequest) (types.FillResult, error)
 	GetUserOpenOrders(ctx context.Context, userId, marketId string) ([]UserOrder, utils.ErrorType, error)
+	SettleMarket(ctx context.Context, operatorId, marketId uuid.UUID, price int64) (types.FillResult, error)
+	SuspendMarket(ctx context.Context, marketId uuid.UUID) (types.RejectReason, utils.ErrorType, error)
 }
 
 type marketSvc struct {
@@ -87,6 +91,11 @@ func (r *marketSvc) CreateMarket(ctx context.Context, teamID, marketName, market
 		return market, utils.ErrInternal, err
 	}
 
+	// The market is stored either way; an Engine that misses the event picks it up on restart.
+	if err := r.announceMarket(ctx, types.MARKET_CREATED, market.Id.String()); err != nil {
+		slog.Error("Unable to announce created market to the engine", "marketId", market.Id, "error", err)
+	}
+
 	return market, utils.NoError, nil
 
 }
@@ -129,10 +138,207 @@ func (r *marketSvc) CancelOrder(ctx context.Context, userId, marketId, orderId u
 	}
 }
 
-func (r *marketSvc) PlaceOrder(ctx context.Context, userId, marketId uuid.UUID, price int64, quantity int64, orderType types.OrderTypes) (types.FillResult, error) {
+// SettleMarket asks the Engine to close a market at its final price. The settlement goes down
+// the market's order stream like any order, so it lands after everything already queued. The
+// Engine answers under the settlement id once the book is emptied and holders are paid, and
+// the market is then announced closed, even when the answer comes after the request gave up.
+func (r *marketSvc) SettleMarket(ctx context.Context, operatorId, marketId uuid.UUID, price int64) (types.FillResult, error) {
+	rules, err := r.repo.GetTradingRules(ctx, marketId)
+	if err != nil {
+		slog.Error("Unable to load market before settlement", "marketId", marketId, "error", err)
+		return types.FillResult{}, err
+	}
+	if reason, err := checkMarketOpen(rules); err != nil {
+		return types.FillResult{Error: err.Error(), Reason: reason}, nil
+	}
+
+	settlementId := uuid.New()
+	ch := r.registry.Register(settlementId.String())
+
+	_, err = r.orderRedis.XAdd(ctx, &redis.XAddArgs{
+		Stream: "ORDERS_" + marketId.String(),
+		Values: map[string]interface{}{
+			"orderId":   settlementId.String(),
+			"userId":    operatorId.String(),
+			"marketId":  marketId.String(),
+			"orderType": string(types.SETTLE_MARKET),
+			"price":     price,
+			"quantity":  0,
+		},
+	}).Result()
+
+	if err != nil {
+		r.registry.Delete(settlementId.String())
+		slog.Error("Unable to write settlement on redis stream.", "error", err)
+		return types.FillResult{}, err
+	}
+
+	slog.Info("Settlement pushed to redis stream, waiting for engine response", "settlementId", settlementId, "marketId", marketId, "price", price)
+
+	select {
+	case fill := <-ch:
+		r.announceSettled(fill, settlementId.String(), marketId.String())
+		// Return empty non-nil Fills slice to signal engine confirmed the settlement.
+		if fill.Fills == nil {
+			fill.Fills = []types.Fills{}
+		}
+		return fill, nil
+	case <-time.After(5 * time.Second):
+		slog.Warn("Settlement timed out waiting for engine response", "settlementId", settlementId)
+		go r.awaitSettlement(settlementId.String(), marketId.String(), ch)
+		return types.FillResult{OrderId: settlementId.String(), Fills: nil}, nil
+	case <-ctx.Done():
+		go r.awaitSettlement(settlementId.String(), marketId.String(), ch)
+		return types.FillResult{}, ctx.Err()
+	}
+}
+
+// settleConfirmWait is how long a settlement the caller stopped waiting for keeps waiting
+// for the Engine's answer, so the closed market is still announced.
+const settleConfirmWait = 10 * time.Minute
+
+// awaitSettlement waits out the Engine's answer to a settlement after the request returned.
+func (r *marketSvc) awaitSettlement(settlementId, marketId string, ch chan types.FillResult) {
+	select {
+	case fill := <-ch:
+		r.announceSettled(fill, settlementId, marketId)
+	case <-time.After(settleConfirmWait):
+		r.registry.Delete(settlementId)
+		slog.Error("Engine never answered the settlement, market is not announced closed", "settlementId", settlementId, "marketId", marketId)
+	}
+}
+
+// announceSettled tells the Engine a settled market is closed so it stops running it.
+// A rejected settlement leaves the market open.
+func (r *marketSvc) announceSettled(fill types.FillResult, settlementId, marketId string) {
+	slog.Info("Settlement acknowledged by engine", "settlementId", settlementId, "error", fill.Error)
+	if fill.Error != "" {
+		return
+	}
+	if err := r.announceMarket(context.Background(), types.MARKET_CLOSED, marketId); err != nil {
+		slog.Error("Unable to announce closed market to the engine", "marketId", marketId, "error", err)
+	}
+}
+
+// SuspendMarket asks the Engine to halt a market. The halt is announced on the market's WS
+// channel and recorded in markets.status once the Engine applies it.
+func (r *marketSvc) SuspendMarket(ctx context.Context, marketId uuid.UUID) (types.RejectReason, utils.ErrorType, error) {
+	rules, err := r.repo.GetTradingRules(ctx, marketId)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", utils.ErrNotFound, errors.New("No market exists of this ID")
+		}
+		slog.Error("Unable to load market before suspension", "marketId", marketId, "error", err)
+		return "", utils.ErrInternal, err
+	}
+	if reason, err := checkMarketOpen(rules); err != nil {
+		return reason, utils.NoError, err
+	}
+
+	if err := r.announceMarket(ctx, types.MARKET_SUSPENDED, marketId.String()); err != nil {
+		slog.Error("Unable to announce market suspension to the engine", "marketId", marketId, "error", err)
+		return "", utils.ErrInternal, err
+	}
+	return "", utils.NoError, nil
+}
+
+// announceMarket writes a market lifecycle event to ENGINE_CONTROL. The stream is capped
+// since the Engine only reads the events written while it runs.
+func (r *marketSvc) announceMarket(ctx context.Context, event types.EngineControlEvent, marketId string) error {
+	return r.orderRedis.XAdd(ctx, &redis.XAddArgs{
+		Stream: "ENGINE_CONTROL",
+		MaxLen: 10000,
+		Approx: true,
+		Values: map[string]interface{}{
+			"event":    string(event),
+			"marketId": marketId,
+		},
+	}).Err()
+}
+
+func (r *marketSvc) ReplaceOrder(ctx context.Context, userId uuid.UUID, replace types.ReplaceOrderRequest) (types.FillResult, error) {
+	orderId := replace.OrderId
+
+	rules, err := r.repo.GetTradingRules(ctx, replace.MarketId)
+	if err != nil {
+		slog.Error("Unable to load market trading rules", "marketId", replace.MarketId, "error", err)
+		return types.FillResult{}, err
+	}
+	if reason, err := checkReplaceRules(rules, replace.Price, replace.Quantity); err != nil {
+		return types.FillResult{OrderId: orderId.String(), Error: err.Error(), Reason: reason}, nil
+	}
+
+	ch := r.registry.Register(orderId.String())
+
+	_, err = r.orderRedis.XAdd(ctx, &redis.XAddArgs{
+		Stream: "ORDERS_" + replace.MarketId.String(),
+		Values: map[string]interface{}{
+			"orderId":   orderId.String(),
+			"userId":    userId.String(),
+			"marketId":  replace.MarketId.String(),
+			"orderType": string(types.REPLACE_ORDER),
+			"price":     replace.Price,
+			"quantity":  int(replace.Quantity),
+		},
+	}).Result()
+
+	if err != nil {
+		r.registry.Delete(orderId.String())
+		slog.Error("Unable to write replace order on redis stream.", "error", err)
+		return types.FillResult{}, err
+	}
+
+	slog.Info("Replace order pushed to redis stream, waiting for engine response", "orderId", orderId, "marketId", replace.MarketId)
+
+	select {
+	case fill := <-ch:
+		slog.Info("Replace order acknowledged by engine", "orderId", orderId, "executedQty", fill.ExecutedQuantity)
+		// The order row is amended by DBWritter from the Engine's ORDER_REPLACED entry on
+		// TRADES, so a replace the Engine refused leaves it untouched.
+		if fill.Error != "" {
+			return fill, nil
+		}
+		if fill.Fills == nil {
+			fill.Fills = []types.Fills{}
+		}
+		return fill, nil
+	case <-time.After(5 * time.Second):
+		r.registry.Delete(orderId.String())
+		slog.Warn("Replace order timed out waiting for engine response", "orderId", orderId)
+		return types.FillResult{OrderId: orderId.String(), ExecutedQuantity: 0, Fills: nil}, nil
+	case <-ctx.Done():
+		r.registry.Delete(orderId.String())
+		return types.FillResult{}, ctx.Err()
+	}
+}
+
+func (r *marketSvc) PlaceOrder(ctx context.Context, userId uuid.UUID, order types.MarketOrder) (types.FillResult, error) {
 	orderId := uuid.New()
+	marketId, price, quantity, orderType := order.MarketId, order.Price, order.Quantity, order.OrderType
 
-	if err := r.repo.CreateOrder(ctx, orderId, userId, marketId, string(orderType), price, quantity); err != nil {
+	timeInForce := order.TimeInForce
+	if timeInForce == "" {
+		timeInForce = types.TIF_GTC
+	}
+	orderKind := order.OrderKind
+	if orderKind == "" {
+		orderKind = types.LIMIT_ORDER
+	}
+	if orderKind == types.MARKET_ORDER || orderKind == types.STOP_ORDER {
+		price = 0
+	}
+
+	// Orders that break the market's trading rules are refused before they get an order row.
+	rules, err := r.repo.GetTradingRules(ctx, marketId)
+	if err != nil {
+		slog.Error("Unable to load market trading rules", "marketId", marketId, "error", err)
+		return types.FillResult{}, err
+	}
+	if reason, err := checkOrderRules(rules, orderKind, price, quantity, order.TriggerPrice); err != nil {
+		return types.FillResult{Error: err.Error(), Reason: reason}, nil
+	}
+
+	if err := r.repo.CreateOrder(ctx, orderId, userId, marketId, string(orderType), string(orderKind), price, quantity, order.TriggerPrice); err != nil {
 		slog.Error("Unable to persist order to DB before routing to engine", "error", err)
 		return types.FillResult{}, err
 	}
@@ -141,15 +347,22 @@ func (r *marketSvc) PlaceOrder(ctx context.Context, userId, marketId uuid.UUID,
 	// before the channel entry exists in the map.
 	ch := r.registry.Register(orderId.String())
 
-	_, err := r.orderRedis.XAdd(ctx, &redis.XAddArgs{
+	_, err = r.orderRedis.XAdd(ctx, &redis.XAddArgs{
 		Stream: "ORDERS_" + marketId.String(),
 		Values: map[string]interface{}{
-			"orderId":   orderId.String(),
-			"userId":    userId.String(),
-			"marketId":  marketId.String(),
-			"price":     price,
-			"quantity":  int(quantity),
-			"orderType": string(orderType),
+			"orderId":      orderId.String(),
+			"userId":       userId.String(),
+			"marketId":     marketId.String(),
+			"price":        price,
+			"quantity":     int(quantity),
+			"orderType":    string(orderType),
+			"timeInForce":  string(timeInForce),
+			"expiresAt":    order.ExpiresAt,
+			"orderKind":    string(orderKind),
+			"triggerPrice": order.TriggerPrice,
+			"postOnly":     strconv.FormatBool(order.PostOnly),
+			"reduceOnly":   strconv.FormatBool(order.ReduceOnly),
+			"stpMode":      string(order.STPMode),
 		},
 	}).Result()
 
@@ -164,9 +377,14 @@ func (r *marketSvc) PlaceOrder(ctx context.Context, userId, marketId uuid.UUID,
 	select {
 	case fill := <-ch:
 		slog.Info("Order fill received from engine", "orderId", orderId, "executedQty", fill.ExecutedQuantity)
+		// CancelledQuantity covers IOC remainders and reduce-only trimming; whatever is
+		// left after both is still resting on the book.
+		resting := int(quantity) - fill.ExecutedQuantity - fill.CancelledQuantity
 		status := "pending"
 		if fill.ExecutedQuantity >= int(quantity) {
 			status = "filled"
+		} else if fill.Error != "" || resting <= 0 {
+			status = "cancelled"
 		} else if fill.ExecutedQuantity > 0 {
 			status = "partial"
 		}
@@ -0,0 +1,79 @@
+package markets
+
+import (
+	"errors"
+
+	"github.com/raiashpanda007/rivon/internals/types"
+)
+
+// The Engine applies the same rules again when the order reaches the book, so these checks
+// only save a round trip through the stream; the messages match the Engine's.
+var (
+	errPriceNotOnTick  = errors.New("price is not a multiple of the market tick size")
+	errQtyNotOnLot     = errors.New("quantity is not a multiple of the market lot size")
+	errPriceOutOfRange = errors.New("price is outside the market price range")
+	errQtyOutOfRange   = errors.New("quantity is outside the market order size range")
+	errMarketClosed    = errors.New("market is closed")
+)
+
+// checkMarketOpen refuses orders for a closed market. A suspended market is in the Engine's
+// post-halt call auction and keeps taking orders, which join the auction.
+func checkMarketOpen(rules types.MarketTradingRules) (types.RejectReason, error) {
+	if rules.Status == "closed" {
+		return types.REJECT_MARKET_CLOSED, errMarketClosed
+	}
+	return "", nil
+}
+
+func checkPrice(rules types.MarketTradingRules, price int64) (types.RejectReason, error) {
+	if price <= 0 || price < rules.MinPrice || (rules.MaxPrice > 0 && price > rules.MaxPrice) {
+		return types.REJECT_PRICE_OUT_OF_RANGE, errPriceOutOfRange
+	}
+	if rules.TickSize > 1 && price%rules.TickSize != 0 {
+		return types.REJECT_PRICE_NOT_ON_TICK, errPriceNotOnTick
+	}
+	return "", nil
+}
+
+func checkQuantity(rules types.MarketTradingRules, quantity int64) (types.RejectReason, error) {
+	if quantity <= 0 || (rules.MaxOrderQty > 0 && quantity > rules.MaxOrderQty) {
+		return types.REJECT_QTY_OUT_OF_RANGE, errQtyOutOfRange
+	}
+	if rules.LotSize > 1 && quantity%rules.LotSize != 0 {
+		return types.REJECT_QTY_NOT_ON_LOT, errQtyNotOnLot
+	}
+	return "", nil
+}
+
+// checkOrderRules validates a new order. MARKET orders have no price of their own and STOP
+// orders are priced by their trigger, so only prices the user chose are checked.
+func checkOrderRules(rules types.MarketTradingRules, kind types.OrderKind, price, quantity, triggerPrice int64) (types.RejectReason, error) {
+	if reason, err := checkMarketOpen(rules); err != nil {
+		return reason, err
+	}
+	if reason, err := checkQuantity(rules, quantity); err != nil {
+		return reason, err
+	}
+	switch kind {
+	case types.MARKET_ORDER:
+		return "", nil
+	case types.STOP_ORDER:
+		return checkPrice(rules, triggerPrice)
+	case types.STOP_LIMIT_ORDER:
+		if reason, err := checkPrice(rules, triggerPrice); err != nil {
+			return reason, err
+		}
+	}
+	return checkPrice(rules, price)
+}
+
+// checkReplaceRules validates the new price and total quantity of a replace.
+func checkReplaceRules(rules types.MarketTradingRules, price, quantity int64) (types.RejectReason, error) {
+	if reason, err := checkMarketOpen(rules); err != nil {
+		return reason, err
+	}
+	if reason, err := checkQuantity(rules, quantity); err != nil {
+		return reason, err
+	}
+	return checkPrice(rules, price)
+}
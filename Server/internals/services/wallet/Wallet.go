@@ -41,7 +41,8 @@ func (r *walletServiceUtils) pushToRedis(ctx context.Context, userID string, w *
 	if err != nil {
 		return err
 	}
-	return r.userMapRedis.Set(ctx, userID, data, 0).Err()
+	// The engine owns the cached wallet once it exists; only fill it in when it is missing.
+	return r.userMapRedis.SetNX(ctx, userID, data, 0).Err()
 }
 
 func (r *walletServiceUtils) GetAssets(ctx context.Context, userID string) ([]AssetWithMarket, utils.ErrorType, error) {
@@ -103,7 +103,7 @@ func (r *walletRepoUtils) GetTransactions(ctx context.Context, userID string, li
 		FROM transactions t
 		JOIN wallets w ON t.wallet_id = w.id
 		LEFT JOIN orders o ON t.order_id = o.id
-		LEFT JOIN markets m ON o.market_id = m.id
+		LEFT JOIN markets m ON m.id = COALESCE(o.market_id, t.market_id)
 		WHERE w.user_id = $1
 		ORDER BY t.created_at DESC
 		LIMIT $2 OFFSET $3`,
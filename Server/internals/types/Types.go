@@ -29,6 +29,7 @@ type TransactionType string
 const (
 	DEBIT  TransactionType = "debit"
 	CREDIT TransactionType = "credit"
+	SETTLE TransactionType = "settle" // market settlement payout, credits the wallet
 )
 
 type LeagueStruct struct {
@@ -166,18 +167,21 @@ type GetSeasonResponse struct {
 }
 
 type MarketTable struct {
-	Id           uuid.UUID    `json:"id"`
-	TeamID       uuid.UUID    `json:"teamId"`
-	MarketName   string       `json:"marketName"`
-	MarketCode   string       `json:"marketCode"`
-	LastPrice    int64        `json:"lastPrice"`
-	MarketStatus string       `json:"status"`
-	Volume24H    int64        `json:"volume24h"`
-	TotalVolume  int64        `json:"totalVolume"`
-	OpenPrice24H int64        `json:"openPrice"`
-	TeamDetails  *TeamDetails `json:"teamDetails,omitempty"`
-	CreatedAt    time.Time    `json:"createdAt"`
-	UpdatedAt    time.Time    `json:"updatedAt"`
+	Id            uuid.UUID    `json:"id"`
+	TeamID        uuid.UUID    `json:"teamId"`
+	MarketName    string       `json:"marketName"`
+	MarketCode    string       `json:"marketCode"`
+	LastPrice     int64        `json:"lastPrice"`
+	MarketStatus  string       `json:"status"`
+	Volume24H     int64        `json:"volume24h"`
+	TotalVolume   int64        `json:"totalVolume"`
+	OpenPrice24H  int64        `json:"openPrice"`
+	High24H       int64        `json:"high24h"`
+	Low24H        int64        `json:"low24h"`
+	TradeCount24H int64        `json:"tradeCount24h"`
+	TeamDetails   *TeamDetails `json:"teamDetails,omitempty"`
+	CreatedAt     time.Time    `json:"createdAt"`
+	UpdatedAt     time.Time    `json:"updatedAt"`
 }
 
 type TeamDetails struct {
@@ -193,11 +197,76 @@ type TeamDetails struct {
 type OrderTypes string
 
 const (
-	BUY_ORDER    OrderTypes = "BUY"
-	SELL_ORDER   OrderTypes = "SELL"
-	CANCEL_ORDER OrderTypes = "CANCEL_ORDER"
+	BUY_ORDER     OrderTypes = "BUY"
+	SELL_ORDER    OrderTypes = "SELL"
+	CANCEL_ORDER  OrderTypes = "CANCEL_ORDER"
+	REPLACE_ORDER OrderTypes = "REPLACE_ORDER"
+	SETTLE_MARKET OrderTypes = "SETTLE_MARKET"
 )
 
+// EngineControlEvent is a market lifecycle change announced to the Engine on the
+// ENGINE_CONTROL stream, so it starts, halts or stops the market without a restart.
+type EngineControlEvent string
+
+const (
+	MARKET_CREATED   EngineControlEvent = "MARKET_CREATED"
+	MARKET_SUSPENDED EngineControlEvent = "MARKET_SUSPENDED"
+	MARKET_CLOSED    EngineControlEvent = "MARKET_CLOSED"
+)
+
+// TimeInForce mirrors the Engine's orderbooks.TimeInForce. Empty means GTC.
+type TimeInForce string
+
+const (
+	TIF_GTC TimeInForce = "GTC"
+	TIF_IOC TimeInForce = "IOC"
+	TIF_FOK TimeInForce = "FOK"
+	TIF_GTD TimeInForce = "GTD"
+)
+
+// OrderKind mirrors the Engine's orderbooks.OrderKind. Empty means LIMIT.
+type OrderKind string
+
+const (
+	LIMIT_ORDER      OrderKind = "LIMIT"
+	MARKET_ORDER     OrderKind = "MARKET"
+	STOP_ORDER       OrderKind = "STOP"       // MARKET order once triggerPrice trades
+	STOP_LIMIT_ORDER OrderKind = "STOP_LIMIT" // LIMIT order once triggerPrice trades
+)
+
+// STPMode mirrors the Engine's orderbooks.STPMode. Empty means CANCEL_OLDEST.
+type STPMode string
+
+const (
+	STP_CANCEL_NEWEST        STPMode = "CANCEL_NEWEST"
+	STP_CANCEL_OLDEST        STPMode = "CANCEL_OLDEST"
+	STP_CANCEL_BOTH          STPMode = "CANCEL_BOTH"
+	STP_DECREMENT_AND_CANCEL STPMode = "DECREMENT_AND_CANCEL"
+)
+
+// RejectReason mirrors the Engine's pubsub.RejectReason sent with ORDER_REJECTED.
+type RejectReason string
+
+const (
+	REJECT_PRICE_NOT_ON_TICK    RejectReason = "PRICE_NOT_ON_TICK"
+	REJECT_QTY_NOT_ON_LOT       RejectReason = "QTY_NOT_ON_LOT"
+	REJECT_PRICE_OUT_OF_RANGE   RejectReason = "PRICE_OUT_OF_RANGE"
+	REJECT_QTY_OUT_OF_RANGE     RejectReason = "QTY_OUT_OF_RANGE"
+	REJECT_INSUFFICIENT_BALANCE RejectReason = "INSUFFICIENT_BALANCE"
+	REJECT_MARKET_CLOSED        RejectReason = "MARKET_CLOSED" // refused here; the Engine never sees the order
+)
+
+// MarketTradingRules are the per-market order constraints from the markets table.
+// MaxPrice and MaxOrderQty of 0 mean no upper limit. Status is the market_state.
+type MarketTradingRules struct {
+	TickSize    int64
+	LotSize     int64
+	MinPrice    int64
+	MaxPrice    int64
+	MaxOrderQty int64
+	Status      string
+}
+
 type RedisStreamMessage struct {
 	UserId   uuid.UUID `json:"userId"`
 	MarketId uuid.UUID `json:"marketId"`
@@ -207,11 +276,18 @@ type RedisStreamMessage struct {
 }
 
 type MarketOrder struct {
-	MarketId  uuid.UUID  `json:"marketId"`
-	Price     int64      `json:"price"`
-	Quantity  int64      `json:"quantity"`
-	OrderType OrderTypes `json:"orderType"`
-	OrderId   *uuid.UUID `json:"orderId,omitempty"` // required for CANCEL_ORDER
+	MarketId     uuid.UUID   `json:"marketId"`
+	Price        int64       `json:"price"`
+	Quantity     int64       `json:"quantity"`
+	OrderType    OrderTypes  `json:"orderType"`
+	OrderId      *uuid.UUID  `json:"orderId,omitempty"`      // required for CANCEL_ORDER
+	TimeInForce  TimeInForce `json:"timeInForce,omitempty"`  // defaults to GTC
+	ExpiresAt    int64       `json:"expiresAt,omitempty"`    // unix millis, required for GTD
+	OrderKind    OrderKind   `json:"orderKind,omitempty"`    // defaults to LIMIT; MARKET and STOP orders ignore price
+	TriggerPrice int64       `json:"triggerPrice,omitempty"` // required for STOP and STOP_LIMIT
+	PostOnly     bool        `json:"postOnly,omitempty"`     // LIMIT only: rejected if it would take liquidity
+	ReduceOnly   bool        `json:"reduceOnly,omitempty"`   // SELL only: trimmed to the held asset position
+	STPMode      STPMode     `json:"stpMode,omitempty"`      // self-trade prevention, defaults to CANCEL_OLDEST
 }
 
 type Fills struct {
@@ -224,10 +300,12 @@ type Fills struct {
 }
 
 type FillResult struct {
-	OrderId          string  `json:"orderId"`
-	ExecutedQuantity int     `json:"executedQty"`
-	Fills            []Fills `json:"fills"`
-	Error            string  `json:"error,omitempty"`
+	OrderId           string       `json:"orderId"`
+	ExecutedQuantity  int          `json:"executedQty"`
+	CancelledQuantity int          `json:"cancelledQty,omitempty"`
+	Fills             []Fills      `json:"fills"`
+	Error             string       `json:"error,omitempty"`
+	Reason            RejectReason `json:"reason,omitempty"`
 }
 
 // PubSubOrderMessage mirrors the Engine's JSON payload on channel "ORDERS".
@@ -235,11 +313,17 @@ type FillResult struct {
 // go-redis v8 uses fmt.Sprint on plain structs, not JSON. JSON tags must match
 // Engine's api.pubsub.go.
 type PubSubOrderMessage struct {
-	OrderId          string  `json:"orderId"`
-	Fills            []Fills `json:"fills"`
-	ExecutedQuantity int     `json:"executedQty"`
-	MessageType      string  `json:"type"`
-	Error            string  `json:"error,omitempty"`
+	OrderId           string       `json:"orderId"`
+	Fills             []Fills      `json:"fills"`
+	ExecutedQuantity  int          `json:"executedQty"`
+	CancelledQuantity int          `json:"cancelledQty,omitempty"`
+	MessageType       string       `json:"type"`
+	Error             string       `json:"error,omitempty"`
+	Reason            RejectReason `json:"reason,omitempty"`
+	// MarketId, Epoch and Seq number the messages of one run of a market's Engine process.
+	MarketId string `json:"marketId,omitempty"`
+	Epoch    int64  `json:"epoch,omitempty"`
+	Seq      int64  `json:"seq,omitempty"`
 }
 
 // MatchesResponse is the football-data.org /v4/competitions/{id}/matches response.
@@ -328,10 +412,49 @@ type KnockoutStageData struct {
 	Matchups []KnockoutMatchup `json:"matchups"`
 }
 
+// ReplaceOrderRequest amends a resting order. Quantity is the new total quantity,
+// including whatever has already been filled.
+type ReplaceOrderRequest struct {
+	MarketId uuid.UUID `json:"marketId"`
+	OrderId  uuid.UUID `json:"orderId"`
+	Price    int64     `json:"price"`
+	Quantity int64     `json:"quantity"`
+}
+
+// SettleMarketRequest closes a market for good at its final price. Every holder is paid
+// Price per unit they hold; 0 settles the market as worthless.
+type SettleMarketRequest struct {
+	MarketId uuid.UUID `json:"marketId"`
+	Price    int64     `json:"price"`
+}
+
+type SettleMarketResponse struct {
+	SettlementId string       `json:"settlementId"`
+	MarketId     string       `json:"marketId"`
+	Price        int64        `json:"price"`
+	Status       string       `json:"status"`
+	Message      string       `json:"message"`
+	Reason       RejectReason `json:"reason,omitempty"`
+}
+
+// SuspendMarketRequest halts a market into a call auction that reopens it after the
+// circuit breaker cooldown.
+type SuspendMarketRequest struct {
+	MarketId uuid.UUID `json:"marketId"`
+}
+
+type SuspendMarketResponse struct {
+	MarketId string       `json:"marketId"`
+	Status   string       `json:"status"`
+	Message  string       `json:"message"`
+	Reason   RejectReason `json:"reason,omitempty"`
+}
+
 type PlaceOrderResponse struct {
-	OrderId          string  `json:"orderId"`
-	ExecutedQuantity int     `json:"executedQty"`
-	Fills            []Fills `json:"fills"`
-	Status           string  `json:"status"`
-	Message          string  `json:"message"`
+	OrderId          string       `json:"orderId"`
+	ExecutedQuantity int          `json:"executedQty"`
+	Fills            []Fills      `json:"fills"`
+	Status           string       `json:"status"`
+	Message          string       `json:"message"`
+	Reason           RejectReason `json:"reason,omitempty"`
 }
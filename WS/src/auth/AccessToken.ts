@@ -0,0 +1,49 @@
+import { createHmac, timingSafeEqual } from "node:crypto";
+import type { IncomingMessage } from "node:http";
+
+// Access tokens are the HS256 JWTs the Server issues in the access_token cookie, signed
+// with the AUTH_SECRET the two share.
+const ACCESS_TOKEN_COOKIE = "access_token";
+
+type AccessTokenClaims = { id?: unknown; exp?: unknown };
+
+function decodeSegment(segment: string): unknown {
+  return JSON.parse(Buffer.from(segment, "base64url").toString("utf8"));
+}
+
+// VerifyAccessToken checks the signature and expiry of token and returns the user id it was
+// issued to, or null when it is not valid.
+export function VerifyAccessToken(token: string, secret: string): string | null {
+  const parts = token.split(".");
+  if (parts.length !== 3) return null;
+  const [header, payload, signature] = parts as [string, string, string];
+
+  try {
+    const { alg } = decodeSegment(header) as { alg?: unknown };
+    if (alg !== "HS256") return null;
+
+    const expected = createHmac("sha256", secret).update(header + "." + payload).digest();
+    const given = Buffer.from(signature, "base64url");
+    if (given.length !== expected.length || !timingSafeEqual(given, expected)) return null;
+
+    const claims = decodeSegment(payload) as AccessTokenClaims;
+    if (typeof claims.exp !== "number" || claims.exp * 1000 <= Date.now()) return null;
+    if (typeof claims.id !== "string" || claims.id === "") return null;
+    return claims.id;
+  } catch {
+    return null;
+  }
+}
+
+// TokenFromRequest reads the access token of a WS upgrade request: the token query parameter
+// for clients that cannot send cookies, otherwise the access_token cookie.
+export function TokenFromRequest(req: IncomingMessage): string | null {
+  const query = new URL(req.url ?? "/", "http://ws").searchParams.get("token");
+  if (query) return query;
+
+  for (const cookie of (req.headers.cookie ?? "").split(";")) {
+    const [name, ...value] = cookie.trim().split("=");
+    if (name === ACCESS_TOKEN_COOKIE) return decodeURIComponent(value.join("="));
+  }
+  return null;
+}
@@ -5,12 +5,14 @@ dotenv.config();
 
 export interface ConfigType {
   PORT: number,
-  REDIS_URL: string
+  REDIS_URL: string,
+  AUTH_SECRET: string // the Server's AUTH_SECRET, which signs the access tokens
 }
 
 const ENVSchema = zod.object({
   PORT: zod.coerce.number().int().positive(),
   REDIS_URL: zod.string(),
+  AUTH_SECRET: zod.string().min(1),
 })
 
 class Config {
@@ -1,22 +1,32 @@
 import { randomUUIDv7 } from "bun";
 import WebSocket from "ws";
 
+// A user may be connected more than once, e.g. from several tabs; private events go to all of them.
 class UserConnectionMap {
 
-  public UserConnectionMap: Map<string, WebSocket>
+  public UserConnectionMap: Map<string, Set<WebSocket>>
   constructor() {
-    this.UserConnectionMap = new Map<string, WebSocket>();
+    this.UserConnectionMap = new Map<string, Set<WebSocket>>();
   }
   public AddUser(userId: string, ws: WebSocket) {
-    this.UserConnectionMap.set(userId, ws);
+    if (!this.UserConnectionMap.has(userId)) {
+      this.UserConnectionMap.set(userId, new Set<WebSocket>());
+    }
+    this.UserConnectionMap.get(userId)?.add(ws);
   }
-  public RemoveUser(userId: string) {
+  // RemoveUser drops one connection of the user and reports whether it was the last.
+  public RemoveUser(userId: string, ws: WebSocket): boolean {
+    const conns = this.UserConnectionMap.get(userId);
+    conns?.delete(ws);
+    if (conns && conns.size > 0) return false;
     this.UserConnectionMap.delete(userId);
+    return true;
   }
 
 }
 
-type ConnectionMeta = { userId?: string; marketId: string };
+// userId is set at connect from the connection's access token and never from a message.
+type ConnectionMeta = { userId?: string; marketId?: string };
 
 class ConnectionMap {
   private ConnectionMap: Map<string, WebSocket>
@@ -27,9 +37,10 @@ class ConnectionMap {
     this.meta = new Map<string, ConnectionMeta>();
   }
 
-  public AddConnection(ws: WebSocket): string {
+  public AddConnection(ws: WebSocket, userId?: string): string {
     const id = randomUUIDv7();
     this.ConnectionMap.set(id, ws);
+    this.meta.set(id, { userId });
     return id;
   }
 
@@ -37,8 +48,9 @@ class ConnectionMap {
     return this.ConnectionMap.get(connectionId);
   }
 
-  public SetMeta(connectionId: string, marketId: string, userId?: string) {
-    this.meta.set(connectionId, { userId, marketId });
+  public SetMarket(connectionId: string, marketId: string) {
+    const meta = this.meta.get(connectionId);
+    if (meta) meta.marketId = marketId;
   }
 
   public GetMeta(connectionId: string): ConnectionMeta | undefined {
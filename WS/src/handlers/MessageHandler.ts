@@ -1,5 +1,5 @@
 import WebSocket from "ws";
-import type { UserConnectionMap, MarketStreamWsConnectionMap, ConnectionMap } from "../connections/connectionMap";
+import type { MarketStreamWsConnectionMap, ConnectionMap } from "../connections/connectionMap";
 import type Publisher from "../redis/Publisher";
 import type MarketSubscriber from "../redis/Subscriber";
 import { MessageType, type ClientMessage, PUBSLISHED_MESSAGE_TYPES } from "../types";
@@ -8,14 +8,12 @@ class MessageHandler {
 
   private publisher: Publisher;
   private marketSubscriber: MarketSubscriber;
-  private userConnectionMap: UserConnectionMap;
   private marketConnMap: MarketStreamWsConnectionMap;
   private connectionMap: ConnectionMap;
 
-  constructor(publisher: Publisher, marketSubscriber: MarketSubscriber, userConnectionMap: UserConnectionMap, marketConnMap: MarketStreamWsConnectionMap, connectionMap: ConnectionMap) {
+  constructor(publisher: Publisher, marketSubscriber: MarketSubscriber, marketConnMap: MarketStreamWsConnectionMap, connectionMap: ConnectionMap) {
     this.publisher = publisher;
     this.marketSubscriber = marketSubscriber;
-    this.userConnectionMap = userConnectionMap;
     this.marketConnMap = marketConnMap;
     this.connectionMap = connectionMap;
   }
@@ -24,13 +22,15 @@ class MessageHandler {
 
     switch (message.type) {
       case MessageType.enum.SUBSCRIBE_MARKET: {
-        const { userID, marketID } = message.payload;
+        const { marketID } = message.payload;
+        // The user is the one the connection authenticated as, if any.
+        const userID = this.connectionMap.GetMeta(connectionId)?.userId;
 
         this.marketConnMap.Add(marketID, ws);
         this.marketSubscriber.SubscribeMarket(marketID);
 
         // Always store marketId so cleanup fires for all connections, not just authed ones
-        this.connectionMap.SetMeta(connectionId, marketID, userID);
+        this.connectionMap.SetMarket(connectionId, marketID);
 
         await this.publisher.PublishMessageInMarket(marketID, JSON.stringify({
           MessageType: PUBSLISHED_MESSAGE_TYPES.orderBookSubs,
@@ -44,7 +44,6 @@ class MessageHandler {
         }));
 
         if (userID) {
-          this.userConnectionMap.AddUser(userID, ws);
           await this.publisher.PublishMessageInMarket(marketID, JSON.stringify({
             MessageType: PUBSLISHED_MESSAGE_TYPES.walletLoad,
             UserId: userID,
@@ -79,6 +78,57 @@ class MessageHandler {
         }));
         break;
       }
+
+      // The client missed a DEPTH_DELTA; the Engine answers with ORDERBOOK_DATA.
+      case MessageType.enum.RESYNC_DEPTH: {
+        const { marketID } = message.payload;
+        const meta = this.connectionMap.GetMeta(connectionId);
+        await this.publisher.PublishMessageInMarket(marketID, JSON.stringify({
+          MessageType: PUBSLISHED_MESSAGE_TYPES.depthResync,
+          UserId: meta?.userId,
+          ConnectionId: connectionId,
+        }));
+        break;
+      }
+
+      // Throttled top-N depth: the Engine sends DEPTH_DATA at most once per intervalMs.
+      case MessageType.enum.SUBSCRIBE_DEPTH: {
+        const { marketID, depth, granularity, intervalMs } = message.payload;
+        const meta = this.connectionMap.GetMeta(connectionId);
+        await this.publisher.PublishMessageInMarket(marketID, JSON.stringify({
+          MessageType: PUBSLISHED_MESSAGE_TYPES.depthSubs,
+          UserId: meta?.userId,
+          ConnectionId: connectionId,
+          Depth: depth,
+          Granularity: granularity,
+          IntervalMs: intervalMs,
+        }));
+        break;
+      }
+
+      case MessageType.enum.SUBSCRIBE_BOOK_TICKER: {
+        const { marketID, intervalMs } = message.payload;
+        const meta = this.connectionMap.GetMeta(connectionId);
+        await this.publisher.PublishMessageInMarket(marketID, JSON.stringify({
+          MessageType: PUBSLISHED_MESSAGE_TYPES.bookTickerSubs,
+          UserId: meta?.userId,
+          ConnectionId: connectionId,
+          IntervalMs: intervalMs,
+        }));
+        break;
+      }
+
+      case MessageType.enum.UNSUBSCRIBE_DEPTH:
+      case MessageType.enum.UNSUBSCRIBE_BOOK_TICKER: {
+        const { marketID } = message.payload;
+        await this.publisher.PublishMessageInMarket(marketID, JSON.stringify({
+          MessageType: message.type === "UNSUBSCRIBE_DEPTH"
+            ? PUBSLISHED_MESSAGE_TYPES.depthUnsubs
+            : PUBSLISHED_MESSAGE_TYPES.bookTickerUnsubs,
+          ConnectionId: connectionId,
+        }));
+        break;
+      }
     }
 
   }
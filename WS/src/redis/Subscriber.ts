@@ -1,7 +1,11 @@
 import type Redis from "ioredis";
 import type { MarketStreamWsConnectionMap, ConnectionMap, UserConnectionMap } from "../connections/connectionMap";
 
-const UNICAST_TYPES = new Set(["ORDERBOOK_DATA", "DEPTH_DATA"]);
+const UNICAST_TYPES = new Set(["ORDERBOOK_DATA", "DEPTH_DATA", "L3_SNAPSHOT", "BOOK_TICKER"]);
+// The Engine publishes a user's private order and wallet events on WS_USER_<userId>. Every
+// WS node listens to all of them and hands each to the connections of that user it holds,
+// whichever market they watch.
+const USER_CHANNEL_PREFIX = "WS_USER_";
 
 class MarketSubscriber {
 
@@ -18,6 +22,19 @@ class MarketSubscriber {
     this.userConnMap = userConnMap;
     this.subscribedMarkets = new Set<string>();
     this.subscriber.on("message", this.handleMessage.bind(this));
+    this.subscriber.on("pmessage", this.handleUserMessage.bind(this));
+    this.subscriber.psubscribe(USER_CHANNEL_PREFIX + "*").catch((error) => {
+      console.error("ERROR in subscribe to user channels :: ", error);
+    });
+  }
+
+  private handleUserMessage(_pattern: string, channel: string, message: string) {
+    const conns = this.userConnMap.UserConnectionMap.get(channel.slice(USER_CHANNEL_PREFIX.length));
+    for (const ws of conns ?? []) {
+      if (ws.readyState === ws.OPEN) {
+        ws.send(message);
+      }
+    }
   }
 
   public async SubscribeMarket(marketId: string) {
@@ -33,21 +50,13 @@ class MarketSubscriber {
   private handleMessage(channel: string, message: string) {
     const marketId = channel.startsWith("WS_OUT_") ? channel.slice(7) : channel;
 
-    let parsed: { type?: string; connectionId?: string; userId?: string } = {};
+    let parsed: { type?: string; connectionId?: string } = {};
     try {
       parsed = JSON.parse(message);
     } catch {
       // unparseable — fall through to broadcast
     }
 
-    if (parsed.type === "ORDER_CANCELLED" && parsed.userId) {
-      const ws = this.userConnMap.UserConnectionMap.get(parsed.userId as string);
-      if (ws && ws.readyState === ws.OPEN) {
-        ws.send(message);
-      }
-      return;
-    }
-
     if (parsed.type && UNICAST_TYPES.has(parsed.type) && parsed.connectionId) {
       const ws = this.connMap.Get(parsed.connectionId);
       if (ws && ws.readyState === ws.OPEN) {
@@ -1,4 +1,5 @@
 import { WebSocketServer, WebSocket } from "ws";
+import type { IncomingMessage } from "node:http";
 import type { ConfigType } from "./config/Config";
 import Config from "./config/Config";
 import type Redis from "ioredis";
@@ -8,6 +9,7 @@ import Types, { ParseClient, PUBSLISHED_MESSAGE_TYPES } from "./types";
 import MarketSubscriber from "./redis/Subscriber";
 import Publisher from "./redis/Publisher";
 import MessageHandler from "./handlers/MessageHandler";
+import { TokenFromRequest, VerifyAccessToken } from "./auth/AccessToken";
 
 class Server {
   private conf: ConfigType;
@@ -28,7 +30,7 @@ class Server {
     this.userConnMap = new UserConnectionMap()
     this.marketSubscriber = new MarketSubscriber(this.redisClient, this.marketConnMap, this.connMap, this.userConnMap)
     this.marketPublisher = new Publisher(this.redisClient);
-    this.messageHandler = new MessageHandler(this.marketPublisher, this.marketSubscriber, this.userConnMap, this.marketConnMap, this.connMap)
+    this.messageHandler = new MessageHandler(this.marketPublisher, this.marketSubscriber, this.marketConnMap, this.connMap)
   }
 
   public InitServer() {
@@ -37,8 +39,24 @@ class Server {
   }
 
 
-  private connectionHandler(ws: WebSocket) {
-    const connectionId = this.connMap.AddConnection(ws);
+  // connectionHandler authenticates the connection from its access token before anything
+  // is registered for it. A connection without a token watches markets anonymously; one
+  // with a token that does not verify is refused.
+  private connectionHandler(ws: WebSocket, req: IncomingMessage) {
+    const token = TokenFromRequest(req);
+    let userId: string | undefined;
+    if (token) {
+      userId = VerifyAccessToken(token, this.conf.AUTH_SECRET) ?? undefined;
+      if (!userId) {
+        ws.close(1008, "Invalid access token");
+        return;
+      }
+    }
+
+    const connectionId = this.connMap.AddConnection(ws, userId);
+    if (userId) {
+      this.userConnMap.AddUser(userId, ws);
+    }
 
     ws.on("message", (data) => {
       const ClientMessage = ParseClient(data)
@@ -51,10 +69,18 @@ class Server {
 
     ws.on("close", async () => {
       const meta = this.connMap.GetMeta(connectionId);
-      if (meta) {
+      const lastOfUser = meta?.userId ? this.userConnMap.RemoveUser(meta.userId, ws) : false;
+      if (meta?.marketId) {
         this.marketConnMap.Remove(meta.marketId, ws);
-        if (meta.userId) {
-          this.userConnMap.RemoveUser(meta.userId);
+        // Throttled subscriptions are kept by the Engine per connection.
+        for (const MessageType of [PUBSLISHED_MESSAGE_TYPES.depthUnsubs, PUBSLISHED_MESSAGE_TYPES.bookTickerUnsubs]) {
+          await this.marketPublisher.PublishMessageInMarket(meta.marketId, JSON.stringify({
+            MessageType,
+            ConnectionId: connectionId,
+          }));
+        }
+        // The wallet stays loaded while the user has another connection open.
+        if (lastOfUser) {
           await this.marketPublisher.PublishMessageInMarket(meta.marketId, JSON.stringify({
             MessageType: PUBSLISHED_MESSAGE_TYPES.walletEvict,
             UserId: meta.userId,
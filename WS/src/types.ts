@@ -5,6 +5,11 @@ export const MessageType = zod.enum([
   "SUBSCRIBE_MARKET",
   "UNSUBSCRIBE_MARKET",
   "CANCEL_ORDER",
+  "RESYNC_DEPTH",
+  "SUBSCRIBE_DEPTH",
+  "UNSUBSCRIBE_DEPTH",
+  "SUBSCRIBE_BOOK_TICKER",
+  "UNSUBSCRIBE_BOOK_TICKER",
 ]);
 
 const ClientMsgSchema = zod.discriminatedUnion("type", [
@@ -12,7 +17,6 @@ const ClientMsgSchema = zod.discriminatedUnion("type", [
     type: zod.literal("SUBSCRIBE_MARKET"),
     payload: zod.object({
       marketID: zod.string().uuid(),
-      userID: zod.string().optional(),
     }),
   }),
 
@@ -31,6 +35,45 @@ const ClientMsgSchema = zod.discriminatedUnion("type", [
       cancelQty: zod.number().int().nonnegative().optional(),
     }),
   }),
+
+  zod.object({
+    type: zod.literal("RESYNC_DEPTH"),
+    payload: zod.object({
+      marketID: zod.string().uuid(),
+    }),
+  }),
+
+  zod.object({
+    type: zod.literal("SUBSCRIBE_DEPTH"),
+    payload: zod.object({
+      marketID: zod.string().uuid(),
+      depth: zod.union([zod.literal(5), zod.literal(10), zod.literal(50)]).optional(),
+      granularity: zod.number().int().positive().optional(),
+      intervalMs: zod.number().int().positive().optional(),
+    }),
+  }),
+
+  zod.object({
+    type: zod.literal("UNSUBSCRIBE_DEPTH"),
+    payload: zod.object({
+      marketID: zod.string().uuid(),
+    }),
+  }),
+
+  zod.object({
+    type: zod.literal("SUBSCRIBE_BOOK_TICKER"),
+    payload: zod.object({
+      marketID: zod.string().uuid(),
+      intervalMs: zod.number().int().positive().optional(),
+    }),
+  }),
+
+  zod.object({
+    type: zod.literal("UNSUBSCRIBE_BOOK_TICKER"),
+    payload: zod.object({
+      marketID: zod.string().uuid(),
+    }),
+  }),
 ]);
 
 export type ClientMessage = zod.infer<typeof ClientMsgSchema>;
@@ -72,6 +115,10 @@ export enum PUBSLISHED_MESSAGE_TYPES {
   depthSubs = "DEPTH_SUBSCRIBE",
   walletLoad = "WALLET_LOAD",
   walletEvict = "WALLET_EVICT",
+  depthResync = "DEPTH_RESYNC",
+  depthUnsubs = "DEPTH_UNSUBSCRIBE",
+  bookTickerSubs = "BOOK_TICKER_SUBSCRIBE",
+  bookTickerUnsubs = "BOOK_TICKER_UNSUBSCRIBE",
 }
 
 
@@ -378,14 +378,14 @@ async function openMarketWsConnections(
   if (wsMode === "per-user") {
     const sockets: WebSocket[] = [];
     for (const user of users) {
-      const ws = await openMarketWs(wsUrl, marketId, user.userId);
+      const ws = await openMarketWs(wsUrl, marketId, user.token);
       sockets.push(ws);
     }
     return sockets;
   }
 
   const representative = users[0];
-  return [await openMarketWs(wsUrl, marketId, representative.userId)];
+  return [await openMarketWs(wsUrl, marketId, representative.token)];
 }
 
 function closeWsConnections(connections: WebSocket[], marketId: string) {
@@ -399,9 +399,13 @@ function closeWsConnections(connections: WebSocket[], marketId: string) {
   }
 }
 
-function openMarketWs(wsUrl: string, marketId: string, userId: string): Promise<WebSocket> {
+// openMarketWs connects as the user the access token was issued to; the WS server takes the
+// user from the token, never from the subscribe message.
+function openMarketWs(wsUrl: string, marketId: string, token: string): Promise<WebSocket> {
   return new Promise((resolve, reject) => {
-    const ws = new WebSocket(wsUrl);
+    const url = new URL(wsUrl);
+    url.searchParams.set("token", token);
+    const ws = new WebSocket(url.toString());
     let settled = false;
 
     const timeout = setTimeout(() => {
@@ -415,7 +419,7 @@ function openMarketWs(wsUrl: string, marketId: string, userId: string): Promise<
       ws.send(
         JSON.stringify({
           type: "SUBSCRIBE_MARKET",
-          payload: { marketID: marketId, userID: userId },
+          payload: { marketID: marketId },
         })
       );
     };
@@ -427,7 +431,7 @@ function openMarketWs(wsUrl: string, marketId: string, userId: string): Promise<
         if (
           msg?.type === "SUBSCRIBED" ||
           msg?.type === "ORDERBOOK_DATA" ||
-          msg?.type === "ORDERBOOK_UPDATE"
+          msg?.type === "DEPTH_DELTA"
         ) {
           settled = true;
           clearTimeout(timeout);